@@ -6,20 +6,54 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/vasiliiperfilev/cookie/internal/app"
 	"github.com/vasiliiperfilev/cookie/internal/data"
 	"github.com/vasiliiperfilev/cookie/internal/database"
+	"github.com/vasiliiperfilev/cookie/internal/mailer"
 )
 
 func main() {
 	var cfg app.Config
 	var dbCfg database.Config
+	var hubBroker string
+	var mailSender string
+	var smtpCfg struct {
+		host     string
+		port     int
+		username string
+		password string
+	}
+	var mailFrom, mailDir string
 
 	flag.IntVar(&cfg.Port, "port", 4000, "API server port")
 	flag.StringVar(&cfg.Env, "env", "development", "Environment (development|staging|production)")
+	flag.StringVar(&cfg.AttachmentsDir, "attachments-dir", "./attachments", "Directory for message attachments")
+	flag.StringVar(&hubBroker, "hub-broker", "postgres", "Chat hub broker between API instances (memory|postgres)")
+	flag.Func("ws-trusted-origins", "Trusted websocket origins (space separated)", func(val string) error {
+		cfg.TrustedOrigins = strings.Fields(val)
+		return nil
+	})
+	flag.IntVar(&cfg.WebhookAttempts, "webhook-attempts", 8, "Webhook delivery attempts before a delivery is dead")
+	flag.DurationVar(&cfg.WebhookBackoff, "webhook-backoff", 30*time.Second, "Delay after the first failed webhook delivery, it doubles with every attempt")
+	flag.DurationVar(&cfg.WebhookPollInterval, "webhook-poll-interval", 5*time.Second, "Interval between sending due webhook deliveries")
+	flag.BoolVar(&cfg.WebhookAllowPrivate, "webhook-allow-private", false, "Allow webhooks to loopback and private addresses, for local development only")
+	flag.DurationVar(&cfg.OutboxPollInterval, "outbox-poll-interval", time.Second, "Interval between checks for domain events written by other instances")
+	flag.IntVar(&cfg.OutboxAttempts, "outbox-attempts", 10, "Attempts to publish a domain event before it is dead")
+	flag.DurationVar(&cfg.OutboxBackoff, "outbox-backoff", 5*time.Second, "Delay after the first failed attempt to publish a domain event, it doubles with every attempt")
+	flag.StringVar(&mailSender, "mail-sender", "file", "Email sender (smtp|file)")
+	flag.StringVar(&mailFrom, "mail-from", "Cookie <no-reply@cookie.local>", "Sender of emails")
+	flag.StringVar(&mailDir, "mail-dir", "./mail", "Directory of emails written by the file sender")
+	flag.StringVar(&smtpCfg.host, "smtp-host", os.Getenv("COOKIE_SMTP_HOST"), "SMTP host")
+	flag.IntVar(&smtpCfg.port, "smtp-port", 587, "SMTP port")
+	flag.StringVar(&smtpCfg.username, "smtp-username", os.Getenv("COOKIE_SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&smtpCfg.password, "smtp-password", os.Getenv("COOKIE_SMTP_PASSWORD"), "SMTP password")
+	flag.DurationVar(&cfg.NotificationPollInterval, "notification-poll-interval", 10*time.Second, "Interval between sending email notifications")
+	flag.DurationVar(&cfg.NotifyUnreadAfter, "notify-unread-after", 15*time.Minute, "How long a message is unread before the recipient gets an email")
+	flag.DurationVar(&cfg.DigestInterval, "digest-interval", 24*time.Hour, "Interval between email digests")
 	// db flags
 	flag.StringVar(&dbCfg.Dsn, "db-dsn", os.Getenv("COOKIE_DB_DSN"), "PostgreSQL DSN")
 	flag.IntVar(&dbCfg.MaxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
@@ -35,6 +69,25 @@ func main() {
 	}
 	defer db.Close()
 	logger.Printf("database connection pool established")
+	switch hubBroker {
+	case "postgres":
+		cfg.Broker, err = app.NewPostgresBroker(db, dbCfg.Dsn, "cookie_hub", logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	case "memory":
+		cfg.Broker = app.NewMemoryBroker()
+	default:
+		logger.Fatalf("unknown hub broker %v", hubBroker)
+	}
+	switch mailSender {
+	case "smtp":
+		cfg.MailSender = mailer.NewSMTPSender(smtpCfg.host, smtpCfg.port, smtpCfg.username, smtpCfg.password, mailFrom)
+	case "file":
+		cfg.MailSender = mailer.NewFileSender(mailDir, mailFrom)
+	default:
+		logger.Fatalf("unknown mail sender %v", mailSender)
+	}
 	models := data.NewModels(db)
 	app := app.New(cfg, logger, models)
 
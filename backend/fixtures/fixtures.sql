@@ -1,23 +1,23 @@
-INSERT INTO users (email, name, password_hash, user_type_id, image_id)
+INSERT INTO users (email, name, password_hash, user_type_id, image_id, activated)
 VALUES 
-    ('test1@user', 'name 1', 'hash', 1, 'test1'),
-    ('test2@user', 'name 2', 'hash', 2, 'test2'),
-    ('test3@user', 'name 3', 'hash', 1, 'test3'),
-    ('test4@user', 'name 4', 'hash', 2, 'test4'),
-    ('testItemModel@user', 'item model', 'hash', 1, 'test4'),
-    ('testOrderModel@user', 'order model', 'hash', 1, 'test4');
+    ('test1@user', 'name 1', 'hash', 1, 'test1', true),
+    ('test2@user', 'name 2', 'hash', 2, 'test2', true),
+    ('test3@user', 'name 3', 'hash', 1, 'test3', true),
+    ('test4@user', 'name 4', 'hash', 2, 'test4', true),
+    ('testItemModel@user', 'item model', 'hash', 1, 'test4', true),
+    ('testOrderModel@user', 'order model', 'hash', 1, 'test4', true);
 
-INSERT INTO conversations (last_message_id)
+INSERT INTO conversations (last_message_id, direct_key)
 VALUES 
-    (0),
-    (0);
+    (0, '2:6'),
+    (0, '4:6');
 
-INSERT INTO conversations_users (conversation_id, user_id)
+INSERT INTO conversations_users (conversation_id, user_id, role)
 VALUES 
-    (1, 2),
-    (1, 6),
-    (2, 6),
-    (2, 4);
+    (1, 2, 'owner'),
+    (1, 6, 'owner'),
+    (2, 6, 'owner'),
+    (2, 4, 'owner');
 
 INSERT INTO items (supplier_id, unit_id, size, name, image_url)
 VALUES
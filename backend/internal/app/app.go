@@ -3,8 +3,11 @@ package app
 import (
 	"log"
 	"net/http"
+	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/mailer"
 )
 
 const JsonContentType = "application/json"
@@ -12,24 +15,155 @@ const JsonContentType = "application/json"
 type Config struct {
 	Port int
 	Env  string
+	// AttachmentsDir is where message attachments are stored,
+	// it is separate from public uploads
+	AttachmentsDir string
+	// Broker connects chat hubs of API instances, a single instance uses MemoryBroker
+	Broker Broker
+	// TrustedOrigins are origins of browsers allowed to open websocket connections
+	TrustedOrigins []string
+	// EventSweepInterval is how often expired chat events are deleted
+	EventSweepInterval time.Duration
+	// WebhookAttempts is the number of attempts before a webhook delivery is dead
+	WebhookAttempts int
+	// WebhookBackoff is the delay after the first failed attempt, it doubles after every next one
+	WebhookBackoff time.Duration
+	// WebhookPollInterval is how often the outbox is checked for due deliveries
+	WebhookPollInterval time.Duration
+	// WebhookAllowPrivate lets webhooks be sent to loopback and private addresses,
+	// it is meant for local development and tests
+	WebhookAllowPrivate bool
+	// OutboxPollInterval is how often the outbox is checked for events written by other instances
+	OutboxPollInterval time.Duration
+	// OutboxAttempts is the number of attempts to publish a domain event before it is dead
+	OutboxAttempts int
+	// OutboxBackoff is the delay after the first failed attempt, it doubles after every next one
+	OutboxBackoff time.Duration
+	// MailSender delivers emails, emails are kept in memory if it isn't configured
+	MailSender mailer.Sender
+	// NotificationPollInterval is how often email notifications are sent
+	NotificationPollInterval time.Duration
+	// NotificationAttempts is the number of attempts before a notification is dead
+	NotificationAttempts int
+	// NotificationRetryDelay is how long a failed notification waits for the next attempt
+	NotificationRetryDelay time.Duration
+	// NotifyUnreadAfter is how long a message is unread before the recipient is notified
+	NotifyUnreadAfter time.Duration
+	// DigestInterval is how often digests are sent, they are sent at the start of every interval
+	DigestInterval time.Duration
 }
 
 type Application struct {
-	config Config
-	logger *log.Logger
-	models data.Models
-	hub    *Hub
+	config     Config
+	logger     *log.Logger
+	models     data.Models
+	hub        *Hub
+	events     *EventBus
+	relay      *outboxRelay
+	mailer     *mailer.Mailer
+	notifier   *notifier
+	webhooks   *webhookDispatcher
+	wsUpgrader websocket.Upgrader
 	http.Handler
 }
 
 func New(config Config, logger *log.Logger, models data.Models) *Application {
 	a := new(Application)
 	a.config = config
+	if a.config.AttachmentsDir == "" {
+		a.config.AttachmentsDir = "./attachments"
+	}
 	a.logger = logger
 	a.models = models
+	if a.models.Event == nil {
+		// websocket events are kept in memory if the event log isn't configured
+		a.models.Event = data.NewStubEventModel()
+	}
+	if a.models.Webhook == nil {
+		a.models.Webhook = data.NewStubWebhookModel()
+	}
+	if a.models.Notification == nil {
+		a.models.Notification = data.NewStubNotificationModel(a.models.User, nil)
+	}
+	if a.models.Presence == nil {
+		a.models.Presence = data.NewStubPresenceModel()
+	}
+	if a.models.Outbox == nil {
+		a.models.Outbox = data.NewStubOutboxModel()
+	}
+	if outbox, ok := a.models.Outbox.(*data.StubOutboxModel); ok {
+		// writes of stub models are added to the outbox in memory
+		for _, model := range []any{a.models.Message, a.models.Conversation, a.models.Order, a.models.Item} {
+			if m, ok := model.(interface{ SetOutboxModel(*data.StubOutboxModel) }); ok {
+				m.SetOutboxModel(outbox)
+			}
+		}
+	}
+	if a.config.Broker == nil {
+		a.config.Broker = NewMemoryBroker()
+	}
+	if a.config.EventSweepInterval == 0 {
+		a.config.EventSweepInterval = time.Hour
+	}
+	if a.config.WebhookAttempts == 0 {
+		a.config.WebhookAttempts = 8
+	}
+	if a.config.WebhookBackoff == 0 {
+		a.config.WebhookBackoff = 30 * time.Second
+	}
+	if a.config.WebhookPollInterval == 0 {
+		a.config.WebhookPollInterval = 5 * time.Second
+	}
+	if a.config.OutboxPollInterval == 0 {
+		a.config.OutboxPollInterval = time.Second
+	}
+	if a.config.OutboxAttempts == 0 {
+		a.config.OutboxAttempts = 10
+	}
+	if a.config.OutboxBackoff == 0 {
+		a.config.OutboxBackoff = 5 * time.Second
+	}
+	if a.config.MailSender == nil {
+		a.config.MailSender = mailer.NewMemorySender()
+	}
+	if a.config.NotificationPollInterval == 0 {
+		a.config.NotificationPollInterval = 10 * time.Second
+	}
+	if a.config.NotificationAttempts == 0 {
+		a.config.NotificationAttempts = 5
+	}
+	if a.config.NotificationRetryDelay == 0 {
+		a.config.NotificationRetryDelay = time.Minute
+	}
+	if a.config.NotifyUnreadAfter == 0 {
+		a.config.NotifyUnreadAfter = 15 * time.Minute
+	}
+	if a.config.DigestInterval == 0 {
+		a.config.DigestInterval = 24 * time.Hour
+	}
+	a.wsUpgrader = websocket.Upgrader{
+		CheckOrigin:     a.checkOrigin,
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+	}
 	// start websocket hub
-	a.hub = newHub(a)
+	a.hub = newHub(a, a.config.Broker)
 	go a.hub.run()
+	go a.hub.sweepEvents()
+	a.events = NewEventBus()
+	a.events.Subscribe("hub", a.hub.handleDomainEvent)
+	// start webhook deliveries
+	a.webhooks = newWebhookDispatcher(a)
+	a.events.Subscribe("webhooks", a.webhooks.handleDomainEvent)
+	go a.webhooks.run()
+	// start email notifications
+	a.mailer = mailer.New(a.config.MailSender)
+	a.notifier = newNotifier(a)
+	a.events.Subscribe("notifications", a.notifier.handleDomainEvent)
+	go a.notifier.run()
+	// start publishing events of the outbox
+	a.relay = newOutboxRelay(a)
+	go a.relay.run()
 	// create router
 	router := a.routes()
 	a.Handler = a.setAccessControlHeaders(router)
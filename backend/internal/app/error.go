@@ -7,7 +7,15 @@ import (
 	"strings"
 )
 
-var ErrUnathorized = errors.New("Unathorized")
+var (
+	ErrUnathorized  = errors.New("Unathorized")
+	ErrNotPermitted = errors.New("not permitted")
+	// ErrNotActivated is returned when the user has to verify their email first
+	ErrNotActivated = errors.New("not activated")
+	// ErrFailedValidation is returned when the validator passed by the caller has errors
+	ErrFailedValidation   = errors.New("failed validation")
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
 
 type ErrorResponse struct {
 	Message string            `json:"message"`
@@ -58,6 +66,11 @@ func (a *Application) editConflictResponse(w http.ResponseWriter, r *http.Reques
 	a.errorResponse(w, r, http.StatusConflict, ErrorResponse{Message: message})
 }
 
+func (a *Application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been modified since it was retrieved, please fetch it again"
+	a.errorResponse(w, r, http.StatusPreconditionFailed, ErrorResponse{Message: message})
+}
+
 func (a *Application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	a.errorResponse(w, r, http.StatusUnauthorized, ErrorResponse{Message: message})
@@ -68,6 +81,16 @@ func (a *Application) forbiddenResponse(w http.ResponseWriter, r *http.Request)
 	a.errorResponse(w, r, http.StatusForbidden, ErrorResponse{Message: message})
 }
 
+func (a *Application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	a.errorResponse(w, r, http.StatusForbidden, ErrorResponse{Message: message})
+}
+
+func (a *Application) editWindowExpiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the edit window for this message has expired"
+	a.errorResponse(w, r, http.StatusForbidden, ErrorResponse{Message: message})
+}
+
 func (a *Application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 
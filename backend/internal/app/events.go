@@ -0,0 +1,143 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/data"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// outboxBatchSize is the number of events claimed at once
+	outboxBatchSize = 100
+	// outboxLease is how long claimed events aren't published by other relays
+	outboxLease = 30 * time.Second
+	// outboxMaxBackoff caps the delay between attempts of failed events
+	outboxMaxBackoff = time.Hour
+)
+
+// EventBus hands domain events to the subscribers of this instance, like the hub
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers []subscriber
+}
+
+// subscriber is named, so that the outbox records which subscribers handled an event
+type subscriber struct {
+	name    string
+	handler func(data.DomainEvent) error
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+func (b *EventBus) Subscribe(name string, handler func(data.DomainEvent) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, subscriber{name: name, handler: handler})
+}
+
+// Publish calls subscribers which haven't handled the event yet, it returns names of
+// the ones which handled it now and errors of the rest. The event is published again
+// to the failed subscribers only, so every subscriber gets it at least once.
+func (b *EventBus) Publish(event data.DomainEvent) ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	handled := []string{}
+	var errs []error
+	for _, s := range b.subscribers {
+		if slices.Contains(event.DispatchedTo, s.name) {
+			continue
+		}
+		if err := s.handler(event); err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", s.name, err))
+			continue
+		}
+		handled = append(handled, s.name)
+	}
+	return handled, errors.Join(errs...)
+}
+
+// outboxRelay publishes events written to the outbox with the changes and marks them
+// as dispatched, it is woken up after writes of this instance and polls for the rest
+type outboxRelay struct {
+	app  *Application
+	wake chan struct{}
+}
+
+func newOutboxRelay(app *Application) *outboxRelay {
+	return &outboxRelay{app: app, wake: make(chan struct{}, 1)}
+}
+
+// notify makes the relay publish new events without waiting for the poll interval
+func (r *outboxRelay) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *outboxRelay) run() {
+	ticker := time.NewTicker(r.app.config.OutboxPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.wake:
+		}
+		r.relay()
+	}
+}
+
+// relay publishes undispatched events until there are none, events which failed
+// are claimed again with exponential backoff until they run out of attempts
+func (r *outboxRelay) relay() {
+	for {
+		events, err := r.app.models.Outbox.ClaimUndispatched(outboxBatchSize, outboxLease)
+		if err != nil {
+			r.app.logger.Printf("Can't claim outbox events: %v", err)
+			return
+		}
+		if len(events) == 0 {
+			return
+		}
+		dispatched := []int64{}
+		for _, event := range events {
+			handled, err := r.app.events.Publish(event)
+			if err != nil {
+				r.app.logger.Printf("Can't publish %v event %v: %v", event.Type, event.Id, err)
+				event.DispatchedTo = append(event.DispatchedTo, handled...)
+				r.fail(event, err)
+				continue
+			}
+			dispatched = append(dispatched, event.Id)
+		}
+		if err := r.app.models.Outbox.MarkDispatched(dispatched); err != nil {
+			r.app.logger.Printf("Can't mark outbox events as dispatched: %v", err)
+			return
+		}
+		if len(events) < outboxBatchSize {
+			return
+		}
+	}
+}
+
+// fail stores the failed attempt, events which failed every attempt are dead and aren't published again
+func (r *outboxRelay) fail(event data.DomainEvent, err error) {
+	event.Attempts++
+	if event.Attempts >= r.app.config.OutboxAttempts {
+		r.app.logger.Printf("Outbox event %v is dead after %v attempts", event.Id, event.Attempts)
+		if err := r.app.models.Outbox.MarkDead(event, err.Error()); err != nil {
+			r.app.logger.Printf("Can't mark outbox event %v as dead: %v", event.Id, err)
+		}
+		return
+	}
+	retryAt := time.Now().Add(backoff(r.app.config.OutboxBackoff, outboxMaxBackoff, event.Attempts))
+	if err := r.app.models.Outbox.MarkFailed(event, err.Error(), retryAt); err != nil {
+		r.app.logger.Printf("Can't mark outbox event %v as failed: %v", event.Id, err)
+	}
+}
@@ -0,0 +1,160 @@
+package app_test
+
+import (
+	"errors"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/app"
+	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/tester"
+)
+
+func TestEventBus(t *testing.T) {
+	t.Run("it publishes the event again to failed subscribers only", func(t *testing.T) {
+		bus := app.NewEventBus()
+		calls := map[string]int{}
+		bus.Subscribe("hub", func(data.DomainEvent) error {
+			calls["hub"]++
+			return nil
+		})
+		bus.Subscribe("webhooks", func(data.DomainEvent) error {
+			calls["webhooks"]++
+			if calls["webhooks"] == 1 {
+				return errors.New("unavailable")
+			}
+			return nil
+		})
+
+		event := data.NewDomainEvent(data.DomainMessageCreated, data.Message{Content: "once"})
+		handled, err := bus.Publish(event)
+		tester.AssertError(t, err)
+		tester.AssertValue(t, handled, []string{"hub"}, "Expected subscribers which handled the event")
+
+		event.DispatchedTo = handled
+		handled, err = bus.Publish(event)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, handled, []string{"webhooks"}, "Expected failed subscriber to get the event again")
+		tester.AssertValue(t, calls["hub"], 1, "Expected hub to get the event once")
+	})
+}
+
+func TestOutboxRelay(t *testing.T) {
+	t.Run("it publishes events written without notifying the relay", func(t *testing.T) {
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		userModel := data.NewStubUserModel(generateUsers(2))
+		conversations := generateConversation(2)
+		messageModel := data.NewStubMessageModel(conversations, []data.Message{})
+		conversationModel := data.NewStubConversationModel(conversations, userModel)
+		outbox := data.NewStubOutboxModel()
+		models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel, Token: data.NewStubTokenModel(generateTokens(2)), Outbox: outbox}
+		cfg := app.Config{Port: 4000, Env: "development", OutboxPollInterval: 10 * time.Millisecond}
+		server := httptest.NewServer(app.New(cfg, logger, models))
+		defer server.Close()
+		ws := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
+		defer ws.Close()
+
+		// the instance which wrote the message crashed before the event was published
+		msg := data.Message{ConversationId: 1, SenderId: 1, Content: "after crash"}
+		err := messageModel.Insert(&msg, func(msg data.Message) data.DomainEvent {
+			event := data.NewDomainEvent(data.DomainMessageCreated, msg)
+			event.ConversationId = msg.ConversationId
+			event.UserId = msg.SenderId
+			return event
+		})
+		tester.AssertNoError(t, err)
+
+		within(t, 500*time.Millisecond, func() {
+			got, _ := readSequencedMessage(t, ws)
+			tester.AssertValue(t, got.Content, "after crash", "Expected message of the outbox")
+		})
+		within(t, 500*time.Millisecond, func() {
+			for {
+				events, err := outbox.ClaimUndispatched(1, 0)
+				tester.AssertNoError(t, err)
+				if len(events) == 0 {
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	})
+
+	t.Run("it publishes message edits and conversation changes of the outbox", func(t *testing.T) {
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		userModel := data.NewStubUserModel(generateUsers(2))
+		conversations := generateConversation(2)
+		messageModel := data.NewStubMessageModel(conversations, []data.Message{})
+		conversationModel := data.NewStubConversationModel(conversations, userModel)
+		outbox := data.NewStubOutboxModel()
+		models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel, Token: data.NewStubTokenModel(generateTokens(2)), Outbox: outbox}
+		cfg := app.Config{Port: 4000, Env: "development", OutboxPollInterval: 10 * time.Millisecond}
+		server := httptest.NewServer(app.New(cfg, logger, models))
+		defer server.Close()
+		ws := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
+		defer ws.Close()
+
+		msg := data.Message{ConversationId: 1, SenderId: 1, Content: "before edit"}
+		tester.AssertNoError(t, messageModel.Insert(&msg, nil))
+		msg.Content = "edited"
+		err := messageModel.Edit(&msg, func(msg data.Message) data.DomainEvent {
+			event := data.NewDomainEvent(data.DomainMessageEdited, msg)
+			event.ConversationId = msg.ConversationId
+			return event
+		})
+		tester.AssertNoError(t, err)
+		within(t, 500*time.Millisecond, func() {
+			got := readMessageEvent(t, ws, app.EventMessageEdited)
+			tester.AssertValue(t, got.Content, "edited", "Expected edited message of the outbox")
+		})
+
+		err = conversationModel.Leave(1, 2, func(p data.Participant) data.DomainEvent {
+			event := data.NewDomainEvent(data.DomainConversationUpdated, data.ConversationChange{Removed: []int64{p.UserId}})
+			event.ConversationId = 1
+			return event
+		})
+		tester.AssertNoError(t, err)
+		within(t, 500*time.Millisecond, func() {
+			got := readConversationEvent(t, ws)
+			tester.AssertValue(t, len(got.Users), 1, "Expected removed user to get the conversation without them")
+		})
+	})
+
+	t.Run("it stops publishing events which fail every attempt", func(t *testing.T) {
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		userModel := data.NewStubUserModel(generateUsers(2))
+		conversations := generateConversation(2)
+		messageModel := data.NewStubMessageModel(conversations, []data.Message{})
+		conversationModel := data.NewStubConversationModel(conversations, userModel)
+		outbox := data.NewStubOutboxModel()
+		models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel, Token: data.NewStubTokenModel(generateTokens(2)), Outbox: outbox}
+		cfg := app.Config{Port: 4000, Env: "development", OutboxPollInterval: 10 * time.Millisecond, OutboxAttempts: 2, OutboxBackoff: 10 * time.Millisecond}
+		server := httptest.NewServer(app.New(cfg, logger, models))
+		defer server.Close()
+
+		// subscribers can't load the conversation of the event
+		msg := data.Message{ConversationId: 1, SenderId: 1, Content: "deleted conversation"}
+		err := messageModel.Insert(&msg, func(msg data.Message) data.DomainEvent {
+			event := data.NewDomainEvent(data.DomainMessageCreated, msg)
+			event.ConversationId = 99
+			event.UserId = msg.SenderId
+			return event
+		})
+		tester.AssertNoError(t, err)
+
+		within(t, 500*time.Millisecond, func() {
+			for len(outbox.GetDead()) == 0 {
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+		dead := outbox.GetDead()
+		tester.AssertValue(t, dead[0].Attempts, 2, "Expected all attempts")
+		events, err := outbox.ClaimUndispatched(1, 0)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, len(events), 0, "Expected dead event not to be claimed")
+	})
+}
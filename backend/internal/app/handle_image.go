@@ -1,8 +1,10 @@
 package app
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -29,47 +31,61 @@ func (a *Application) handlePostImage(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	buff := make([]byte, 512)
-	_, err = file.Read(buff)
+	filetype, err := detectContentType(file)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
 	}
-	filetype := http.DetectContentType(buff)
 	if filetype != "image/jpeg" && filetype != "image/png" && filetype != "image/jpg" {
-		a.badRequestResponse(w, r, err)
+		a.badRequestResponse(w, r, errors.New("unsupported image type"))
 		return
 	}
 
-	err = os.MkdirAll("./uploads", os.ModePerm)
-	if err != nil {
-		a.serverErrorResponse(w, r, err)
-		return
-	}
-	fileName := fmt.Sprintf("%d%s", time.Now().UnixNano(), filepath.Ext(fileHeader.Filename))
-	dst, err := os.Create(fmt.Sprintf("./uploads/%s", fileName))
+	fileName, err := saveUpload(file, "./uploads", fileHeader.Filename)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
 	}
 
-	defer dst.Close()
+	writeJsonResponse(w, http.StatusCreated, ImageResponse{ImageId: fileName}, nil)
+}
 
-	_, err = file.Seek(0, io.SeekStart)
+func (a *Application) handleGetImage(w http.ResponseWriter, r *http.Request) {
+	imageId := filepath.Clean(getField(r, 0))
+	http.ServeFile(w, r, fmt.Sprintf("./uploads/%s", imageId))
+}
+
+// detectContentType sniffs the content type of the uploaded file
+// and rewinds it to the beginning
+func detectContentType(file multipart.File) (string, error) {
+	buff := make([]byte, 512)
+	_, err := file.Read(buff)
 	if err != nil {
-		a.serverErrorResponse(w, r, err)
-		return
+		return "", err
 	}
-	_, err = io.Copy(dst, file)
+	_, err = file.Seek(0, io.SeekStart)
 	if err != nil {
-		a.serverErrorResponse(w, r, err)
-		return
+		return "", err
 	}
-
-	writeJsonResponse(w, http.StatusCreated, ImageResponse{ImageId: fileName}, nil)
+	return http.DetectContentType(buff), nil
 }
 
-func (a *Application) handleGetImage(w http.ResponseWriter, r *http.Request) {
-	imageId := filepath.Clean(getField(r, 0))
-	http.ServeFile(w, r, fmt.Sprintf("./uploads/%s", imageId))
+// saveUpload copies the uploaded file into dir under a unique name and returns the name
+func saveUpload(file multipart.File, dir string, originalName string) (string, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+	fileName := fmt.Sprintf("%d%s", time.Now().UnixNano(), filepath.Ext(originalName))
+	dst, err := os.Create(filepath.Join(dir, fileName))
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, file)
+	if err != nil {
+		return "", err
+	}
+	return fileName, nil
 }
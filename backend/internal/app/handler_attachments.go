@@ -0,0 +1,165 @@
+package app
+
+import (
+	"errors"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/validator"
+)
+
+const MAX_ATTACHMENT_SIZE = 10 * 1024 * 1024 // 10MB
+
+// handlePostAttachment uploads a file which can be sent with a message afterwards
+func (a *Application) handlePostAttachment(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_ATTACHMENT_SIZE)
+	if err := r.ParseMultipartForm(MAX_ATTACHMENT_SIZE); err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	mimeType, err := detectContentType(file)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	attachment := data.Attachment{
+		UploaderId: user.Id,
+		Filename:   filepath.Base(fileHeader.Filename),
+		MimeType:   mimeType,
+		Size:       fileHeader.Size,
+	}
+	v := validator.New()
+	if data.ValidateAttachment(v, attachment); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if mimeType != "application/pdf" {
+		cfg, _, err := image.DecodeConfig(file)
+		if err != nil {
+			a.badRequestResponse(w, r, err)
+			return
+		}
+		attachment.Width, attachment.Height = cfg.Width, cfg.Height
+		_, err = file.Seek(0, 0)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	attachment.FileId, err = saveUpload(file, a.config.AttachmentsDir, attachment.Filename)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	err = a.models.Attachment.Insert(&attachment)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	writeJsonResponse(w, http.StatusCreated, attachment, nil)
+}
+
+// handleGetAttachment serves the file to members of the conversation it was sent to,
+// attachments which weren't sent yet are available only to the uploader
+func (a *Application) handleGetAttachment(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	attachmentId, _ := strconv.ParseInt(getField(r, 0), 10, 64)
+	attachment, err := a.models.Attachment.GetById(attachmentId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	allowed, err := a.canAccessAttachment(user, attachment)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !allowed {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	// only images are shown inline, anything else is downloaded and never sniffed into html
+	disposition := "attachment"
+	if strings.HasPrefix(attachment.MimeType, "image/") {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", attachment.MimeType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	http.ServeFile(w, r, filepath.Join(a.config.AttachmentsDir, filepath.Base(attachment.FileId)))
+}
+
+func (a *Application) canAccessAttachment(user data.User, attachment data.Attachment) (bool, error) {
+	if attachment.MessageId == 0 {
+		return attachment.UploaderId == user.Id, nil
+	}
+	msg, err := a.models.Message.GetById(attachment.MessageId)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	cvs, err := a.models.Conversation.GetById(msg.ConversationId)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return isConversationMember(cvs, user.Id), nil
+}
+
+// removeAttachmentFiles deletes stored files of attachments which rows were already removed,
+// a file which can't be deleted is only logged, the message is deleted anyway
+func (a *Application) removeAttachmentFiles(attachments []data.Attachment) {
+	for _, attachment := range attachments {
+		err := os.Remove(filepath.Join(a.config.AttachmentsDir, filepath.Base(attachment.FileId)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			a.logger.Printf("Can't remove file of attachment %v: %v", attachment.Id, err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package app_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/app"
+	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/tester"
+)
+
+func TestAttachments(t *testing.T) {
+	cfg := app.Config{Port: 4000, Env: "development", AttachmentsDir: t.TempDir()}
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	userModel := data.NewStubUserModel(generateUsers(3))
+	conversations := generateConversation(2)
+	conversationModel := data.NewStubConversationModel(conversations, userModel)
+	// message 1 is sent with an attachment stored on disk
+	sent := data.Attachment{Id: 1, MessageId: 1, UploaderId: 1, FileId: "sent.pdf", Filename: "sent.pdf", MimeType: "application/pdf"}
+	err := os.WriteFile(filepath.Join(cfg.AttachmentsDir, sent.FileId), []byte("%PDF-1.4\n"), 0o600)
+	tester.AssertNoError(t, err)
+	attachmentModel := data.NewStubAttachmentModel([]data.Attachment{sent})
+	messageModel := data.NewStubMessageModel(conversations, []data.Message{{ConversationId: 1, SenderId: 1, Content: "sent", CreatedAt: time.Now()}})
+	messageModel.SetAttachmentModel(attachmentModel)
+	models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel, Attachment: attachmentModel, Token: data.NewStubTokenModel(generateTokens(3))}
+	handler := app.New(cfg, logger, models)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	var img bytes.Buffer
+	err = png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 4, 3)))
+	tester.AssertNoError(t, err)
+	pdf := []byte("%PDF-1.4\n%delivery note\n")
+
+	t.Run("it POST an image attachment with dimensions", func(t *testing.T) {
+		response := uploadAttachment(t, server.URL, "1", "photo.png", img.Bytes())
+		tester.AssertStatus(t, response.StatusCode, http.StatusCreated)
+		got := parseAttachment(t, response.Body)
+		tester.AssertValue(t, got.MimeType, "image/png", "Expected png mime type")
+		tester.AssertValue(t, got.Filename, "photo.png", "Expected original filename")
+		tester.AssertValue(t, got.Size, int64(img.Len()), "Expected file size")
+		tester.AssertValue(t, got.Width, 4, "Expected image width")
+		tester.AssertValue(t, got.Height, 3, "Expected image height")
+
+		response = getAttachment(t, server.URL, "1", got.Id)
+		tester.AssertStatus(t, response.StatusCode, http.StatusOK)
+		tester.AssertValue(t, response.Header.Get("Content-Disposition"), `inline; filename=photo.png`, "Expected image to be shown inline")
+	})
+
+	t.Run("it 422 if file type is not supported", func(t *testing.T) {
+		response := uploadAttachment(t, server.URL, "1", "notes.txt", []byte("plain text"))
+		tester.AssertStatus(t, response.StatusCode, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("it sends a pdf attachment to conversation members only", func(t *testing.T) {
+		response := uploadAttachment(t, server.URL, "1", "invoice.pdf", pdf)
+		tester.AssertStatus(t, response.StatusCode, http.StatusCreated)
+		attachment := parseAttachment(t, response.Body)
+		tester.AssertValue(t, attachment.MimeType, "application/pdf", "Expected pdf mime type")
+		// not sent attachment is available only to the uploader
+		tester.AssertStatus(t, getAttachment(t, server.URL, "2", attachment.Id).StatusCode, http.StatusNotFound)
+		tester.AssertStatus(t, getAttachment(t, server.URL, "1", attachment.Id).StatusCode, http.StatusOK)
+
+		ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
+		defer ws1.Close()
+		js := createWsPayload(t, PostMessageEvent{
+			Type:    app.EventMessage,
+			Payload: data.PostMessageDto{ConversationId: 1, Content: "invoice", AttachmentIds: []int64{attachment.Id}},
+		})
+		writeWSMessage(t, ws1, js)
+		within(t, 500*time.Millisecond, func() {
+			got := readMessageEvent(t, ws1, app.EventMessage)
+			tester.AssertValue(t, len(got.Attachments), 1, "Expected message to have attachment")
+			tester.AssertValue(t, got.Attachments[0].Filename, "invoice.pdf", "Expected attachment filename")
+			tester.AssertValue(t, got.Attachments[0].MessageId, got.Id, "Expected attachment to be linked")
+		})
+
+		response = getAttachment(t, server.URL, "2", attachment.Id)
+		tester.AssertStatus(t, response.StatusCode, http.StatusOK)
+		tester.AssertValue(t, response.Header.Get("Content-Type"), "application/pdf", "Expected pdf content type")
+		tester.AssertValue(t, response.Header.Get("X-Content-Type-Options"), "nosniff", "Expected content type sniffing to be disabled")
+		tester.AssertValue(t, response.Header.Get("Content-Disposition"), `attachment; filename=invoice.pdf`, "Expected pdf to be downloaded")
+		body, err := io.ReadAll(response.Body)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, body, pdf, "Expected file content")
+		tester.AssertStatus(t, getAttachment(t, server.URL, "3", attachment.Id).StatusCode, http.StatusNotFound)
+	})
+
+	t.Run("it responds with error event if attachment belongs to other user", func(t *testing.T) {
+		response := uploadAttachment(t, server.URL, "2", "invoice.pdf", pdf)
+		tester.AssertStatus(t, response.StatusCode, http.StatusCreated)
+		attachment := parseAttachment(t, response.Body)
+
+		ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
+		defer ws1.Close()
+		js := createWsPayload(t, PostMessageEvent{
+			Type:    app.EventMessage,
+			Payload: data.PostMessageDto{ConversationId: 1, Content: "invoice", AttachmentIds: []int64{attachment.Id}},
+		})
+		writeWSMessage(t, ws1, js)
+		wantError := app.WsError{Code: app.ErrorCodeNotFound, Message: app.NotFoundMessage, Errors: map[string]string{}}
+		within(t, 500*time.Millisecond, func() { assertErrorEvent(t, ws1, wantError) })
+	})
+
+	t.Run("it removes attachment files of deleted message", func(t *testing.T) {
+		tester.AssertStatus(t, getAttachment(t, server.URL, "2", sent.Id).StatusCode, http.StatusOK)
+		response := sendAuthorizedRequest(t, handler, http.MethodDelete, "/v1/messages/1", "1", nil)
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		_, err := os.Stat(filepath.Join(cfg.AttachmentsDir, sent.FileId))
+		tester.AssertValue(t, errors.Is(err, os.ErrNotExist), true, "Expected attachment file to be removed")
+		tester.AssertStatus(t, getAttachment(t, server.URL, "2", sent.Id).StatusCode, http.StatusNotFound)
+	})
+}
+
+func uploadAttachment(t *testing.T, url, userId, filename string, content []byte) *http.Response {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	tester.AssertNoError(t, err)
+	_, err = part.Write(content)
+	tester.AssertNoError(t, err)
+	tester.AssertNoError(t, writer.Close())
+
+	request, err := http.NewRequest(http.MethodPost, url+"/v1/attachments", &body)
+	tester.AssertNoError(t, err)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	request.Header.Set("Authorization", "Bearer "+strings.Repeat(userId, 26))
+	response, err := http.DefaultClient.Do(request)
+	tester.AssertNoError(t, err)
+	return response
+}
+
+func getAttachment(t *testing.T, url, userId string, id int64) *http.Response {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodGet, url+"/v1/attachments/"+strconv.FormatInt(id, 10), nil)
+	tester.AssertNoError(t, err)
+	request.Header.Set("Authorization", "Bearer "+strings.Repeat(userId, 26))
+	response, err := http.DefaultClient.Do(request)
+	tester.AssertNoError(t, err)
+	return response
+}
+
+func parseAttachment(t *testing.T, body io.Reader) data.Attachment {
+	t.Helper()
+	var got data.Attachment
+	err := json.NewDecoder(body).Decode(&got)
+	tester.AssertNoError(t, err)
+	return got
+}
@@ -32,17 +32,30 @@ func (a *Application) handlePostConversation(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	v := validator.New()
-	c, err := a.models.Conversation.Insert(dto)
+	if data.ValidatePostConversation(v, dto); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	dto.OwnerId = user.Id
+	c, err := a.models.Conversation.Insert(dto, func(c data.Conversation) data.DomainEvent {
+		return conversationEvent(c.Id, user.Id)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateConversation):
-			v.AddError("userIds", "conversation with these users already exists")
-			a.failedValidationResponse(w, r, v.Errors)
+			// direct conversation already exists, respond with it instead of creating a new one
+			existing, err := a.models.Conversation.GetById(c.Id)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+				return
+			}
+			writeJsonResponse(w, http.StatusOK, existing, nil)
 		default:
 			a.serverErrorResponse(w, r, err)
 		}
 		return
 	}
+	a.relay.notify()
 
 	writeJsonResponse(w, http.StatusCreated, c, nil)
 }
@@ -63,6 +76,13 @@ func (a *Application) handleGetConversation(w http.ResponseWriter, r *http.Reque
 		a.notFoundResponse(w, r)
 		return
 	}
+	v := validator.New()
+	// archived conversations are listed separately
+	archived := readBool(r.URL.Query(), "archived", false, v)
+	if !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
 	conversations, err := a.models.Conversation.GetAllByUserId(userId)
 	if err != nil {
@@ -75,5 +95,391 @@ func (a *Application) handleGetConversation(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	writeJsonResponse(w, http.StatusOK, conversations, nil)
+	result := []data.Conversation{}
+	userIds := []int64{}
+	for _, conversation := range conversations {
+		if conversation.Archived != archived {
+			continue
+		}
+		for _, u := range conversation.Users {
+			if u.Id != user.Id && !slices.Contains(userIds, u.Id) {
+				userIds = append(userIds, u.Id)
+			}
+		}
+		result = append(result, conversation)
+	}
+	// users can be connected to any instance
+	presence, err := a.models.Presence.GetByUserIds(userIds)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	presenceByUser := map[int64]data.Presence{}
+	for _, p := range presence {
+		presenceByUser[p.UserId] = p
+	}
+	for i := range result {
+		result[i].Presence = []data.Presence{}
+		for _, u := range result[i].Users {
+			if u.Id != user.Id {
+				result[i].Presence = append(result[i].Presence, presenceByUser[u.Id])
+			}
+		}
+	}
+
+	writeJsonResponse(w, http.StatusOK, result, nil)
+}
+
+// handles /v1/conversations/([0-9]+)/read route
+func (a *Application) handlePostConversationRead(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	conversationId, _ := strconv.ParseInt(getField(r, 0), 10, 64)
+	var dto data.PostReadDto
+	err = readJsonFromBody(w, r, &dto)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	cvs, err := a.models.Conversation.GetById(conversationId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	receipt, err := a.markConversationRead(user, cvs, dto.MessageId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	writeJsonResponse(w, http.StatusOK, receipt, nil)
+}
+
+// markConversationRead advances the read pointer of the user up to the message and notifies
+// the members, the user has to be a member of the conversation and the message has to belong to it
+func (a *Application) markConversationRead(user data.User, cvs data.Conversation, messageId int64) (data.ReadReceipt, error) {
+	if !isConversationMember(cvs, user.Id) {
+		return data.ReadReceipt{}, data.ErrRecordNotFound
+	}
+	msg, err := a.models.Message.GetById(messageId)
+	if err != nil {
+		return data.ReadReceipt{}, err
+	}
+	if msg.ConversationId != cvs.Id {
+		return data.ReadReceipt{}, data.ErrRecordNotFound
+	}
+	receipt := data.ReadReceipt{ConversationId: cvs.Id, UserId: user.Id, MessageId: msg.Id}
+	err = a.models.Conversation.UpdateLastRead(receipt, func(receipt data.ReadReceipt) data.DomainEvent {
+		event := data.NewDomainEvent(data.DomainConversationRead, receipt)
+		event.ConversationId = receipt.ConversationId
+		event.UserId = receipt.UserId
+		return event
+	})
+	if err != nil {
+		return data.ReadReceipt{}, err
+	}
+	a.relay.notify()
+	return receipt, nil
+}
+
+func isConversationMember(cvs data.Conversation, userId int64) bool {
+	return slices.ContainsFunc(cvs.Users, func(u data.User) bool { return u.Id == userId })
+}
+
+// handles /v1/conversations/([0-9]+) route, only owners can rename the conversation
+func (a *Application) handlePatchConversation(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var dto data.PatchConversationDto
+	err = readJsonFromBody(w, r, &dto)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateConversationTitle(v, dto.Title); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	conversationId, _ := strconv.ParseInt(getField(r, 0), 10, 64)
+	cvs, ok := a.getMemberConversation(w, r, user, conversationId)
+	if !ok {
+		return
+	}
+	if cvs.RoleOf(user.Id) != data.RoleOwner {
+		a.forbiddenResponse(w, r)
+		return
+	}
+	cvs.Title = dto.Title
+	err = a.models.Conversation.Update(&cvs, func(c data.Conversation) data.DomainEvent {
+		return conversationEvent(c.Id, user.Id)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	a.relay.notify()
+
+	writeJsonResponse(w, http.StatusOK, cvs, nil)
+}
+
+// handles /v1/conversations/([0-9]+)/settings route, settings are personal to the user
+func (a *Application) handlePutConversationSettings(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var settings data.ConversationSettings
+	err = readJsonFromBody(w, r, &settings)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateConversationSettings(v, settings); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	conversationId, _ := strconv.ParseInt(getField(r, 0), 10, 64)
+	cvs, ok := a.getMemberConversation(w, r, user, conversationId)
+	if !ok {
+		return
+	}
+	err = a.models.Conversation.UpdateSettings(cvs.Id, user.Id, settings)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	writeJsonResponse(w, http.StatusOK, settings, nil)
+}
+
+// handles /v1/conversations/([0-9]+)/members route, owners invite users to group conversations
+func (a *Application) handlePostConversationMember(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var dto data.PostParticipantDto
+	err = readJsonFromBody(w, r, &dto)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	conversationId, _ := strconv.ParseInt(getField(r, 0), 10, 64)
+	cvs, ok := a.getManagedConversation(w, r, user, conversationId)
+	if !ok {
+		return
+	}
+	v := validator.New()
+	err = a.models.Conversation.AddParticipant(cvs.Id, data.Participant{UserId: dto.UserId, Role: data.RoleMember}, func(data.Participant) data.DomainEvent {
+		return conversationEvent(cvs.Id, user.Id)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateParticipant):
+			v.AddError("userId", "is already a member of the conversation")
+			a.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("userId", "user doesn't exist")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	a.relay.notify()
+	cvs, err = a.models.Conversation.GetById(cvs.Id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	writeJsonResponse(w, http.StatusOK, cvs, nil)
+}
+
+// handles /v1/conversations/([0-9]+)/members/([0-9]+) route, owners remove other users from group conversations
+func (a *Application) handleDeleteConversationMember(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	conversationId, _ := strconv.ParseInt(getField(r, 0), 10, 64)
+	userId, _ := strconv.ParseInt(getField(r, 1), 10, 64)
+	cvs, ok := a.getManagedConversation(w, r, user, conversationId)
+	if !ok {
+		return
+	}
+	if userId == user.Id {
+		v := validator.New()
+		v.AddError("userId", "use leave to remove yourself from the conversation")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = a.models.Conversation.Leave(cvs.Id, userId, func(p data.Participant) data.DomainEvent {
+		return conversationEvent(cvs.Id, user.Id, p.UserId)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	a.relay.notify()
+	cvs, err = a.models.Conversation.GetById(cvs.Id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	writeJsonResponse(w, http.StatusOK, cvs, nil)
+}
+
+// handles /v1/conversations/([0-9]+)/leave route, if the last owner leaves
+// the next member becomes an owner
+func (a *Application) handlePostConversationLeave(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	conversationId, _ := strconv.ParseInt(getField(r, 0), 10, 64)
+	cvs, ok := a.getMemberConversation(w, r, user, conversationId)
+	if !ok {
+		return
+	}
+	if !cvs.Group {
+		v := validator.New()
+		v.AddError("conversation", "can't leave a direct conversation")
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = a.models.Conversation.Leave(cvs.Id, user.Id, func(p data.Participant) data.DomainEvent {
+		return conversationEvent(cvs.Id, user.Id, p.UserId)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	a.relay.notify()
+
+	writeJsonResponse(w, http.StatusNoContent, nil, nil)
+}
+
+// getMemberConversation loads the conversation if the user is its member,
+// otherwise it writes an error response and returns false
+func (a *Application) getMemberConversation(w http.ResponseWriter, r *http.Request, user data.User, conversationId int64) (data.Conversation, bool) {
+	cvs, err := a.models.Conversation.GetById(conversationId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return data.Conversation{}, false
+	}
+	if !isConversationMember(cvs, user.Id) {
+		a.notFoundResponse(w, r)
+		return data.Conversation{}, false
+	}
+	return cvs, true
+}
+
+// getManagedConversation loads the group conversation if the user is its owner,
+// otherwise it writes an error response and returns false
+func (a *Application) getManagedConversation(w http.ResponseWriter, r *http.Request, user data.User, conversationId int64) (data.Conversation, bool) {
+	cvs, ok := a.getMemberConversation(w, r, user, conversationId)
+	if !ok {
+		return data.Conversation{}, false
+	}
+	if cvs.RoleOf(user.Id) != data.RoleOwner {
+		a.forbiddenResponse(w, r)
+		return data.Conversation{}, false
+	}
+	if !cvs.Group {
+		v := validator.New()
+		v.AddError("conversation", "members of a direct conversation can't be changed")
+		a.failedValidationResponse(w, r, v.Errors)
+		return data.Conversation{}, false
+	}
+	return cvs, true
+}
+
+// conversationEvent builds the event of a new conversation or a change of its title or members,
+// users removed by the change are notified as well
+func conversationEvent(conversationId int64, userId int64, removed ...int64) data.DomainEvent {
+	event := data.NewDomainEvent(data.DomainConversationUpdated, data.ConversationChange{Removed: removed})
+	event.ConversationId = conversationId
+	event.UserId = userId
+	return event
 }
@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/vasiliiperfilev/cookie/internal/app"
 	"github.com/vasiliiperfilev/cookie/internal/data"
@@ -87,9 +88,9 @@ func TestPostConversation(t *testing.T) {
 		}
 	})
 
-	t.Run("it don't allow POST same conversation", func(t *testing.T) {
+	t.Run("it returns existing direct conversation on POST same users", func(t *testing.T) {
 		server := app.New(cfg, logger, models)
-		userIds := []int64{3, 4}
+		userIds := []int64{4, 3}
 		userInput := data.PostConversationDto{
 			UserIds: userIds,
 		}
@@ -100,6 +101,27 @@ func TestPostConversation(t *testing.T) {
 			postRequest.Header.Set("Authorization", "Bearer "+strings.Repeat("3", 26))
 			server.ServeHTTP(response, postRequest)
 		}
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		got := tester.ParseResponse[data.Conversation](t, response)
+		tester.AssertValue(t, got.Id, int64(2), "Expected id of existing conversation")
+	})
+
+	t.Run("it allows groups with same users", func(t *testing.T) {
+		server := app.New(cfg, logger, models)
+		for i := 0; i < 2; i++ {
+			response := sendAuthorizedRequest(t, server, http.MethodPost, "/v1/conversations", "3", data.PostConversationDto{
+				UserIds: []int64{3, 4},
+				Group:   true,
+			})
+			tester.AssertStatus(t, response.Code, http.StatusCreated)
+		}
+	})
+
+	t.Run("it 422 if user ids are duplicated", func(t *testing.T) {
+		server := app.New(cfg, logger, models)
+		response := sendAuthorizedRequest(t, server, http.MethodPost, "/v1/conversations", "3", data.PostConversationDto{
+			UserIds: []int64{3, 4, 4},
+		})
 		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
 	})
 
@@ -115,6 +137,91 @@ func TestPostConversation(t *testing.T) {
 	})
 }
 
+func TestConversationRead(t *testing.T) {
+	cfg := app.Config{Port: 4000, Env: "development"}
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	userModel := data.NewStubUserModel(generateUsers(3))
+	// conversation between user ids 1 and 2 with 2 messages of user 2
+	conversationModel := data.NewStubConversationModel(generateConversation(2), userModel)
+	messageModel := data.NewStubMessageModel(generateConversation(2), []data.Message{
+		{ConversationId: 1, SenderId: 2, Content: "first"},
+		{ConversationId: 1, SenderId: 2, Content: "second"},
+	})
+	conversationModel.SetMessageModel(messageModel)
+	models := data.Models{User: userModel, Conversation: conversationModel, Message: messageModel}
+	server := app.New(cfg, logger, models)
+
+	t.Run("it GET unread count of conversation", func(t *testing.T) {
+		got := mustGetConversations(t, server, 1)
+		tester.AssertValue(t, got[0].UnreadCount, 2, "Expected unread messages of other user")
+		tester.AssertValue(t, got[0].LastReadMessageId, int64(0), "Expected nothing to be read")
+		got = mustGetConversations(t, server, 2)
+		tester.AssertValue(t, got[0].UnreadCount, 0, "Expected own messages to be read")
+	})
+
+	t.Run("it POST read pointer and decreases unread count", func(t *testing.T) {
+		request := createPostReadRequest(t, 1, 1, data.PostReadDto{MessageId: 1})
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		assertContentType(t, response, app.JsonContentType)
+		want := data.ReadReceipt{ConversationId: 1, UserId: 1, MessageId: 1}
+		tester.AssertValue(t, tester.ParseResponse[data.ReadReceipt](t, response), want, "Expected read receipt")
+		got := mustGetConversations(t, server, 1)
+		tester.AssertValue(t, got[0].UnreadCount, 1, "Expected 1 unread message")
+		tester.AssertValue(t, got[0].LastReadMessageId, int64(1), "Expected first message to be read")
+	})
+
+	t.Run("it doesn't move read pointer back", func(t *testing.T) {
+		request := createPostReadRequest(t, 1, 1, data.PostReadDto{MessageId: 2})
+		server.ServeHTTP(httptest.NewRecorder(), request)
+		request = createPostReadRequest(t, 1, 1, data.PostReadDto{MessageId: 1})
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		got := mustGetConversations(t, server, 1)
+		tester.AssertValue(t, got[0].UnreadCount, 0, "Expected no unread messages")
+		tester.AssertValue(t, got[0].LastReadMessageId, int64(2), "Expected last message to be read")
+	})
+
+	t.Run("it 404 if POST read pointer to not own conversation", func(t *testing.T) {
+		request := createPostReadRequest(t, 3, 1, data.PostReadDto{MessageId: 1})
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		tester.AssertStatus(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("it 404 if POST read pointer to non existing message", func(t *testing.T) {
+		request := createPostReadRequest(t, 1, 1, data.PostReadDto{MessageId: 99})
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		tester.AssertStatus(t, response.Code, http.StatusNotFound)
+	})
+}
+
+func createPostReadRequest(t *testing.T, userId int64, conversationId int64, dto data.PostReadDto) *http.Request {
+	requestBody := new(bytes.Buffer)
+	json.NewEncoder(requestBody).Encode(dto)
+	request, err := http.NewRequest(http.MethodPost, fmt.Sprintf("/v1/conversations/%v/read", conversationId), requestBody)
+	tester.AssertNoError(t, err)
+	request.Header.Set("Authorization", "Bearer "+strings.Repeat(strconv.FormatInt(userId, 10), 26))
+	return request
+}
+
+func mustGetConversations(t *testing.T, server http.Handler, userId int64) []data.Conversation {
+	t.Helper()
+	request := createGetAllConversationRequest(t, userId)
+	request.Header.Set("Authorization", "Bearer "+strings.Repeat(strconv.FormatInt(userId, 10), 26))
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, request)
+	tester.AssertStatus(t, response.Code, http.StatusOK)
+	return tester.ParseResponse[[]data.Conversation](t, response)
+}
+
 func createGetAllConversationRequest(t *testing.T, userId int64) *http.Request {
 	getRequest, err := http.NewRequest(http.MethodGet, fmt.Sprintf("/v1/conversations?userId=%v", userId), nil)
 	tester.AssertNoError(t, err)
@@ -128,3 +235,148 @@ func createPostConversationRequest(t *testing.T, dto data.PostConversationDto) *
 	tester.AssertNoError(t, err)
 	return request
 }
+
+func TestConversationMembers(t *testing.T) {
+	cfg := app.Config{Port: 4000, Env: "development"}
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	userModel := data.NewStubUserModel(generateUsers(4))
+	conversationModel := data.NewStubConversationModel([]data.Conversation{}, userModel)
+	messageModel := data.NewStubMessageModel([]data.Conversation{}, []data.Message{})
+	models := data.Models{User: userModel, Conversation: conversationModel, Message: messageModel}
+	server := app.New(cfg, logger, models)
+
+	response := sendAuthorizedRequest(t, server, http.MethodPost, "/v1/conversations", "1", data.PostConversationDto{
+		UserIds: []int64{1, 2, 3},
+		Title:   "Friday orders",
+	})
+	tester.AssertStatus(t, response.Code, http.StatusCreated)
+	group := tester.ParseResponse[data.Conversation](t, response)
+	url := fmt.Sprintf("/v1/conversations/%d", group.Id)
+
+	t.Run("it POST group conversation with creator as owner", func(t *testing.T) {
+		tester.AssertValue(t, group.Title, "Friday orders", "Expected conversation title")
+		tester.AssertValue(t, group.Group, true, "Expected group conversation")
+		tester.AssertValue(t, group.RoleOf(1), data.RoleOwner, "Expected creator to be owner")
+		tester.AssertValue(t, group.RoleOf(2), data.RoleMember, "Expected other users to be members")
+	})
+
+	t.Run("it PATCH title by owner only", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, server, http.MethodPatch, url, "2", data.PatchConversationDto{Title: "renamed"})
+		tester.AssertStatus(t, response.Code, http.StatusForbidden)
+		response = sendAuthorizedRequest(t, server, http.MethodPatch, url, "1", data.PatchConversationDto{Title: "renamed"})
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		tester.AssertValue(t, tester.ParseResponse[data.Conversation](t, response).Title, "renamed", "Expected new title")
+	})
+
+	t.Run("it invites a user by owner only", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, server, http.MethodPost, url+"/members", "2", data.PostParticipantDto{UserId: 4})
+		tester.AssertStatus(t, response.Code, http.StatusForbidden)
+		response = sendAuthorizedRequest(t, server, http.MethodPost, url+"/members", "1", data.PostParticipantDto{UserId: 4})
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		tester.AssertValue(t, tester.ParseResponse[data.Conversation](t, response).RoleOf(4), data.RoleMember, "Expected invited user to be member")
+		response = sendAuthorizedRequest(t, server, http.MethodPost, url+"/members", "1", data.PostParticipantDto{UserId: 4})
+		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		response = sendAuthorizedRequest(t, server, http.MethodPost, url+"/members", "1", data.PostParticipantDto{UserId: 42})
+		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("it removes a member", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, server, http.MethodDelete, url+"/members/1", "1", nil)
+		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		response = sendAuthorizedRequest(t, server, http.MethodDelete, url+"/members/3", "1", nil)
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		tester.AssertValue(t, tester.ParseResponse[data.Conversation](t, response).RoleOf(3), "", "Expected user to be removed")
+		tester.AssertValue(t, len(mustGetConversations(t, server, 3)), 0, "Expected removed user to lose the conversation")
+		response = sendAuthorizedRequest(t, server, http.MethodPatch, url, "3", data.PatchConversationDto{Title: "renamed"})
+		tester.AssertStatus(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("it promotes a member when the last owner leaves", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, server, http.MethodPost, url+"/leave", "1", nil)
+		tester.AssertStatus(t, response.Code, http.StatusNoContent)
+		got := mustGetConversations(t, server, 2)
+		tester.AssertValue(t, got[0].RoleOf(1), "", "Expected user to leave")
+		tester.AssertValue(t, got[0].RoleOf(2), data.RoleOwner, "Expected next member to become owner")
+	})
+
+	t.Run("it doesn't change members of a direct conversation", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, server, http.MethodPost, "/v1/conversations", "1", data.PostConversationDto{UserIds: []int64{1, 4}})
+		tester.AssertStatus(t, response.Code, http.StatusCreated)
+		direct := tester.ParseResponse[data.Conversation](t, response)
+		tester.AssertValue(t, direct.RoleOf(4), data.RoleOwner, "Expected every user of direct conversation to be owner")
+		directUrl := fmt.Sprintf("/v1/conversations/%d", direct.Id)
+		response = sendAuthorizedRequest(t, server, http.MethodPost, directUrl+"/members", "1", data.PostParticipantDto{UserId: 2})
+		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		response = sendAuthorizedRequest(t, server, http.MethodPost, directUrl+"/leave", "1", nil)
+		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestConversationSettings(t *testing.T) {
+	cfg := app.Config{Port: 4000, Env: "development"}
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	users := generateUsers(4)
+	userModel := data.NewStubUserModel(users)
+	now := time.Now()
+	conversationModel := data.NewStubConversationModel([]data.Conversation{
+		{Id: 1, Users: []data.User{users[0], users[1]}, LastMessage: data.Message{Id: 1, CreatedAt: now.Add(-2 * time.Hour)}},
+		{Id: 2, Users: []data.User{users[0], users[2]}, LastMessage: data.Message{Id: 2, CreatedAt: now.Add(-time.Hour)}},
+		{Id: 3, Users: []data.User{users[0], users[3]}, LastMessage: data.Message{Id: 3, CreatedAt: now.Add(-3 * time.Hour)}},
+	}, userModel)
+	models := data.Models{User: userModel, Conversation: conversationModel}
+	server := app.New(cfg, logger, models)
+	conversationIds := func(conversations []data.Conversation) []int64 {
+		return data.Map(conversations, func(c data.Conversation) int64 { return c.Id })
+	}
+
+	t.Run("it GET conversations sorted by last message", func(t *testing.T) {
+		got := conversationIds(mustGetConversations(t, server, 1))
+		tester.AssertValue(t, fmt.Sprint(got), fmt.Sprint([]int64{2, 1, 3}), "Expected latest conversations first")
+	})
+
+	t.Run("it GET pinned conversations first", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, server, http.MethodPut, "/v1/conversations/3/settings", "1", data.ConversationSettings{Pinned: true})
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		got := conversationIds(mustGetConversations(t, server, 1))
+		tester.AssertValue(t, fmt.Sprint(got), fmt.Sprint([]int64{3, 2, 1}), "Expected pinned conversation first")
+		// settings are personal
+		got = conversationIds(mustGetConversations(t, server, 4))
+		tester.AssertValue(t, fmt.Sprint(got), fmt.Sprint([]int64{3}), "Expected conversation of other user")
+		tester.AssertValue(t, mustGetConversations(t, server, 4)[0].Pinned, false, "Expected conversation not pinned for other user")
+	})
+
+	t.Run("it filters archived conversations", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, server, http.MethodPut, "/v1/conversations/2/settings", "1", data.ConversationSettings{Archived: true})
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		got := conversationIds(mustGetConversations(t, server, 1))
+		tester.AssertValue(t, fmt.Sprint(got), fmt.Sprint([]int64{3, 1}), "Expected archived conversation to be hidden")
+		response = sendAuthorizedRequest(t, server, http.MethodGet, "/v1/conversations?userId=1&archived=true", "1", nil)
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		got = conversationIds(tester.ParseResponse[[]data.Conversation](t, response))
+		tester.AssertValue(t, fmt.Sprint(got), fmt.Sprint([]int64{2}), "Expected archived conversations only")
+		response = sendAuthorizedRequest(t, server, http.MethodGet, "/v1/conversations?userId=1&archived=maybe", "1", nil)
+		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("it mutes a conversation until the time", func(t *testing.T) {
+		mutedUntil := now.Add(time.Hour).Truncate(time.Second)
+		response := sendAuthorizedRequest(t, server, http.MethodPut, "/v1/conversations/1/settings", "1", data.ConversationSettings{MutedUntil: &mutedUntil})
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		got := mustGetConversations(t, server, 1)
+		muted := got[len(got)-1]
+		tester.AssertValue(t, muted.Id, int64(1), "Expected muted conversation")
+		tester.AssertValue(t, muted.IsMuted(now), true, "Expected conversation to be muted")
+		tester.AssertValue(t, muted.IsMuted(mutedUntil), false, "Expected conversation to be unmuted later")
+	})
+
+	t.Run("it doesn't mute a conversation in the past", func(t *testing.T) {
+		mutedUntil := now.Add(-time.Hour)
+		response := sendAuthorizedRequest(t, server, http.MethodPut, "/v1/conversations/1/settings", "1", data.ConversationSettings{MutedUntil: &mutedUntil})
+		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("it 404 if PUT settings of not own conversation", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, server, http.MethodPut, "/v1/conversations/1/settings", "3", data.ConversationSettings{Pinned: true})
+		tester.AssertStatus(t, response.Code, http.StatusNotFound)
+	})
+}
@@ -48,7 +48,7 @@ func (a *Application) handlePostItem(w http.ResponseWriter, r *http.Request) {
 		a.serverErrorResponse(w, r, err)
 		return
 	}
-	writeJsonResponse(w, http.StatusCreated, item, nil)
+	writeJsonResponse(w, http.StatusCreated, item, etagHeader(item.Version))
 }
 
 func (a *Application) handleGetItem(w http.ResponseWriter, r *http.Request) {
@@ -73,7 +73,7 @@ func (a *Application) handleGetItem(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	writeJsonResponse(w, http.StatusOK, item, nil)
+	writeJsonResponse(w, http.StatusOK, item, etagHeader(item.Version))
 }
 
 func (a *Application) handleGetAllItems(w http.ResponseWriter, r *http.Request) {
@@ -142,16 +142,26 @@ func (a *Application) handlePutItem(w http.ResponseWriter, r *http.Request) {
 		a.forbiddenResponse(w, r)
 		return
 	}
+	if !matchesIfMatch(r, item.Version) {
+		a.preconditionFailedResponse(w, r)
+		return
+	}
 	item.Unit = dto.Unit
 	item.Size = dto.Size
 	item.Name = dto.Name
 	item.ImageId = dto.ImageId
-	updatedItem, err := a.models.Item.Update(item)
+	updatedItem, err := a.models.Item.Update(item, itemEvent(data.DomainItemUpdated))
 	if err != nil {
-		a.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-	writeJsonResponse(w, http.StatusOK, updatedItem, nil)
+	a.relay.notify()
+	writeJsonResponse(w, http.StatusOK, updatedItem, etagHeader(updatedItem.Version))
 }
 
 func (a *Application) handleDeleteItem(w http.ResponseWriter, r *http.Request) {
@@ -180,6 +190,25 @@ func (a *Application) handleDeleteItem(w http.ResponseWriter, r *http.Request) {
 		a.forbiddenResponse(w, r)
 		return
 	}
-	a.models.Item.Delete(itemId)
+	err = a.models.Item.Delete(itemId, itemEvent(data.DomainItemDeleted))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	a.relay.notify()
 	writeJsonResponse(w, http.StatusNoContent, nil, nil)
 }
+
+// itemEvent builds events of item changes, they concern the supplier of the item
+func itemEvent(eventType string) func(data.Item) data.DomainEvent {
+	return func(item data.Item) data.DomainEvent {
+		event := data.NewDomainEvent(eventType, item)
+		event.UserId = item.SupplierId
+		return event
+	}
+}
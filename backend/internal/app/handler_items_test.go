@@ -233,7 +233,8 @@ func TestItemPut(t *testing.T) {
 	itemModel := data.NewStubItemModel([]data.Item{
 		item1,
 	})
-	models := data.Models{User: data.NewStubUserModel(generateUsers(4)), Item: itemModel}
+	userModel := data.NewStubUserModel(generateUsers(4))
+	models := data.Models{User: userModel, Item: itemModel, Conversation: data.NewStubConversationModel(generateConversation(4), userModel)}
 	server := app.New(cfg, logger, models)
 
 	t.Run("it PUT changed item if requested by owner", func(t *testing.T) {
@@ -262,9 +263,55 @@ func TestItemPut(t *testing.T) {
 		tester.AssertStatus(t, response.Code, http.StatusOK)
 		assertContentType(t, response, app.JsonContentType)
 		assertItemResponse(t, response, want)
+		want.Version = item1.Version + 1
+		tester.AssertValue(t, response.Header().Get("ETag"), fmt.Sprintf(`"%d"`, want.Version), "Expected ETag with new version")
 		asserItemInModel(t, itemModel, item1.Id, want)
 	})
 
+	t.Run("it return 412 if PUT with stale If-Match", func(t *testing.T) {
+		before, err := itemModel.GetById(item1.Id)
+		tester.AssertNoError(t, err)
+		dto := data.PostItemDto{
+			Unit:    "kg",
+			Size:    3,
+			Name:    "Carrot",
+			ImageId: "New url",
+		}
+		requestBody := new(bytes.Buffer)
+		json.NewEncoder(requestBody).Encode(dto)
+		request, err := http.NewRequest(http.MethodPut, fmt.Sprintf("/v1/items/%v", item1.Id), requestBody)
+		request.Header.Set("Authorization", "Bearer "+strings.Repeat(strconv.FormatInt(item1.SupplierId, 10), 26))
+		request.Header.Set("If-Match", fmt.Sprintf(`"%d"`, before.Version-1))
+		tester.AssertNoError(t, err)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		tester.AssertStatus(t, response.Code, http.StatusPreconditionFailed)
+		asserItemInModel(t, itemModel, item1.Id, before)
+	})
+
+	t.Run("it PUT item with matching If-Match", func(t *testing.T) {
+		before, err := itemModel.GetById(item1.Id)
+		tester.AssertNoError(t, err)
+		dto := data.PostItemDto{
+			Unit:    "kg",
+			Size:    3,
+			Name:    "Carrot",
+			ImageId: "New url",
+		}
+		requestBody := new(bytes.Buffer)
+		json.NewEncoder(requestBody).Encode(dto)
+		request, err := http.NewRequest(http.MethodPut, fmt.Sprintf("/v1/items/%v", item1.Id), requestBody)
+		request.Header.Set("Authorization", "Bearer "+strings.Repeat(strconv.FormatInt(item1.SupplierId, 10), 26))
+		request.Header.Set("If-Match", fmt.Sprintf(`"%d"`, before.Version))
+		tester.AssertNoError(t, err)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		tester.AssertValue(t, response.Header().Get("ETag"), fmt.Sprintf(`"%d"`, before.Version+1), "Expected ETag with new version")
+	})
+
 	t.Run("it return 403 if PUT requested not by owner", func(t *testing.T) {
 		dto := data.PostItemDto{
 			Unit:    "kg",
@@ -357,7 +404,8 @@ func TestItemDelete(t *testing.T) {
 	itemModel := data.NewStubItemModel([]data.Item{
 		item1, item2,
 	})
-	models := data.Models{User: data.NewStubUserModel(generateUsers(4)), Item: itemModel}
+	userModel := data.NewStubUserModel(generateUsers(4))
+	models := data.Models{User: userModel, Item: itemModel, Conversation: data.NewStubConversationModel(generateConversation(4), userModel)}
 	server := app.New(cfg, logger, models)
 
 	t.Run("it DELETE item if requested by owner", func(t *testing.T) {
@@ -4,12 +4,16 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/validator"
 	"golang.org/x/exp/slices"
 )
 
 // handles /v1/conversations/([0-9]+)/messages route
+// supports ?before=<messageId>, ?after=<messageId> and ?limit=<n> cursor pagination
 func (a *Application) handleGetMessages(w http.ResponseWriter, r *http.Request) {
 	user, err := a.AuthenticateHttpRequest(w, r)
 	if err != nil {
@@ -36,7 +40,35 @@ func (a *Application) handleGetMessages(w http.ResponseWriter, r *http.Request)
 		a.notFoundResponse(w, r)
 		return
 	}
-	messages, err := a.models.Message.GetAllByConversationId(conversationId)
+	v := validator.New()
+	qs := r.URL.Query()
+	page := data.MessagePage{
+		Before: readInt(qs, "before", 0, v),
+		After:  readInt(qs, "after", 0, v),
+		Limit:  int(readInt(qs, "limit", data.MessagePageDefaultLimit, v)),
+	}
+	if data.ValidateMessagePage(v, page); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// cursor has to point into the same conversation, otherwise the page is meaningless
+	for key, cursor := range map[string]int64{"before": page.Before, "after": page.After} {
+		if cursor == 0 {
+			continue
+		}
+		msg, err := a.models.Message.GetById(cursor)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		v.Check(err == nil && msg.ConversationId == conversationId, key, "must be a message of the conversation")
+	}
+	if !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	messages, err := a.models.Message.GetPageByConversationId(conversationId, page)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -75,3 +107,266 @@ func (a *Application) handleGetMessage(w http.ResponseWriter, r *http.Request) {
 
 	writeJsonResponse(w, http.StatusOK, msg, nil)
 }
+
+func (a *Application) handlePatchMessage(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var dto data.PatchMessageDto
+	err = readJsonFromBody(w, r, &dto)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidatePatchMessageDto(v, dto); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	messageId, _ := strconv.ParseInt(getField(r, 0), 10, 64)
+	msg, ok := a.getChangeableMessage(w, r, user, messageId)
+	if !ok {
+		return
+	}
+	msg.Content = dto.Content
+	err = a.models.Message.Edit(&msg, messageEvent(data.DomainMessageEdited))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	a.relay.notify()
+
+	writeJsonResponse(w, http.StatusOK, msg, nil)
+}
+
+// handleDeleteMessage leaves a tombstone in place of the message
+func (a *Application) handleDeleteMessage(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	messageId, _ := strconv.ParseInt(getField(r, 0), 10, 64)
+	msg, ok := a.getChangeableMessage(w, r, user, messageId)
+	if !ok {
+		return
+	}
+	removed, err := a.models.Message.Delete(&msg, messageEvent(data.DomainMessageDeleted))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	a.relay.notify()
+	a.removeAttachmentFiles(removed)
+
+	writeJsonResponse(w, http.StatusOK, msg, nil)
+}
+
+func (a *Application) handleGetMessageEdits(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	messageId, _ := strconv.ParseInt(getField(r, 0), 10, 64)
+	msg, err := a.models.Message.GetById(messageId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	cvs, err := a.models.Conversation.GetById(msg.ConversationId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !isConversationMember(cvs, user.Id) {
+		a.notFoundResponse(w, r)
+		return
+	}
+	edits, err := a.models.Message.GetEditsByMessageId(msg.Id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	writeJsonResponse(w, http.StatusOK, edits, nil)
+}
+
+// handlePostMessage sends the message the same way as the websocket message event,
+// it is used by clients which can't keep a websocket open
+func (a *Application) handlePostMessage(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	conversationId, _ := strconv.ParseInt(getField(r, 0), 10, 64)
+	var dto data.PostMessageDto
+	err = readJsonFromBody(w, r, &dto)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	dto.ConversationId = conversationId
+	conversation, err := a.models.Conversation.GetById(conversationId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	msg, err := a.postMessage(user, conversation, dto)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	writeJsonResponse(w, http.StatusCreated, msg, nil)
+}
+
+// postMessage stores the message and sends it to members of the conversation,
+// conversations of other users don't exist for the user
+func (a *Application) postMessage(user data.User, conversation data.Conversation, dto data.PostMessageDto) (data.Message, error) {
+	if !isConversationMember(conversation, user.Id) {
+		return data.Message{}, data.ErrRecordNotFound
+	}
+	msg := data.Message{
+		Content:        dto.Content,
+		ConversationId: conversation.Id,
+		PrevMessageId:  dto.PrevMessageId,
+		SenderId:       user.Id,
+	}
+	for _, id := range dto.AttachmentIds {
+		msg.Attachments = append(msg.Attachments, data.Attachment{Id: id})
+	}
+	err := a.models.Message.Insert(&msg, messageEvent(data.DomainMessageCreated))
+	if err != nil {
+		return data.Message{}, err
+	}
+	a.relay.notify()
+	return msg, nil
+}
+
+// getChangeableMessage loads a message which the user is allowed to edit or delete:
+// only the author can change a message which isn't deleted yet and is within the edit window.
+// It writes an error response and returns false otherwise
+func (a *Application) getChangeableMessage(w http.ResponseWriter, r *http.Request, user data.User, messageId int64) (data.Message, bool) {
+	msg, err := a.models.Message.GetById(messageId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return data.Message{}, false
+	}
+	if msg.DeletedAt != nil {
+		a.notFoundResponse(w, r)
+		return data.Message{}, false
+	}
+	if msg.SenderId != user.Id {
+		a.forbiddenResponse(w, r)
+		return data.Message{}, false
+	}
+	if time.Since(msg.CreatedAt) > data.MessageEditWindow {
+		a.editWindowExpiredResponse(w, r)
+		return data.Message{}, false
+	}
+	return msg, true
+}
+
+// messageEvent builds events of message changes, they concern members of the message conversation
+func messageEvent(eventType string) func(data.Message) data.DomainEvent {
+	return func(msg data.Message) data.DomainEvent {
+		event := data.NewDomainEvent(eventType, msg)
+		event.ConversationId = msg.ConversationId
+		event.UserId = msg.SenderId
+		return event
+	}
+}
+
+// handles /v1/messages/search?q=<query>&conversationId=<id>&page=<n>&pageSize=<n> route,
+// searches messages in every conversation of the user unless conversationId is set
+func (a *Application) handleSearchMessages(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	search := data.MessageSearch{
+		Query:          strings.TrimSpace(qs.Get("q")),
+		UserId:         user.Id,
+		ConversationId: readInt(qs, "conversationId", 0, v),
+		Page:           int(readInt(qs, "page", 1, v)),
+		PageSize:       int(readInt(qs, "pageSize", data.MessageSearchDefaultPageSize, v)),
+	}
+	if data.ValidateMessageSearch(v, search); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	results, err := a.models.Message.Search(search)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	writeJsonResponse(w, http.StatusOK, results, nil)
+}
@@ -1,7 +1,9 @@
 package app_test
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -9,6 +11,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/vasiliiperfilev/cookie/internal/app"
 	"github.com/vasiliiperfilev/cookie/internal/data"
@@ -35,8 +38,8 @@ func TestMessagesHandler(t *testing.T) {
 		tester.AssertStatus(t, response.Code, http.StatusOK)
 		var got []data.Message
 		json.NewDecoder(response.Body).Decode(&got)
-		// 0 message is sentinel node
-		if !reflect.DeepEqual(got[1], want) {
+		// the sentinel message 0 doesn't belong to the conversation
+		if !reflect.DeepEqual(got[0], want) {
 			t.Fatalf("Want message %v, but got %v", want, got[0])
 		}
 	})
@@ -50,3 +53,240 @@ func TestMessagesHandler(t *testing.T) {
 		tester.AssertStatus(t, response.Code, http.StatusNotFound)
 	})
 }
+
+func TestMessagesPagination(t *testing.T) {
+	cfg := app.Config{Port: 4000, Env: "development"}
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	userModel := data.NewStubUserModel(generateUsers(4))
+	conversations := generateConversation(2)
+	conversationModel := data.NewStubConversationModel(conversations, userModel)
+	messages := []data.Message{}
+	for i := 0; i < 5; i++ {
+		messages = append(messages, data.Message{ConversationId: 1, Content: "test", SenderId: 1})
+	}
+	messageModel := data.NewStubMessageModel(conversations, messages)
+	models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel}
+	app := app.New(cfg, logger, models)
+
+	cases := []struct {
+		name  string
+		query string
+		want  []int64
+	}{
+		{name: "it GET latest messages with limit", query: "?limit=2", want: []int64{4, 5}},
+		{name: "it GET messages before cursor", query: "?before=4&limit=2", want: []int64{2, 3}},
+		{name: "it GET messages after cursor", query: "?after=2&limit=2", want: []int64{3, 4}},
+		{name: "it GET only messages of the conversation at the beginning of history", query: "?before=2", want: []int64{1}},
+		{name: "it GET empty page after last message", query: "?after=5", want: []int64{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			response := getMessages(t, app, "/v1/conversations/1/messages"+c.query)
+			tester.AssertStatus(t, response.Code, http.StatusOK)
+			var got []data.Message
+			json.NewDecoder(response.Body).Decode(&got)
+			ids := make([]int64, 0, len(got))
+			for _, msg := range got {
+				ids = append(ids, msg.Id)
+			}
+			tester.AssertValue(t, ids, c.want, "message ids")
+		})
+	}
+
+	invalid := []struct {
+		name  string
+		query string
+	}{
+		{name: "it 422 if before and after are used together", query: "?before=4&after=2"},
+		{name: "it 422 if limit is out of range", query: "?limit=0"},
+		{name: "it 422 if limit is not a number", query: "?limit=abc"},
+		{name: "it 422 if cursor doesn't exist", query: "?before=100"},
+	}
+	for _, c := range invalid {
+		t.Run(c.name, func(t *testing.T) {
+			response := getMessages(t, app, "/v1/conversations/1/messages"+c.query)
+			tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		})
+	}
+}
+
+func getMessages(t *testing.T, app http.Handler, url string) *httptest.ResponseRecorder {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	tester.AssertNoError(t, err)
+	request.Header.Set("Authorization", "Bearer "+strings.Repeat("1", 26))
+	response := httptest.NewRecorder()
+	app.ServeHTTP(response, request)
+	return response
+}
+
+func TestMessageChanges(t *testing.T) {
+	cfg := app.Config{Port: 4000, Env: "development"}
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	userModel := data.NewStubUserModel(generateUsers(3))
+	conversations := generateConversation(2)
+	conversationModel := data.NewStubConversationModel(conversations, userModel)
+	messageModel := data.NewStubMessageModel(conversations, []data.Message{
+		{ConversationId: 1, Content: "first", SenderId: 1, CreatedAt: time.Now()},
+		{ConversationId: 1, Content: "old", SenderId: 1, CreatedAt: time.Now().Add(-time.Hour)},
+		{ConversationId: 1, Content: "other", SenderId: 2, CreatedAt: time.Now()},
+		{ConversationId: 1, Content: "to delete", SenderId: 1, CreatedAt: time.Now()},
+	})
+	models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel}
+	app := app.New(cfg, logger, models)
+
+	t.Run("it PATCH message content and keeps edit history", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, app, http.MethodPatch, "/v1/messages/1", "1", data.PatchMessageDto{Content: "edited"})
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		var got data.Message
+		json.NewDecoder(response.Body).Decode(&got)
+		tester.AssertValue(t, got.Content, "edited", "Expected new content")
+		if got.EditedAt == nil {
+			t.Fatal("Expected message to be marked as edited")
+		}
+
+		response = sendAuthorizedRequest(t, app, http.MethodGet, "/v1/messages/1/edits", "2", nil)
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		var edits []data.MessageEdit
+		json.NewDecoder(response.Body).Decode(&edits)
+		tester.AssertValue(t, len(edits), 1, "Expected one edit")
+		tester.AssertValue(t, edits[0].Content, "first", "Expected previous content in history")
+	})
+
+	t.Run("it 422 if content is empty", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, app, http.MethodPatch, "/v1/messages/1", "1", data.PatchMessageDto{})
+		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("it 403 if user is not the author", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, app, http.MethodPatch, "/v1/messages/3", "1", data.PatchMessageDto{Content: "edited"})
+		tester.AssertStatus(t, response.Code, http.StatusForbidden)
+		response = sendAuthorizedRequest(t, app, http.MethodDelete, "/v1/messages/3", "1", nil)
+		tester.AssertStatus(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("it 403 if edit window has expired", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, app, http.MethodPatch, "/v1/messages/2", "1", data.PatchMessageDto{Content: "edited"})
+		tester.AssertStatus(t, response.Code, http.StatusForbidden)
+		response = sendAuthorizedRequest(t, app, http.MethodDelete, "/v1/messages/2", "1", nil)
+		tester.AssertStatus(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("it 404 edit history if user is not in conversation", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, app, http.MethodGet, "/v1/messages/1/edits", "3", nil)
+		tester.AssertStatus(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("it DELETE message leaving a tombstone", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, app, http.MethodDelete, "/v1/messages/4", "1", nil)
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		var got data.Message
+		json.NewDecoder(response.Body).Decode(&got)
+		tester.AssertValue(t, got.Content, "", "Expected content to be erased")
+		if got.DeletedAt == nil {
+			t.Fatal("Expected message to be marked as deleted")
+		}
+
+		response = sendAuthorizedRequest(t, app, http.MethodGet, "/v1/conversations/1/messages", "1", nil)
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		var history []data.Message
+		json.NewDecoder(response.Body).Decode(&history)
+		tombstone := history[len(history)-1]
+		tester.AssertValue(t, tombstone.Id, int64(4), "Expected tombstone to stay in history")
+		tester.AssertValue(t, tombstone.PrevMessageId, int64(0), "Expected prev message id to be kept")
+		if tombstone.DeletedAt == nil {
+			t.Fatal("Expected tombstone in history")
+		}
+	})
+
+	t.Run("it 404 if message is already deleted", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, app, http.MethodPatch, "/v1/messages/4", "1", data.PatchMessageDto{Content: "edited"})
+		tester.AssertStatus(t, response.Code, http.StatusNotFound)
+		response = sendAuthorizedRequest(t, app, http.MethodDelete, "/v1/messages/4", "1", nil)
+		tester.AssertStatus(t, response.Code, http.StatusNotFound)
+	})
+}
+
+func sendAuthorizedRequest(t *testing.T, app http.Handler, method, url, userId string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		tester.AssertNoError(t, err)
+		reader = bytes.NewReader(js)
+	}
+	request, err := http.NewRequest(method, url, reader)
+	tester.AssertNoError(t, err)
+	request.Header.Set("Authorization", "Bearer "+strings.Repeat(userId, 26))
+	response := httptest.NewRecorder()
+	app.ServeHTTP(response, request)
+	return response
+}
+
+func TestMessageSearch(t *testing.T) {
+	cfg := app.Config{Port: 4000, Env: "development"}
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	users := generateUsers(3)
+	userModel := data.NewStubUserModel(users)
+	conversations := []data.Conversation{
+		{Id: 1, Users: []data.User{users[0], users[1]}},
+		{Id: 2, Users: []data.User{users[0], users[2]}},
+	}
+	conversationModel := data.NewStubConversationModel(conversations, userModel)
+	messageModel := data.NewStubMessageModel(conversations, []data.Message{
+		{ConversationId: 1, Content: "we agreed about the Friday delivery", SenderId: 1},
+		{ConversationId: 1, Content: "invoice is sent", SenderId: 2},
+		{ConversationId: 2, Content: "Friday delivery is late", SenderId: 3},
+		{ConversationId: 2, Content: "<script>alert('invoice')</script> is late", SenderId: 3},
+	})
+	models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel}
+	app := app.New(cfg, logger, models)
+
+	cases := []struct {
+		name   string
+		userId string
+		query  string
+		want   int
+	}{
+		{name: "it finds messages in all user conversations", userId: "1", query: "?q=friday+delivery", want: 2},
+		{name: "it doesn't find messages of other conversations", userId: "2", query: "?q=friday+delivery", want: 1},
+		{name: "it filters by conversation", userId: "1", query: "?q=friday&conversationId=2", want: 1},
+		{name: "it paginates results", userId: "1", query: "?q=friday&page=2&pageSize=1", want: 1},
+		{name: "it returns empty page after results", userId: "1", query: "?q=friday&page=3&pageSize=1", want: 0},
+		{name: "it requires all words to match", userId: "1", query: "?q=friday+invoice", want: 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			response := sendAuthorizedRequest(t, app, http.MethodGet, "/v1/messages/search"+c.query, c.userId, nil)
+			tester.AssertStatus(t, response.Code, http.StatusOK)
+			var got []data.MessageSearchResult
+			json.NewDecoder(response.Body).Decode(&got)
+			tester.AssertValue(t, len(got), c.want, "Expected number of results")
+			for _, result := range got {
+				if !strings.Contains(result.Snippet, data.SnippetStartSel+"Friday"+data.SnippetStopSel) {
+					t.Errorf("Expected highlighted snippet, got %v", result.Snippet)
+				}
+			}
+		})
+	}
+
+	t.Run("it escapes html in snippet", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, app, http.MethodGet, "/v1/messages/search?q=late&conversationId=2", "1", nil)
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		var got []data.MessageSearchResult
+		json.NewDecoder(response.Body).Decode(&got)
+		tester.AssertValue(t, len(got), 2, "Expected number of results")
+		snippet := got[0].Snippet
+		tester.AssertValue(t, snippet, "&lt;script&gt;alert(&#39;invoice&#39;)&lt;/script&gt; is "+data.SnippetStartSel+"late"+data.SnippetStopSel, "Expected escaped snippet")
+	})
+
+	t.Run("it 422 if query is empty", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, app, http.MethodGet, "/v1/messages/search?q=+", "1", nil)
+		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("it 422 if page size is out of range", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, app, http.MethodGet, "/v1/messages/search?q=friday&pageSize=1000", "1", nil)
+		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+	})
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/validator"
+)
+
+func (a *Application) handleGetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	preferences, err := a.models.Notification.GetPreferences(user.Id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	writeJsonResponse(w, http.StatusOK, preferences, nil)
+}
+
+// handlePutNotificationPreferences replaces the email notification preferences of the user
+func (a *Application) handlePutNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var preferences data.NotificationPreferences
+	err = readJsonFromBody(w, r, &preferences)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateNotificationPreferences(v, preferences); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	preferences.UserId = user.Id
+	err = a.models.Notification.UpdatePreferences(preferences)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	writeJsonResponse(w, http.StatusOK, preferences, nil)
+}
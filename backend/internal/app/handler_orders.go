@@ -27,45 +27,24 @@ func (a *Application) handlePostOrder(w http.ResponseWriter, r *http.Request) {
 		a.badRequestResponse(w, r, err)
 		return
 	}
-	dto.ClientId = user.Id
 	v := validator.New()
-	if data.ValidatePostOrderInput(v, dto); !v.Valid() {
-		a.failedValidationResponse(w, r, v.Errors)
-		return
-	}
-	order, err := a.models.Order.Insert(dto)
+	order, err := a.createOrder(user, dto, v)
 	if err != nil {
 		switch {
-		case errors.Is(err, data.ErrUnprocessableEntity):
-			v.AddError("itemIds", "At least one of order items doesn't exist")
+		case errors.Is(err, ErrFailedValidation):
 			a.failedValidationResponse(w, r, v.Errors)
-		default:
-			a.serverErrorResponse(w, r, err)
-		}
-		return
-	}
-	msg, err := a.models.Message.GetById(order.MessageId)
-	if err != nil {
-		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			a.notFoundResponse(w, r)
+		case errors.Is(err, ErrNotPermitted):
+			a.forbiddenResponse(w, r)
+		case errors.Is(err, ErrNotActivated):
+			a.inactiveAccountResponse(w, r)
 		default:
 			a.serverErrorResponse(w, r, err)
 		}
 		return
 	}
-	client, err := a.models.User.GetById(msg.SenderId)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			a.notFoundResponse(w, r)
-		default:
-			a.serverErrorResponse(w, r, err)
-		}
-		return
-	}
-	order.Client = client
-	writeJsonResponse(w, http.StatusCreated, order, nil)
+	writeJsonResponse(w, http.StatusCreated, order, etagHeader(order.Version))
 }
 
 func (a *Application) handleGetOrder(w http.ResponseWriter, r *http.Request) {
@@ -111,7 +90,7 @@ func (a *Application) handleGetOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	order.Client = client
-	writeJsonResponse(w, http.StatusOK, order, nil)
+	writeJsonResponse(w, http.StatusOK, order, etagHeader(order.Version))
 }
 
 func (a *Application) handleGetAllOrders(w http.ResponseWriter, r *http.Request) {
@@ -168,7 +147,7 @@ func (a *Application) handleGetAllOrders(w http.ResponseWriter, r *http.Request)
 }
 
 func (a *Application) handlePatchOrder(w http.ResponseWriter, r *http.Request) {
-	_, err := a.AuthenticateHttpRequest(w, r)
+	user, err := a.AuthenticateHttpRequest(w, r)
 	if err != nil {
 		switch {
 		case errors.Is(err, ErrUnathorized):
@@ -186,54 +165,146 @@ func (a *Application) handlePatchOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	v := validator.New()
-	if data.ValidatePatchOrderInput(v, dto); !v.Valid() {
-		a.failedValidationResponse(w, r, v.Errors)
-		return
-	}
-	order, err := a.models.Order.GetById(orderId)
+	matches := func(version int) bool { return matchesIfMatch(r, version) }
+	order, err := a.changeOrder(user, orderId, dto, matches, v)
 	if err != nil {
 		switch {
+		case errors.Is(err, ErrFailedValidation):
+			a.failedValidationResponse(w, r, v.Errors)
 		case errors.Is(err, data.ErrRecordNotFound):
 			a.notFoundResponse(w, r)
+		case errors.Is(err, ErrPreconditionFailed):
+			a.preconditionFailedResponse(w, r)
+		case errors.Is(err, ErrNotPermitted):
+			a.forbiddenResponse(w, r)
+		case errors.Is(err, ErrNotActivated):
+			a.inactiveAccountResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
 		default:
 			a.serverErrorResponse(w, r, err)
 		}
 		return
 	}
-	// TODO: if no permission to accept return 403
-	// TODO: if not in conversation return 403
-	if dto.Items != nil {
-		order.Items = dto.Items
-		// TODO: change status to supplier/client chages
-	} else {
-		order.StateId = dto.StateId
+	writeJsonResponse(w, http.StatusOK, order, etagHeader(order.Version))
+}
+
+// createOrder is shared by the REST and websocket APIs
+func (a *Application) createOrder(user data.User, dto data.PostOrderDto, v *validator.Validator) (data.Order, error) {
+	dto.ClientId = user.Id
+	if data.ValidatePostOrderInput(v, dto); !v.Valid() {
+		return data.Order{}, ErrFailedValidation
+	}
+	if err := a.requireActivated(user); err != nil {
+		return data.Order{}, err
 	}
-	// TODO: update message message
-	updatedOrder, err := a.models.Order.Update(order)
+	conversation, err := a.models.Conversation.GetById(dto.ConversationId)
 	if err != nil {
-		a.serverErrorResponse(w, r, err)
-		return
+		return data.Order{}, err
+	}
+	if !isConversationMember(conversation, user.Id) {
+		return data.Order{}, ErrNotPermitted
+	}
+	permissions, err := a.models.Permission.GetAllForType(int64(user.Type))
+	if err != nil {
+		return data.Order{}, err
+	}
+	if !permissions.Include(data.PermissionCreateOrder) {
+		return data.Order{}, ErrNotPermitted
+	}
+	order, err := a.models.Order.Insert(dto, func(order data.Order) data.DomainEvent {
+		order.Client = user
+		event := data.NewDomainEvent(data.DomainOrderCreated, order)
+		event.ConversationId = conversation.Id
+		event.UserId = user.Id
+		return event
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnprocessableEntity):
+			v.AddError("itemIds", "At least one of order items doesn't exist")
+			return data.Order{}, ErrFailedValidation
+		default:
+			return data.Order{}, err
+		}
+	}
+	a.relay.notify()
+	order.Client = user
+	return order, nil
+}
+
+// changeOrder is shared by the REST and websocket APIs, matches checks the version
+// of the stored order and can be nil
+func (a *Application) changeOrder(user data.User, orderId int64, dto data.PatchOrderDto, matches func(version int) bool, v *validator.Validator) (data.Order, error) {
+	if data.ValidatePatchOrderInput(v, dto); !v.Valid() {
+		return data.Order{}, ErrFailedValidation
+	}
+	if dto.StateId == data.OrderStateAccepted {
+		if err := a.requireActivated(user); err != nil {
+			return data.Order{}, err
+		}
+	}
+	order, err := a.models.Order.GetById(orderId)
+	if err != nil {
+		return data.Order{}, err
+	}
+	if matches != nil && !matches(order.Version) {
+		return data.Order{}, ErrPreconditionFailed
 	}
 	msg, err := a.models.Message.GetById(order.MessageId)
 	if err != nil {
+		return data.Order{}, err
+	}
+	conversation, err := a.models.Conversation.GetById(msg.ConversationId)
+	if err != nil {
+		return data.Order{}, err
+	}
+	if !isConversationMember(conversation, user.Id) {
+		return data.Order{}, ErrNotPermitted
+	}
+	permissions, err := a.models.Permission.GetAllForType(int64(user.Type))
+	if err != nil {
+		return data.Order{}, err
+	}
+	// permission codes are the ids of order states the user can set,
+	// changing items sets the state of changes made by the user type
+	if len(dto.Items) > 0 {
 		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			a.notFoundResponse(w, r)
+		case permissions.Include(data.PermissionSupplierChangesOrder):
+			order.StateId = data.OrderStateSupplierChanges
+		case permissions.Include(data.PermissionClientChangesOrder):
+			order.StateId = data.OrderStateClientChanges
 		default:
-			a.serverErrorResponse(w, r, err)
+			return data.Order{}, ErrNotPermitted
 		}
-		return
+		order.Items = dto.Items
+	} else {
+		if !permissions.Include(int(dto.StateId)) {
+			return data.Order{}, ErrNotPermitted
+		}
+		order.StateId = dto.StateId
 	}
 	client, err := a.models.User.GetById(msg.SenderId)
+	if err != nil {
+		return data.Order{}, err
+	}
+	order, err = a.models.Order.Update(order, func(order data.Order) data.DomainEvent {
+		order.Client = client
+		event := data.NewDomainEvent(data.DomainOrderUpdated, order)
+		event.ConversationId = conversation.Id
+		event.UserId = user.Id
+		return event
+	})
 	if err != nil {
 		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			a.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrUnprocessableEntity):
+			v.AddError("itemIds", "At least one of order items doesn't exist")
+			return data.Order{}, ErrFailedValidation
 		default:
-			a.serverErrorResponse(w, r, err)
+			return data.Order{}, err
 		}
-		return
 	}
-	updatedOrder.Client = client
-	writeJsonResponse(w, http.StatusOK, updatedOrder, nil)
+	a.relay.notify()
+	order.Client = client
+	return order, nil
 }
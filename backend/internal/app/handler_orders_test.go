@@ -36,8 +36,10 @@ func TestOrderPost(t *testing.T) {
 		},
 	})
 	userModel := data.NewStubUserModel(generateUsers(4))
-	conversationModel := data.NewStubConversationModel(generateConversation(4), userModel)
-	messageModel := data.NewStubMessageModel(generateConversation(4), []data.Message{{Id: 1, ConversationId: 1, PrevMessageId: 0}})
+	// the last conversation is between suppliers
+	conversations := append(generateConversation(4), data.Conversation{Id: 7, Users: []data.User{{Id: 2}, {Id: 4}}})
+	conversationModel := data.NewStubConversationModel(conversations, userModel)
+	messageModel := data.NewStubMessageModel(conversations, []data.Message{{Id: 1, ConversationId: 1, PrevMessageId: 0}})
 	orderModel := data.NewStubOrderModel([]data.Order{}, itemModel, conversationModel, messageModel)
 	models := data.Models{
 		Conversation: conversationModel,
@@ -45,6 +47,7 @@ func TestOrderPost(t *testing.T) {
 		Item:         itemModel,
 		Message:      messageModel,
 		Order:        orderModel,
+		Permission:   data.NewStubPermissionsModel(),
 	}
 	server := app.New(cfg, logger, models)
 
@@ -82,6 +85,7 @@ func TestOrderPost(t *testing.T) {
 			},
 			StateId:   data.OrderStateCreated,
 			MessageId: int64(len(messages) - 1), // order attached to last message
+			Version:   1,
 		}
 
 		tester.AssertStatus(t, response.Code, http.StatusCreated)
@@ -130,7 +134,47 @@ func TestOrderPost(t *testing.T) {
 	})
 
 	t.Run("it 403 if POST order to not own conversation", func(t *testing.T) {
-		// TODO: implement
+		clientId := int64(1)
+		dto := data.PostOrderDto{
+			ConversationId: 7,
+			Items:          []data.ItemQuantity{{ItemId: 1, Quantity: 1}},
+		}
+		wantOrderCount := countUserOrder(t, orderModel, clientId)
+		request := createPostOrderRequest(t, dto, clientId)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		tester.AssertStatus(t, response.Code, http.StatusForbidden)
+		tester.AssertValue(t, countUserOrder(t, orderModel, clientId), wantOrderCount, "Expected to not have new orders")
+	})
+
+	t.Run("it 403 if supplier POST order", func(t *testing.T) {
+		supplierId := int64(2)
+		dto := data.PostOrderDto{
+			ConversationId: 1,
+			Items:          []data.ItemQuantity{{ItemId: 1, Quantity: 1}},
+		}
+		request := createPostOrderRequest(t, dto, supplierId)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		tester.AssertStatus(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("it 403 if unactivated client POST order", func(t *testing.T) {
+		clientId := int64(1)
+		deactivateUser(t, userModel, clientId)
+		dto := data.PostOrderDto{
+			ConversationId: 1,
+			Items:          []data.ItemQuantity{{ItemId: 1, Quantity: 1}},
+		}
+		wantOrderCount := countUserOrder(t, orderModel, clientId)
+		request := createPostOrderRequest(t, dto, clientId)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		tester.AssertStatus(t, response.Code, http.StatusForbidden)
+		tester.AssertValue(t, countUserOrder(t, orderModel, clientId), wantOrderCount, "Expected to not have new orders")
 	})
 }
 
@@ -161,6 +205,7 @@ func TestOrderGet(t *testing.T) {
 		Item:         itemModel,
 		Message:      messageModel,
 		Order:        orderModel,
+		Permission:   data.NewStubPermissionsModel(),
 	}
 	server := app.New(cfg, logger, models)
 
@@ -179,7 +224,7 @@ func TestOrderGet(t *testing.T) {
 				},
 			},
 		}
-		want, err := orderModel.Insert(dto)
+		want, err := orderModel.Insert(dto, nil)
 		tester.AssertNoError(t, err)
 
 		request := createGetOrderRequest(t, 1, 1)
@@ -241,6 +286,7 @@ func TestOrderGetAll(t *testing.T) {
 		Item:         itemModel,
 		Message:      messageModel,
 		Order:        orderModel,
+		Permission:   data.NewStubPermissionsModel(),
 	}
 	server := app.New(cfg, logger, models)
 
@@ -261,9 +307,9 @@ func TestOrderGetAll(t *testing.T) {
 		}
 		client, err := userModel.GetById(1)
 		tester.AssertNoError(t, err)
-		order1, err := orderModel.Insert(dto)
+		order1, err := orderModel.Insert(dto, nil)
 		tester.AssertNoError(t, err)
-		order2, err := orderModel.Insert(dto)
+		order2, err := orderModel.Insert(dto, nil)
 		tester.AssertNoError(t, err)
 		order1.Client = client
 		order2.Client = client
@@ -336,6 +382,7 @@ func TestOrderPatch(t *testing.T) {
 		Item:         itemModel,
 		Message:      messageModel,
 		Order:        orderModel,
+		Permission:   data.NewStubPermissionsModel(),
 	}
 	server := app.New(cfg, logger, models)
 
@@ -362,8 +409,78 @@ func TestOrderPatch(t *testing.T) {
 
 		want := testOrder
 		want.StateId = data.OrderStateAccepted
+		want.Version = testOrder.Version + 1
 		tester.AssertStatus(t, response.Code, http.StatusOK)
 		assertContentType(t, response, app.JsonContentType)
+		tester.AssertValue(t, response.Header().Get("ETag"), fmt.Sprintf(`"%d"`, want.Version), "Expected ETag with new version")
+		got := tester.ParseResponse[data.Order](t, response)
+		assertOrder(t, got, want)
+		assertOrderInModel(t, orderModel, got.Id, want)
+	})
+
+	t.Run("it 412 if PATCH order with stale If-Match", func(t *testing.T) {
+		supplierId := int64(2)
+		before, err := orderModel.GetById(testOrder.Id)
+		tester.AssertNoError(t, err)
+		dto := data.PatchOrderDto{
+			StateId: data.OrderStateFulfilled,
+		}
+		request := createPatchOrderRequest(t, dto, supplierId, testOrder.Id)
+		request.Header.Set("If-Match", fmt.Sprintf(`"%d"`, before.Version+1))
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		tester.AssertStatus(t, response.Code, http.StatusPreconditionFailed)
+		assertOrderInModel(t, orderModel, testOrder.Id, before)
+	})
+
+	t.Run("it 403 if client PATCH order state to accepted", func(t *testing.T) {
+		clientId := int64(1)
+		before, err := orderModel.GetById(testOrder.Id)
+		tester.AssertNoError(t, err)
+		dto := data.PatchOrderDto{
+			StateId: data.OrderStateAccepted,
+		}
+		request := createPatchOrderRequest(t, dto, clientId, testOrder.Id)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		tester.AssertStatus(t, response.Code, http.StatusForbidden)
+		assertOrderInModel(t, orderModel, testOrder.Id, before)
+	})
+
+	t.Run("it 403 if unactivated supplier PATCH order state to accepted", func(t *testing.T) {
+		supplierId := int64(2)
+		deactivateUser(t, userModel, supplierId)
+		before, err := orderModel.GetById(testOrder.Id)
+		tester.AssertNoError(t, err)
+		dto := data.PatchOrderDto{
+			StateId: data.OrderStateAccepted,
+		}
+		request := createPatchOrderRequest(t, dto, supplierId, testOrder.Id)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		tester.AssertStatus(t, response.Code, http.StatusForbidden)
+		assertOrderInModel(t, orderModel, testOrder.Id, before)
+	})
+
+	t.Run("it 200 if supplier PATCH order items as supplier changes", func(t *testing.T) {
+		supplierId := int64(2)
+		before, err := orderModel.GetById(testOrder.Id)
+		tester.AssertNoError(t, err)
+		dto := data.PatchOrderDto{
+			Items: []data.ItemQuantity{{ItemId: 1, Quantity: 3}},
+		}
+		request := createPatchOrderRequest(t, dto, supplierId, testOrder.Id)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		want := before
+		want.Items = dto.Items
+		want.StateId = data.OrderStateSupplierChanges
+		want.Version = before.Version + 1
+		tester.AssertStatus(t, response.Code, http.StatusOK)
 		got := tester.ParseResponse[data.Order](t, response)
 		assertOrder(t, got, want)
 		assertOrderInModel(t, orderModel, got.Id, want)
@@ -449,6 +566,19 @@ func TestOrderPatch(t *testing.T) {
 // 	}
 // }
 
+// deactivateUser makes the user unactivated until the end of the test
+func deactivateUser(t *testing.T, userModel *data.StubUserModel, userId int64) {
+	t.Helper()
+	user, err := userModel.GetById(userId)
+	tester.AssertNoError(t, err)
+	user.Activated = false
+	tester.AssertNoError(t, userModel.Update(user))
+	t.Cleanup(func() {
+		user.Activated = true
+		userModel.Update(user)
+	})
+}
+
 func createPostOrderRequest(t *testing.T, dto data.PostOrderDto, clientId int64) *http.Request {
 	requestBody := new(bytes.Buffer)
 	json.NewEncoder(requestBody).Encode(dto)
@@ -3,12 +3,21 @@ package app
 import (
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/vasiliiperfilev/cookie/internal/data"
 	"github.com/vasiliiperfilev/cookie/internal/validator"
 )
 
+// passwordResetTokenTTL is how long a password reset token can be used
+const passwordResetTokenTTL = 45 * time.Minute
+
+type passwordResetEmail struct {
+	User               data.User
+	PasswordResetToken string
+}
+
 type UserToken struct {
 	User  data.User  `json:"user"`
 	Token data.Token `json:"token"`
@@ -61,8 +70,8 @@ func (a *Application) handlePostToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Otherwise, if the password is correct, we generate a new token with a 24-hour
-	// expiry time and the scope 'authentication'.
-	token, err := a.models.Token.New(user.Id, 24*time.Hour, data.ScopeAuthentication)
+	// expiry time and the scope 'authentication', it is listed with the user agent.
+	token, err := a.models.Token.NewSession(user.Id, 24*time.Hour, r.UserAgent())
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -73,3 +82,182 @@ func (a *Application) handlePostToken(w http.ResponseWriter, r *http.Request) {
 		a.serverErrorResponse(w, r, err)
 	}
 }
+
+// handleGetTokens lists unexpired sessions of the user, the latest first
+func (a *Application) handleGetTokens(w http.ResponseWriter, r *http.Request) {
+	user, current, err := a.authenticateSession(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	tokens, err := a.models.Token.GetAllForUser(data.ScopeAuthentication, user.Id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	sessions := []data.Session{}
+	for _, token := range tokens {
+		sessions = append(sessions, data.NewSession(token, current.Hash))
+	}
+
+	writeJsonResponse(w, http.StatusOK, sessions, nil)
+}
+
+// handleDeleteCurrentToken logs out, it revokes the token of the request
+func (a *Application) handleDeleteCurrentToken(w http.ResponseWriter, r *http.Request) {
+	user, current, err := a.authenticateSession(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	a.revokeToken(w, r, user, current.Id)
+}
+
+// handleDeleteToken revokes a session of the user, like the one of a lost device
+func (a *Application) handleDeleteToken(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	tokenId, _ := strconv.ParseInt(getField(r, 0), 10, 64)
+	a.revokeToken(w, r, user, tokenId)
+}
+
+// revokeToken deletes the authentication token of the user and closes connections it authenticated
+func (a *Application) revokeToken(w http.ResponseWriter, r *http.Request, user data.User, tokenId int64) {
+	token, err := a.models.Token.Delete(data.ScopeAuthentication, user.Id, tokenId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	a.hub.revokeSessions(user.Id, token)
+
+	writeJsonResponse(w, http.StatusNoContent, nil, nil)
+}
+
+// handlePostActivationToken emails a new activation token to the unactivated user of the email,
+// the response is the same for unknown and activated users
+func (a *Application) handlePostActivationToken(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := readJsonFromBody(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	response := map[string]string{"message": "an email will be sent to you containing activation instructions"}
+
+	user, err := a.models.User.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			writeJsonResponse(w, http.StatusAccepted, response, nil)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.Activated {
+		writeJsonResponse(w, http.StatusAccepted, response, nil)
+		return
+	}
+
+	// only the latest activation token can be used
+	err = a.models.Token.DeleteAllForUser(data.ScopeActivation, user.Id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	token, err := a.models.Token.New(user.Id, activationTokenTTL, data.ScopeActivation)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	a.background(func() {
+		err := a.mailer.Send(user.Email, "token_activation.tmpl", welcomeEmail{User: user, ActivationToken: token.Plaintext})
+		if err != nil {
+			a.logger.Printf("Can't send activation email to user %v: %v", user.Id, err)
+		}
+	})
+
+	writeJsonResponse(w, http.StatusAccepted, response, nil)
+}
+
+// handlePostPasswordResetToken emails a password reset token to the user of the email,
+// the response is the same whether the email is registered or not
+func (a *Application) handlePostPasswordResetToken(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := readJsonFromBody(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	response := map[string]string{"message": "an email will be sent to you containing password reset instructions"}
+
+	user, err := a.models.User.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			writeJsonResponse(w, http.StatusAccepted, response, nil)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, err := a.models.Token.New(user.Id, passwordResetTokenTTL, data.ScopePasswordReset)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	a.background(func() {
+		err := a.mailer.Send(user.Email, "token_password_reset.tmpl", passwordResetEmail{User: user, PasswordResetToken: token.Plaintext})
+		if err != nil {
+			a.logger.Printf("Can't send password reset email to user %v: %v", user.Id, err)
+		}
+	})
+
+	writeJsonResponse(w, http.StatusAccepted, response, nil)
+}
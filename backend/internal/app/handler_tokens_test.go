@@ -3,17 +3,23 @@ package app_test
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/vasiliiperfilev/cookie/internal/app"
 	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/mailer"
 	"github.com/vasiliiperfilev/cookie/internal/tester"
 	"github.com/vasiliiperfilev/cookie/internal/validator"
+	"golang.org/x/exp/slices"
 )
 
 func TestPostToken(t *testing.T) {
@@ -53,6 +59,174 @@ func TestPostToken(t *testing.T) {
 	})
 }
 
+func TestPasswordReset(t *testing.T) {
+	email := "test@test.com"
+	password := "pa5$wOrd123"
+	newPassword := "n3w$Password"
+	sender := mailer.NewMemorySender()
+	cfg := app.Config{Port: 4000, Env: "development", MailSender: sender}
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	user := data.User{Id: 1, Email: email, Name: "test", Type: 1, ImageId: "id", Version: 1}
+	user.Password.Set(password)
+	tokenModel := data.NewStubTokenModel(generateTokens(1))
+	userModel := data.NewStubUserModel([]data.User{user})
+	userModel.SetTokenModel(tokenModel)
+	models := data.Models{User: userModel, Token: tokenModel}
+	server := app.New(cfg, logger, models)
+
+	t.Run("it responds the same to registered and unregistered emails", func(t *testing.T) {
+		unregistered := httptest.NewRecorder()
+		server.ServeHTTP(unregistered, createPasswordResetTokenRequest(t, "unknown@test.com"))
+		registered := httptest.NewRecorder()
+		server.ServeHTTP(registered, createPasswordResetTokenRequest(t, email))
+
+		tester.AssertStatus(t, unregistered.Code, http.StatusAccepted)
+		tester.AssertStatus(t, registered.Code, http.StatusAccepted)
+		tester.AssertValue(t, registered.Body.String(), unregistered.Body.String(), "Expected the same response")
+	})
+
+	resetToken := emailedToken(t, waitForEmail(t, sender, email, "Reset your Cookie password"))
+
+	t.Run("it 422 if PUT password which isn't strong enough", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, createPutPasswordRequest(t, "password", resetToken))
+		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("it sets the password and revokes authentication tokens", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, createPutPasswordRequest(t, newPassword, resetToken))
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+
+		_, err := tokenModel.Get(data.ScopeAuthentication, data.TokenHash(strings.Repeat("1", 26)))
+		tester.AssertValue(t, errors.Is(err, data.ErrRecordNotFound), true, "Expected revoked authentication token")
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, createLoginRequest(t, email, password))
+		tester.AssertStatus(t, response.Code, http.StatusUnauthorized)
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, createLoginRequest(t, email, newPassword))
+		tester.AssertStatus(t, response.Code, http.StatusCreated)
+	})
+
+	t.Run("it 422 if PUT used password reset token", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, createPutPasswordRequest(t, newPassword, resetToken))
+		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("it accepts the password reset token once for concurrent requests", func(t *testing.T) {
+		token, err := tokenModel.New(1, time.Hour, data.ScopePasswordReset)
+		tester.AssertNoError(t, err)
+		codes := make(chan int, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				response := httptest.NewRecorder()
+				server.ServeHTTP(response, createPutPasswordRequest(t, newPassword, token.Plaintext))
+				codes <- response.Code
+			}()
+		}
+		got := []int{<-codes, <-codes}
+		slices.Sort(got)
+		tester.AssertValue(t, got, []int{http.StatusOK, http.StatusUnprocessableEntity}, "Expected one request to reset the password")
+	})
+}
+
+func TestSessions(t *testing.T) {
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	userModel := data.NewStubUserModel(generateUsers(2))
+	conversations := generateConversation(2)
+	tokenModel := data.NewStubTokenModel(generateTokens(2))
+	models := data.Models{
+		Message:      data.NewStubMessageModel(conversations, []data.Message{}),
+		User:         userModel,
+		Conversation: data.NewStubConversationModel(conversations, userModel),
+		Token:        tokenModel,
+	}
+	server := httptest.NewServer(app.New(app.Config{Port: 4000, Env: "development"}, logger, models))
+	defer server.Close()
+	token := strings.Repeat("1", 26)
+	phone, err := tokenModel.NewSession(1, time.Hour, "phone")
+	tester.AssertNoError(t, err)
+
+	t.Run("it lists sessions of the user", func(t *testing.T) {
+		response, err := http.DefaultClient.Do(newAuthRequest(t, http.MethodGet, server.URL+"/v1/tokens", token, nil))
+		tester.AssertNoError(t, err)
+		defer response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusOK)
+		var sessions []data.Session
+		tester.AssertNoError(t, json.NewDecoder(response.Body).Decode(&sessions))
+		tester.AssertValue(t, len(sessions), 2, "Expected sessions of the user only")
+		tester.AssertValue(t, sessions[0].Id, phone.Id, "Expected the latest session first")
+		tester.AssertValue(t, sessions[0].UserAgent, "phone", "Expected user agent of the session")
+		tester.AssertValue(t, sessions[0].Current, false, "Expected other session")
+		tester.AssertValue(t, sessions[1].Current, true, "Expected current session")
+	})
+
+	t.Run("it 404 if DELETE session of another user", func(t *testing.T) {
+		others, err := tokenModel.GetAllForUser(data.ScopeAuthentication, 2)
+		tester.AssertNoError(t, err)
+		url := fmt.Sprintf("%v/v1/tokens/%v", server.URL, others[0].Id)
+		response, err := http.DefaultClient.Do(newAuthRequest(t, http.MethodDelete, url, token, nil))
+		tester.AssertNoError(t, err)
+		response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusNotFound)
+	})
+
+	t.Run("it closes connections of the revoked session", func(t *testing.T) {
+		ws := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", phone.Plaintext)
+		defer ws.Close()
+		url := fmt.Sprintf("%v/v1/tokens/%v", server.URL, phone.Id)
+		response, err := http.DefaultClient.Do(newAuthRequest(t, http.MethodDelete, url, token, nil))
+		tester.AssertNoError(t, err)
+		response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusNoContent)
+
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		_, _, err = ws.ReadMessage()
+		if !websocket.IsCloseError(err, app.CloseUnauthorized) {
+			t.Fatalf("Expected close with code %v, got %v", app.CloseUnauthorized, err)
+		}
+		_, err = tokenModel.Get(data.ScopeAuthentication, phone.Hash)
+		tester.AssertValue(t, errors.Is(err, data.ErrRecordNotFound), true, "Expected revoked token")
+	})
+
+	t.Run("it logs out", func(t *testing.T) {
+		response, err := http.DefaultClient.Do(newAuthRequest(t, http.MethodDelete, server.URL+"/v1/tokens/current", token, nil))
+		tester.AssertNoError(t, err)
+		response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusNoContent)
+
+		response, err = http.DefaultClient.Do(newAuthRequest(t, http.MethodGet, server.URL+"/v1/tokens", token, nil))
+		tester.AssertNoError(t, err)
+		response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusUnauthorized)
+	})
+}
+
+func createPasswordResetTokenRequest(t *testing.T, email string) *http.Request {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"email": email})
+	request, err := http.NewRequest(http.MethodPost, "/v1/tokens/password-reset", bytes.NewReader(body))
+	tester.AssertNoError(t, err)
+	return request
+}
+
+func createPutPasswordRequest(t *testing.T, password string, token string) *http.Request {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"password": password, "token": token})
+	request, err := http.NewRequest(http.MethodPut, "/v1/users/password", bytes.NewReader(body))
+	tester.AssertNoError(t, err)
+	return request
+}
+
+func createLoginRequest(t *testing.T, email string, password string) *http.Request {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"email": email, "password": password})
+	request, err := http.NewRequest(http.MethodPost, "/v1/tokens", bytes.NewReader(body))
+	tester.AssertNoError(t, err)
+	return request
+}
+
 func assertTokenResponse(t *testing.T, body *bytes.Buffer, userId int64) {
 	t.Helper()
 	var got app.UserToken
@@ -3,11 +3,21 @@ package app
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/vasiliiperfilev/cookie/internal/data"
 	"github.com/vasiliiperfilev/cookie/internal/validator"
 )
 
+// activationTokenTTL is how long new users have to verify their email
+const activationTokenTTL = 3 * 24 * time.Hour
+
+type welcomeEmail struct {
+	User            data.User
+	ActivationToken string
+}
+
+// handlePostUser registers an unactivated user and emails them the activation token
 func (a *Application) handlePostUser(w http.ResponseWriter, r *http.Request) {
 	registerUserInput := new(data.PostUserDto)
 	err := readJsonFromBody(w, r, registerUserInput)
@@ -46,9 +56,118 @@ func (a *Application) handlePostUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	token, err := a.models.Token.New(user.Id, activationTokenTTL, data.ScopeActivation)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	a.background(func() {
+		err := a.mailer.Send(user.Email, "user_welcome.tmpl", welcomeEmail{User: user, ActivationToken: token.Plaintext})
+		if err != nil {
+			a.logger.Printf("Can't send welcome email to user %v: %v", user.Id, err)
+		}
+	})
+
+	writeJsonResponse(w, http.StatusOK, user, nil)
+}
+
+// handlePutUserActivated activates the user of the activation token, the token can be used once
+func (a *Application) handlePutUserActivated(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token string `json:"token"`
+	}
+	err := readJsonFromBody(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.Token); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := a.models.Token.Consume(data.ScopeActivation, input.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired activation token")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := a.models.User.GetById(token.UserId)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	user.Activated = true
+	err = a.models.User.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// other activation tokens of the user aren't needed anymore
+	err = a.models.Token.DeleteAllForUser(data.ScopeActivation, user.Id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
 	writeJsonResponse(w, http.StatusOK, user, nil)
 }
 
+// handlePutUserPassword sets the password of the user of the password reset token,
+// sessions of the user are revoked since the old password could be compromised
+func (a *Application) handlePutUserPassword(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+		Token    string `json:"token"`
+	}
+	err := readJsonFromBody(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.Token)
+	if !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the token is consumed with the password change, so it is used once, and sessions
+	// created meanwhile are revoked with the rest
+	user, sessions, err := a.models.User.ResetPassword(input.Token, input.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// connections authenticated by the sessions are closed with them
+	a.hub.revokeSessions(user.Id, sessions...)
+
+	writeJsonResponse(w, http.StatusOK, map[string]string{"message": "your password was successfully reset"}, nil)
+}
+
 func (a *Application) handleGetUsers(w http.ResponseWriter, r *http.Request) {
 	_, err := a.AuthenticateHttpRequest(w, r)
 	if err != nil {
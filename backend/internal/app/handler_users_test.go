@@ -8,11 +8,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"regexp"
 	"strings"
 	"testing"
 
 	"github.com/vasiliiperfilev/cookie/internal/app"
 	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/mailer"
 	"github.com/vasiliiperfilev/cookie/internal/tester"
 	"golang.org/x/exp/slices"
 )
@@ -21,7 +23,7 @@ import (
 func TestUserPost(t *testing.T) {
 	cfg := app.Config{Port: 4000, Env: "development"}
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
-	models := data.Models{User: data.NewStubUserModel([]data.User{})}
+	models := data.Models{User: data.NewStubUserModel([]data.User{}), Token: data.NewStubTokenModel([]data.Token{})}
 	server := app.New(cfg, logger, models)
 
 	t.Run("it allows registration with correct values", func(t *testing.T) {
@@ -88,6 +90,85 @@ func TestUserPost(t *testing.T) {
 	})
 }
 
+func TestUserActivation(t *testing.T) {
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	sender := mailer.NewMemorySender()
+	cfg := app.Config{Port: 4000, Env: "development", MailSender: sender}
+	userModel := data.NewStubUserModel([]data.User{})
+	models := data.Models{User: userModel, Token: data.NewStubTokenModel([]data.Token{})}
+	server := app.New(cfg, logger, models)
+	userInput := data.PostUserDto{
+		Email:    "new@nowhere.com",
+		Name:     "new",
+		Password: "test123!A",
+		Type:     data.UserTypeClient,
+		ImageId:  "imageid",
+	}
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, createRegisterRequest(t, new(bytes.Buffer), userInput))
+	tester.AssertStatus(t, response.Code, http.StatusOK)
+	registered := tester.ParseResponse[data.User](t, response)
+	tester.AssertValue(t, registered.Activated, false, "Expected unactivated user")
+
+	activationToken := emailedToken(t, waitForEmail(t, sender, userInput.Email, "Welcome"))
+
+	t.Run("it 422 if PUT invalid activation token", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, createActivationRequest(t, strings.Repeat("A", 26)))
+		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("it re-sends the activation token and invalidates the old one", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]string{"email": userInput.Email})
+		request, err := http.NewRequest(http.MethodPost, "/v1/tokens/activation", bytes.NewReader(body))
+		tester.AssertNoError(t, err)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		tester.AssertStatus(t, response.Code, http.StatusAccepted)
+
+		resent := emailedToken(t, waitForEmail(t, sender, userInput.Email, "Activate your Cookie account"))
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, createActivationRequest(t, activationToken))
+		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		activationToken = resent
+	})
+
+	t.Run("it activates the user of the token", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, createActivationRequest(t, activationToken))
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		got := tester.ParseResponse[data.User](t, response)
+		tester.AssertValue(t, got.Activated, true, "Expected activated user")
+		user, err := userModel.GetById(registered.Id)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, user.Activated, true, "Expected activated user in the model")
+	})
+
+	t.Run("it 422 if PUT used activation token", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, createActivationRequest(t, activationToken))
+		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+	})
+}
+
+// emailedToken returns the token of the JSON body the email tells to send
+func emailedToken(t *testing.T, email mailer.Message) string {
+	t.Helper()
+	matches := regexp.MustCompile(`"token": "([A-Z2-7]{26})"`).FindStringSubmatch(email.PlainBody)
+	if len(matches) != 2 {
+		t.Fatalf("Expected token in the email, got %q", email.PlainBody)
+	}
+	return matches[1]
+}
+
+func createActivationRequest(t *testing.T, token string) *http.Request {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"token": token})
+	request, err := http.NewRequest(http.MethodPut, "/v1/users/activated", bytes.NewReader(body))
+	tester.AssertNoError(t, err)
+	return request
+}
+
 func TestUserSearch(t *testing.T) {
 	cfg := app.Config{Port: 4000, Env: "development"}
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
@@ -0,0 +1,151 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/validator"
+)
+
+// handlePostWebhook subscribes the URL to the event types, the response is
+// the only one which has the secret of the webhook
+func (a *Application) handlePostWebhook(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var dto data.PostWebhookDto
+	err = readJsonFromBody(w, r, &dto)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidatePostWebhookInput(v, dto); !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	webhook := data.Webhook{UserId: user.Id, Url: dto.Url, EventTypes: dto.EventTypes}
+	err = a.models.Webhook.Insert(&webhook)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	writeJsonResponse(w, http.StatusCreated, webhook, nil)
+}
+
+func (a *Application) handleGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	webhooks, err := a.models.Webhook.GetAllByUserId(user.Id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	writeJsonResponse(w, http.StatusOK, webhooks, nil)
+}
+
+func (a *Application) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	webhookId, _ := strconv.ParseInt(getField(r, 0), 10, 64)
+	_, ok := a.getOwnWebhook(w, r, user, webhookId)
+	if !ok {
+		return
+	}
+	err = a.models.Webhook.Delete(webhookId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	writeJsonResponse(w, http.StatusNoContent, nil, nil)
+}
+
+// handles /v1/webhooks/([0-9]+)/deliveries?status=<status>&limit=<n> route,
+// it is the delivery log of the webhook, latest deliveries first
+func (a *Application) handleGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	status := qs.Get("status")
+	limit := readInt(qs, "limit", data.WebhookDeliveriesDefaultLimit, v)
+	v.Check(status == "" || validator.PermittedValue(status, data.DeliveryPending, data.DeliveryDelivered, data.DeliveryDead), "status", "must be pending, delivered or dead")
+	v.Check(limit > 0 && limit <= data.WebhookDeliveriesMaxLimit, "limit", "must be between 1 and 100")
+	if !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	webhookId, _ := strconv.ParseInt(getField(r, 0), 10, 64)
+	_, ok := a.getOwnWebhook(w, r, user, webhookId)
+	if !ok {
+		return
+	}
+	deliveries, err := a.models.Webhook.GetAllDeliveries(webhookId, status, int(limit))
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	writeJsonResponse(w, http.StatusOK, deliveries, nil)
+}
+
+// getOwnWebhook loads the webhook of the user,
+// it writes an error response and returns false otherwise
+func (a *Application) getOwnWebhook(w http.ResponseWriter, r *http.Request, user data.User, webhookId int64) (data.Webhook, bool) {
+	webhook, err := a.models.Webhook.GetById(webhookId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return data.Webhook{}, false
+	}
+	if webhook.UserId != user.Id {
+		a.forbiddenResponse(w, r)
+		return data.Webhook{}, false
+	}
+	return webhook, true
+}
@@ -6,6 +6,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/validator"
 )
 
 func writeJsonResponse(w http.ResponseWriter, status int, data any, headers http.Header) error {
@@ -84,3 +90,84 @@ func readJsonFromBody[T any](w http.ResponseWriter, r *http.Request, dst *T) err
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
 	return readJson(r.Body, dst)
 }
+
+// etag formats a record version as a strong entity tag
+func etag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+func etagHeader(version int) http.Header {
+	headers := http.Header{}
+	headers.Set("ETag", etag(version))
+	return headers
+}
+
+// matchesIfMatch reports whether the If-Match header of the request allows
+// modifying a record with the given version. Requests without the header match.
+func matchesIfMatch(r *http.Request, version int) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag(version) {
+			return true
+		}
+	}
+	return false
+}
+
+// readInt reads an integer query string value, it returns defaultValue if the key is absent
+// and records a validation error if the value can't be parsed
+func readInt(qs url.Values, key string, defaultValue int64, v *validator.Validator) int64 {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+	return i
+}
+
+// readBool reads a boolean query string value, it returns defaultValue if the key is absent
+// and records a validation error if the value can't be parsed
+func readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
+// background runs fn in a goroutine which logs the panic instead of crashing the server
+func (a *Application) background(fn func()) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				a.logger.Printf("%v", err)
+			}
+		}()
+		fn()
+	}()
+}
+
+// backoff is the delay after the failed attempt, it doubles with every attempt up to the limit
+func backoff(base time.Duration, limit time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		return limit
+	}
+	return delay
+}
@@ -0,0 +1,211 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/data"
+)
+
+const (
+	// notificationBatchSize is the number of notifications claimed at once,
+	// digests are claimed for this number of users
+	notificationBatchSize = 50
+	// notificationLease is how long claimed notifications aren't sent by other workers
+	notificationLease = time.Minute
+)
+
+// templates of emails by notification type
+var notificationTemplates = map[string]string{
+	data.NotificationOrderCreated:   "order_created.tmpl",
+	data.NotificationOrderUpdated:   "order_updated.tmpl",
+	data.NotificationUnreadMessages: "unread_messages.tmpl",
+}
+
+type orderEmail struct {
+	User  data.User
+	Order data.Order
+	State string
+}
+
+type unreadMessagesEmail struct {
+	User   data.User
+	Unread data.UnreadMessages
+}
+
+type digestEmail struct {
+	User  data.User
+	Lines []string
+}
+
+// notifier emails users about activity they could miss while they are offline,
+// notifications are queued and sent by every instance
+type notifier struct {
+	app *Application
+	// lastDigest is when this instance sent digests last time
+	lastDigest time.Time
+}
+
+func newNotifier(app *Application) *notifier {
+	return &notifier{app: app, lastDigest: time.Now()}
+}
+
+// handleDomainEvent queues notifications of orders for members of the conversation
+// other than the author of the change
+func (n *notifier) handleDomainEvent(event data.DomainEvent) error {
+	var notificationType string
+	switch event.Type {
+	case data.DomainOrderCreated:
+		notificationType = data.NotificationOrderCreated
+	case data.DomainOrderUpdated:
+		notificationType = data.NotificationOrderUpdated
+	default:
+		return nil
+	}
+	conversation, err := n.app.models.Conversation.GetById(event.ConversationId)
+	if err != nil {
+		return fmt.Errorf("load conversation %v: %w", event.ConversationId, err)
+	}
+	userIds := []int64{}
+	for _, user := range conversation.Users {
+		if user.Id != event.UserId {
+			userIds = append(userIds, user.Id)
+		}
+	}
+	return n.app.models.Notification.Enqueue(userIds, notificationType, event.Payload)
+}
+
+// run queues notifications of unread messages and sends notifications every poll interval,
+// digests are sent once per digest interval. Unread messages are looked for by one instance
+// per interval, it holds the lease for the interval.
+func (n *notifier) run() {
+	ticker := time.NewTicker(n.app.config.NotificationPollInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		err := n.app.models.Notification.EnqueueUnreadMessages(n.app.config.NotifyUnreadAfter, n.app.config.NotificationPollInterval)
+		if err != nil {
+			n.app.logger.Printf("Can't queue notifications of unread messages: %v", err)
+		}
+		n.sendPending()
+		// digests are sent at the start of every interval, daily digests at midnight UTC
+		if now.Truncate(n.app.config.DigestInterval).After(n.lastDigest) {
+			n.lastDigest = now
+			n.sendDigests()
+		}
+	}
+}
+
+func (n *notifier) sendPending() {
+	for {
+		notifications, err := n.app.models.Notification.ClaimPending(notificationBatchSize, notificationLease)
+		if err != nil {
+			n.app.logger.Printf("Can't claim notifications: %v", err)
+			return
+		}
+		sent := []int64{}
+		failed := []int64{}
+		for _, notification := range notifications {
+			if err := n.send(notification); err != nil {
+				n.app.logger.Printf("Can't send notification %v: %v", notification.Id, err)
+				failed = append(failed, notification.Id)
+				continue
+			}
+			sent = append(sent, notification.Id)
+		}
+		n.markFailed(failed)
+		if err := n.app.models.Notification.MarkSent(sent); err != nil {
+			n.app.logger.Printf("Can't mark notifications as sent: %v", err)
+			return
+		}
+		if len(notifications) < notificationBatchSize {
+			return
+		}
+	}
+}
+
+func (n *notifier) send(notification data.Notification) error {
+	var emailData any
+	switch notification.Type {
+	case data.NotificationOrderCreated, data.NotificationOrderUpdated:
+		var order data.Order
+		if err := json.Unmarshal(notification.Payload, &order); err != nil {
+			return err
+		}
+		emailData = orderEmail{User: notification.User, Order: order, State: data.OrderStateMessage[order.StateId]}
+	case data.NotificationUnreadMessages:
+		var unread data.UnreadMessages
+		if err := json.Unmarshal(notification.Payload, &unread); err != nil {
+			return err
+		}
+		emailData = unreadMessagesEmail{User: notification.User, Unread: unread}
+	default:
+		return fmt.Errorf("unknown notification type %v", notification.Type)
+	}
+	return n.app.mailer.Send(notification.User.Email, notificationTemplates[notification.Type], emailData)
+}
+
+// markFailed counts the failed attempt, notifications which failed every attempt are dead
+func (n *notifier) markFailed(ids []int64) {
+	if len(ids) == 0 {
+		return
+	}
+	err := n.app.models.Notification.MarkFailed(ids, n.app.config.NotificationAttempts, n.app.config.NotificationRetryDelay)
+	if err != nil {
+		n.app.logger.Printf("Can't mark notifications as failed: %v", err)
+	}
+}
+
+// sendDigests sends every user one email with their queued digest notifications
+func (n *notifier) sendDigests() {
+	for {
+		notifications, err := n.app.models.Notification.ClaimDigests(notificationBatchSize, notificationLease)
+		if err != nil {
+			n.app.logger.Printf("Can't claim digest notifications: %v", err)
+			return
+		}
+		if len(notifications) == 0 {
+			return
+		}
+		byUser := map[int64][]data.Notification{}
+		for _, notification := range notifications {
+			byUser[notification.UserId] = append(byUser[notification.UserId], notification)
+		}
+		for _, userNotifications := range byUser {
+			digest := digestEmail{User: userNotifications[0].User}
+			ids := []int64{}
+			for _, notification := range userNotifications {
+				digest.Lines = append(digest.Lines, digestLine(notification))
+				ids = append(ids, notification.Id)
+			}
+			err := n.app.mailer.Send(digest.User.Email, "digest.tmpl", digest)
+			if err != nil {
+				n.app.logger.Printf("Can't send digest of user %v: %v", digest.User.Id, err)
+				n.markFailed(ids)
+				continue
+			}
+			if err := n.app.models.Notification.MarkSent(ids); err != nil {
+				n.app.logger.Printf("Can't mark notifications as sent: %v", err)
+			}
+		}
+	}
+}
+
+func digestLine(notification data.Notification) string {
+	switch notification.Type {
+	case data.NotificationOrderCreated:
+		var order data.Order
+		json.Unmarshal(notification.Payload, &order)
+		return fmt.Sprintf("New order #%v from %v", order.Id, order.Client.Name)
+	case data.NotificationOrderUpdated:
+		var order data.Order
+		json.Unmarshal(notification.Payload, &order)
+		return fmt.Sprintf("Order #%v: %v", order.Id, data.OrderStateMessage[order.StateId])
+	case data.NotificationUnreadMessages:
+		var unread data.UnreadMessages
+		json.Unmarshal(notification.Payload, &unread)
+		return fmt.Sprintf("%v unread message(s) in conversation #%v", unread.UnreadCount, unread.ConversationId)
+	default:
+		return notification.Type
+	}
+}
@@ -0,0 +1,215 @@
+package app_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/app"
+	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/mailer"
+	"github.com/vasiliiperfilev/cookie/internal/tester"
+)
+
+func TestNotifications(t *testing.T) {
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	users := []data.User{
+		{Id: 1, Email: "client@test.com", Name: "client", Type: data.UserTypeClient, Activated: true},
+		{Id: 2, Email: "supplier@test.com", Name: "supplier", Type: data.UserTypeSupplier, Activated: true},
+	}
+	conversations := []data.Conversation{{Id: 1, Users: users}}
+	userModel := data.NewStubUserModel(users)
+	messageModel := data.NewStubMessageModel(conversations, []data.Message{})
+	conversationModel := data.NewStubConversationModel(conversations, userModel)
+	conversationModel.SetMessageModel(messageModel)
+	itemModel := data.NewStubItemModel([]data.Item{{Id: 1, SupplierId: 2}})
+	models := data.Models{
+		Message:      messageModel,
+		User:         userModel,
+		Conversation: conversationModel,
+		Item:         itemModel,
+		Order:        data.NewStubOrderModel([]data.Order{}, itemModel, conversationModel, messageModel),
+		Permission:   data.NewStubPermissionsModel(),
+		Token:        data.NewStubTokenModel(generateTokens(2)),
+		Notification: data.NewStubNotificationModel(userModel, conversationModel),
+	}
+	sender := mailer.NewMemorySender()
+	cfg := app.Config{
+		Port:                     4000,
+		Env:                      "development",
+		MailSender:               sender,
+		NotificationPollInterval: 10 * time.Millisecond,
+		NotifyUnreadAfter:        time.Millisecond,
+		DigestInterval:           100 * time.Millisecond,
+	}
+	server := httptest.NewServer(app.New(cfg, logger, models))
+	defer server.Close()
+	clientToken := strings.Repeat("1", 26)
+	supplierToken := strings.Repeat("2", 26)
+
+	t.Run("it emails the supplier about a new order", func(t *testing.T) {
+		postOrder(t, server.URL, clientToken)
+		email := waitForEmail(t, sender, "supplier@test.com", "New order #1 from client")
+		tester.AssertValue(t, strings.Contains(email.PlainBody, "Hi supplier"), true, "Expected greeting of the recipient")
+		tester.AssertValue(t, len(findEmails(sender, "client@test.com", "New order")), 0, "Expected no email to the author")
+	})
+
+	t.Run("it emails about unread messages", func(t *testing.T) {
+		response := postMessage(t, server.URL, supplierToken, 1, data.PostMessageDto{Content: "unread"})
+		response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusCreated)
+		waitForEmail(t, sender, "client@test.com", "You have 1 unread message(s)")
+	})
+
+	t.Run("it sends digests instead of instant emails", func(t *testing.T) {
+		putNotificationPreferences(t, server.URL, supplierToken, data.NotificationPreferences{Mode: data.NotifyDigest, Orders: true})
+		postOrder(t, server.URL, clientToken)
+		email := waitForEmail(t, sender, "supplier@test.com", "Your daily Cookie digest")
+		tester.AssertValue(t, strings.Contains(email.PlainBody, "New order #2 from client"), true, "Expected order in the digest")
+		tester.AssertValue(t, len(findEmails(sender, "supplier@test.com", "New order #2")), 0, "Expected no instant email")
+	})
+
+	t.Run("it doesn't email users who turned notifications off", func(t *testing.T) {
+		putNotificationPreferences(t, server.URL, supplierToken, data.NotificationPreferences{Mode: data.NotifyOff})
+		before := len(findEmails(sender, "supplier@test.com", ""))
+		postOrder(t, server.URL, clientToken)
+		time.Sleep(200 * time.Millisecond)
+		tester.AssertValue(t, len(findEmails(sender, "supplier@test.com", "")), before, "Expected no emails")
+	})
+
+	t.Run("it 422 if PUT unknown mode", func(t *testing.T) {
+		body, _ := json.Marshal(data.NotificationPreferences{Mode: "hourly"})
+		response, err := http.DefaultClient.Do(newAuthRequest(t, http.MethodPut, server.URL+"/v1/notifications/preferences", supplierToken, body))
+		tester.AssertNoError(t, err)
+		response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestNotificationDelivery(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	users := []data.User{
+		{Id: 1, Email: "client@test.com", Name: "client", Type: data.UserTypeClient, Activated: true},
+		{Id: 2, Email: "supplier@test.com", Name: "supplier", Type: data.UserTypeSupplier, Activated: true},
+	}
+	newServer := func(sender mailer.Sender, notificationModel *data.StubNotificationModel, userModel data.UserModel) *httptest.Server {
+		conversations := []data.Conversation{{Id: 1, Users: users}}
+		models := data.Models{
+			Message:      data.NewStubMessageModel(conversations, []data.Message{}),
+			User:         userModel,
+			Conversation: data.NewStubConversationModel(conversations, userModel),
+			Token:        data.NewStubTokenModel(generateTokens(2)),
+			Notification: notificationModel,
+		}
+		cfg := app.Config{
+			Port:                     4000,
+			Env:                      "development",
+			MailSender:               sender,
+			NotificationPollInterval: 10 * time.Millisecond,
+			NotificationAttempts:     3,
+			NotificationRetryDelay:   10 * time.Millisecond,
+			DigestInterval:           100 * time.Millisecond,
+		}
+		return httptest.NewServer(app.New(cfg, logger, models))
+	}
+	unread, _ := json.Marshal(data.UnreadMessages{ConversationId: 1, UnreadCount: 1})
+
+	t.Run("it stops sending notifications which failed every attempt", func(t *testing.T) {
+		userModel := data.NewStubUserModel(users)
+		notificationModel := data.NewStubNotificationModel(userModel, nil)
+		sender := &failingSender{}
+		server := newServer(sender, notificationModel, userModel)
+		defer server.Close()
+
+		tester.AssertNoError(t, notificationModel.Enqueue([]int64{1}, data.NotificationUnreadMessages, unread))
+		dead := tester.RetryUntil(time.Second, func() bool { return sender.count() == 3 })
+		tester.AssertValue(t, dead, true, "Expected every attempt to be made")
+		time.Sleep(100 * time.Millisecond)
+		tester.AssertValue(t, sender.count(), 3, "Expected no attempts after the last one")
+	})
+
+	t.Run("it sends one digest of more notifications than a batch", func(t *testing.T) {
+		userModel := data.NewStubUserModel(users)
+		notificationModel := data.NewStubNotificationModel(userModel, nil)
+		notificationModel.UpdatePreferences(data.NotificationPreferences{UserId: 2, Mode: data.NotifyDigest, Messages: true})
+		for i := 0; i < 60; i++ {
+			tester.AssertNoError(t, notificationModel.Enqueue([]int64{2}, data.NotificationUnreadMessages, unread))
+		}
+		sender := mailer.NewMemorySender()
+		server := newServer(sender, notificationModel, userModel)
+		defer server.Close()
+
+		email := waitForEmail(t, sender, "supplier@test.com", "Your daily Cookie digest")
+		tester.AssertValue(t, strings.Count(email.PlainBody, "unread message(s)"), 60, "Expected every notification in the digest")
+		time.Sleep(200 * time.Millisecond)
+		tester.AssertValue(t, len(findEmails(sender, "supplier@test.com", "")), 1, "Expected one digest")
+	})
+}
+
+// failingSender counts attempts to send emails, every one of them fails
+type failingSender struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *failingSender) Send(msg mailer.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	return errors.New("mail server is unavailable")
+}
+
+func (s *failingSender) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func postOrder(t *testing.T, url string, token string) {
+	t.Helper()
+	body, _ := json.Marshal(data.PostOrderDto{ConversationId: 1, Items: []data.ItemQuantity{{ItemId: 1, Quantity: 2}}})
+	response, err := http.DefaultClient.Do(newAuthRequest(t, http.MethodPost, url+"/v1/orders", token, body))
+	tester.AssertNoError(t, err)
+	response.Body.Close()
+	tester.AssertStatus(t, response.StatusCode, http.StatusCreated)
+}
+
+func putNotificationPreferences(t *testing.T, url string, token string, preferences data.NotificationPreferences) {
+	t.Helper()
+	body, _ := json.Marshal(preferences)
+	response, err := http.DefaultClient.Do(newAuthRequest(t, http.MethodPut, url+"/v1/notifications/preferences", token, body))
+	tester.AssertNoError(t, err)
+	response.Body.Close()
+	tester.AssertStatus(t, response.StatusCode, http.StatusOK)
+}
+
+func findEmails(sender *mailer.MemorySender, to string, subject string) []mailer.Message {
+	emails := []mailer.Message{}
+	for _, email := range sender.Messages(to) {
+		if strings.HasPrefix(email.Subject, subject) {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
+
+func waitForEmail(t *testing.T, sender *mailer.MemorySender, to string, subject string) mailer.Message {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if emails := findEmails(sender, to, subject); len(emails) > 0 {
+			return emails[len(emails)-1]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected email %q to %v", subject, to)
+	return mailer.Message{}
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
@@ -14,44 +15,134 @@ func (a *Application) AuthenticateHttpRequest(w http.ResponseWriter, r *http.Req
 	// header in the request.
 	w.Header().Add("Vary", "Authorization")
 
+	token, err := bearerToken(r)
+	if err != nil {
+		return data.User{}, err
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		return data.User{}, ErrUnathorized
+	}
+
+	user, err := a.models.User.GetForToken(data.ScopeAuthentication, token)
+	if err != nil {
+		return data.User{}, ErrUnathorized
+	}
+
+	return user, nil
+}
+
+// authenticateSession is AuthenticateHttpRequest which returns the authentication token as well
+func (a *Application) authenticateSession(w http.ResponseWriter, r *http.Request) (data.User, data.Token, error) {
+	w.Header().Add("Vary", "Authorization")
+
+	token, err := bearerToken(r)
+	if err != nil {
+		return data.User{}, data.Token{}, err
+	}
+
+	return a.AuthenticateWsToken(token)
+}
+
+func bearerToken(r *http.Request) (string, error) {
 	authorizationHeader := r.Header.Get("Authorization")
 
 	if authorizationHeader == "" {
-		return data.User{}, ErrUnathorized
+		return "", ErrUnathorized
 	}
 
 	headerParts := strings.Split(authorizationHeader, " ")
 	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-		return data.User{}, ErrUnathorized
+		return "", ErrUnathorized
 	}
 
-	token := headerParts[1]
+	return headerParts[1], nil
+}
 
+// AuthenticateWsTicket exchanges the single use ticket for the user and authentication token it was issued for
+func (a *Application) AuthenticateWsTicket(ticket string) (data.User, data.Token, error) {
 	v := validator.New()
-	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
-		return data.User{}, ErrUnathorized
+	if data.ValidateTokenPlaintext(v, ticket); !v.Valid() {
+		return data.User{}, data.Token{}, ErrUnathorized
 	}
 
-	user, err := a.models.User.GetForToken(data.ScopeAuthentication, token)
+	token, err := a.models.Token.Consume(data.ScopeWsTicket, ticket)
 	if err != nil {
-		return data.User{}, ErrUnathorized
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return data.User{}, data.Token{}, ErrUnathorized
+		default:
+			return data.User{}, data.Token{}, err
+		}
 	}
 
-	return user, nil
+	return a.authenticateTokenHash(token.ParentHash)
 }
 
-func (a *Application) AuthenticateWsUpgradeRequest(w http.ResponseWriter, r *http.Request) (data.User, error) {
-	token := r.URL.Query().Get("token")
-
+// AuthenticateWsToken is used for the token sent in the first message of a websocket connection
+func (a *Application) AuthenticateWsToken(tokenPlaintext string) (data.User, data.Token, error) {
 	v := validator.New()
-	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
-		return data.User{}, ErrUnathorized
+	if data.ValidateTokenPlaintext(v, tokenPlaintext); !v.Valid() {
+		return data.User{}, data.Token{}, ErrUnathorized
 	}
 
-	user, err := a.models.User.GetForToken(data.ScopeAuthentication, token)
+	return a.authenticateTokenHash(data.TokenHash(tokenPlaintext))
+}
+
+// AuthenticateEventStream accepts the ticket query parameter, since browsers can't set
+// headers of event streams, and the Authorization header of other clients
+func (a *Application) AuthenticateEventStream(w http.ResponseWriter, r *http.Request) (data.User, data.Token, error) {
+	w.Header().Add("Vary", "Authorization")
+	qs := r.URL.Query()
+	if qs.Has("ticket") {
+		return a.AuthenticateWsTicket(qs.Get("ticket"))
+	}
+	token, err := bearerToken(r)
 	if err != nil {
-		return data.User{}, ErrUnathorized
+		return data.User{}, data.Token{}, err
 	}
+	return a.AuthenticateWsToken(token)
+}
 
-	return user, nil
+// authenticateTokenHash returns the authentication token as well,
+// so that long lived connections can be closed when it expires or is revoked
+func (a *Application) authenticateTokenHash(hash []byte) (data.User, data.Token, error) {
+	token, err := a.models.Token.Get(data.ScopeAuthentication, hash)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return data.User{}, data.Token{}, ErrUnathorized
+		default:
+			return data.User{}, data.Token{}, err
+		}
+	}
+
+	user, err := a.models.User.GetById(token.UserId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return data.User{}, data.Token{}, ErrUnathorized
+		default:
+			return data.User{}, data.Token{}, err
+		}
+	}
+
+	return user, token, nil
+}
+
+// requireActivated returns ErrNotActivated unless the user verified their email,
+// the user is loaded again since long lived connections keep the user they were opened by
+func (a *Application) requireActivated(user data.User) error {
+	if user.Activated {
+		return nil
+	}
+	user, err := a.models.User.GetById(user.Id)
+	if err != nil {
+		return err
+	}
+	if !user.Activated {
+		return ErrNotActivated
+	}
+	return nil
 }
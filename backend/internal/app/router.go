@@ -12,14 +12,36 @@ func (a *Application) routes() http.Handler {
 		newRoute(http.MethodGet, "/v1/healthcheck", a.healthcheckHandler),
 		newRoute(http.MethodPost, "/v1/users", a.handlePostUser),
 		newRoute(http.MethodGet, "/v1/users", a.handleGetUsers),
+		newRoute(http.MethodPut, "/v1/users/activated", a.handlePutUserActivated),
+		newRoute(http.MethodPut, "/v1/users/password", a.handlePutUserPassword),
 		newRoute(http.MethodPost, "/v1/tokens", a.handlePostToken),
+		newRoute(http.MethodGet, "/v1/tokens", a.handleGetTokens),
+		newRoute(http.MethodDelete, "/v1/tokens/current", a.handleDeleteCurrentToken),
+		newRoute(http.MethodDelete, "/v1/tokens/([0-9]+)", a.handleDeleteToken),
+		newRoute(http.MethodPost, "/v1/tokens/activation", a.handlePostActivationToken),
+		newRoute(http.MethodPost, "/v1/tokens/password-reset", a.handlePostPasswordResetToken),
 		newRoute(http.MethodPost, "/v1/conversations", a.handlePostConversation),
 		newRoute(http.MethodGet, "/v1/conversations", a.handleGetConversation),
+		newRoute(http.MethodPost, "/v1/conversations/([0-9]+)/read", a.handlePostConversationRead),
+		newRoute(http.MethodPatch, "/v1/conversations/([0-9]+)", a.handlePatchConversation),
+		newRoute(http.MethodPost, "/v1/conversations/([0-9]+)/members", a.handlePostConversationMember),
+		newRoute(http.MethodDelete, "/v1/conversations/([0-9]+)/members/([0-9]+)", a.handleDeleteConversationMember),
+		newRoute(http.MethodPost, "/v1/conversations/([0-9]+)/leave", a.handlePostConversationLeave),
+		newRoute(http.MethodPut, "/v1/conversations/([0-9]+)/settings", a.handlePutConversationSettings),
+		newRoute(http.MethodPost, "/v1/chat/tickets", a.handlePostWsTicket),
+		newRoute(http.MethodGet, "/v1/chat/schema", a.handleGetWsSchema),
 		newRoute(http.MethodGet, "/v1/chat", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			a.wsChatHandler(a.hub, w, r)
 		})),
+		newRoute(http.MethodGet, "/v1/chat/events", a.handleGetChatEvents),
+		newRoute(http.MethodPost, "/v1/chat/acks", a.handlePostChatAck),
 		newRoute(http.MethodGet, "/v1/conversations/([0-9]+)/messages", a.handleGetMessages),
+		newRoute(http.MethodPost, "/v1/conversations/([0-9]+)/messages", a.handlePostMessage),
+		newRoute(http.MethodGet, "/v1/messages/search", a.handleSearchMessages),
 		newRoute(http.MethodGet, "/v1/messages/([0-9]+)", a.handleGetMessage),
+		newRoute(http.MethodPatch, "/v1/messages/([0-9]+)", a.handlePatchMessage),
+		newRoute(http.MethodDelete, "/v1/messages/([0-9]+)", a.handleDeleteMessage),
+		newRoute(http.MethodGet, "/v1/messages/([0-9]+)/edits", a.handleGetMessageEdits),
 		newRoute(http.MethodPost, "/v1/items", a.handlePostItem),
 		newRoute(http.MethodGet, "/v1/items", a.handleGetAllItems),
 		newRoute(http.MethodGet, "/v1/items/([0-9]+)", a.handleGetItem),
@@ -29,8 +51,16 @@ func (a *Application) routes() http.Handler {
 		newRoute(http.MethodGet, "/v1/orders", a.handleGetAllOrders),
 		newRoute(http.MethodGet, "/v1/orders/([0-9]+)", a.handleGetOrder),
 		newRoute(http.MethodPatch, "/v1/orders/([0-9]+)", a.handlePatchOrder),
+		newRoute(http.MethodPost, "/v1/webhooks", a.handlePostWebhook),
+		newRoute(http.MethodGet, "/v1/webhooks", a.handleGetWebhooks),
+		newRoute(http.MethodDelete, "/v1/webhooks/([0-9]+)", a.handleDeleteWebhook),
+		newRoute(http.MethodGet, "/v1/webhooks/([0-9]+)/deliveries", a.handleGetWebhookDeliveries),
+		newRoute(http.MethodGet, "/v1/notifications/preferences", a.handleGetNotificationPreferences),
+		newRoute(http.MethodPut, "/v1/notifications/preferences", a.handlePutNotificationPreferences),
 		newRoute(http.MethodPost, "/v1/images", a.handlePostImage),
 		newRoute(http.MethodGet, "/v1/images/([^/]+)", a.handleGetImage),
+		newRoute(http.MethodPost, "/v1/attachments", a.handlePostAttachment),
+		newRoute(http.MethodGet, "/v1/attachments/([0-9]+)", a.handleGetAttachment),
 	}
 	return NewRouter(routes)
 }
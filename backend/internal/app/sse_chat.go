@@ -0,0 +1,200 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/validator"
+)
+
+// eventStream writes hub events as server-sent events, every event is
+// the JSON of WsEvent, so that clients parse both transports the same way
+type eventStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// write sends stored events with their seq as the id,
+// browsers send it back as Last-Event-ID when they reconnect
+func (s eventStream) write(evt WsEvent) error {
+	s.rc.SetWriteDeadline(time.Now().Add(writeWait))
+	js, _ := json.Marshal(evt)
+	if evt.Seq != 0 {
+		fmt.Fprintf(s.w, "id: %d\n", evt.Seq)
+	}
+	_, err := fmt.Fprintf(s.w, "data: %s\n\n", js)
+	if err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// ping is a comment which keeps proxies from closing the idle stream
+func (s eventStream) ping() error {
+	s.rc.SetWriteDeadline(time.Now().Add(writeWait))
+	_, err := fmt.Fprint(s.w, ": ping\n\n")
+	if err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+func (s eventStream) close(code int, reason string) {
+	payload, _ := json.Marshal(CloseDto{Code: code, Reason: reason})
+	s.write(WsEvent{Type: EventClose, Payload: payload})
+}
+
+// handleGetChatEvents streams the events a websocket connection of the user would get
+// to clients behind proxies which don't allow websockets, they send messages with
+// the REST API. The stream resumes after since or Last-Event-ID like websockets do.
+func (a *Application) handleGetChatEvents(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	since := readInt(qs, "since", -1, v)
+	if qs.Has("since") {
+		v.Check(since >= 0, "since", "must not be negative")
+	}
+	if lastEventId := r.Header.Get("Last-Event-ID"); lastEventId != "" {
+		seq, err := strconv.ParseInt(lastEventId, 10, 64)
+		v.Check(err == nil && seq >= 0, "Last-Event-ID", "must be a seq")
+		since = seq
+	}
+	if !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, session, err := a.AuthenticateEventStream(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	conversations, err := a.models.Conversation.GetAllByUserId(user.Id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	stream := eventStream{w: w, rc: http.NewResponseController(w)}
+	// the stream outlives the write timeout of the server, writes set their own deadlines
+	stream.rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	client := &Client{User: user, session: session, conversations: map[int64]data.Conversation{}, hub: a.hub, messages: make(chan WsEvent, 256), since: since}
+	for _, conversation := range conversations {
+		client.conversations[conversation.Id] = conversation
+	}
+	a.hub.register <- client
+	defer func() {
+		a.hub.unregister <- client
+	}()
+	payload, _ := json.Marshal(user)
+	if err := stream.write(WsEvent{Type: EventAuthenticated, Payload: payload}); err != nil {
+		return
+	}
+	client.streamPump(r.Context(), stream)
+}
+
+// handlePostChatAck acknowledges received events for clients of event streams,
+// websocket clients send EventAck instead
+func (a *Application) handlePostChatAck(w http.ResponseWriter, r *http.Request) {
+	user, err := a.AuthenticateHttpRequest(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnathorized):
+			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var dto AckDto
+	err = readJsonFromBody(w, r, &dto)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(dto.Seq > 0, "seq", "must be positive")
+	if !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = a.models.Event.Ack(user.Id, dto.Seq)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	writeJsonResponse(w, http.StatusOK, dto, nil)
+}
+
+// streamPump is writePump of event streams, it returns when the client goes away
+// or the stream is closed
+func (c *Client) streamPump(ctx context.Context, stream eventStream) {
+	ticker := time.NewTicker(pingPeriod)
+	expiry := time.NewTimer(time.Until(c.session.Expiry))
+	sessionCheck := time.NewTicker(sessionCheckPeriod)
+	defer func() {
+		ticker.Stop()
+		expiry.Stop()
+		sessionCheck.Stop()
+	}()
+	lastSeq, truncated, err := c.replay(stream.write)
+	if err != nil {
+		return
+	}
+	if truncated {
+		stream.close(CloseResync, closeReasons[CloseResync])
+		return
+	}
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				if c.closeCode != 0 {
+					stream.close(c.closeCode, closeReasons[c.closeCode])
+				}
+				return
+			}
+			if msg.Seq != 0 && msg.Seq <= lastSeq {
+				continue
+			}
+			if err := stream.write(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := stream.ping(); err != nil {
+				return
+			}
+		case <-expiry.C:
+			stream.close(CloseUnauthorized, "token expired")
+			return
+		case <-sessionCheck.C:
+			if c.sessionRevoked() {
+				stream.close(CloseUnauthorized, "token revoked")
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
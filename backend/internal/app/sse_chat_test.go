@@ -0,0 +1,131 @@
+package app_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/app"
+	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/tester"
+)
+
+func TestChatEvents(t *testing.T) {
+	messageModel, appServer := createServer(2)
+	server := httptest.NewServer(appServer)
+	defer server.Close()
+
+	t.Run("it streams messages posted over REST", func(t *testing.T) {
+		stream := mustOpenEventStream(t, server.URL, strings.Repeat("2", 26), "")
+		defer stream.Body.Close()
+		events := bufio.NewReader(stream.Body)
+		_, authenticated := readStreamEvent(t, events)
+		tester.AssertValue(t, authenticated.Type, app.EventAuthenticated, "Expected authenticated event first")
+
+		response := postMessage(t, server.URL, strings.Repeat("1", 26), 1, data.PostMessageDto{Content: "over http", PrevMessageId: 0})
+		defer response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusCreated)
+		var posted data.Message
+		tester.AssertNoError(t, json.NewDecoder(response.Body).Decode(&posted))
+		assertContainsMessage(t, messageModel, 1, posted)
+
+		id, got := readStreamEvent(t, events)
+		tester.AssertValue(t, got.Type, app.EventMessage, "Expected message event")
+		tester.AssertValue(t, id, "1", "Expected seq as the event id")
+		var msg data.Message
+		tester.AssertNoError(t, json.Unmarshal(got.Payload, &msg))
+		tester.AssertValue(t, msg.Content, "over http", "Expected posted message")
+	})
+
+	t.Run("it replays events after Last-Event-ID", func(t *testing.T) {
+		response := postMessage(t, server.URL, strings.Repeat("1", 26), 1, data.PostMessageDto{Content: "missed", PrevMessageId: 0})
+		response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusCreated)
+
+		stream := mustOpenEventStream(t, server.URL, strings.Repeat("2", 26), "1")
+		defer stream.Body.Close()
+		events := bufio.NewReader(stream.Body)
+		readStreamEvent(t, events)
+		id, got := readStreamEvent(t, events)
+		var msg data.Message
+		tester.AssertNoError(t, json.Unmarshal(got.Payload, &msg))
+		tester.AssertValue(t, id, "2", "Expected event after the last one")
+		tester.AssertValue(t, msg.Content, "missed", "Expected missed message")
+	})
+
+	t.Run("it acks received events", func(t *testing.T) {
+		response := sendAuthorizedRequest(t, appServer, http.MethodPost, "/v1/chat/acks", "2", app.AckDto{Seq: 2})
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		response = sendAuthorizedRequest(t, appServer, http.MethodPost, "/v1/chat/acks", "2", app.AckDto{Seq: 0})
+		tester.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("it 401 if the stream isn't authenticated", func(t *testing.T) {
+		response, err := http.Get(server.URL + "/v1/chat/events")
+		tester.AssertNoError(t, err)
+		defer response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusUnauthorized)
+	})
+
+	t.Run("it 404 if POST message to not own conversation", func(t *testing.T) {
+		response := postMessage(t, server.URL, strings.Repeat("1", 26), 99, data.PostMessageDto{Content: "lost"})
+		defer response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusNotFound)
+	})
+}
+
+func mustOpenEventStream(t *testing.T, url string, token string, lastEventId string) *http.Response {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodGet, url+"/v1/chat/events", nil)
+	tester.AssertNoError(t, err)
+	request.Header.Set("Authorization", "Bearer "+token)
+	if lastEventId != "" {
+		request.Header.Set("Last-Event-ID", lastEventId)
+	}
+	response, err := http.DefaultClient.Do(request)
+	tester.AssertNoError(t, err)
+	tester.AssertStatus(t, response.StatusCode, http.StatusOK)
+	tester.AssertValue(t, response.Header.Get("Content-Type"), "text/event-stream", "Expected event stream")
+	return response
+}
+
+func postMessage(t *testing.T, url string, token string, conversationId int64, dto data.PostMessageDto) *http.Response {
+	t.Helper()
+	body, err := json.Marshal(dto)
+	tester.AssertNoError(t, err)
+	request, err := http.NewRequest(http.MethodPost, url+fmt.Sprintf("/v1/conversations/%v/messages", conversationId), bytes.NewReader(body))
+	tester.AssertNoError(t, err)
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := http.DefaultClient.Do(request)
+	tester.AssertNoError(t, err)
+	return response
+}
+
+// readStreamEvent returns the id and the event of the next server-sent event, comments are skipped
+func readStreamEvent(t *testing.T, events *bufio.Reader) (string, app.WsEvent) {
+	t.Helper()
+	var id string
+	var event app.WsEvent
+	within(t, 500*time.Millisecond, func() {
+		for {
+			line, err := events.ReadString('\n')
+			tester.AssertNoError(t, err)
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				tester.AssertNoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+			case line == "" && event.Type != "":
+				return
+			}
+		}
+	})
+	return id, event
+}
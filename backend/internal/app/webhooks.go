@@ -0,0 +1,277 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/data"
+)
+
+// Headers of webhook deliveries, receivers check the signature with the secret of the webhook
+const (
+	WebhookEventHeader     = "X-Cookie-Event"
+	WebhookDeliveryHeader  = "X-Cookie-Delivery"
+	WebhookTimestampHeader = "X-Cookie-Timestamp"
+	WebhookSignatureHeader = "X-Cookie-Signature"
+)
+
+const (
+	// webhookTimeout is how long a receiver has to respond
+	webhookTimeout = 10 * time.Second
+	// webhookMaxBackoff caps the delay between attempts
+	webhookMaxBackoff = 6 * time.Hour
+	// webhookBatchSize is the number of deliveries sent at once
+	webhookBatchSize = 20
+)
+
+// WebhookPayload is the body of a delivery
+type WebhookPayload struct {
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// SignWebhook returns the signature of the delivery body, it is the hex
+// HMAC-SHA256 of the timestamp and the body joined with a dot
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookDispatcher adds domain events to the outbox and sends due deliveries,
+// every instance runs it, deliveries are claimed so that only one sends them
+type webhookDispatcher struct {
+	app    *Application
+	client *http.Client
+}
+
+// errWebhookAddress is returned when a receiver resolves to an address webhooks can't be sent to
+var errWebhookAddress = errors.New("webhook address not allowed")
+
+func newWebhookDispatcher(app *Application) *webhookDispatcher {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !app.config.WebhookAllowPrivate {
+		// the resolved address is checked, so hosts can't rebind to internal addresses after validation
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !publicIP(ip) {
+				return errWebhookAddress
+			}
+			return nil
+		}
+	}
+	transport := &http.Transport{
+		// a proxy would be dialed instead of the receiver
+		Proxy:               nil,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+		MaxIdleConnsPerHost: 2,
+	}
+	client := &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: transport,
+		// redirects aren't followed, they could lead to internal addresses
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &webhookDispatcher{app: app, client: client}
+}
+
+// webhookDeniedNetworks are special purpose networks webhooks can't be sent to, IPv6
+// translation prefixes are denied as well since they embed IPv4 addresses,
+// IPv4-mapped addresses are matched as IPv4 ones
+var webhookDeniedNetworks = parseCIDRs(
+	// IPv4
+	"0.0.0.0/8",       // this network
+	"10.0.0.0/8",      // private
+	"100.64.0.0/10",   // carrier-grade NAT
+	"127.0.0.0/8",     // loopback
+	"169.254.0.0/16",  // link local
+	"172.16.0.0/12",   // private
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"192.88.99.0/24",  // 6to4 relay anycast
+	"192.168.0.0/16",  // private
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"224.0.0.0/4",     // multicast
+	"240.0.0.0/4",     // reserved and broadcast
+	// IPv6
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"64:ff9b::/96",   // NAT64
+	"64:ff9b:1::/48", // local NAT64
+	"100::/64",       // discard
+	"2001::/32",      // Teredo
+	"2001:db8::/32",  // documentation
+	"2002::/16",      // 6to4
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link local
+	"ff00::/8",       // multicast
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// publicIP reports whether webhooks can be sent to the address
+func publicIP(ip net.IP) bool {
+	for _, network := range webhookDeniedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// webhookErrorClass is the error of a delivery shown to the owner of the webhook,
+// transport errors aren't shown since they tell about the network of the server
+func webhookErrorClass(err error) string {
+	switch {
+	case errors.Is(err, errWebhookAddress):
+		return "destination address not allowed"
+	default:
+		return "request failed"
+	}
+}
+
+// handleDomainEvent enqueues the event for webhooks of the users it concerns
+func (d *webhookDispatcher) handleDomainEvent(event data.DomainEvent) error {
+	var webhookType string
+	switch event.Type {
+	case data.DomainOrderCreated:
+		webhookType = data.WebhookOrderCreated
+	case data.DomainOrderUpdated:
+		webhookType = data.WebhookOrderUpdated
+	case data.DomainMessageCreated:
+		webhookType = data.WebhookMessageReceived
+	default:
+		return nil
+	}
+	conversation, err := d.app.models.Conversation.GetById(event.ConversationId)
+	if err != nil {
+		return fmt.Errorf("load conversation %v: %w", event.ConversationId, err)
+	}
+	userIds := []int64{}
+	for _, user := range conversation.Users {
+		// authors don't receive their own messages
+		if event.Type == data.DomainMessageCreated && user.Id == event.UserId {
+			continue
+		}
+		userIds = append(userIds, user.Id)
+	}
+	payload, _ := json.Marshal(WebhookPayload{Type: webhookType, CreatedAt: event.CreatedAt, Data: event.Payload})
+	return d.app.models.Webhook.Enqueue(event.Id, userIds, webhookType, payload)
+}
+
+// run sends due deliveries every poll interval
+func (d *webhookDispatcher) run() {
+	ticker := time.NewTicker(d.app.config.WebhookPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		d.sendDue()
+	}
+}
+
+func (d *webhookDispatcher) sendDue() {
+	// deliveries are claimed for longer than an attempt takes
+	deliveries, err := d.app.models.Webhook.ClaimDue(webhookBatchSize, 2*webhookTimeout)
+	if err != nil {
+		d.app.logger.Printf("Can't claim webhook deliveries: %v", err)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery data.WebhookDelivery) {
+			defer wg.Done()
+			delivery = d.send(delivery)
+			err := d.app.models.Webhook.UpdateDelivery(delivery)
+			if err != nil {
+				d.app.logger.Printf("Can't update webhook delivery %v: %v", delivery.Id, err)
+			}
+		}(delivery)
+	}
+	wg.Wait()
+}
+
+// send makes an attempt and returns the delivery with its result, failed deliveries
+// are retried with exponential backoff until they run out of attempts
+func (d *webhookDispatcher) send(delivery data.WebhookDelivery) data.WebhookDelivery {
+	delivery.Attempts++
+	statusCode, err := d.post(delivery)
+	delivery.LastStatusCode = statusCode
+	now := time.Now()
+	switch {
+	case err == nil && statusCode >= 200 && statusCode < 300:
+		delivery.Status = data.DeliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+		return delivery
+	case err == nil:
+		delivery.LastError = fmt.Sprintf("unexpected status code %v", statusCode)
+	default:
+		d.app.logger.Printf("Can't send webhook delivery %v: %v", delivery.Id, err)
+		delivery.LastError = webhookErrorClass(err)
+	}
+	if delivery.Attempts >= d.app.config.WebhookAttempts {
+		delivery.Status = data.DeliveryDead
+		return delivery
+	}
+	delivery.NextAttemptAt = now.Add(backoff(d.app.config.WebhookBackoff, webhookMaxBackoff, delivery.Attempts))
+	return delivery
+}
+
+func (d *webhookDispatcher) post(delivery data.WebhookDelivery) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, delivery.Webhook.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", JsonContentType)
+	request.Header.Set(WebhookEventHeader, delivery.EventType)
+	// receivers deduplicate deliveries by the event, so it stays the same if the event is enqueued again
+	deliveryId := delivery.EventId
+	if deliveryId == 0 {
+		deliveryId = delivery.Id
+	}
+	request.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(deliveryId, 10))
+	request.Header.Set(WebhookTimestampHeader, timestamp)
+	request.Header.Set(WebhookSignatureHeader, SignWebhook(delivery.Webhook.Secret, timestamp, delivery.Payload))
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	// the body is drained so that the connection is reused
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	return response.StatusCode, nil
+}
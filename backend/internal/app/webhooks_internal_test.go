@@ -0,0 +1,40 @@
+package app
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vasiliiperfilev/cookie/internal/tester"
+)
+
+func TestPublicIP(t *testing.T) {
+	cases := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"0.1.2.3", false},
+		{"10.0.0.1", false},
+		{"100.64.0.1", false},
+		{"127.0.0.1", false},
+		{"169.254.169.254", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"198.18.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:10.0.0.1", false},
+		{"::", false},
+		{"::1", false},
+		{"64:ff9b::a00:1", false},
+		{"2002:a00:1::", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"ff02::1", false},
+	}
+	for _, c := range cases {
+		t.Run(c.ip, func(t *testing.T) {
+			tester.AssertValue(t, publicIP(net.ParseIP(c.ip)), c.want, "Expected address to be checked")
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package app_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/app"
+	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/tester"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func TestWebhooks(t *testing.T) {
+	var receiverStatus atomic.Int64
+	receiverStatus.Store(http.StatusOK)
+	received := make(chan webhookRequest, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- webhookRequest{header: r.Header, body: body}
+		w.WriteHeader(int(receiverStatus.Load()))
+	}))
+	defer receiver.Close()
+	server := httptest.NewServer(createWebhookServer(2, true))
+	defer server.Close()
+	webhook := createWebhook(t, server.URL, strings.Repeat("2", 26), data.PostWebhookDto{
+		Url:        receiver.URL,
+		EventTypes: []string{data.WebhookMessageReceived},
+	})
+
+	t.Run("it returns the secret only on creation", func(t *testing.T) {
+		tester.AssertValue(t, len(webhook.Secret), 64, "Expected secret")
+		request := newAuthRequest(t, http.MethodGet, server.URL+"/v1/webhooks", strings.Repeat("2", 26), nil)
+		response, err := http.DefaultClient.Do(request)
+		tester.AssertNoError(t, err)
+		defer response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusOK)
+		var webhooks []data.Webhook
+		tester.AssertNoError(t, json.NewDecoder(response.Body).Decode(&webhooks))
+		tester.AssertValue(t, len(webhooks), 1, "Expected user webhook")
+		tester.AssertValue(t, webhooks[0].Secret, "", "Expected no secret")
+	})
+
+	t.Run("it sends signed messages of other users", func(t *testing.T) {
+		response := postMessage(t, server.URL, strings.Repeat("2", 26), 1, data.PostMessageDto{Content: "own"})
+		response.Body.Close()
+		response = postMessage(t, server.URL, strings.Repeat("1", 26), 1, data.PostMessageDto{Content: "hook"})
+		response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusCreated)
+
+		got := receiveWebhook(t, received)
+		tester.AssertValue(t, got.header.Get(app.WebhookEventHeader), data.WebhookMessageReceived, "Expected event type")
+		signature := app.SignWebhook(webhook.Secret, got.header.Get(app.WebhookTimestampHeader), got.body)
+		tester.AssertValue(t, got.header.Get(app.WebhookSignatureHeader), signature, "Expected valid signature")
+		var payload app.WebhookPayload
+		tester.AssertNoError(t, json.Unmarshal(got.body, &payload))
+		var msg data.Message
+		tester.AssertNoError(t, json.Unmarshal(payload.Data, &msg))
+		tester.AssertValue(t, msg.Content, "hook", "Expected message of the other user")
+
+		deliveries := waitForDeliveries(t, server.URL, webhook.Id, data.DeliveryDelivered)
+		tester.AssertValue(t, len(deliveries), 1, "Expected one delivery")
+		tester.AssertValue(t, deliveries[0].Attempts, 1, "Expected one attempt")
+		tester.AssertValue(t, got.header.Get(app.WebhookDeliveryHeader), strconv.FormatInt(deliveries[0].EventId, 10), "Expected event id as delivery id")
+	})
+
+	t.Run("it enqueues an event once per webhook", func(t *testing.T) {
+		webhookModel := data.NewStubWebhookModel()
+		webhook := data.Webhook{UserId: 2, Url: receiver.URL, EventTypes: []string{data.WebhookMessageReceived}}
+		tester.AssertNoError(t, webhookModel.Insert(&webhook))
+		for i := 0; i < 2; i++ {
+			tester.AssertNoError(t, webhookModel.Enqueue(7, []int64{2}, data.WebhookMessageReceived, []byte(`{}`)))
+		}
+		deliveries, err := webhookModel.GetAllDeliveries(webhook.Id, "", data.WebhookDeliveriesMaxLimit)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, len(deliveries), 1, "Expected one delivery of the event")
+		tester.AssertValue(t, deliveries[0].EventId, int64(7), "Expected event id")
+	})
+
+	t.Run("it retries failed deliveries until they are dead", func(t *testing.T) {
+		receiverStatus.Store(http.StatusInternalServerError)
+		response := postMessage(t, server.URL, strings.Repeat("1", 26), 1, data.PostMessageDto{Content: "failing"})
+		response.Body.Close()
+
+		first := receiveWebhook(t, received)
+		second := receiveWebhook(t, received)
+		tester.AssertValue(t, first.header.Get(app.WebhookDeliveryHeader), second.header.Get(app.WebhookDeliveryHeader), "Expected the same delivery")
+		deliveries := waitForDeliveries(t, server.URL, webhook.Id, data.DeliveryDead)
+		tester.AssertValue(t, deliveries[0].Attempts, 2, "Expected all attempts")
+		tester.AssertValue(t, deliveries[0].LastStatusCode, http.StatusInternalServerError, "Expected last status code")
+	})
+
+	t.Run("it 403 if GET deliveries of another user webhook", func(t *testing.T) {
+		request := newAuthRequest(t, http.MethodGet, fmt.Sprintf("%v/v1/webhooks/%v/deliveries", server.URL, webhook.Id), strings.Repeat("1", 26), nil)
+		response, err := http.DefaultClient.Do(request)
+		tester.AssertNoError(t, err)
+		response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusForbidden)
+	})
+
+	t.Run("it 422 if POST webhook with unknown event type", func(t *testing.T) {
+		body, _ := json.Marshal(data.PostWebhookDto{Url: receiver.URL, EventTypes: []string{"item.updated"}})
+		request := newAuthRequest(t, http.MethodPost, server.URL+"/v1/webhooks", strings.Repeat("2", 26), body)
+		response, err := http.DefaultClient.Do(request)
+		tester.AssertNoError(t, err)
+		response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("it deletes the webhook", func(t *testing.T) {
+		request := newAuthRequest(t, http.MethodDelete, fmt.Sprintf("%v/v1/webhooks/%v", server.URL, webhook.Id), strings.Repeat("2", 26), nil)
+		response, err := http.DefaultClient.Do(request)
+		tester.AssertNoError(t, err)
+		response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusNoContent)
+
+		request = newAuthRequest(t, http.MethodGet, fmt.Sprintf("%v/v1/webhooks/%v/deliveries", server.URL, webhook.Id), strings.Repeat("2", 26), nil)
+		response, err = http.DefaultClient.Do(request)
+		tester.AssertNoError(t, err)
+		response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusNotFound)
+	})
+}
+
+func TestWebhookAddresses(t *testing.T) {
+	var requests atomic.Int64
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data", http.StatusFound)
+	}))
+	defer receiver.Close()
+
+	t.Run("it doesn't send webhooks to private addresses", func(t *testing.T) {
+		server := httptest.NewServer(createWebhookServer(2, false))
+		defer server.Close()
+		webhook := createWebhook(t, server.URL, strings.Repeat("2", 26), data.PostWebhookDto{Url: receiver.URL, EventTypes: []string{data.WebhookMessageReceived}})
+		response := postMessage(t, server.URL, strings.Repeat("1", 26), 1, data.PostMessageDto{Content: "internal"})
+		response.Body.Close()
+
+		deliveries := waitForDeliveries(t, server.URL, webhook.Id, data.DeliveryDead)
+		tester.AssertValue(t, deliveries[0].LastError, "destination address not allowed", "Expected generic error")
+		tester.AssertValue(t, requests.Load(), int64(0), "Expected no requests to the receiver")
+	})
+
+	t.Run("it doesn't follow redirects", func(t *testing.T) {
+		server := httptest.NewServer(createWebhookServer(2, true))
+		defer server.Close()
+		webhook := createWebhook(t, server.URL, strings.Repeat("2", 26), data.PostWebhookDto{Url: receiver.URL, EventTypes: []string{data.WebhookMessageReceived}})
+		response := postMessage(t, server.URL, strings.Repeat("1", 26), 1, data.PostMessageDto{Content: "redirected"})
+		response.Body.Close()
+
+		deliveries := waitForDeliveries(t, server.URL, webhook.Id, data.DeliveryDead)
+		tester.AssertValue(t, deliveries[0].LastStatusCode, http.StatusFound, "Expected the redirect response")
+	})
+}
+
+// createWebhookServer creates the app, allowPrivate lets it send webhooks to local receivers of the tests
+func createWebhookServer(numUsers int, allowPrivate bool) *app.Application {
+	cfg := app.Config{
+		Port:                4000,
+		Env:                 "development",
+		WebhookAttempts:     2,
+		WebhookBackoff:      10 * time.Millisecond,
+		WebhookPollInterval: 10 * time.Millisecond,
+		WebhookAllowPrivate: allowPrivate,
+	}
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	messageModel := data.NewStubMessageModel(generateConversation(numUsers), []data.Message{})
+	userModel := data.NewStubUserModel(generateUsers(numUsers))
+	conversationModel := data.NewStubConversationModel(generateConversation(numUsers), userModel)
+	models := data.Models{
+		Message:      messageModel,
+		User:         userModel,
+		Conversation: conversationModel,
+		Token:        data.NewStubTokenModel(generateTokens(numUsers)),
+		Webhook:      data.NewStubWebhookModel(),
+	}
+	return app.New(cfg, logger, models)
+}
+
+func newAuthRequest(t *testing.T, method string, url string, token string, body []byte) *http.Request {
+	t.Helper()
+	request, err := http.NewRequest(method, url, bytes.NewReader(body))
+	tester.AssertNoError(t, err)
+	request.Header.Set("Authorization", "Bearer "+token)
+	return request
+}
+
+func createWebhook(t *testing.T, url string, token string, dto data.PostWebhookDto) data.Webhook {
+	t.Helper()
+	body, _ := json.Marshal(dto)
+	response, err := http.DefaultClient.Do(newAuthRequest(t, http.MethodPost, url+"/v1/webhooks", token, body))
+	tester.AssertNoError(t, err)
+	defer response.Body.Close()
+	tester.AssertStatus(t, response.StatusCode, http.StatusCreated)
+	var webhook data.Webhook
+	tester.AssertNoError(t, json.NewDecoder(response.Body).Decode(&webhook))
+	return webhook
+}
+
+func getDeliveries(t *testing.T, url string, token string, webhookId int64, status string) []data.WebhookDelivery {
+	t.Helper()
+	request := newAuthRequest(t, http.MethodGet, fmt.Sprintf("%v/v1/webhooks/%v/deliveries?status=%v", url, webhookId, status), token, nil)
+	response, err := http.DefaultClient.Do(request)
+	tester.AssertNoError(t, err)
+	defer response.Body.Close()
+	tester.AssertStatus(t, response.StatusCode, http.StatusOK)
+	var deliveries []data.WebhookDelivery
+	tester.AssertNoError(t, json.NewDecoder(response.Body).Decode(&deliveries))
+	return deliveries
+}
+
+// waitForDeliveries polls the delivery log of user 2 webhook until it has a delivery with the status
+func waitForDeliveries(t *testing.T, url string, webhookId int64, status string) []data.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		deliveries := getDeliveries(t, url, strings.Repeat("2", 26), webhookId, status)
+		if len(deliveries) > 0 {
+			return deliveries
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %v delivery", status)
+	return nil
+}
+
+func receiveWebhook(t *testing.T, received chan webhookRequest) webhookRequest {
+	t.Helper()
+	select {
+	case got := <-received:
+		return got
+	case <-time.After(time.Second):
+		t.Fatal("Expected webhook request")
+		return webhookRequest{}
+	}
+}
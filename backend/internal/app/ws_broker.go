@@ -0,0 +1,162 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// MAX_NOTIFY_PAYLOAD is the limit of a Postgres NOTIFY payload
+	MAX_NOTIFY_PAYLOAD = 8000
+	// brokerPayloadTTL is how long payloads over the limit are kept for listeners
+	brokerPayloadTTL = time.Minute
+	// brokerPayloadRef prefixes ids of stored payloads, payloads are JSON objects
+	brokerPayloadRef = "#"
+)
+
+var ErrBrokerOverflow = errors.New("broker subscriber is full")
+
+// Broker fans hub deliveries out to the hubs of every API instance,
+// so that users get events no matter which instance they are connected to
+type Broker interface {
+	// Publish sends the payload to every subscriber, including the publisher
+	Publish(payload []byte) error
+	// Subscribe returns payloads published by any instance,
+	// a nil payload means that payloads published meanwhile might be lost
+	Subscribe() <-chan []byte
+}
+
+// MemoryBroker connects hubs of a single process, it is used by default and in tests
+type MemoryBroker struct {
+	mu          sync.Mutex
+	subscribers []chan []byte
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+// Publish never blocks the hub, payloads for subscribers which are full are dropped
+func (b *MemoryBroker) Publish(payload []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var err error
+	for _, subscriber := range b.subscribers {
+		select {
+		case subscriber <- payload:
+		default:
+			err = ErrBrokerOverflow
+		}
+	}
+	return err
+}
+
+func (b *MemoryBroker) Subscribe() <-chan []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subscriber := make(chan []byte, 1024)
+	b.subscribers = append(b.subscribers, subscriber)
+	return subscriber
+}
+
+// PostgresBroker uses LISTEN/NOTIFY of the database shared by all instances,
+// payloads over the NOTIFY limit are stored and notifications carry their id
+type PostgresBroker struct {
+	db            *sql.DB
+	channel       string
+	notifications chan []byte
+}
+
+// NewPostgresBroker starts listening to the channel with a dedicated connection,
+// notifications are sent through the connection pool
+func NewPostgresBroker(db *sql.DB, dsn string, channel string, logger *log.Logger) (*PostgresBroker, error) {
+	listener := pq.NewListener(dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Printf("Hub broker listener error: %v", err)
+		}
+	})
+	err := listener.Listen(channel)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	b := &PostgresBroker{db: db, channel: channel, notifications: make(chan []byte, 1024)}
+	go func() {
+		for n := range listener.Notify {
+			// nil notification means the connection was reestablished and notifications
+			// sent meanwhile are lost, subscribers resync their clients
+			if n == nil {
+				b.notifications <- nil
+				continue
+			}
+			if !strings.HasPrefix(n.Extra, brokerPayloadRef) {
+				b.notifications <- []byte(n.Extra)
+				continue
+			}
+			payload, err := b.loadPayload(strings.TrimPrefix(n.Extra, brokerPayloadRef))
+			if err != nil {
+				logger.Printf("Can't load hub broker payload %v: %v", n.Extra, err)
+				continue
+			}
+			b.notifications <- payload
+		}
+	}()
+	go b.deleteExpiredPayloads(logger)
+	return b, nil
+}
+
+func (b *PostgresBroker) Publish(payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	notification := string(payload)
+	if len(payload) >= MAX_NOTIFY_PAYLOAD {
+		var id int64
+		err := b.db.QueryRowContext(ctx, `INSERT INTO broker_payloads (payload) VALUES ($1) RETURNING payload_id`, notification).Scan(&id)
+		if err != nil {
+			return err
+		}
+		notification = brokerPayloadRef + strconv.FormatInt(id, 10)
+	}
+
+	_, err := b.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, b.channel, notification)
+	return err
+}
+
+func (b *PostgresBroker) loadPayload(id string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var payload string
+	err := b.db.QueryRowContext(ctx, `SELECT payload FROM broker_payloads WHERE payload_id = $1`, id).Scan(&payload)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(payload), nil
+}
+
+// deleteExpiredPayloads runs on every instance, listeners load payloads right after the notification
+func (b *PostgresBroker) deleteExpiredPayloads(logger *log.Logger) {
+	ticker := time.NewTicker(brokerPayloadTTL)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		_, err := b.db.ExecContext(ctx, `DELETE FROM broker_payloads WHERE created_at < $1`, time.Now().Add(-brokerPayloadTTL))
+		cancel()
+		if err != nil {
+			logger.Printf("Can't delete expired hub broker payloads: %v", err)
+		}
+	}
+}
+
+func (b *PostgresBroker) Subscribe() <-chan []byte {
+	return b.notifications
+}
@@ -0,0 +1,68 @@
+package app_test
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/app"
+	"github.com/vasiliiperfilev/cookie/internal/database"
+	"github.com/vasiliiperfilev/cookie/internal/tester"
+)
+
+func TestIntegrationPostgresBroker(t *testing.T) {
+	dsn := fmt.Sprintf(
+		"postgres://%s:%s@localhost:%s/%s?sslmode=disable",
+		database.POSTGRES_USER,
+		database.POSTGRES_PASSWORD,
+		database.POSTGRES_PORT,
+		database.POSTGRES_DB,
+	)
+	cfg := database.Config{
+		MaxOpenConns: 25,
+		MaxIdleConns: 25,
+		MaxIdleTime:  "15m",
+		Dsn:          dsn,
+	}
+	db, err := database.OpenDB(cfg)
+	tester.AssertNoError(t, err)
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+
+	t.Run("it delivers payloads to every instance", func(t *testing.T) {
+		publisher, err := app.NewPostgresBroker(db, dsn, "cookie_hub_test", logger)
+		tester.AssertNoError(t, err)
+		subscriber, err := app.NewPostgresBroker(db, dsn, "cookie_hub_test", logger)
+		tester.AssertNoError(t, err)
+
+		err = publisher.Publish([]byte(`{"origin":"test"}`))
+		tester.AssertNoError(t, err)
+		for _, b := range []app.Broker{publisher, subscriber} {
+			select {
+			case payload := <-b.Subscribe():
+				tester.AssertValue(t, string(payload), `{"origin":"test"}`, "Expected published payload")
+			case <-time.After(time.Second):
+				t.Fatal("Expected payload to be delivered")
+			}
+		}
+	})
+
+	t.Run("it delivers payloads over NOTIFY limit", func(t *testing.T) {
+		publisher, err := app.NewPostgresBroker(db, dsn, "cookie_hub_large_test", logger)
+		tester.AssertNoError(t, err)
+		subscriber, err := app.NewPostgresBroker(db, dsn, "cookie_hub_large_test", logger)
+		tester.AssertNoError(t, err)
+
+		want := fmt.Sprintf(`{"origin":"%s"}`, strings.Repeat("a", app.MAX_NOTIFY_PAYLOAD))
+		err = publisher.Publish([]byte(want))
+		tester.AssertNoError(t, err)
+		select {
+		case payload := <-subscriber.Subscribe():
+			tester.AssertValue(t, string(payload), want, "Expected published payload")
+		case <-time.After(time.Second):
+			t.Fatal("Expected payload to be delivered")
+		}
+	})
+}
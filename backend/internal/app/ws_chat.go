@@ -1,40 +1,158 @@
 package app
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/validator"
+	"golang.org/x/exp/slices"
 )
 
-var wsUpgrader = websocket.Upgrader{
-	CheckOrigin:     func(r *http.Request) bool { return true },
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
+// Time allowed to send the auth message after connect.
+const authWait = 10 * time.Second
+
+// wsTicketTTL is how long a ticket can be exchanged for a connection
+const wsTicketTTL = 30 * time.Second
+
+// checkOrigin allows browsers of trusted origins only,
+// other clients don't send Origin and authenticate with a token or ticket
+func (a *Application) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || slices.Contains(a.config.TrustedOrigins, origin)
 }
 
-func (a *Application) wsChatHandler(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	user, err := a.AuthenticateWsUpgradeRequest(w, r)
+func (a *Application) handlePostWsTicket(w http.ResponseWriter, r *http.Request) {
+	_, err := a.AuthenticateHttpRequest(w, r)
 	if err != nil {
 		switch {
 		case errors.Is(err, ErrUnathorized):
 			a.invalidAuthenticationTokenResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// the header was validated by the authentication
+	plaintext, _ := bearerToken(r)
+	parent, err := a.models.Token.Get(data.ScopeAuthentication, data.TokenHash(plaintext))
+	if err != nil {
+		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			a.notFoundResponse(w, r)
+			a.invalidAuthenticationTokenResponse(w, r)
 		default:
 			a.serverErrorResponse(w, r, err)
 		}
 		return
 	}
-	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	ticket, err := a.models.Token.NewChild(parent, wsTicketTTL, data.ScopeWsTicket)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = writeJsonResponse(w, http.StatusCreated, ticket, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// wsChatHandler authenticates the connection with the ticket query parameter,
+// connections without it are authenticated by the first message,
+// both are answered with EventAuthenticated once the client gets events
+func (a *Application) wsChatHandler(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	// clients reconnect with the seq of the last received event to get the missed ones
+	since := readInt(qs, "since", -1, v)
+	if qs.Has("since") {
+		v.Check(since >= 0, "since", "must not be negative")
+	}
+	if !v.Valid() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	var user data.User
+	var session data.Token
+	var err error
+	if qs.Has("ticket") {
+		user, session, err = a.AuthenticateWsTicket(qs.Get("ticket"))
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrUnathorized):
+				a.invalidAuthenticationTokenResponse(w, r)
+			default:
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+	conn, err := a.wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		a.logger.Print(err)
+		return
+	}
+	if !qs.Has("ticket") {
+		user, session, err = a.authenticateWsConn(conn)
+		if err != nil {
+			closeCode := CloseUnauthorized
+			if !errors.Is(err, ErrUnathorized) {
+				a.logger.Print(err)
+				closeCode = websocket.CloseInternalServerErr
+			}
+			closeWs(conn, closeCode, err.Error())
+			return
+		}
+	}
+	// the hub indexes members of conversations of connected users
+	conversations, err := a.models.Conversation.GetAllByUserId(user.Id)
 	if err != nil {
 		a.logger.Print(err)
+		closeWs(conn, websocket.CloseInternalServerErr, ServerErrorMessage)
 		return
 	}
-	client := &Client{User: user, Conversations: map[int64]data.Conversation{}, conn: conn, hub: hub, messages: make(chan WsEvent, 256)}
+	client := &Client{User: user, session: session, conversations: map[int64]data.Conversation{}, conn: conn, hub: hub, messages: make(chan WsEvent, 256), since: since}
+	for _, conversation := range conversations {
+		client.conversations[conversation.Id] = conversation
+	}
 	client.hub.register <- client
+	// the client gets events after this one, the write goroutine isn't started yet
+	payload, _ := json.Marshal(user)
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	conn.WriteJSON(WsEvent{Type: EventAuthenticated, Payload: payload})
 
 	go client.readPump()
 	go client.writePump()
 }
+
+// authenticateWsConn reads the auth event, which must be the first message of the connection
+func (a *Application) authenticateWsConn(conn *websocket.Conn) (data.User, data.Token, error) {
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(authWait))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		return data.User{}, data.Token{}, ErrUnathorized
+	}
+	var event WsEvent
+	var dto AuthDto
+	err = readJson(bytes.NewReader(msg), &event)
+	if err != nil || event.Type != EventAuth {
+		return data.User{}, data.Token{}, ErrUnathorized
+	}
+	err = readJson(bytes.NewReader(event.Payload), &dto)
+	if err != nil {
+		return data.User{}, data.Token{}, ErrUnathorized
+	}
+	return a.AuthenticateWsToken(dto.Token)
+}
+
+// closeWs sends the close message before closing connections the hub doesn't manage
+func closeWs(conn *websocket.Conn, closeCode int, text string) {
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, text))
+	conn.Close()
+}
@@ -3,8 +3,10 @@ package app_test
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
@@ -35,9 +37,9 @@ func TestChat(t *testing.T) {
 		messageModel, appServer := createServer(2)
 		server := httptest.NewServer(appServer)
 		defer server.Close()
-		ws1 := mustDialWS(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat?token="+strings.Repeat("1", 26))
+		ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
 		defer ws1.Close()
-		ws2 := mustDialWS(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat?token="+strings.Repeat("2", 26))
+		ws2 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
 		defer ws2.Close()
 		// send first message
 		dto := PostMessageEvent{
@@ -87,9 +89,9 @@ func TestChat(t *testing.T) {
 		messageModel, appServer := createServer(2)
 		server := httptest.NewServer(appServer)
 		defer server.Close()
-		ws1 := mustDialWS(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat?token="+strings.Repeat("1", 26))
+		ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
 		defer ws1.Close()
-		ws2 := mustDialWS(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat?token="+strings.Repeat("2", 26))
+		ws2 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
 		defer ws2.Close()
 		// send message from 1 to 2
 		dto1 := PostMessageEvent{
@@ -139,11 +141,11 @@ func TestChat(t *testing.T) {
 		messageModel, appServer := createServer(10)
 		server := httptest.NewServer(appServer)
 		defer server.Close()
-		ws1 := mustDialWS(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat?token="+strings.Repeat("1", 26))
+		ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
 		defer ws1.Close()
 		// send messages from 1 to 2
 		for i := 2; i <= 9; i++ {
-			ws2 := mustDialWS(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat?token="+strings.Repeat(strconv.Itoa(i), 26))
+			ws2 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat(strconv.Itoa(i), 26))
 			defer ws2.Close()
 			for j := 1; j <= 100; j++ {
 				dto := PostMessageEvent{
@@ -177,12 +179,12 @@ func TestChat(t *testing.T) {
 		// create only 1 conversation between user ids 1 and 2
 		conversationModel := data.NewStubConversationModel(generateConversation(2), userModel)
 		messageModel := data.NewStubMessageModel(generateConversation(2), []data.Message{})
-		models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel}
+		models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel, Token: data.NewStubTokenModel(generateTokens(3))}
 		appServer := app.New(cfg, logger, models)
 		server := httptest.NewServer(appServer)
 		conns := []*websocket.Conn{}
 		for i := 1; i <= 3; i++ {
-			ws := mustDialWS(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat?token="+strings.Repeat(strconv.Itoa(i), 26))
+			ws := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat(strconv.Itoa(i), 26))
 			defer ws.Close()
 			conns = append(conns, ws)
 		}
@@ -214,16 +216,783 @@ func TestChat(t *testing.T) {
 	})
 }
 
+func TestChatRead(t *testing.T) {
+	t.Run("other participant receives read receipt", func(t *testing.T) {
+		_, appServer := createServer(2)
+		server := httptest.NewServer(appServer)
+		defer server.Close()
+		ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
+		defer ws1.Close()
+		ws2 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
+		defer ws2.Close()
+		// user 1 sends a message
+		msg := data.Message{Id: 1, SenderId: 1, ConversationId: 1, Content: "test1"}
+		js := createWsPayload(t, PostMessageEvent{
+			Type:    app.EventMessage,
+			Payload: data.PostMessageDto{ConversationId: 1, Content: "test1"},
+		})
+		writeWSMessage(t, ws1, js)
+		within(t, 500*time.Millisecond, func() { assertMessage(t, ws1, msg) })
+		within(t, 500*time.Millisecond, func() { assertMessage(t, ws2, msg) })
+		// user 2 reads it
+		js = createWsPayload(t, struct {
+			Type    string
+			Payload data.PostReadDto
+		}{
+			Type:    app.EventRead,
+			Payload: data.PostReadDto{ConversationId: 1, MessageId: 1},
+		})
+		writeWSMessage(t, ws2, js)
+		want := data.ReadReceipt{ConversationId: 1, UserId: 2, MessageId: 1}
+		within(t, 500*time.Millisecond, func() { assertReadEvent(t, ws1, want) })
+	})
+
+	t.Run("it responds with error event if message is not in conversation", func(t *testing.T) {
+		_, appServer := createServer(2)
+		server := httptest.NewServer(appServer)
+		defer server.Close()
+		ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
+		defer ws1.Close()
+		js := createWsPayload(t, struct {
+			Type    string
+			Payload data.PostReadDto
+		}{
+			Type:    app.EventRead,
+			Payload: data.PostReadDto{ConversationId: 1, MessageId: 42},
+		})
+		writeWSMessage(t, ws1, js)
+		wantError := app.WsError{Code: app.ErrorCodeNotFound, Message: app.NotFoundMessage, Errors: map[string]string{}}
+		within(t, 500*time.Millisecond, func() { assertErrorEvent(t, ws1, wantError) })
+	})
+}
+
+func TestChatPresence(t *testing.T) {
+	t.Run("contacts receive online and offline presence", func(t *testing.T) {
+		_, appServer := createServer(2)
+		server := httptest.NewServer(appServer)
+		defer server.Close()
+		ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
+		defer ws1.Close()
+		ws2 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
+		within(t, 500*time.Millisecond, func() {
+			got := readPresenceEvent(t, ws1)
+			tester.AssertValue(t, got.UserId, int64(2), "Expected presence of user 2")
+			tester.AssertValue(t, got.Online, true, "Expected user 2 to be online")
+		})
+		ws2.Close()
+		within(t, 500*time.Millisecond, func() {
+			got := readPresenceEvent(t, ws1)
+			tester.AssertValue(t, got.UserId, int64(2), "Expected presence of user 2")
+			tester.AssertValue(t, got.Online, false, "Expected user 2 to be offline")
+			if got.LastSeen.IsZero() {
+				t.Error("Expected to have last seen time")
+			}
+		})
+	})
+
+	t.Run("conversation listing includes presence of other participants", func(t *testing.T) {
+		_, appServer := createServer(2)
+		server := httptest.NewServer(appServer)
+		defer server.Close()
+		ws2 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
+		defer ws2.Close()
+		passed := tester.RetryUntil(500*time.Millisecond, func() bool {
+			conversations := mustGetConversations(t, appServer, 1)
+			presence := conversations[0].Presence
+			return len(presence) == 1 && presence[0].UserId == 2 && presence[0].Online
+		})
+		if !passed {
+			t.Fatal("Expected user 2 to be online in conversation listing")
+		}
+	})
+
+	t.Run("other participants receive typing event which is not persisted", func(t *testing.T) {
+		messageModel, appServer := createServer(2)
+		server := httptest.NewServer(appServer)
+		defer server.Close()
+		ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
+		defer ws1.Close()
+		ws2 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
+		defer ws2.Close()
+		js := createWsPayload(t, struct {
+			Type    string
+			Payload app.TypingDto
+		}{
+			Type:    app.EventTyping,
+			Payload: app.TypingDto{ConversationId: 1, Typing: true},
+		})
+		writeWSMessage(t, ws1, js)
+		want := app.Typing{ConversationId: 1, UserId: 1, Typing: true}
+		within(t, 500*time.Millisecond, func() {
+			passed := tester.RetryUntil(500*time.Millisecond, func() bool {
+				_, msg, err := ws2.ReadMessage()
+				tester.AssertNoError(t, err)
+				var got struct {
+					Type    string
+					Payload app.Typing
+				}
+				json.NewDecoder(bytes.NewReader(msg)).Decode(&got)
+				return got.Type == app.EventTyping && got.Payload == want
+			})
+			if !passed {
+				t.Errorf("Expected to have %v", want)
+			}
+		})
+		messages, err := messageModel.GetAllByConversationId(1)
+		tester.AssertNoError(t, err)
+		// only sentinel message
+		tester.AssertValue(t, len(messages), 1, "Expected typing not to be persisted")
+	})
+}
+
+func TestChatMessageChanges(t *testing.T) {
+	t.Run("members receive edited and deleted messages", func(t *testing.T) {
+		cfg := app.Config{Port: 4000, Env: "development"}
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		userModel := data.NewStubUserModel(generateUsers(2))
+		conversations := generateConversation(2)
+		messageModel := data.NewStubMessageModel(conversations, []data.Message{
+			{ConversationId: 1, Content: "test", SenderId: 1, CreatedAt: time.Now()},
+		})
+		conversationModel := data.NewStubConversationModel(conversations, userModel)
+		models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel, Token: data.NewStubTokenModel(generateTokens(2))}
+		server := httptest.NewServer(app.New(cfg, logger, models))
+		defer server.Close()
+		ws2 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
+		defer ws2.Close()
+
+		js := createWsPayload(t, data.PatchMessageDto{Content: "edited"})
+		request, err := http.NewRequest(http.MethodPatch, server.URL+"/v1/messages/1", bytes.NewReader(js))
+		tester.AssertNoError(t, err)
+		request.Header.Set("Authorization", "Bearer "+strings.Repeat("1", 26))
+		response, err := http.DefaultClient.Do(request)
+		tester.AssertNoError(t, err)
+		tester.AssertStatus(t, response.StatusCode, http.StatusOK)
+		within(t, 500*time.Millisecond, func() {
+			got := readMessageEvent(t, ws2, app.EventMessageEdited)
+			tester.AssertValue(t, got.Content, "edited", "Expected edited content")
+		})
+
+		request, err = http.NewRequest(http.MethodDelete, server.URL+"/v1/messages/1", nil)
+		tester.AssertNoError(t, err)
+		request.Header.Set("Authorization", "Bearer "+strings.Repeat("1", 26))
+		response, err = http.DefaultClient.Do(request)
+		tester.AssertNoError(t, err)
+		tester.AssertStatus(t, response.StatusCode, http.StatusOK)
+		within(t, 500*time.Millisecond, func() {
+			got := readMessageEvent(t, ws2, app.EventMessageDeleted)
+			tester.AssertValue(t, got.Id, int64(1), "Expected deleted message id")
+			if got.DeletedAt == nil {
+				t.Error("Expected tombstone")
+			}
+		})
+	})
+}
+
+func TestChatMembership(t *testing.T) {
+	t.Run("removed member stops receiving conversation events", func(t *testing.T) {
+		cfg := app.Config{Port: 4000, Env: "development"}
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		users := generateUsers(3)
+		conversations := []data.Conversation{{
+			Id:    1,
+			Group: true,
+			Users: users,
+			Participants: []data.Participant{
+				{UserId: 1, Role: data.RoleOwner},
+				{UserId: 2, Role: data.RoleMember},
+				{UserId: 3, Role: data.RoleMember},
+			},
+		}}
+		userModel := data.NewStubUserModel(users)
+		messageModel := data.NewStubMessageModel(conversations, []data.Message{})
+		conversationModel := data.NewStubConversationModel(conversations, userModel)
+		models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel, Token: data.NewStubTokenModel(generateTokens(3))}
+		server := httptest.NewServer(app.New(cfg, logger, models))
+		defer server.Close()
+		ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
+		defer ws1.Close()
+		ws3 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("3", 26))
+		defer ws3.Close()
+		// both clients cache the conversation
+		msg := data.Message{Id: 1, SenderId: 1, ConversationId: 1, Content: "before"}
+		writeWSMessage(t, ws1, createWsPayload(t, PostMessageEvent{
+			Type:    app.EventMessage,
+			Payload: data.PostMessageDto{ConversationId: 1, Content: "before"},
+		}))
+		within(t, 500*time.Millisecond, func() { assertMessage(t, ws1, msg) })
+		within(t, 500*time.Millisecond, func() { assertMessage(t, ws3, msg) })
+
+		request, err := http.NewRequest(http.MethodDelete, server.URL+"/v1/conversations/1/members/3", nil)
+		tester.AssertNoError(t, err)
+		request.Header.Set("Authorization", "Bearer "+strings.Repeat("1", 26))
+		response, err := http.DefaultClient.Do(request)
+		tester.AssertNoError(t, err)
+		tester.AssertStatus(t, response.StatusCode, http.StatusOK)
+		within(t, 500*time.Millisecond, func() {
+			got := readConversationEvent(t, ws3)
+			tester.AssertValue(t, got.RoleOf(3), "", "Expected removed user to be notified")
+		})
+		within(t, 500*time.Millisecond, func() { readConversationEvent(t, ws1) })
+
+		writeWSMessage(t, ws1, createWsPayload(t, PostMessageEvent{
+			Type:    app.EventMessage,
+			Payload: data.PostMessageDto{ConversationId: 1, Content: "after"},
+		}))
+		within(t, 500*time.Millisecond, func() {
+			assertMessage(t, ws1, data.Message{Id: 2, SenderId: 1, ConversationId: 1, Content: "after"})
+		})
+		// removed user can't send either, the error is the next event it receives
+		writeWSMessage(t, ws3, createWsPayload(t, PostMessageEvent{
+			Type:    app.EventMessage,
+			Payload: data.PostMessageDto{ConversationId: 1, Content: "still here"},
+		}))
+		within(t, 500*time.Millisecond, func() {
+			for {
+				_, msg, err := ws3.ReadMessage()
+				tester.AssertNoError(t, err)
+				var got app.WsEvent
+				json.NewDecoder(bytes.NewReader(msg)).Decode(&got)
+				if got.Type != app.EventPresence {
+					tester.AssertValue(t, got.Type, app.EventError, "Expected no messages after removal")
+					return
+				}
+			}
+		})
+	})
+}
+
+func TestChatMute(t *testing.T) {
+	t.Run("muted conversation delivers flagged messages", func(t *testing.T) {
+		cfg := app.Config{Port: 4000, Env: "development"}
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		userModel := data.NewStubUserModel(generateUsers(3))
+		conversations := generateConversation(3)
+		messageModel := data.NewStubMessageModel(conversations, []data.Message{})
+		conversationModel := data.NewStubConversationModel(conversations, userModel)
+		models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel, Token: data.NewStubTokenModel(generateTokens(3))}
+		server := httptest.NewServer(app.New(cfg, logger, models))
+		defer server.Close()
+		ws2 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
+		defer ws2.Close()
+		ws3 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("3", 26))
+		defer ws3.Close()
+		ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
+		defer ws1.Close()
+
+		mutedUntil := time.Now().Add(time.Hour)
+		js := createWsPayload(t, data.ConversationSettings{MutedUntil: &mutedUntil})
+		request, err := http.NewRequest(http.MethodPut, server.URL+"/v1/conversations/1/settings", bytes.NewReader(js))
+		tester.AssertNoError(t, err)
+		request.Header.Set("Authorization", "Bearer "+strings.Repeat("2", 26))
+		response, err := http.DefaultClient.Do(request)
+		tester.AssertNoError(t, err)
+		tester.AssertStatus(t, response.StatusCode, http.StatusOK)
+
+		writeWSMessage(t, ws1, createWsPayload(t, PostMessageEvent{
+			Type:    app.EventMessage,
+			Payload: data.PostMessageDto{ConversationId: 1, Content: "quiet"},
+		}))
+		within(t, 500*time.Millisecond, func() {
+			tester.AssertValue(t, readMessageMuted(t, ws2), true, "Expected message flagged as muted")
+			tester.AssertValue(t, readMessageMuted(t, ws3), false, "Expected message not muted for other members")
+		})
+	})
+}
+
+func TestChatResume(t *testing.T) {
+	cfg := app.Config{Port: 4000, Env: "development"}
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	userModel := data.NewStubUserModel(generateUsers(2))
+	conversations := generateConversation(2)
+	messageModel := data.NewStubMessageModel(conversations, []data.Message{})
+	conversationModel := data.NewStubConversationModel(conversations, userModel)
+	eventModel := data.NewStubEventModel()
+	models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel, Token: data.NewStubTokenModel(generateTokens(2)), Event: eventModel}
+	server := httptest.NewServer(app.New(cfg, logger, models))
+	defer server.Close()
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/chat"
+	sendMessage := func(ws *websocket.Conn, content string) {
+		writeWSMessage(t, ws, createWsPayload(t, PostMessageEvent{
+			Type:    app.EventMessage,
+			Payload: data.PostMessageDto{ConversationId: 1, Content: content},
+		}))
+	}
+
+	t.Run("it replays missed events since the seq and continues live", func(t *testing.T) {
+		ws1 := mustDialChat(t, wsUrl, strings.Repeat("1", 26))
+		defer ws1.Close()
+		ws2 := mustDialChat(t, wsUrl, strings.Repeat("2", 26))
+		sendMessage(ws1, "first")
+		within(t, 500*time.Millisecond, func() {
+			msg, seq := readSequencedMessage(t, ws2)
+			tester.AssertValue(t, msg.Content, "first", "Expected first message")
+			tester.AssertValue(t, seq, int64(1), "Expected first seq")
+		})
+		ws2.Close()
+
+		sendMessage(ws1, "second")
+		sendMessage(ws1, "third")
+		// sender receives own messages once they are stored
+		within(t, 500*time.Millisecond, func() {
+			for i := 0; i < 3; i++ {
+				readSequencedMessage(t, ws1)
+			}
+		})
+
+		ws2 = mustDialChat(t, wsUrl+"?since=1", strings.Repeat("2", 26))
+		defer ws2.Close()
+		within(t, 500*time.Millisecond, func() {
+			for i, want := range []string{"second", "third"} {
+				msg, seq := readSequencedMessage(t, ws2)
+				tester.AssertValue(t, msg.Content, want, "Expected missed message")
+				tester.AssertValue(t, seq, int64(i+2), "Expected increasing seq")
+			}
+		})
+		sendMessage(ws1, "fourth")
+		within(t, 500*time.Millisecond, func() {
+			msg, seq := readSequencedMessage(t, ws2)
+			tester.AssertValue(t, msg.Content, "fourth", "Expected live message")
+			tester.AssertValue(t, seq, int64(4), "Expected seq after replayed ones")
+		})
+	})
+
+	t.Run("it stores acked seq", func(t *testing.T) {
+		ws2 := mustDialChat(t, wsUrl, strings.Repeat("2", 26))
+		defer ws2.Close()
+		writeWSMessage(t, ws2, createWsPayload(t, app.WsEvent{Type: app.EventAck, Payload: createWsPayload(t, app.AckDto{Seq: 3})}))
+		acked := tester.RetryUntil(500*time.Millisecond, func() bool { return eventModel.Acked(2) == 3 })
+		tester.AssertValue(t, acked, true, "Expected events to be acked")
+	})
+
+	t.Run("it closes with resync after a truncated replay", func(t *testing.T) {
+		events, _ := eventModel.GetAllSince(2, 0, data.EventReplayLimit)
+		since := events[len(events)-1].Seq
+		for i := 0; i < data.EventReplayLimit+1; i++ {
+			eventModel.Append([]int64{2}, app.EventRead, []byte(`{}`))
+		}
+
+		ws2 := mustDialChat(t, wsUrl+fmt.Sprintf("?since=%v", since), strings.Repeat("2", 26))
+		defer ws2.Close()
+		ws2.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for i := 0; i < data.EventReplayLimit; i++ {
+			_, _, err := ws2.ReadMessage()
+			tester.AssertNoError(t, err)
+		}
+		_, _, err := ws2.ReadMessage()
+		if !websocket.IsCloseError(err, app.CloseResync) {
+			t.Fatalf("Expected close with code %v, got %v", app.CloseResync, err)
+		}
+
+		// the rest is replayed after reconnect
+		since += data.EventReplayLimit
+		ws2 = mustDialChat(t, wsUrl+fmt.Sprintf("?since=%v", since), strings.Repeat("2", 26))
+		defer ws2.Close()
+		within(t, 500*time.Millisecond, func() {
+			var evt app.WsEvent
+			err := ws2.ReadJSON(&evt)
+			tester.AssertNoError(t, err)
+			tester.AssertValue(t, evt.Seq, since+1, "Expected the last event")
+		})
+	})
+
+	t.Run("it 422 if since is negative", func(t *testing.T) {
+		_, response, err := websocket.DefaultDialer.Dial(wsUrl+"?since=-2", nil)
+		tester.AssertError(t, err)
+		tester.AssertStatus(t, response.StatusCode, http.StatusUnprocessableEntity)
+	})
+}
+
+func TestChatAuth(t *testing.T) {
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	newServer := func(tokens []data.Token) *httptest.Server {
+		userModel := data.NewStubUserModel(generateUsers(2))
+		conversations := generateConversation(2)
+		models := data.Models{
+			Message:      data.NewStubMessageModel(conversations, []data.Message{}),
+			User:         userModel,
+			Conversation: data.NewStubConversationModel(conversations, userModel),
+			Token:        data.NewStubTokenModel(tokens),
+		}
+		cfg := app.Config{Port: 4000, Env: "development", TrustedOrigins: []string{"https://cookie.test"}}
+		return httptest.NewServer(app.New(cfg, logger, models))
+	}
+	assertClosed := func(t *testing.T, ws *websocket.Conn, code int) {
+		t.Helper()
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		_, _, err := ws.ReadMessage()
+		if !websocket.IsCloseError(err, code) {
+			t.Fatalf("Expected close with code %v, got %v", code, err)
+		}
+	}
+
+	t.Run("it connects with a single use ticket", func(t *testing.T) {
+		server := newServer(generateTokens(2))
+		defer server.Close()
+		response := sendAuthorizedRequest(t, server.Config.Handler, http.MethodPost, "/v1/chat/tickets", "1", nil)
+		tester.AssertStatus(t, response.Code, http.StatusCreated)
+		ticket := tester.ParseResponse[data.Token](t, response)
+		wsUrl := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/chat?ticket=" + ticket.Plaintext
+		ws := mustDialWS(t, wsUrl)
+		defer ws.Close()
+		mustReadAuthenticated(t, ws)
+
+		_, response2, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+		tester.AssertError(t, err)
+		tester.AssertStatus(t, response2.StatusCode, http.StatusUnauthorized)
+	})
+
+	t.Run("it closes connections which don't authenticate with the first message", func(t *testing.T) {
+		server := newServer(generateTokens(2))
+		defer server.Close()
+		ws := mustDialWS(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat")
+		defer ws.Close()
+		writeWSMessage(t, ws, createWsPayload(t, PostMessageEvent{
+			Type:    app.EventMessage,
+			Payload: data.PostMessageDto{ConversationId: 1, Content: "hello"},
+		}))
+		assertClosed(t, ws, app.CloseUnauthorized)
+	})
+
+	t.Run("it closes connections when the token expires", func(t *testing.T) {
+		tokens := generateTokens(2)
+		tokens[0].Expiry = time.Now().Add(300 * time.Millisecond)
+		server := newServer(tokens)
+		defer server.Close()
+		ws := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", tokens[0].Plaintext)
+		defer ws.Close()
+		assertClosed(t, ws, app.CloseUnauthorized)
+	})
+
+	t.Run("it allows browsers of trusted origins only", func(t *testing.T) {
+		server := newServer(generateTokens(2))
+		defer server.Close()
+		wsUrl := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/chat"
+		_, response, err := websocket.DefaultDialer.Dial(wsUrl, http.Header{"Origin": {"https://evil.test"}})
+		tester.AssertError(t, err)
+		tester.AssertStatus(t, response.StatusCode, http.StatusForbidden)
+		ws, _, err := websocket.DefaultDialer.Dial(wsUrl, http.Header{"Origin": {"https://cookie.test"}})
+		tester.AssertNoError(t, err)
+		ws.Close()
+	})
+}
+
+func TestChatRequests(t *testing.T) {
+	_, appServer := createServer(2)
+	server := httptest.NewServer(appServer)
+	defer server.Close()
+	ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
+	defer ws1.Close()
+	readUntil := func(eventType string) app.WsEvent {
+		t.Helper()
+		var got app.WsEvent
+		within(t, 500*time.Millisecond, func() {
+			for got.Type != eventType {
+				got = app.WsEvent{}
+				tester.AssertNoError(t, ws1.ReadJSON(&got))
+			}
+		})
+		return got
+	}
+
+	t.Run("it replies to the request with the persisted entity", func(t *testing.T) {
+		writeWSMessage(t, ws1, createWsPayload(t, app.WsEvent{
+			Type:      app.EventMessage,
+			RequestId: "send-1",
+			Payload:   createWsPayload(t, data.PostMessageDto{ConversationId: 1, Content: "hello"}),
+		}))
+		got := readUntil(app.EventReply)
+		tester.AssertValue(t, got.RequestId, "send-1", "Expected request id of the request")
+		var msg data.Message
+		tester.AssertNoError(t, json.Unmarshal(got.Payload, &msg))
+		tester.AssertValue(t, msg.Content, "hello", "Expected persisted message")
+		if msg.Id == 0 {
+			t.Fatalf("Expected persisted message id")
+		}
+	})
+
+	t.Run("it responds with error code and request id", func(t *testing.T) {
+		cases := []struct {
+			event app.WsEvent
+			code  string
+		}{
+			{app.WsEvent{Type: app.EventMessage, RequestId: "send-2", Payload: createWsPayload(t, data.PostMessageDto{ConversationId: 99, Content: "hello"})}, app.ErrorCodeNotFound},
+			{app.WsEvent{Type: app.EventMessage, RequestId: "send-3", Payload: createWsPayload(t, "wrong payload")}, app.ErrorCodeInvalidPayload},
+			{app.WsEvent{Type: "unknown", RequestId: "send-4"}, app.ErrorCodeUnsupportedEvent},
+		}
+		for _, c := range cases {
+			writeWSMessage(t, ws1, createWsPayload(t, c.event))
+			got := readUntil(app.EventError)
+			var wsError app.WsError
+			tester.AssertNoError(t, json.Unmarshal(got.Payload, &wsError))
+			tester.AssertValue(t, got.RequestId, c.event.RequestId, "Expected request id of the failed request")
+			tester.AssertValue(t, wsError.Code, c.code, "Expected error code")
+		}
+	})
+
+	t.Run("it publishes schema of every event", func(t *testing.T) {
+		response, err := http.Get(server.URL + "/v1/chat/schema")
+		tester.AssertNoError(t, err)
+		defer response.Body.Close()
+		tester.AssertStatus(t, response.StatusCode, http.StatusOK)
+		var schema app.WsSchema
+		tester.AssertNoError(t, json.NewDecoder(response.Body).Decode(&schema))
+		tester.AssertValue(t, schema.Version, app.WsProtocolVersion, "Expected protocol version")
+		for _, eventType := range []string{app.EventAuth, app.EventMessage, app.EventReply, app.EventError, app.EventAck, app.EventTyping} {
+			if _, ok := schema.Events[eventType]; !ok {
+				t.Fatalf("Expected schema of %v event", eventType)
+			}
+		}
+		request := schema.Events[app.EventMessage].Request
+		tester.AssertValue(t, fmt.Sprint(request["required"]), "[conversationId content prevMessageId]", "Expected required fields of the message request")
+	})
+}
+
+func TestChatOrders(t *testing.T) {
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	userModel := data.NewStubUserModel(generateUsers(2))
+	conversations := generateConversation(2)
+	messageModel := data.NewStubMessageModel(conversations, []data.Message{})
+	conversationModel := data.NewStubConversationModel(conversations, userModel)
+	itemModel := data.NewStubItemModel([]data.Item{{Id: 1, SupplierId: 2}})
+	orderModel := data.NewStubOrderModel([]data.Order{}, itemModel, conversationModel, messageModel)
+	models := data.Models{
+		Message:      messageModel,
+		User:         userModel,
+		Conversation: conversationModel,
+		Item:         itemModel,
+		Order:        orderModel,
+		Permission:   data.NewStubPermissionsModel(),
+		Token:        data.NewStubTokenModel(generateTokens(2)),
+		Event:        data.NewStubEventModel(),
+	}
+	appServer := app.New(app.Config{Port: 4000, Env: "development"}, logger, models)
+	server := httptest.NewServer(appServer)
+	defer server.Close()
+	// user 1 is a client and user 2 is a supplier
+	client := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
+	defer client.Close()
+	supplier := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
+	defer supplier.Close()
+	readUntil := func(ws *websocket.Conn, eventType string) app.WsEvent {
+		t.Helper()
+		var got app.WsEvent
+		within(t, 500*time.Millisecond, func() {
+			for got.Type != eventType {
+				got = app.WsEvent{}
+				tester.AssertNoError(t, ws.ReadJSON(&got))
+			}
+		})
+		return got
+	}
+	readOrder := func(event app.WsEvent) data.Order {
+		t.Helper()
+		var order data.Order
+		tester.AssertNoError(t, json.Unmarshal(event.Payload, &order))
+		return order
+	}
+	var created data.Order
+
+	t.Run("it creates the order and sends it to members", func(t *testing.T) {
+		writeWSMessage(t, client, createWsPayload(t, app.WsEvent{
+			Type:      app.EventNewOrder,
+			RequestId: "order-1",
+			Payload:   createWsPayload(t, data.PostOrderDto{ConversationId: 1, Items: []data.ItemQuantity{{ItemId: 1, Quantity: 2}}}),
+		}))
+		created = readOrder(readUntil(client, app.EventReply))
+		stored, err := orderModel.GetById(created.Id)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, created.StateId, data.OrderStateCreated, "Expected created order")
+		tester.AssertValue(t, created.Client.Id, int64(1), "Expected sender to be the client of the order")
+		tester.AssertValue(t, stored.MessageId, created.MessageId, "Expected stored order")
+		got := readOrder(readUntil(supplier, app.EventNewOrder))
+		tester.AssertValue(t, got.Id, created.Id, "Expected supplier to receive the order")
+	})
+
+	t.Run("it changes the order state and sends it to members", func(t *testing.T) {
+		writeWSMessage(t, supplier, createWsPayload(t, app.WsEvent{
+			Type:      app.EventUpdateOrder,
+			RequestId: "order-2",
+			Payload:   createWsPayload(t, app.UpdateOrderDto{OrderId: created.Id, PatchOrderDto: data.PatchOrderDto{StateId: data.OrderStateAccepted}}),
+		}))
+		reply := readOrder(readUntil(supplier, app.EventReply))
+		tester.AssertValue(t, reply.StateId, data.OrderStateAccepted, "Expected accepted order")
+		got := readOrder(readUntil(client, app.EventUpdateOrder))
+		tester.AssertValue(t, got.StateId, data.OrderStateAccepted, "Expected client to receive the accepted order")
+	})
+
+	t.Run("it responds with error code if the command isn't permitted or valid", func(t *testing.T) {
+		cases := []struct {
+			ws    *websocket.Conn
+			event app.WsEvent
+			code  string
+		}{
+			{supplier, app.WsEvent{Type: app.EventNewOrder, RequestId: "order-3", Payload: createWsPayload(t, data.PostOrderDto{ConversationId: 1, Items: []data.ItemQuantity{{ItemId: 1, Quantity: 1}}})}, app.ErrorCodeForbidden},
+			{client, app.WsEvent{Type: app.EventUpdateOrder, RequestId: "order-4", Payload: createWsPayload(t, app.UpdateOrderDto{OrderId: created.Id, PatchOrderDto: data.PatchOrderDto{StateId: data.OrderStateFulfilled}})}, app.ErrorCodeForbidden},
+			{client, app.WsEvent{Type: app.EventNewOrder, RequestId: "order-5", Payload: createWsPayload(t, data.PostOrderDto{ConversationId: 1})}, app.ErrorCodeFailedValidation},
+			{client, app.WsEvent{Type: app.EventUpdateOrder, RequestId: "order-6", Payload: createWsPayload(t, app.UpdateOrderDto{OrderId: 99, PatchOrderDto: data.PatchOrderDto{StateId: data.OrderStateAccepted}})}, app.ErrorCodeNotFound},
+			// the order was accepted since it was created
+			{supplier, app.WsEvent{Type: app.EventUpdateOrder, RequestId: "order-7", Payload: createWsPayload(t, app.UpdateOrderDto{OrderId: created.Id, Version: created.Version, PatchOrderDto: data.PatchOrderDto{StateId: data.OrderStateFulfilled}})}, app.ErrorCodePreconditionFailed},
+		}
+		for _, c := range cases {
+			writeWSMessage(t, c.ws, createWsPayload(t, c.event))
+			got := readUntil(c.ws, app.EventError)
+			var wsError app.WsError
+			tester.AssertNoError(t, json.Unmarshal(got.Payload, &wsError))
+			tester.AssertValue(t, got.RequestId, c.event.RequestId, "Expected request id of the failed request")
+			tester.AssertValue(t, wsError.Code, c.code, "Expected error code")
+		}
+		stored, err := orderModel.GetById(created.Id)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, stored.StateId, data.OrderStateAccepted, "Expected order to not change")
+	})
+
+	t.Run("it sends orders changed over REST to members", func(t *testing.T) {
+		request := createPatchOrderRequest(t, data.PatchOrderDto{StateId: data.OrderStateFulfilled}, 2, created.Id)
+		response := httptest.NewRecorder()
+		appServer.ServeHTTP(response, request)
+
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		got := readOrder(readUntil(client, app.EventUpdateOrder))
+		tester.AssertValue(t, got.StateId, data.OrderStateFulfilled, "Expected client to receive the fulfilled order")
+		got = readOrder(readUntil(supplier, app.EventUpdateOrder))
+		tester.AssertValue(t, got.StateId, data.OrderStateFulfilled, "Expected supplier to receive own change")
+	})
+
+	t.Run("it sends items changed over REST to users who share a conversation with the supplier", func(t *testing.T) {
+		requestBody := new(bytes.Buffer)
+		json.NewEncoder(requestBody).Encode(data.PostItemDto{Name: "flour", Unit: "kg", Size: 2})
+		request, err := http.NewRequest(http.MethodPut, "/v1/items/1", requestBody)
+		tester.AssertNoError(t, err)
+		request.Header.Set("Authorization", "Bearer "+strings.Repeat("2", 26))
+		response := httptest.NewRecorder()
+		appServer.ServeHTTP(response, request)
+
+		tester.AssertStatus(t, response.Code, http.StatusOK)
+		var item data.Item
+		tester.AssertNoError(t, json.Unmarshal(readUntil(client, app.EventItemUpdated).Payload, &item))
+		tester.AssertValue(t, item.Name, "flour", "Expected client to receive the changed item")
+	})
+}
+
+func TestChatInstances(t *testing.T) {
+	t.Run("members connected to other instance receive messages", func(t *testing.T) {
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		userModel := data.NewStubUserModel(generateUsers(2))
+		conversations := generateConversation(2)
+		messageModel := data.NewStubMessageModel(conversations, []data.Message{})
+		conversationModel := data.NewStubConversationModel(conversations, userModel)
+		// instances share the database and so the outbox
+		models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel, Token: data.NewStubTokenModel(generateTokens(2)), Event: data.NewStubEventModel(), Outbox: data.NewStubOutboxModel()}
+		broker := app.NewMemoryBroker()
+		server1 := httptest.NewServer(app.New(app.Config{Port: 4000, Env: "development", Broker: broker}, logger, models))
+		defer server1.Close()
+		server2 := httptest.NewServer(app.New(app.Config{Port: 4001, Env: "development", Broker: broker}, logger, models))
+		defer server2.Close()
+		ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server1.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
+		defer ws1.Close()
+		ws2 := mustDialChat(t, "ws"+strings.TrimPrefix(server2.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
+		defer ws2.Close()
+
+		writeWSMessage(t, ws1, createWsPayload(t, PostMessageEvent{
+			Type:    app.EventMessage,
+			Payload: data.PostMessageDto{ConversationId: 1, Content: "from the other instance"},
+		}))
+		within(t, 500*time.Millisecond, func() {
+			msg, seq := readSequencedMessage(t, ws2)
+			tester.AssertValue(t, msg.Content, "from the other instance", "Expected message from the other instance")
+			tester.AssertValue(t, seq, int64(1), "Expected seq of the recipient")
+		})
+		within(t, 500*time.Millisecond, func() {
+			msg, _ := readSequencedMessage(t, ws1)
+			tester.AssertValue(t, msg.Content, "from the other instance", "Expected sender to receive own message once")
+		})
+	})
+
+	t.Run("members connected to other instance receive messages over the NOTIFY limit", func(t *testing.T) {
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		userModel := data.NewStubUserModel(generateUsers(2))
+		conversations := generateConversation(2)
+		messageModel := data.NewStubMessageModel(conversations, []data.Message{})
+		conversationModel := data.NewStubConversationModel(conversations, userModel)
+		models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel, Token: data.NewStubTokenModel(generateTokens(2)), Event: data.NewStubEventModel(), Outbox: data.NewStubOutboxModel()}
+		broker := notifyBroker{app.NewMemoryBroker()}
+		server1 := httptest.NewServer(app.New(app.Config{Port: 4000, Env: "development", Broker: broker}, logger, models))
+		defer server1.Close()
+		server2 := httptest.NewServer(app.New(app.Config{Port: 4001, Env: "development", Broker: broker}, logger, models))
+		defer server2.Close()
+		ws2 := mustDialChat(t, "ws"+strings.TrimPrefix(server2.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
+		defer ws2.Close()
+
+		content := strings.Repeat("a", app.MAX_NOTIFY_PAYLOAD)
+		response := sendAuthorizedRequest(t, server1.Config.Handler, http.MethodPost, "/v1/conversations/1/messages", "1", data.PostMessageDto{Content: content})
+		tester.AssertStatus(t, response.Code, http.StatusCreated)
+		within(t, 500*time.Millisecond, func() {
+			msg, _ := readSequencedMessage(t, ws2)
+			tester.AssertValue(t, msg.Content, content, "Expected message loaded from the event log")
+		})
+	})
+
+	t.Run("users see presence of contacts connected to other instance", func(t *testing.T) {
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		userModel := data.NewStubUserModel(generateUsers(2))
+		conversations := generateConversation(2)
+		messageModel := data.NewStubMessageModel(conversations, []data.Message{})
+		conversationModel := data.NewStubConversationModel(conversations, userModel)
+		// instances share presence in the database
+		models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel, Token: data.NewStubTokenModel(generateTokens(2)), Presence: data.NewStubPresenceModel()}
+		broker := app.NewMemoryBroker()
+		server1 := httptest.NewServer(app.New(app.Config{Port: 4000, Env: "development", Broker: broker}, logger, models))
+		defer server1.Close()
+		app2 := app.New(app.Config{Port: 4001, Env: "development", Broker: broker}, logger, models)
+		server2 := httptest.NewServer(app2)
+		defer server2.Close()
+		ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server1.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
+		defer ws1.Close()
+		laptop := mustDialChat(t, "ws"+strings.TrimPrefix(server1.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
+		defer laptop.Close()
+		within(t, 500*time.Millisecond, func() {
+			got := readPresenceEvent(t, ws1)
+			tester.AssertValue(t, got.Online, true, "Expected user 2 to be online")
+		})
+		presence := mustGetConversations(t, app2, 1)[0].Presence
+		tester.AssertValue(t, len(presence) == 1 && presence[0].Online, true, "Expected user 2 to be online in listing of other instance")
+
+		// user 2 stays online while connected to any instance
+		phone := mustDialChat(t, "ws"+strings.TrimPrefix(server2.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
+		phone.Close()
+		ws1.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, payload, err := ws1.ReadMessage()
+		if err == nil {
+			t.Fatalf("Expected no presence event while user 2 is connected to other instance, got %s", payload)
+		}
+		presence = mustGetConversations(t, app2, 1)[0].Presence
+		tester.AssertValue(t, presence[0].Online, true, "Expected user 2 to stay online")
+	})
+}
+
+// notifyBroker rejects payloads over the NOTIFY limit, deliveries of stored events have to fit it
+type notifyBroker struct {
+	*app.MemoryBroker
+}
+
+func (b notifyBroker) Publish(payload []byte) error {
+	if len(payload) >= app.MAX_NOTIFY_PAYLOAD {
+		return errors.New("payload is too large")
+	}
+	return b.MemoryBroker.Publish(payload)
+}
+
 func TestChatErrors(t *testing.T) {
 	t.Run("it handles client disconnection", func(t *testing.T) {
 		_, appServer := createServer(2)
 		server := httptest.NewServer(appServer)
 		defer server.Close()
-		ws1 := mustDialWS(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat?token="+strings.Repeat("1", 26))
+		ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
 		err := ws1.Close()
 		tester.AssertNoError(t, err)
 		// can establish ws connection again
-		ws1 = mustDialWS(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat?token="+strings.Repeat("1", 26))
+		ws1 = mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
 		defer ws1.Close()
 	})
 
@@ -231,8 +1000,8 @@ func TestChatErrors(t *testing.T) {
 		_, appServer := createServer(2)
 		server := httptest.NewServer(appServer)
 		defer server.Close()
-		ws1 := mustDialWS(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat?token="+strings.Repeat("1", 26))
-		ws2 := mustDialWS(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat?token="+strings.Repeat("2", 26))
+		ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
+		ws2 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
 		// send first message
 		want := PostMessageEvent{
 			Type: app.EventMessage,
@@ -249,9 +1018,9 @@ func TestChatErrors(t *testing.T) {
 		err = ws1.Close()
 		tester.AssertNoError(t, err)
 		// can establish ws connection gain
-		ws1 = mustDialWS(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat?token="+strings.Repeat("1", 26))
+		ws1 = mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
 		defer ws1.Close()
-		ws2 = mustDialWS(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat?token="+strings.Repeat("2", 26))
+		ws2 = mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
 		defer ws2.Close()
 	})
 
@@ -264,9 +1033,9 @@ func TestChatErrors(t *testing.T) {
 		_, appServer := createServer(2)
 		server := httptest.NewServer(appServer)
 		defer server.Close()
-		ws1 := mustDialWS(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat?token="+strings.Repeat("1", 26))
+		ws1 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("1", 26))
 		defer ws1.Close()
-		ws2 := mustDialWS(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat?token="+strings.Repeat("2", 26))
+		ws2 := mustDialChat(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/v1/chat", strings.Repeat("2", 26))
 		defer ws2.Close()
 		// send first message
 		want := struct {
@@ -279,7 +1048,7 @@ func TestChatErrors(t *testing.T) {
 		js := createWsPayload(t, want)
 		writeWSMessage(t, ws1, js)
 		// receive error back message
-		wantError := app.ErrorResponse{Message: app.PayloadErrorMessage, Errors: map[string]string{}}
+		wantError := app.WsError{Code: app.ErrorCodeInvalidPayload, Message: app.PayloadErrorMessage, Errors: map[string]string{}}
 		within(t, 500*time.Millisecond, func() { assertErrorEvent(t, ws1, wantError) })
 		// client 2 receives nothing
 		assertNoMessage(t, ws2)
@@ -305,7 +1074,7 @@ func createServer(numUsers int) (*data.StubMessageModel, *app.Application) {
 	messageModel := data.NewStubMessageModel(generateConversation(numUsers), []data.Message{})
 	userModel := data.NewStubUserModel(generateUsers(numUsers))
 	conversationModel := data.NewStubConversationModel(generateConversation(numUsers), userModel)
-	models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel}
+	models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel, Token: data.NewStubTokenModel(generateTokens(numUsers))}
 	appServer := app.New(cfg, logger, models)
 	return messageModel, appServer
 }
@@ -325,11 +1094,26 @@ func generateConversation(numUsers int) []data.Conversation {
 func generateUsers(numUsers int) []data.User {
 	u := []data.User{}
 	for i := 1; i <= numUsers; i++ {
-		u = append(u, data.User{Id: int64(i), Name: fmt.Sprintf("test user %v", i), Type: i%2 + 1})
+		u = append(u, data.User{Id: int64(i), Email: fmt.Sprintf("user%v@test.com", i), Name: fmt.Sprintf("test user %v", i), Type: i%2 + 1, Activated: true})
 	}
 	return u
 }
 
+func generateTokens(numUsers int) []data.Token {
+	tokens := []data.Token{}
+	for i := 1; i <= numUsers; i++ {
+		plaintext := strings.Repeat(strconv.Itoa(i), 26)
+		tokens = append(tokens, data.Token{
+			Plaintext: plaintext,
+			Hash:      data.TokenHash(plaintext),
+			UserId:    int64(i),
+			Expiry:    time.Now().Add(24 * time.Hour),
+			Scope:     data.ScopeAuthentication,
+		})
+	}
+	return tokens
+}
+
 func mustDialWS(t *testing.T, url string) *websocket.Conn {
 	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
 
@@ -340,6 +1124,25 @@ func mustDialWS(t *testing.T, url string) *websocket.Conn {
 	return ws
 }
 
+// mustDialChat authenticates with the first message and waits until the connection gets events
+func mustDialChat(t *testing.T, url string, token string) *websocket.Conn {
+	t.Helper()
+	ws := mustDialWS(t, url)
+	writeWSMessage(t, ws, createWsPayload(t, app.WsEvent{Type: app.EventAuth, Payload: createWsPayload(t, app.AuthDto{Token: token})}))
+	mustReadAuthenticated(t, ws)
+	return ws
+}
+
+func mustReadAuthenticated(t *testing.T, ws *websocket.Conn) {
+	t.Helper()
+	var got app.WsEvent
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	err := ws.ReadJSON(&got)
+	ws.SetReadDeadline(time.Time{})
+	tester.AssertNoError(t, err)
+	tester.AssertValue(t, got.Type, app.EventAuthenticated, "Expected authenticated event")
+}
+
 func writeWSMessage(t testing.TB, conn *websocket.Conn, message []byte) {
 	t.Helper()
 	if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
@@ -359,7 +1162,7 @@ func assertContainsMessage(t *testing.T, m data.MessageModel, conversationId int
 	passed := tester.RetryUntil(500*time.Millisecond, func() bool {
 		messages, err := m.GetAllByConversationId(int64(conversationId))
 		tester.AssertNoError(t, err)
-		return slices.Contains(messages, want)
+		return slices.ContainsFunc(messages, func(m data.Message) bool { return reflect.DeepEqual(m, want) })
 	})
 
 	if !passed {
@@ -383,6 +1186,107 @@ func assertMessage(t *testing.T, ws *websocket.Conn, want data.Message) {
 	}
 }
 
+func assertReadEvent(t *testing.T, ws *websocket.Conn, want data.ReadReceipt) {
+	t.Helper()
+
+	passed := tester.RetryUntil(1000*time.Millisecond, func() bool {
+		_, msg, err := ws.ReadMessage()
+		tester.AssertNoError(t, err)
+		var got struct {
+			Type    string
+			Payload data.ReadReceipt
+		}
+		json.NewDecoder(bytes.NewReader(msg)).Decode(&got)
+		return got.Type == app.EventRead && reflect.DeepEqual(got.Payload, want)
+	})
+
+	if !passed {
+		t.Fatalf("Expected to have %v", want)
+	}
+}
+
+func readPresenceEvent(t *testing.T, ws *websocket.Conn) data.Presence {
+	t.Helper()
+	for {
+		_, msg, err := ws.ReadMessage()
+		tester.AssertNoError(t, err)
+		var got struct {
+			Type    string
+			Payload data.Presence
+		}
+		json.NewDecoder(bytes.NewReader(msg)).Decode(&got)
+		if got.Type == app.EventPresence {
+			return got.Payload
+		}
+	}
+}
+
+func readMessageEvent(t *testing.T, ws *websocket.Conn, eventType string) data.Message {
+	t.Helper()
+	for {
+		_, msg, err := ws.ReadMessage()
+		tester.AssertNoError(t, err)
+		var got struct {
+			Type    string
+			Payload data.Message
+		}
+		json.NewDecoder(bytes.NewReader(msg)).Decode(&got)
+		if got.Type == eventType {
+			return got.Payload
+		}
+	}
+}
+
+// readMessageMuted reads the next message event and reports whether it is flagged as muted
+func readMessageMuted(t *testing.T, ws *websocket.Conn) bool {
+	t.Helper()
+	for {
+		_, msg, err := ws.ReadMessage()
+		tester.AssertNoError(t, err)
+		var got struct {
+			Type  string
+			Muted bool
+		}
+		json.NewDecoder(bytes.NewReader(msg)).Decode(&got)
+		if got.Type == app.EventMessage {
+			return got.Muted
+		}
+	}
+}
+
+func readSequencedMessage(t *testing.T, ws *websocket.Conn) (data.Message, int64) {
+	t.Helper()
+	for {
+		_, msg, err := ws.ReadMessage()
+		tester.AssertNoError(t, err)
+		var got struct {
+			Type    string
+			Payload data.Message
+			Seq     int64
+		}
+		json.NewDecoder(bytes.NewReader(msg)).Decode(&got)
+		if got.Type == app.EventMessage {
+			return got.Payload, got.Seq
+		}
+	}
+}
+
+func readConversationEvent(t *testing.T, ws *websocket.Conn) data.Conversation {
+	t.Helper()
+	for {
+		_, msg, err := ws.ReadMessage()
+		tester.AssertNoError(t, err)
+		var got struct {
+			Type    string
+			Payload data.Conversation
+		}
+		json.NewDecoder(bytes.NewReader(msg)).Decode(&got)
+		if got.Type == app.EventConversationUpdated {
+			return got.Payload
+		}
+	}
+}
+
 func assertNoMessage(t *testing.T, ws *websocket.Conn) {
 	t.Helper()
 
@@ -400,17 +1304,16 @@ func assertNoMessage(t *testing.T, ws *websocket.Conn) {
 	}
 }
 
-func assertErrorEvent(t *testing.T, ws *websocket.Conn, want app.ErrorResponse) {
+func assertErrorEvent(t *testing.T, ws *websocket.Conn, want app.WsError) {
 	t.Helper()
 
-	_, msg, err := ws.ReadMessage()
-	tester.AssertNoError(t, err)
-	var gotEvent app.WsEvent
-	json.NewDecoder(bytes.NewReader(msg)).Decode(&gotEvent)
-	var gotPayload app.ErrorResponse
-	json.NewDecoder(bytes.NewReader(gotEvent.Payload)).Decode(&gotPayload)
-
 	passed := tester.RetryUntil(1000*time.Millisecond, func() bool {
+		_, msg, err := ws.ReadMessage()
+		tester.AssertNoError(t, err)
+		var gotEvent app.WsEvent
+		json.NewDecoder(bytes.NewReader(msg)).Decode(&gotEvent)
+		var gotPayload app.WsError
+		json.NewDecoder(bytes.NewReader(gotEvent.Payload)).Decode(&gotPayload)
 		return reflect.DeepEqual(gotEvent.Type, app.EventError) && reflect.DeepEqual(gotPayload, want)
 	})
 
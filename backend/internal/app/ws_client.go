@@ -3,7 +3,8 @@ package app
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -22,14 +23,68 @@ const (
 
 	// Maximum message size allowed from peer.
 	maxMessageSize = 512
+
+	// Check if the token of the connection wasn't revoked with this period.
+	sessionCheckPeriod = time.Minute
+
+	// CloseResync is sent to clients which fell behind and lost events or whose
+	// replay was truncated, they reconnect with since to get the rest
+	CloseResync = 4000
+	// CloseUnauthorized is sent when the connection isn't authenticated
+	// or its token expired or was revoked
+	CloseUnauthorized = 4001
 )
 
+// closeReasons are reasons of close codes the hub disconnects clients with
+var closeReasons = map[int]string{
+	CloseResync:       "resync",
+	CloseUnauthorized: "token revoked",
+}
+
 type Client struct {
-	User          data.User
-	Conversations map[int64]data.Conversation
+	User data.User
+	// session is the authentication token of the connection
+	session data.Token
+	// conversations is a cache used by the read goroutine, the hub drops stale ones
+	mu            sync.Mutex
+	conversations map[int64]data.Conversation
 	hub           *Hub
 	conn          *websocket.Conn
 	messages      chan WsEvent
+	// since is the last seq received by the client before reconnect,
+	// stored events after it are replayed before live ones, negative means no replay
+	since int64
+	// closeCode is set by the hub before it closes messages
+	closeCode int
+}
+
+func (c *Client) conversation(id int64) (data.Conversation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conversation, ok := c.conversations[id]
+	return conversation, ok
+}
+
+func (c *Client) cacheConversation(conversation data.Conversation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conversations[conversation.Id] = conversation
+}
+
+func (c *Client) cachedConversations() []data.Conversation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conversations := []data.Conversation{}
+	for _, conversation := range c.conversations {
+		conversations = append(conversations, conversation)
+	}
+	return conversations
+}
+
+func (c *Client) forgetConversation(id int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conversations, id)
 }
 
 func (c *Client) readPump() {
@@ -51,25 +106,41 @@ func (c *Client) readPump() {
 			// If Connection is closed, we will Recieve an error here
 			// We only want to log Strange errors, but not simple Disconnection
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				c.hub.errors <- c.hub.createErrorMessage(c, fmt.Sprintf("error reading message: %v", err))
+				c.hub.app.logger.Printf("error reading message of user %v: %v", c.User.Id, err)
 			}
 			break // Break the loop to close conn & Cleanup
 		}
 		event, err := c.readEvent(msg)
 		if err != nil {
-			c.hub.errors <- c.hub.createErrorMessage(c, PayloadErrorMessage)
+			c.hub.fail(WsEvent{Sender: c}, ErrorCodeInvalidPayload)
 			continue
 		}
-		c.hub.broadcast <- event
+		c.hub.handleEvent(event)
 	}
 }
 
 func (c *Client) writePump() {
 	ticker := time.NewTicker(pingPeriod)
+	expiry := time.NewTimer(time.Until(c.session.Expiry))
+	sessionCheck := time.NewTicker(sessionCheckPeriod)
 	defer func() {
 		ticker.Stop()
+		expiry.Stop()
+		sessionCheck.Stop()
 		c.conn.Close()
 	}()
+	lastSeq, truncated, err := c.replay(func(evt WsEvent) error {
+		c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		return c.write(evt)
+	})
+	if err != nil {
+		return
+	}
+	if truncated {
+		c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(CloseResync, closeReasons[CloseResync]))
+		return
+	}
 	// if event comes before the end of ping period work on it
 	// if ping period came earlier - send ping to check if client alive
 	for {
@@ -78,18 +149,19 @@ func (c *Client) writePump() {
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// The hub closed the channel after readPump was stopped see defer in readPump
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				// or because the client fell behind
+				closeMessage := []byte{}
+				if c.closeCode != 0 {
+					closeMessage = websocket.FormatCloseMessage(c.closeCode, closeReasons[c.closeCode])
+				}
+				c.conn.WriteMessage(websocket.CloseMessage, closeMessage)
 				return
 			}
-
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
+			// the event was already replayed
+			if msg.Seq != 0 && msg.Seq <= lastSeq {
+				continue
 			}
-			js, _ := json.Marshal(msg)
-			w.Write(js)
-
-			if err := w.Close(); err != nil {
+			if err := c.write(msg); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -97,10 +169,72 @@ func (c *Client) writePump() {
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+		case <-expiry.C:
+			c.closeUnauthorized("token expired")
+			return
+		case <-sessionCheck.C:
+			if c.sessionRevoked() {
+				c.closeUnauthorized("token revoked")
+				return
+			}
 		}
 	}
 }
 
+// sessionRevoked checks if the token of the connection still exists,
+// the connection stays open if the check fails
+func (c *Client) sessionRevoked() bool {
+	_, err := c.hub.app.models.Token.Get(data.ScopeAuthentication, c.session.Hash)
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, data.ErrRecordNotFound):
+		return true
+	default:
+		c.hub.app.logger.Printf("Can't check token of user %v: %v", c.User.Id, err)
+		return false
+	}
+}
+
+func (c *Client) closeUnauthorized(reason string) {
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(CloseUnauthorized, reason))
+}
+
+// replay writes stored events the client missed before live ones with the write
+// function of its transport, it returns seq of the last replayed event.
+// If more events are left than EventReplayLimit the replay is truncated,
+// the connection is closed with CloseResync and the client reconnects for the rest.
+func (c *Client) replay(write func(WsEvent) error) (int64, bool, error) {
+	if c.since < 0 {
+		return 0, false, nil
+	}
+	events, err := c.hub.app.models.Event.GetAllSince(c.User.Id, c.since, data.EventReplayLimit)
+	if err != nil {
+		c.hub.app.logger.Printf("Can't replay events of user %v: %v", c.User.Id, err)
+		return 0, false, write(c.hub.createErrorEvent(WsEvent{Sender: c}, ErrorCodeServerError))
+	}
+	lastSeq := int64(0)
+	for _, event := range events {
+		err := write(WsEvent{Type: event.Type, Payload: event.Payload, Seq: event.Seq})
+		if err != nil {
+			return 0, false, err
+		}
+		lastSeq = event.Seq
+	}
+	return lastSeq, len(events) == data.EventReplayLimit, nil
+}
+
+func (c *Client) write(msg WsEvent) error {
+	w, err := c.conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		return err
+	}
+	js, _ := json.Marshal(msg)
+	w.Write(js)
+	return w.Close()
+}
+
 func (c *Client) readEvent(msg []byte) (WsEvent, error) {
 	var event WsEvent
 	err := readJson(bytes.NewReader(msg), &event)
@@ -1,25 +1,135 @@
 package app
 
-import "encoding/json"
+import (
+	"encoding/json"
+
+	"github.com/vasiliiperfilev/cookie/internal/data"
+)
 
 const (
 	EventMessage        = "message"
 	EventNewOrder       = "new_order"
 	EventUpdateOrder    = "update_order"
+	EventRead           = "read"
+	EventTyping         = "typing"
+	EventPresence       = "presence"
+	EventMessageEdited  = "message_edited"
+	EventMessageDeleted = "message_deleted"
+	// EventConversationUpdated is sent when a conversation is created or its title or members change
+	EventConversationUpdated = "conversation_updated"
+	// EventItemUpdated and EventItemDeleted are sent to users who share a conversation with the supplier
+	EventItemUpdated = "item_updated"
+	EventItemDeleted = "item_deleted"
+	// EventAck is sent by a client to acknowledge events up to the seq
+	EventAck = "ack"
+	// EventAuth is the first message of a connection without a ticket
+	EventAuth = "auth"
+	// EventAuthenticated is the first event of an authenticated connection
+	EventAuthenticated = "authenticated"
+	// EventClose ends an event stream, its payload has the close code a websocket would get
+	EventClose = "close"
+	// EventReply is sent to the sender of a request with a request id once it is done,
+	// its payload is the persisted entity
+	EventReply          = "reply"
 	EventError          = "error"
 	PayloadErrorMessage = "Invalid payload"
 	ServerErrorMessage  = "Server error"
+	NotFoundMessage     = "The requested resource could not be found"
+	UnsupportedMessage  = "Unsupported event type"
+	ValidationMessage   = "Validation error"
+	ForbiddenMessage    = "Not authorized"
+	EditConflictMessage = "Unable to update the record due to an edit conflict, please try again"
+	PreconditionMessage = "The record has been modified since it was retrieved, please fetch it again"
+	InactiveMessage     = "Your user account must be activated to access this resource"
+)
+
+// Codes of WsError
+const (
+	ErrorCodeInvalidPayload   = "invalid_payload"
+	ErrorCodeNotFound         = "not_found"
+	ErrorCodeServerError      = "server_error"
+	ErrorCodeUnsupportedEvent = "unsupported_event"
+	// ErrorCodeFailedValidation errors have messages of invalid fields
+	ErrorCodeFailedValidation = "failed_validation"
+	ErrorCodeForbidden        = "forbidden"
+	ErrorCodeEditConflict     = "edit_conflict"
+	// ErrorCodePreconditionFailed is sent if the record doesn't have the version of the request
+	ErrorCodePreconditionFailed = "precondition_failed"
+	ErrorCodeInactiveAccount    = "inactive_account"
 )
 
+var errorCodeMessages = map[string]string{
+	ErrorCodeInvalidPayload:     PayloadErrorMessage,
+	ErrorCodeNotFound:           NotFoundMessage,
+	ErrorCodeServerError:        ServerErrorMessage,
+	ErrorCodeUnsupportedEvent:   UnsupportedMessage,
+	ErrorCodeFailedValidation:   ValidationMessage,
+	ErrorCodeForbidden:          ForbiddenMessage,
+	ErrorCodeEditConflict:       EditConflictMessage,
+	ErrorCodePreconditionFailed: PreconditionMessage,
+	ErrorCodeInactiveAccount:    InactiveMessage,
+}
+
 // WsEvent is the Messages sent over the websocket
 // Used to differ between different actions
 type WsEvent struct {
-	Type    string          `json:"type"`
-	Sender  *Client         `json:"-"`
-	Payload json.RawMessage `json:"payload"`
+	Type string `json:"type"`
+	// RequestId is set by the client, replies and errors of the request carry it back
+	RequestId string          `json:"requestId,omitempty"`
+	Sender    *Client         `json:"-"`
+	Payload   json.RawMessage `json:"payload"`
+	// Seq increases for every stored event of the recipient, ephemeral
+	// events like typing and presence have no seq
+	Seq int64 `json:"seq,omitempty"`
+	// Muted is set for messages of conversations the recipient has muted, clients shouldn't notify about them
+	Muted bool `json:"muted,omitempty"`
 }
 
 type WsMessage struct {
 	Sender  *Client
 	Payload json.RawMessage
 }
+
+// WsError is the payload of EventError
+type WsError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Errors  map[string]string `json:"errors"`
+}
+
+// CloseDto is the payload of EventClose
+type CloseDto struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// AuthDto is sent by a client to authenticate the connection with its token
+type AuthDto struct {
+	Token string `json:"token"`
+}
+
+// UpdateOrderDto is sent by a client to change items or the state of the order
+type UpdateOrderDto struct {
+	OrderId int64 `json:"orderId"`
+	// Version is optional, like If-Match of REST the order is changed only if it has this version
+	Version int `json:"version,omitempty"`
+	data.PatchOrderDto
+}
+
+// AckDto is sent by a client to acknowledge received events up to the seq
+type AckDto struct {
+	Seq int64 `json:"seq"`
+}
+
+// TypingDto is sent by a client while the user is typing, typing events are
+// never persisted
+type TypingDto struct {
+	ConversationId int64 `json:"conversationId"`
+	Typing         bool  `json:"typing"`
+}
+
+type Typing struct {
+	ConversationId int64 `json:"conversationId"`
+	UserId         int64 `json:"userId"`
+	Typing         bool  `json:"typing"`
+}
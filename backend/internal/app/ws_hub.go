@@ -2,192 +2,778 @@ package app
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/validator"
 	"golang.org/x/exp/slices"
 )
 
+// Hub owns connected clients, its loop only registers clients and hands events to them.
+// Events are handled and stored by the goroutines of senders, sends to clients never block.
 type Hub struct {
-	clients    map[*Client]bool
-	broadcast  chan WsEvent
-	errors     chan WsEvent
+	// id tells deliveries of this hub from the ones of other instances
+	id string
+	// indexes are owned by the loop: connected clients of every user,
+	// conversations of connected users and members of these conversations
+	users         map[int64]map[*Client]bool
+	conversations map[int64]map[int64]bool
+	members       map[int64]map[int64]bool
+	// local are deliveries of this hub waiting to be sent to its clients
+	local chan delivery
+	// replies and errors are sent by the loop to the sender of the request
+	replies    chan WsEvent
 	register   chan *Client
 	unregister chan *Client
-	app        *Application
+	broker     Broker
+	deliveries <-chan []byte
+	// sequence keeps events of every recipient stored for replay and published in the same order
+	sequence recipientLocks
+	// presence counts clients of this instance, changes of the presence of users
+	// are written to the presence model and broadcast by their own goroutine
+	presence        *presenceTracker
+	presenceChanges chan presenceChange
+	app             *Application
 }
 
-func newHub(app *Application) *Hub {
+// delivery is an event published through the broker, every hub sends it
+// to its own clients of the recipients
+type delivery struct {
+	Origin string `json:"origin"`
+	// ConversationId is set for events of a conversation, its members are the recipients
+	ConversationId int64 `json:"conversationId,omitempty"`
+	// Except is a member who doesn't get the event
+	Except int64 `json:"except,omitempty"`
+	// UserIds are recipients of events which don't belong to a conversation
+	UserIds []int64         `json:"userIds,omitempty"`
+	Event   WsEvent         `json:"event"`
+	Seqs    map[int64]int64 `json:"seqs,omitempty"`
+	// Stored events are published without the payload, other instances load it from the event log
+	Stored bool    `json:"stored,omitempty"`
+	Muted  []int64 `json:"muted,omitempty"`
+	// Members is set when the conversation is created or changed, it replaces indexed members
+	// and cached conversations are reloaded on the next event of the client
+	Members []int64 `json:"members,omitempty"`
+	// Removed are former members who get the event about their removal
+	Removed []int64 `json:"removed,omitempty"`
+	// Revoked are hashes of revoked authentication tokens, connections of the recipients
+	// they authenticated are closed instead of getting the event
+	Revoked [][]byte `json:"revoked,omitempty"`
+	// Resync is set by the hub itself when deliveries of other instances might be lost
+	Resync bool `json:"-"`
+}
+
+// recipientLocks serialize events by recipient, events of users
+// who don't share them don't wait for each other
+type recipientLocks struct {
+	stripes [256]sync.Mutex
+}
+
+// lock locks stripes of the users in increasing order and returns the unlock function
+func (l *recipientLocks) lock(userIds []int64) func() {
+	stripes := []int{}
+	for _, userId := range userIds {
+		stripe := int(uint64(userId) % uint64(len(l.stripes)))
+		if !slices.Contains(stripes, stripe) {
+			stripes = append(stripes, stripe)
+		}
+	}
+	slices.Sort(stripes)
+	for _, stripe := range stripes {
+		l.stripes[stripe].Lock()
+	}
+	return func() {
+		for _, stripe := range stripes {
+			l.stripes[stripe].Unlock()
+		}
+	}
+}
+
+func newHub(app *Application, broker Broker) *Hub {
+	id := make([]byte, 8)
+	rand.Read(id)
 	return &Hub{
-		broadcast:  make(chan WsEvent, 256),
-		errors:     make(chan WsEvent, 256),
-		register:   make(chan *Client, 256),
-		clients:    make(map[*Client]bool),
-		unregister: make(chan *Client, 256),
-		app:        app,
+		id:         hex.EncodeToString(id),
+		broker:     broker,
+		deliveries: broker.Subscribe(),
+		local:      make(chan delivery, 1024),
+		replies:    make(chan WsEvent, 256),
+		// register is unbuffered, a registered client gets every event stored after the send
+		register:      make(chan *Client),
+		unregister:    make(chan *Client, 256),
+		users:         make(map[int64]map[*Client]bool),
+		conversations: make(map[int64]map[int64]bool),
+		members:       make(map[int64]map[int64]bool),
+		presence:      newPresenceTracker(),
+		// the loop drops presence changes if the database can't keep up
+		presenceChanges: make(chan presenceChange, 1024),
+		app:             app,
 	}
 }
 
 func (h *Hub) run() {
+	go h.receive()
+	go h.trackPresence()
 	for {
 		select {
 		case client := <-h.register:
-			h.clients[client] = true
-		case event := <-h.broadcast:
-			switch event.Type {
-			case EventMessage:
-				h.handleMessageEvent(event)
-			case EventNewOrder:
-				h.handleNewOrderEvent(event)
-			case EventUpdateOrder:
-				h.handleUpdateOrderEvent(event)
-			default:
-				h.app.logger.Printf("Unsupported websocket event %v, payload %v", event.Type, string(event.Payload))
+			h.addClient(client)
+			if h.presence.connect(client.User.Id) {
+				h.changePresence(client.User, true)
 			}
+		case d := <-h.local:
+			h.deliver(d)
 		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				close(client.messages)
-				delete(h.clients, client)
+			h.disconnect(client, 0)
+		case event := <-h.replies:
+			if event.Type == EventError {
+				h.app.logger.Printf("Websocker error event %s", string(event.Payload))
 			}
-		case event := <-h.errors:
-			h.app.logger.Printf("Websocker error event %s", string(event.Payload))
-			event.Sender.messages <- event
+			h.send(event.Sender, event)
 		}
 	}
 }
 
+// receive hands deliveries of other instances to the loop,
+// payloads of stored events are loaded here so that the loop never waits for the database
+func (h *Hub) receive() {
+	for payload := range h.deliveries {
+		if payload == nil {
+			h.local <- delivery{Resync: true}
+			continue
+		}
+		var d delivery
+		if err := json.Unmarshal(payload, &d); err != nil {
+			h.app.logger.Printf("Invalid hub delivery %s: %v", string(payload), err)
+			continue
+		}
+		// own deliveries are sent before they are published
+		if d.Origin == h.id {
+			continue
+		}
+		if d.Stored {
+			h.loadEvent(&d)
+		}
+		h.local <- d
+	}
+}
+
+// loadEvent sets the payload of the stored event, every recipient has the same one
+func (h *Hub) loadEvent(d *delivery) {
+	userId := int64(0)
+	for id := range d.Seqs {
+		if userId == 0 || id < userId {
+			userId = id
+		}
+	}
+	event, err := h.app.models.Event.Get(userId, d.Seqs[userId])
+	if err != nil {
+		// recipients are disconnected by the loop and replay the event
+		h.app.logger.Printf("Can't load %v event %v of user %v: %v", d.Event.Type, d.Seqs[userId], userId, err)
+		return
+	}
+	d.Event.Payload = event.Payload
+	d.Stored = false
+}
+
+// sweepEvents deletes expired events, events of clients which never ack expire too
+func (h *Hub) sweepEvents() {
+	ticker := time.NewTicker(h.app.config.EventSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		deleted, err := h.app.models.Event.DeleteExpired()
+		if err != nil {
+			h.app.logger.Printf("Can't delete expired events: %v", err)
+			continue
+		}
+		if deleted > 0 {
+			h.app.logger.Printf("Deleted %v expired events", deleted)
+		}
+	}
+}
+
+// handleEvent is called by the read goroutine of the sender, so that database
+// work for one client doesn't hold back events of the others
+func (h *Hub) handleEvent(event WsEvent) {
+	switch event.Type {
+	case EventMessage:
+		h.handleMessageEvent(event)
+	case EventNewOrder:
+		h.handleNewOrderEvent(event)
+	case EventUpdateOrder:
+		h.handleUpdateOrderEvent(event)
+	case EventRead:
+		h.handleReadEvent(event)
+	case EventTyping:
+		h.handleTypingEvent(event)
+	case EventAck:
+		h.handleAckEvent(event)
+	default:
+		h.app.logger.Printf("Unsupported websocket event %v, payload %v", event.Type, string(event.Payload))
+		h.fail(event, ErrorCodeUnsupportedEvent)
+	}
+}
+
 func (h *Hub) handleMessageEvent(event WsEvent) {
 	var dto data.PostMessageDto
 	err := readJson(bytes.NewReader(event.Payload), &dto)
 	if err != nil {
-		h.errors <- h.createErrorMessage(event.Sender, PayloadErrorMessage)
+		h.fail(event, ErrorCodeInvalidPayload)
 		return
 	}
-	msg := data.Message{
-		Content:        dto.Content,
-		ConversationId: dto.ConversationId,
-		PrevMessageId:  dto.PrevMessageId,
-		SenderId:       event.Sender.User.Id,
+	conversation, err := h.getConversation(event, dto.ConversationId)
+	if err != nil {
+		h.failWith(event, err)
+		return
 	}
-	err = h.app.models.Message.Insert(&msg)
+	msg, err := h.app.postMessage(event.Sender.User, conversation, dto)
 	if err != nil {
-		h.errors <- h.createErrorMessage(event.Sender, ServerErrorMessage)
+		h.failWith(event, err)
 		return
 	}
-	payload, _ := json.Marshal(msg)
-	msgEvt := WsEvent{
-		Type:    EventMessage,
-		Payload: payload,
+	h.reply(event, msg)
+}
+
+func (h *Hub) handleNewOrderEvent(event WsEvent) {
+	var dto data.PostOrderDto
+	err := readJson(bytes.NewReader(event.Payload), &dto)
+	if err != nil {
+		h.fail(event, ErrorCodeInvalidPayload)
+		return
 	}
-	conversation, err := h.getConversation(event, msg)
+	v := validator.New()
+	// members get the order from the domain event
+	order, err := h.app.createOrder(event.Sender.User, dto, v)
 	if err != nil {
-		h.errors <- h.createErrorMessage(event.Sender, PayloadErrorMessage)
+		h.failOrder(event, err, v)
 		return
 	}
+	h.reply(event, order)
+}
 
-	for client := range h.clients {
-		if slices.ContainsFunc(conversation.Users, func(u data.User) bool { return u.Id == client.User.Id }) {
-			client.Conversations[dto.ConversationId] = conversation
-			client.messages <- msgEvt
-		}
+func (h *Hub) handleUpdateOrderEvent(event WsEvent) {
+	var dto UpdateOrderDto
+	err := readJson(bytes.NewReader(event.Payload), &dto)
+	if err != nil {
+		h.fail(event, ErrorCodeInvalidPayload)
+		return
 	}
+	v := validator.New()
+	// without the version the latest one is changed, concurrent changes fail with an edit conflict
+	matches := func(version int) bool { return dto.Version == 0 || dto.Version == version }
+	order, err := h.app.changeOrder(event.Sender.User, dto.OrderId, dto.PatchOrderDto, matches, v)
+	if err != nil {
+		h.failOrder(event, err, v)
+		return
+	}
+	h.reply(event, order)
 }
 
-func (h *Hub) handleNewOrderEvent(event WsEvent) {
-	var order data.Order
-	err := readJson(bytes.NewReader(event.Payload), &order)
+// failOrder maps errors of the order commands to the codes of their REST responses
+func (h *Hub) failOrder(request WsEvent, err error, v *validator.Validator) {
+	switch {
+	case errors.Is(err, ErrFailedValidation):
+		wsError := WsError{Code: ErrorCodeFailedValidation, Message: errorCodeMessages[ErrorCodeFailedValidation], Errors: v.Errors}
+		payload, _ := json.Marshal(wsError)
+		h.replies <- WsEvent{Type: EventError, RequestId: request.RequestId, Payload: payload, Sender: request.Sender}
+	case errors.Is(err, ErrNotPermitted):
+		h.fail(request, ErrorCodeForbidden)
+	case errors.Is(err, ErrNotActivated):
+		h.fail(request, ErrorCodeInactiveAccount)
+	case errors.Is(err, data.ErrEditConflict):
+		h.fail(request, ErrorCodeEditConflict)
+	case errors.Is(err, ErrPreconditionFailed):
+		h.fail(request, ErrorCodePreconditionFailed)
+	default:
+		h.failWith(request, err)
+	}
+}
+
+func (h *Hub) handleReadEvent(event WsEvent) {
+	var dto data.PostReadDto
+	err := readJson(bytes.NewReader(event.Payload), &dto)
 	if err != nil {
-		h.errors <- h.createErrorMessage(event.Sender, PayloadErrorMessage)
+		h.fail(event, ErrorCodeInvalidPayload)
 		return
 	}
 
-	msg, err := h.app.models.Message.GetById(order.MessageId)
+	conversation, err := h.getConversation(event, dto.ConversationId)
 	if err != nil {
-		h.errors <- h.createErrorMessage(event.Sender, ServerErrorMessage)
+		h.failWith(event, err)
 		return
 	}
 
-	payload, _ := json.Marshal(order)
-	orderEvent := WsEvent{
-		Type:    EventNewOrder,
-		Payload: payload,
+	receipt, err := h.app.markConversationRead(event.Sender.User, conversation, dto.MessageId)
+	if err != nil {
+		h.failWith(event, err)
+		return
 	}
+	h.reply(event, receipt)
+}
 
-	conversation, err := h.getConversation(event, msg)
+func (h *Hub) handleTypingEvent(event WsEvent) {
+	var dto TypingDto
+	err := readJson(bytes.NewReader(event.Payload), &dto)
 	if err != nil {
-		h.errors <- h.createErrorMessage(event.Sender, PayloadErrorMessage)
+		h.fail(event, ErrorCodeInvalidPayload)
 		return
 	}
 
-	for client := range h.clients {
-		if slices.ContainsFunc(conversation.Users, func(u data.User) bool { return u.Id == client.User.Id }) {
-			client.Conversations[msg.ConversationId] = conversation
-			client.messages <- orderEvent
-		}
+	conversation, err := h.getConversation(event, dto.ConversationId)
+	if err != nil {
+		h.failWith(event, err)
+		return
+	}
+	if !isConversationMember(conversation, event.Sender.User.Id) {
+		h.fail(event, ErrorCodeNotFound)
+		return
+	}
+
+	typing := Typing{
+		ConversationId: conversation.Id,
+		UserId:         event.Sender.User.Id,
+		Typing:         dto.Typing,
+	}
+	payload, _ := json.Marshal(typing)
+	typingEvent := WsEvent{
+		Type:    EventTyping,
+		Payload: payload,
 	}
+	h.sendToConversationExcept(conversation, event.Sender.User.Id, typingEvent)
+	h.reply(event, typing)
 }
 
-func (h *Hub) handleUpdateOrderEvent(event WsEvent) {
-	var order data.Order
-	err := readJson(bytes.NewReader(event.Payload), &order)
+func (h *Hub) handleAckEvent(event WsEvent) {
+	var dto AckDto
+	err := readJson(bytes.NewReader(event.Payload), &dto)
+	if err != nil || dto.Seq < 1 {
+		h.fail(event, ErrorCodeInvalidPayload)
+		return
+	}
+	err = h.app.models.Event.Ack(event.Sender.User.Id, dto.Seq)
 	if err != nil {
-		h.errors <- h.createErrorMessage(event.Sender, PayloadErrorMessage)
+		h.failWith(event, err)
 		return
 	}
+	h.reply(event, dto)
+}
 
-	msg, err := h.app.models.Message.GetById(order.MessageId)
-	if err != nil {
-		h.errors <- h.createErrorMessage(event.Sender, ServerErrorMessage)
+// domainWsEvents are websocket event types of domain events sent to clients
+var domainWsEvents = map[string]string{
+	data.DomainMessageCreated:      EventMessage,
+	data.DomainMessageEdited:       EventMessageEdited,
+	data.DomainMessageDeleted:      EventMessageDeleted,
+	data.DomainConversationRead:    EventRead,
+	data.DomainConversationUpdated: EventConversationUpdated,
+	data.DomainOrderCreated:        EventNewOrder,
+	data.DomainOrderUpdated:        EventUpdateOrder,
+	data.DomainItemUpdated:         EventItemUpdated,
+	data.DomainItemDeleted:         EventItemDeleted,
+}
+
+// handleDomainEvent sends changes made through any transport to the users they concern
+func (h *Hub) handleDomainEvent(event data.DomainEvent) error {
+	evt := WsEvent{Type: domainWsEvents[event.Type], Payload: event.Payload}
+	switch event.Type {
+	case data.DomainMessageCreated, data.DomainMessageEdited, data.DomainMessageDeleted, data.DomainConversationRead,
+		data.DomainConversationUpdated, data.DomainOrderCreated, data.DomainOrderUpdated:
+		conversation, err := h.app.models.Conversation.GetById(event.ConversationId)
+		if err != nil {
+			return fmt.Errorf("load conversation %v: %w", event.ConversationId, err)
+		}
+		switch event.Type {
+		case data.DomainMessageCreated:
+			h.sendMessage(conversation, evt)
+		case data.DomainConversationUpdated:
+			var change data.ConversationChange
+			if err := json.Unmarshal(event.Payload, &change); err != nil {
+				return fmt.Errorf("read conversation change: %w", err)
+			}
+			h.updateConversation(conversation, change.Removed...)
+		default:
+			h.sendToConversation(conversation, evt)
+		}
+	case data.DomainItemUpdated, data.DomainItemDeleted:
+		// clients see items of suppliers they have conversations with
+		conversations, err := h.app.models.Conversation.GetAllByUserId(event.UserId)
+		if err != nil {
+			return fmt.Errorf("load conversations of user %v: %w", event.UserId, err)
+		}
+		recipients := map[int64]bool{event.UserId: true}
+		for _, conversation := range conversations {
+			for _, user := range conversation.Users {
+				recipients[user.Id] = true
+			}
+		}
+		userIds := []int64{}
+		for userId := range recipients {
+			userIds = append(userIds, userId)
+		}
+		h.sendToUsers(userIds, evt)
+	}
+	return nil
+}
+
+// reply sends the entity to the sender of the request, requests without an id get no replies
+func (h *Hub) reply(request WsEvent, entity any) {
+	if request.RequestId == "" {
 		return
 	}
-	msg.Content = fmt.Sprintf("New state of order with id %v: %v", order.Id, data.OrderStateMessage[order.StateId])
-	err = h.app.models.Message.Update(msg)
+	payload, _ := json.Marshal(entity)
+	h.replies <- WsEvent{Type: EventReply, RequestId: request.RequestId, Payload: payload, Sender: request.Sender}
+}
+
+func (h *Hub) fail(request WsEvent, code string) {
+	h.replies <- h.createErrorEvent(request, code)
+}
+
+// failWith hides errors other than not found from the client
+func (h *Hub) failWith(request WsEvent, err error) {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		h.fail(request, ErrorCodeNotFound)
+	default:
+		h.app.logger.Printf("Websocket %v event of user %v failed: %v", request.Type, request.Sender.User.Id, err)
+		h.fail(request, ErrorCodeServerError)
+	}
+}
+
+// changePresence hands the change to the presence goroutine, the loop can't wait for the database.
+// Recipients are everyone who shares a conversation with the user.
+func (h *Hub) changePresence(user data.User, online bool) {
+	recipients := map[int64]bool{}
+	for conversationId := range h.conversations[user.Id] {
+		for userId := range h.members[conversationId] {
+			if userId != user.Id {
+				recipients[userId] = true
+			}
+		}
+	}
+	userIds := []int64{}
+	for userId := range recipients {
+		userIds = append(userIds, userId)
+	}
+
+	select {
+	case h.presenceChanges <- presenceChange{user: user, online: online, recipients: userIds}:
+	default:
+		// the presence model is fixed by the next refresh, contacts get no event
+		h.app.logger.Printf("Dropping presence change of user %v", user.Id)
+	}
+}
+
+// trackPresence writes presence changes in the order they were made and refreshes
+// users of this instance, so that they don't expire while connected
+func (h *Hub) trackPresence() {
+	ticker := time.NewTicker(data.PresenceTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case change := <-h.presenceChanges:
+			h.broadcastPresence(change)
+		case <-ticker.C:
+			if err := h.app.models.Presence.Refresh(h.id, h.presence.userIds()); err != nil {
+				h.app.logger.Printf("Can't refresh presence: %v", err)
+			}
+		}
+	}
+}
+
+// broadcastPresence notifies the recipients if the user became online or offline on every instance
+func (h *Hub) broadcastPresence(change presenceChange) {
+	var everywhere bool
+	var err error
+	if change.online {
+		everywhere, err = h.app.models.Presence.Connect(h.id, change.user.Id)
+	} else {
+		everywhere, err = h.app.models.Presence.Disconnect(h.id, change.user.Id)
+	}
 	if err != nil {
-		h.errors <- h.createErrorMessage(event.Sender, ServerErrorMessage)
+		h.app.logger.Printf("Can't change presence of user %v: %v", change.user.Id, err)
 		return
 	}
-	_, err = h.app.models.Order.Update(order)
-	if err != nil {
-		h.errors <- h.createErrorMessage(event.Sender, ServerErrorMessage)
+	// the user is still connected to another instance
+	if !everywhere {
 		return
 	}
-	payload, _ := json.Marshal(order)
-	orderEvent := WsEvent{
-		Type:    EventUpdateOrder,
+
+	payload, _ := json.Marshal(data.Presence{UserId: change.user.Id, Online: change.online, LastSeen: time.Now()})
+	presenceEvent := WsEvent{
+		Type:    EventPresence,
 		Payload: payload,
 	}
+	h.publish(delivery{UserIds: change.recipients, Event: presenceEvent})
+}
+
+// sendToConversation stores the event for every member of the conversation
+// and delivers it to the connected ones
+func (h *Hub) sendToConversation(conversation data.Conversation, evt WsEvent) {
+	h.sendToMembers(conversation, evt, nil)
+}
 
-	conversation, err := h.getConversation(event, msg)
+// sendMessage delivers a new message to the conversation, members who muted
+// the conversation get it flagged as muted
+func (h *Hub) sendMessage(conversation data.Conversation, evt WsEvent) {
+	muted, err := h.app.models.Conversation.GetMutedUserIds(conversation.Id)
 	if err != nil {
-		h.errors <- h.createErrorMessage(event.Sender, PayloadErrorMessage)
+		h.app.logger.Printf("Can't get muted users of conversation %v: %v", conversation.Id, err)
+	}
+	h.sendToMembers(conversation, evt, muted)
+}
+
+func (h *Hub) sendToMembers(conversation data.Conversation, evt WsEvent, muted []int64) {
+	userIds := data.Map(conversation.Users, func(u data.User) int64 { return u.Id })
+	defer h.sequence.lock(userIds)()
+	seqs := h.appendEvent(userIds, evt)
+	h.publish(delivery{ConversationId: conversation.Id, Event: evt, Seqs: seqs, Muted: muted})
+}
+
+// appendEvent stores the event for replay and returns its seq by user id,
+// if it can't be stored the event is still delivered live without seq
+func (h *Hub) appendEvent(userIds []int64, evt WsEvent) map[int64]int64 {
+	seqs, err := h.app.models.Event.Append(userIds, evt.Type, evt.Payload)
+	if err != nil {
+		h.app.logger.Printf("Can't store %v event: %v", evt.Type, err)
+		return map[int64]int64{}
+	}
+	return seqs
+}
+
+// sendToUsers stores the event for the users and delivers it to the connected ones,
+// it is used for events which don't belong to a conversation
+func (h *Hub) sendToUsers(userIds []int64, evt WsEvent) {
+	defer h.sequence.lock(userIds)()
+	seqs := h.appendEvent(userIds, evt)
+	h.publish(delivery{UserIds: userIds, Event: evt, Seqs: seqs})
+}
+
+// sendToConversationExcept delivers an ephemeral event to connected members
+// of the conversation other than the user, the event isn't stored for replay
+func (h *Hub) sendToConversationExcept(conversation data.Conversation, userId int64, evt WsEvent) {
+	h.publish(delivery{ConversationId: conversation.Id, Except: userId, Event: evt})
+}
+
+// publish hands the event to own clients and to clients of other instances
+func (h *Hub) publish(d delivery) {
+	d.Origin = h.id
+	h.local <- d
+	if len(d.Seqs) > 0 {
+		d.Event.Payload = nil
+		d.Stored = true
+	}
+	payload, _ := json.Marshal(d)
+	if err := h.broker.Publish(payload); err != nil {
+		// clients of other instances get stored events on reconnect
+		h.app.logger.Printf("Can't publish %v event: %v", d.Event.Type, err)
+	}
+}
+
+// deliver sends the event to connected clients of the recipients
+func (h *Hub) deliver(d delivery) {
+	if d.Resync {
+		h.resyncClients()
+		return
+	}
+	if d.Revoked != nil {
+		h.closeSessions(d)
+		return
+	}
+	if d.Members != nil {
+		h.indexMembers(d.ConversationId, d.Members, d.Removed)
+	}
+	for _, userId := range h.recipients(d) {
+		for client := range h.users[userId] {
+			if d.Members != nil {
+				client.forgetConversation(d.ConversationId)
+			}
+			// the payload couldn't be loaded, the client gets the event by replay
+			if d.Stored {
+				h.disconnect(client, CloseResync)
+				continue
+			}
+			evt := d.Event
+			evt.Seq = d.Seqs[userId]
+			evt.Muted = slices.Contains(d.Muted, userId)
+			h.send(client, evt)
+		}
+	}
+}
+
+func (h *Hub) recipients(d delivery) []int64 {
+	if d.ConversationId == 0 {
+		return d.UserIds
+	}
+	userIds := []int64{}
+	for userId := range h.members[d.ConversationId] {
+		if userId != d.Except {
+			userIds = append(userIds, userId)
+		}
+	}
+	return append(userIds, d.Removed...)
+}
+
+// send never blocks the hub, clients which can't keep up are disconnected
+// with CloseResync and get missed events by reconnecting with since
+func (h *Hub) send(client *Client, evt WsEvent) {
+	if !h.users[client.User.Id][client] {
 		return
 	}
+	select {
+	case client.messages <- evt:
+	default:
+		h.app.logger.Printf("Disconnecting slow client of user %v", client.User.Id)
+		h.disconnect(client, CloseResync)
+	}
+}
+
+// revokeSessions closes connections of the user authenticated by the tokens on every instance,
+// connections check their tokens periodically in case the delivery is lost
+func (h *Hub) revokeSessions(userId int64, tokens ...data.Token) {
+	if len(tokens) == 0 {
+		return
+	}
+	hashes := [][]byte{}
+	for _, token := range tokens {
+		hashes = append(hashes, token.Hash)
+	}
+	h.publish(delivery{UserIds: []int64{userId}, Revoked: hashes})
+}
 
-	for client := range h.clients {
-		if slices.ContainsFunc(conversation.Users, func(u data.User) bool { return u.Id == client.User.Id }) {
-			client.Conversations[msg.ConversationId] = conversation
-			client.messages <- orderEvent
+// closeSessions disconnects clients of the recipients authenticated by the revoked tokens
+func (h *Hub) closeSessions(d delivery) {
+	for _, userId := range d.UserIds {
+		for client := range h.users[userId] {
+			if slices.ContainsFunc(d.Revoked, func(hash []byte) bool { return bytes.Equal(hash, client.session.Hash) }) {
+				h.disconnect(client, CloseUnauthorized)
+			}
+		}
+	}
+}
+
+// resyncClients disconnects every client with CloseResync, clients reconnect
+// with their last seq and replay events they missed
+func (h *Hub) resyncClients() {
+	h.app.logger.Printf("Hub broker deliveries might be lost, resyncing %v users", len(h.users))
+	for _, clients := range h.users {
+		for client := range clients {
+			h.disconnect(client, CloseResync)
 		}
 	}
 }
 
-func (h *Hub) getConversation(event WsEvent, msg data.Message) (data.Conversation, error) {
-	if conversation, ok := event.Sender.Conversations[msg.ConversationId]; !ok {
-		c, err := h.app.models.Conversation.GetById(msg.ConversationId)
+// disconnect removes the client, its write goroutine closes the connection with the code
+func (h *Hub) disconnect(client *Client, closeCode int) {
+	if !h.users[client.User.Id][client] {
+		return
+	}
+	client.closeCode = closeCode
+	close(client.messages)
+	// recipients of the presence are found by the index before it drops the user
+	if h.presence.disconnect(client.User.Id) {
+		h.changePresence(client.User, false)
+	}
+	h.removeClient(client)
+}
+
+// addClient indexes the client and conversations it has loaded on connect
+func (h *Hub) addClient(client *Client) {
+	userId := client.User.Id
+	if h.users[userId] == nil {
+		h.users[userId] = map[*Client]bool{}
+		h.conversations[userId] = map[int64]bool{}
+	}
+	h.users[userId][client] = true
+	for _, conversation := range client.cachedConversations() {
+		h.conversations[userId][conversation.Id] = true
+		// indexed members are kept up to date by deliveries
+		if _, ok := h.members[conversation.Id]; !ok {
+			h.members[conversation.Id] = map[int64]bool{}
+			for _, u := range conversation.Users {
+				h.members[conversation.Id][u.Id] = true
+			}
+		}
+	}
+}
+
+// removeClient drops conversations which have no connected members left
+func (h *Hub) removeClient(client *Client) {
+	userId := client.User.Id
+	delete(h.users[userId], client)
+	if len(h.users[userId]) > 0 {
+		return
+	}
+	delete(h.users, userId)
+	for conversationId := range h.conversations[userId] {
+		if !h.hasConnectedMember(conversationId) {
+			delete(h.members, conversationId)
+		}
+	}
+	delete(h.conversations, userId)
+}
+
+func (h *Hub) hasConnectedMember(conversationId int64) bool {
+	for userId := range h.members[conversationId] {
+		if len(h.users[userId]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// indexMembers replaces members of the conversation if any of them is connected to this hub
+func (h *Hub) indexMembers(conversationId int64, members []int64, removed []int64) {
+	for _, userId := range removed {
+		delete(h.conversations[userId], conversationId)
+	}
+	h.members[conversationId] = map[int64]bool{}
+	for _, userId := range members {
+		h.members[conversationId][userId] = true
+		if len(h.users[userId]) > 0 {
+			h.conversations[userId][conversationId] = true
+		}
+	}
+	if !h.hasConnectedMember(conversationId) {
+		delete(h.members, conversationId)
+	}
+}
+
+// updateConversation notifies members and removed users about the new conversation
+// or its changed title or members, clients reload the conversation on their next event
+func (h *Hub) updateConversation(conversation data.Conversation, removed ...int64) {
+	payload, _ := json.Marshal(conversation)
+	evt := WsEvent{
+		Type:    EventConversationUpdated,
+		Payload: payload,
+	}
+	members := data.Map(conversation.Users, func(u data.User) int64 { return u.Id })
+	recipients := append(append([]int64{}, members...), removed...)
+	defer h.sequence.lock(recipients)()
+	seqs := h.appendEvent(recipients, evt)
+	h.publish(delivery{ConversationId: conversation.Id, Members: members, Removed: removed, Event: evt, Seqs: seqs})
+}
+
+func (h *Hub) getConversation(event WsEvent, conversationId int64) (data.Conversation, error) {
+	if conversation, ok := event.Sender.conversation(conversationId); !ok {
+		c, err := h.app.models.Conversation.GetById(conversationId)
 		if err != nil {
 			return data.Conversation{}, err
 		}
-		event.Sender.Conversations[msg.ConversationId] = c
+		event.Sender.cacheConversation(c)
 		return c, nil
 	} else {
 		return conversation, nil
 	}
 }
 
-func (h *Hub) createErrorMessage(client *Client, message string) WsEvent {
-	data := ErrorResponse{Message: message, Errors: map[string]string{}}
-	payload, _ := json.Marshal(data)
-	errEvt := WsEvent{Type: EventError, Payload: payload, Sender: client}
-	return errEvt
+// createErrorEvent returns the error for the sender of the request
+func (h *Hub) createErrorEvent(request WsEvent, code string) WsEvent {
+	wsError := WsError{Code: code, Message: errorCodeMessages[code], Errors: map[string]string{}}
+	payload, _ := json.Marshal(wsError)
+	return WsEvent{Type: EventError, RequestId: request.RequestId, Payload: payload, Sender: request.Sender}
 }
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/tester"
+)
+
+func TestHubSend(t *testing.T) {
+	newTestHub := func() *Hub {
+		userModel := data.NewStubUserModel([]data.User{})
+		models := data.Models{
+			User:         userModel,
+			Conversation: data.NewStubConversationModel([]data.Conversation{}, userModel),
+			Event:        data.NewStubEventModel(),
+		}
+		a := &Application{logger: log.New(io.Discard, "", 0), models: models}
+		return newHub(a, NewMemoryBroker())
+	}
+
+	t.Run("it disconnects a client which can't keep up", func(t *testing.T) {
+		hub := newTestHub()
+		slow := &Client{User: data.User{Id: 1}, messages: make(chan WsEvent, 1)}
+		fast := &Client{User: data.User{Id: 2}, messages: make(chan WsEvent, 2)}
+		hub.addClient(slow)
+		hub.addClient(fast)
+		for i := 0; i < 2; i++ {
+			hub.deliver(delivery{UserIds: []int64{1, 2}, Event: WsEvent{Type: EventMessage}})
+		}
+		tester.AssertValue(t, hub.users[1][slow], false, "Expected slow client to be disconnected")
+		tester.AssertValue(t, slow.closeCode, CloseResync, "Expected resync close code")
+		tester.AssertValue(t, len(slow.messages), 1, "Expected buffered events to be kept")
+		_, ok := <-slow.messages
+		tester.AssertValue(t, ok, true, "Expected buffered event")
+		_, ok = <-slow.messages
+		tester.AssertValue(t, ok, false, "Expected messages to be closed")
+		tester.AssertValue(t, hub.users[2][fast], true, "Expected other clients to stay")
+		tester.AssertValue(t, len(fast.messages), 2, "Expected other clients to get every event")
+	})
+
+	t.Run("it doesn't send to disconnected clients", func(t *testing.T) {
+		hub := newTestHub()
+		client := &Client{User: data.User{Id: 1}, messages: make(chan WsEvent, 1)}
+		hub.addClient(client)
+		hub.disconnect(client, 0)
+		// a late error event of the client must not panic on closed messages
+		hub.send(client, hub.createErrorEvent(WsEvent{Sender: client}, ErrorCodeInvalidPayload))
+		hub.disconnect(client, CloseResync)
+		tester.AssertValue(t, client.closeCode, 0, "Expected normal close")
+	})
+	t.Run("it delivers conversation events to indexed members", func(t *testing.T) {
+		hub := newTestHub()
+		conversation := data.Conversation{Id: 1, Users: []data.User{{Id: 1}, {Id: 2}}}
+		phone := &Client{User: data.User{Id: 1}, conversations: map[int64]data.Conversation{1: conversation}, messages: make(chan WsEvent, 4)}
+		laptop := &Client{User: data.User{Id: 1}, conversations: map[int64]data.Conversation{1: conversation}, messages: make(chan WsEvent, 4)}
+		other := &Client{User: data.User{Id: 3}, conversations: map[int64]data.Conversation{}, messages: make(chan WsEvent, 4)}
+		hub.addClient(phone)
+		hub.addClient(laptop)
+		hub.addClient(other)
+		hub.deliver(delivery{ConversationId: 1, Event: WsEvent{Type: EventMessage}})
+		tester.AssertValue(t, len(phone.messages), 1, "Expected every device of the member to get the event")
+		tester.AssertValue(t, len(laptop.messages), 1, "Expected every device of the member to get the event")
+		tester.AssertValue(t, len(other.messages), 0, "Expected no events for other users")
+
+		// user 3 is added and user 1 is removed
+		hub.deliver(delivery{ConversationId: 1, Members: []int64{2, 3}, Removed: []int64{1}, Event: WsEvent{Type: EventConversationUpdated}})
+		hub.deliver(delivery{ConversationId: 1, Event: WsEvent{Type: EventMessage}})
+		tester.AssertValue(t, len(phone.messages), 2, "Expected removed member to get only the update")
+		tester.AssertValue(t, len(other.messages), 2, "Expected new member to get events")
+		tester.AssertValue(t, hub.conversations[1][1], false, "Expected conversation to be removed from index of the user")
+
+		hub.disconnect(other, 0)
+		tester.AssertValue(t, len(hub.members), 0, "Expected conversation without connected members to be dropped")
+	})
+
+	t.Run("it resyncs every client when broker deliveries might be lost", func(t *testing.T) {
+		hub := newTestHub()
+		deliveries := make(chan []byte)
+		defer close(deliveries)
+		hub.deliveries = deliveries
+		phone := &Client{User: data.User{Id: 1}, messages: make(chan WsEvent, 1)}
+		other := &Client{User: data.User{Id: 2}, messages: make(chan WsEvent, 1)}
+		hub.addClient(phone)
+		hub.addClient(other)
+		go hub.receive()
+		// the broker listener reconnected
+		deliveries <- nil
+		select {
+		case d := <-hub.local:
+			hub.deliver(d)
+		case <-time.After(time.Second):
+			t.Fatal("Expected resync delivery")
+		}
+		for _, client := range []*Client{phone, other} {
+			tester.AssertValue(t, hub.users[client.User.Id][client], false, "Expected client to be disconnected")
+			tester.AssertValue(t, client.closeCode, CloseResync, "Expected resync close code")
+		}
+	})
+}
+
+func TestRecipientLocks(t *testing.T) {
+	t.Run("it serializes events of the same recipient only", func(t *testing.T) {
+		var locks recipientLocks
+		unlock := locks.lock([]int64{1, 2})
+		other := make(chan bool)
+		go func() {
+			defer locks.lock([]int64{3})()
+			other <- true
+		}()
+		select {
+		case <-other:
+		case <-time.After(time.Second):
+			t.Fatal("Expected events of other users not to wait")
+		}
+
+		shared := make(chan bool)
+		go func() {
+			defer locks.lock([]int64{2, 4})()
+			shared <- true
+		}()
+		select {
+		case <-shared:
+			t.Fatal("Expected events of the same user to wait")
+		case <-time.After(50 * time.Millisecond):
+		}
+		unlock()
+		<-shared
+	})
+}
@@ -0,0 +1,180 @@
+package app_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vasiliiperfilev/cookie/internal/app"
+	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/tester"
+)
+
+// the stub authentication knows users 1-9, hundreds of clients are simulated
+// by connecting many devices of every user
+const (
+	loadUsers          = 9
+	loadDevicesPerUser = 40
+)
+
+func TestChatLoad(t *testing.T) {
+	t.Run("every client of hundreds gets every message", func(t *testing.T) {
+		server, clients := startLoadServer(t)
+		defer server.Close()
+		defer closeAll(clients)
+
+		const messages = 50
+		received := countMessages(clients, messages)
+		for i := 0; i < messages; i++ {
+			writeWSMessage(t, clients[0], createWsPayload(t, PostMessageEvent{
+				Type:    app.EventMessage,
+				Payload: data.PostMessageDto{ConversationId: 1, Content: fmt.Sprintf("message %v", i)},
+			}))
+		}
+		assertReceivedAll(t, received, len(clients), 10*time.Second)
+	})
+
+	t.Run("a client which never reads is disconnected with resync", func(t *testing.T) {
+		_, appServer := createServer(2)
+		server := httptest.NewServer(appServer)
+		defer server.Close()
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/chat"
+		stalled := mustDialChat(t, url, strings.Repeat("1", 26))
+		defer stalled.Close()
+		reader := mustDialChat(t, url, strings.Repeat("2", 26))
+		defer reader.Close()
+
+		// messages overflow socket buffers and the buffer of the client
+		const messages = 500
+		content := strings.Repeat("a", 32*1024)
+		received := countMessages([]*websocket.Conn{reader}, messages)
+		for i := 0; i < messages; i++ {
+			response := sendAuthorizedRequest(t, appServer, http.MethodPost, "/v1/conversations/1/messages", "2", data.PostMessageDto{Content: content})
+			tester.AssertStatus(t, response.Code, http.StatusCreated)
+		}
+		// the stalled client doesn't hold back the others
+		assertReceivedAll(t, received, 1, 10*time.Second)
+
+		stalled.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			_, _, err := stalled.ReadMessage()
+			if err == nil {
+				continue
+			}
+			if !websocket.IsCloseError(err, app.CloseResync) {
+				t.Fatalf("Expected close with code %v, got %v", app.CloseResync, err)
+			}
+			break
+		}
+	})
+
+	t.Run("every client gets messages of concurrent senders", func(t *testing.T) {
+		server, clients := startLoadServer(t)
+		defer server.Close()
+		defer closeAll(clients)
+
+		const messagesPerUser = 10
+		received := countMessages(clients, loadUsers*messagesPerUser)
+		var wg sync.WaitGroup
+		for user := 0; user < loadUsers; user++ {
+			wg.Add(1)
+			go func(sender *websocket.Conn) {
+				defer wg.Done()
+				for i := 0; i < messagesPerUser; i++ {
+					js, _ := json.Marshal(PostMessageEvent{
+						Type:    app.EventMessage,
+						Payload: data.PostMessageDto{ConversationId: 1, Content: "concurrent"},
+					})
+					sender.WriteMessage(websocket.TextMessage, js)
+				}
+			}(clients[user*loadDevicesPerUser])
+		}
+		wg.Wait()
+		assertReceivedAll(t, received, len(clients), 10*time.Second)
+	})
+}
+
+// startLoadServer connects every device of every user to a group conversation of all users
+func startLoadServer(t *testing.T) (*httptest.Server, []*websocket.Conn) {
+	t.Helper()
+	logger := log.New(io.Discard, "", 0)
+	userModel := data.NewStubUserModel(generateUsers(loadUsers))
+	conversations := generateConversation(loadUsers)[:1]
+	messageModel := data.NewStubMessageModel(conversations, []data.Message{})
+	conversationModel := data.NewStubConversationModel(conversations, userModel)
+	models := data.Models{Message: messageModel, User: userModel, Conversation: conversationModel, Event: data.NewStubEventModel(), Token: data.NewStubTokenModel(generateTokens(loadUsers))}
+	server := httptest.NewServer(app.New(app.Config{Port: 4000, Env: "development"}, logger, models))
+	clients := []*websocket.Conn{}
+	for user := 1; user <= loadUsers; user++ {
+		for device := 0; device < loadDevicesPerUser; device++ {
+			url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/chat"
+			clients = append(clients, mustDialChat(t, url, strings.Repeat(fmt.Sprint(user), 26)))
+		}
+	}
+	return server, clients
+}
+
+// countMessages reads every client until it gets the number of message events
+// with increasing seq, it reports an error for every client which didn't
+func countMessages(clients []*websocket.Conn, want int) chan error {
+	received := make(chan error, len(clients))
+	for _, ws := range clients {
+		go func(ws *websocket.Conn) {
+			count := 0
+			lastSeq := int64(0)
+			for count < want {
+				_, msg, err := ws.ReadMessage()
+				if err != nil {
+					received <- fmt.Errorf("got %v of %v messages: %v", count, want, err)
+					return
+				}
+				var got struct {
+					Type string
+					Seq  int64
+				}
+				json.NewDecoder(bytes.NewReader(msg)).Decode(&got)
+				if got.Type != app.EventMessage {
+					continue
+				}
+				if got.Seq <= lastSeq {
+					received <- fmt.Errorf("got seq %v after %v", got.Seq, lastSeq)
+					return
+				}
+				lastSeq = got.Seq
+				count++
+			}
+			received <- nil
+		}(ws)
+	}
+	return received
+}
+
+func assertReceivedAll(t *testing.T, received chan error, clients int, d time.Duration) {
+	t.Helper()
+	timeout := time.After(d)
+	for i := 0; i < clients; i++ {
+		select {
+		case err := <-received:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-timeout:
+			t.Fatalf("Only %v of %v clients got every message in %v", i, clients, d)
+		}
+	}
+}
+
+func closeAll(clients []*websocket.Conn) {
+	for _, ws := range clients {
+		ws.Close()
+	}
+}
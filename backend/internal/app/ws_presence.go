@@ -0,0 +1,60 @@
+package app
+
+import (
+	"sync"
+
+	"github.com/vasiliiperfilev/cookie/internal/data"
+)
+
+// presenceTracker counts connected clients of every user on this instance,
+// presence on every instance is kept by the presence model.
+// It is written by the hub loop and read by the presence goroutine.
+type presenceTracker struct {
+	mu          sync.RWMutex
+	connections map[int64]int
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{
+		connections: make(map[int64]int),
+	}
+}
+
+// connect returns true if it is the first connected client of the user
+func (p *presenceTracker) connect(userId int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.connections[userId]++
+	return p.connections[userId] == 1
+}
+
+// disconnect returns true if it was the last connected client of the user
+func (p *presenceTracker) disconnect(userId int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.connections[userId] <= 1 {
+		delete(p.connections, userId)
+		return true
+	}
+	p.connections[userId]--
+	return false
+}
+
+// userIds returns users who have connected clients
+func (p *presenceTracker) userIds() []int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	userIds := []int64{}
+	for userId := range p.connections {
+		userIds = append(userIds, userId)
+	}
+	return userIds
+}
+
+// presenceChange is made by the hub loop when the first client of the user connects
+// or the last one disconnects, recipients are found by the loop indexes
+type presenceChange struct {
+	user       data.User
+	online     bool
+	recipients []int64
+}
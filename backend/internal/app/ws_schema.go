@@ -0,0 +1,222 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/data"
+)
+
+// WsProtocolVersion changes when events or their payloads change incompatibly
+const WsProtocolVersion = 1
+
+// WsSchema describes the websocket protocol with JSON schemas of payloads
+type WsSchema struct {
+	Version  int                      `json:"version"`
+	Envelope jsonSchema               `json:"envelope"`
+	Events   map[string]WsEventSchema `json:"events"`
+}
+
+// WsEventSchema has payloads of the event type, a missing one means
+// the event isn't sent in that direction
+type WsEventSchema struct {
+	Description string `json:"description"`
+	// Request is sent by clients
+	Request jsonSchema `json:"request,omitempty"`
+	// Event is sent by the server
+	Event jsonSchema `json:"event,omitempty"`
+	// Reply is the payload of EventReply to a request with a request id
+	Reply jsonSchema `json:"reply,omitempty"`
+}
+
+type jsonSchema map[string]any
+
+type wsEventPayloads struct {
+	description           string
+	request, event, reply any
+}
+
+// wsEvents is the source of the published schema, every event type has to be listed
+var wsEvents = map[string]wsEventPayloads{
+	EventAuth: {
+		description: "First message of a connection without a ticket",
+		request:     AuthDto{},
+	},
+	EventAuthenticated: {
+		description: "First event of an authenticated connection",
+		event:       data.User{},
+	},
+	EventMessage: {
+		description: "New message of a conversation",
+		request:     data.PostMessageDto{},
+		event:       data.Message{},
+		reply:       data.Message{},
+	},
+	EventMessageEdited: {
+		description: "Message content was edited",
+		event:       data.Message{},
+	},
+	EventMessageDeleted: {
+		description: "Message was deleted",
+		event:       data.Message{},
+	},
+	EventNewOrder: {
+		description: "New order of a conversation",
+		request:     data.PostOrderDto{},
+		event:       data.Order{},
+		reply:       data.Order{},
+	},
+	EventUpdateOrder: {
+		description: "Order state or items changed",
+		request:     UpdateOrderDto{},
+		event:       data.Order{},
+		reply:       data.Order{},
+	},
+	EventItemUpdated: {
+		description: "Item of a supplier the user has a conversation with changed",
+		event:       data.Item{},
+	},
+	EventItemDeleted: {
+		description: "Item of a supplier the user has a conversation with was deleted",
+		event:       data.Item{},
+	},
+	EventRead: {
+		description: "Member read a conversation up to the message",
+		request:     data.PostReadDto{},
+		event:       data.ReadReceipt{},
+		reply:       data.ReadReceipt{},
+	},
+	EventTyping: {
+		description: "Member started or stopped typing, typing events aren't stored",
+		request:     TypingDto{},
+		event:       Typing{},
+		reply:       Typing{},
+	},
+	EventPresence: {
+		description: "User who shares a conversation went online or offline",
+		event:       data.Presence{},
+	},
+	EventConversationUpdated: {
+		description: "Conversation was created or its title or members changed",
+		event:       data.Conversation{},
+	},
+	EventAck: {
+		description: "Client received events up to the seq",
+		request:     AckDto{},
+		reply:       AckDto{},
+	},
+	EventClose: {
+		description: "Last event of a server-sent event stream, the code is the websocket close code",
+		event:       CloseDto{},
+	},
+	EventReply: {
+		description: "Request with the request id is done, the payload is the reply of the request event type",
+		event:       json.RawMessage{},
+	},
+	EventError: {
+		description: "Request with the request id failed, events which can't be parsed have no request id",
+		event:       WsError{},
+	},
+}
+
+func newWsSchema() WsSchema {
+	schema := WsSchema{
+		Version:  WsProtocolVersion,
+		Envelope: newJsonSchema(reflect.TypeOf(WsEvent{})),
+		Events:   map[string]WsEventSchema{},
+	}
+	for eventType, payloads := range wsEvents {
+		event := WsEventSchema{Description: payloads.description}
+		if payloads.request != nil {
+			event.Request = newJsonSchema(reflect.TypeOf(payloads.request))
+		}
+		if payloads.event != nil {
+			event.Event = newJsonSchema(reflect.TypeOf(payloads.event))
+		}
+		if payloads.reply != nil {
+			event.Reply = newJsonSchema(reflect.TypeOf(payloads.reply))
+		}
+		schema.Events[eventType] = event
+	}
+	return schema
+}
+
+func (a *Application) handleGetWsSchema(w http.ResponseWriter, r *http.Request) {
+	err := writeJsonResponse(w, http.StatusOK, newWsSchema(), nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// newJsonSchema describes how the type is encoded by encoding/json
+func newJsonSchema(t reflect.Type) jsonSchema {
+	return typeSchema(t, map[reflect.Type]bool{})
+}
+
+func typeSchema(t reflect.Type, visiting map[reflect.Type]bool) jsonSchema {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return jsonSchema{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(json.RawMessage{}):
+		return jsonSchema{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonSchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	case reflect.Pointer:
+		return jsonSchema{"anyOf": []jsonSchema{typeSchema(t.Elem(), visiting), {"type": "null"}}}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return jsonSchema{"type": "string", "contentEncoding": "base64"}
+		}
+		return jsonSchema{"type": "array", "items": typeSchema(t.Elem(), visiting)}
+	case reflect.Map:
+		return jsonSchema{"type": "object", "additionalProperties": typeSchema(t.Elem(), visiting)}
+	case reflect.Struct:
+		// recursive types are left open
+		if visiting[t] {
+			return jsonSchema{"type": "object"}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		properties := jsonSchema{}
+		required := []string{}
+		addStructFields(t, visiting, properties, &required)
+		return jsonSchema{"type": "object", "properties": properties, "required": required}
+	default:
+		return jsonSchema{}
+	}
+}
+
+// addStructFields adds fields of embedded structs to the properties the same way encoding/json does
+func addStructFields(t reflect.Type, visiting map[reflect.Type]bool, properties jsonSchema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addStructFields(field.Type, visiting, properties, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type, visiting)
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
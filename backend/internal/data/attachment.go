@@ -0,0 +1,31 @@
+package data
+
+import (
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/validator"
+)
+
+// Attachment is a file uploaded to be sent with a message,
+// MessageId is 0 until the message is sent
+type Attachment struct {
+	Id         int64     `json:"id"`
+	MessageId  int64     `json:"messageId"`
+	UploaderId int64     `json:"uploaderId"`
+	FileId     string    `json:"-"`
+	Filename   string    `json:"filename"`
+	MimeType   string    `json:"mimeType"`
+	Size       int64     `json:"size"`
+	Width      int       `json:"width,omitempty"`
+	Height     int       `json:"height,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+var AttachmentMimeTypes = []string{"image/jpeg", "image/png", "application/pdf"}
+
+func ValidateAttachment(v *validator.Validator, attachment Attachment) {
+	v.Check(attachment.Filename != "", "filename", "must be provided")
+	v.Check(len(attachment.Filename) <= 255, "filename", "must not be more than 255 bytes long")
+	v.Check(validator.PermittedValue(attachment.MimeType, AttachmentMimeTypes...), "file", "must be a jpeg, png or pdf file")
+	v.Check(attachment.Size > 0, "file", "must not be empty")
+}
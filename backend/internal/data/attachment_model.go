@@ -0,0 +1,160 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/exp/slices"
+)
+
+type AttachmentModel interface {
+	Insert(attachment *Attachment) error
+	GetById(id int64) (Attachment, error)
+}
+
+type PsqlAttachmentModel struct {
+	db *sql.DB
+}
+
+func NewPsqlAttachmentModel(db *sql.DB) *PsqlAttachmentModel {
+	return &PsqlAttachmentModel{db: db}
+}
+
+func (m PsqlAttachmentModel) Insert(attachment *Attachment) error {
+	query := `
+		INSERT INTO attachments (uploader_id, file_id, filename, mime_type, size, width, height)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING attachment_id, created_at`
+
+	args := []any{attachment.UploaderId, attachment.FileId, attachment.Filename, attachment.MimeType, attachment.Size, attachment.Width, attachment.Height}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.db.QueryRowContext(ctx, query, args...).Scan(&attachment.Id, &attachment.CreatedAt)
+}
+
+func (m PsqlAttachmentModel) GetById(id int64) (Attachment, error) {
+	query := `
+		SELECT attachment_id, COALESCE(message_id, 0), uploader_id, file_id, filename, mime_type, size, width, height, created_at
+		FROM attachments
+		WHERE attachment_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var attachment Attachment
+	err := scanAttachment(m.db.QueryRowContext(ctx, query, id), &attachment)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return Attachment{}, ErrRecordNotFound
+		default:
+			return Attachment{}, err
+		}
+	}
+
+	return attachment, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAttachment(row rowScanner, attachment *Attachment) error {
+	return row.Scan(
+		&attachment.Id,
+		&attachment.MessageId,
+		&attachment.UploaderId,
+		&attachment.FileId,
+		&attachment.Filename,
+		&attachment.MimeType,
+		&attachment.Size,
+		&attachment.Width,
+		&attachment.Height,
+		&attachment.CreatedAt,
+	)
+}
+
+// linkAttachments attaches uploaded files to the message, every attachment
+// has to be uploaded by the sender and not be sent with another message yet
+func linkAttachments(tx *sql.Tx, msg *Message) error {
+	if len(msg.Attachments) == 0 {
+		return nil
+	}
+	ids := Map(msg.Attachments, func(a Attachment) int64 { return a.Id })
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+
+	query := `
+		UPDATE attachments
+		SET message_id = $1
+		WHERE attachment_id = ANY($2) AND uploader_id = $3 AND message_id IS NULL
+		RETURNING attachment_id, message_id, uploader_id, file_id, filename, mime_type, size, width, height, created_at`
+
+	rows, err := tx.Query(query, msg.Id, pq.Array(ids), msg.SenderId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	attachments := []Attachment{}
+	for rows.Next() {
+		var attachment Attachment
+		if err := scanAttachment(rows, &attachment); err != nil {
+			return err
+		}
+		attachments = append(attachments, attachment)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(attachments) != len(ids) {
+		return ErrRecordNotFound
+	}
+	slices.SortFunc(attachments, func(a, b Attachment) bool { return a.Id < b.Id })
+	msg.Attachments = attachments
+
+	return nil
+}
+
+// loadAttachments fills attachments of the messages
+func loadAttachments(ctx context.Context, db *sql.DB, messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := Map(messages, func(m Message) int64 { return m.Id })
+
+	query := `
+		SELECT attachment_id, message_id, uploader_id, file_id, filename, mime_type, size, width, height, created_at
+		FROM attachments
+		WHERE message_id = ANY($1)
+		ORDER BY attachment_id`
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byMessage := map[int64][]Attachment{}
+	for rows.Next() {
+		var attachment Attachment
+		if err := scanAttachment(rows, &attachment); err != nil {
+			return err
+		}
+		byMessage[attachment.MessageId] = append(byMessage[attachment.MessageId], attachment)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Attachments = byMessage[messages[i].Id]
+	}
+
+	return nil
+}
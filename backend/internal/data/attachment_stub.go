@@ -0,0 +1,78 @@
+package data
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+type StubAttachmentModel struct {
+	mu          sync.Mutex
+	attachments []Attachment
+	idCount     int64
+}
+
+func NewStubAttachmentModel(attachments []Attachment) *StubAttachmentModel {
+	return &StubAttachmentModel{attachments: attachments, idCount: int64(len(attachments))}
+}
+
+func (s *StubAttachmentModel) Insert(attachment *Attachment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idCount++
+	attachment.Id = s.idCount
+	attachment.CreatedAt = time.Now()
+	s.attachments = append(s.attachments, *attachment)
+	return nil
+}
+
+func (s *StubAttachmentModel) GetById(id int64) (Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.attachments, func(a Attachment) bool { return a.Id == id })
+	if i < 0 {
+		return Attachment{}, ErrRecordNotFound
+	}
+	return s.attachments[i], nil
+}
+
+func (s *StubAttachmentModel) link(msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	indexes := []int{}
+	for _, requested := range msg.Attachments {
+		i := slices.IndexFunc(s.attachments, func(a Attachment) bool {
+			return a.Id == requested.Id && a.UploaderId == msg.SenderId && a.MessageId == 0
+		})
+		if i < 0 {
+			return ErrRecordNotFound
+		}
+		if !slices.Contains(indexes, i) {
+			indexes = append(indexes, i)
+		}
+	}
+	attachments := []Attachment{}
+	for _, i := range indexes {
+		s.attachments[i].MessageId = msg.Id
+		attachments = append(attachments, s.attachments[i])
+	}
+	slices.SortFunc(attachments, func(a, b Attachment) bool { return a.Id < b.Id })
+	msg.Attachments = attachments
+	return nil
+}
+
+func (s *StubAttachmentModel) deleteByMessageId(id int64) []Attachment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attachments, removed := []Attachment{}, []Attachment{}
+	for _, a := range s.attachments {
+		if a.MessageId != id {
+			attachments = append(attachments, a)
+		} else {
+			removed = append(removed, a)
+		}
+	}
+	s.attachments = attachments
+	return removed
+}
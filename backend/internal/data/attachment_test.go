@@ -0,0 +1,72 @@
+package data_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/database"
+	"github.com/vasiliiperfilev/cookie/internal/tester"
+)
+
+func TestAttachmentModelIntegration(t *testing.T) {
+	dsn := fmt.Sprintf(
+		"postgres://%s:%s@localhost:%s/%s?sslmode=disable",
+		database.POSTGRES_USER,
+		database.POSTGRES_PASSWORD,
+		database.POSTGRES_PORT,
+		database.POSTGRES_DB,
+	)
+	cfg := database.Config{
+		MaxOpenConns: 25,
+		MaxIdleConns: 25,
+		MaxIdleTime:  "15m",
+		Dsn:          dsn,
+	}
+	db, err := database.OpenDB(cfg)
+	tester.AssertNoError(t, err)
+	attachmentModel := data.NewPsqlAttachmentModel(db)
+	messageModel := data.NewPsqlMessageModel(db)
+
+	t.Run("it inserts an attachment and sends it with a message", func(t *testing.T) {
+		attachment := data.Attachment{
+			UploaderId: 1,
+			FileId:     fmt.Sprintf("%d.pdf", time.Now().UnixNano()),
+			Filename:   "invoice.pdf",
+			MimeType:   "application/pdf",
+			Size:       42,
+		}
+		err := attachmentModel.Insert(&attachment)
+		tester.AssertNoError(t, err)
+
+		msg := data.Message{ConversationId: 0, SenderId: 1, Content: "invoice", Attachments: []data.Attachment{{Id: attachment.Id}}}
+		err = messageModel.Insert(&msg, nil)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, len(msg.Attachments), 1, "message must have an attachment")
+		tester.AssertValue(t, msg.Attachments[0].Filename, attachment.Filename, "attachment must be loaded")
+
+		got, err := messageModel.GetById(msg.Id)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, len(got.Attachments), 1, "message must have an attachment")
+		gotAttachment, err := attachmentModel.GetById(attachment.Id)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, gotAttachment.MessageId, msg.Id, "attachment must be linked to the message")
+	})
+
+	t.Run("it doesn't send attachment uploaded by other user", func(t *testing.T) {
+		attachment := data.Attachment{
+			UploaderId: 2,
+			FileId:     fmt.Sprintf("%d.pdf", time.Now().UnixNano()),
+			Filename:   "invoice.pdf",
+			MimeType:   "application/pdf",
+			Size:       42,
+		}
+		err := attachmentModel.Insert(&attachment)
+		tester.AssertNoError(t, err)
+
+		msg := data.Message{ConversationId: 0, SenderId: 1, Content: "invoice", Attachments: []data.Attachment{{Id: attachment.Id}}}
+		err = messageModel.Insert(&msg, nil)
+		tester.AssertValue(t, err, data.ErrRecordNotFound, "expected attachment not to be found")
+	})
+}
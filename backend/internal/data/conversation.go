@@ -3,19 +3,92 @@ package data
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/vasiliiperfilev/cookie/internal/tester"
+	"github.com/vasiliiperfilev/cookie/internal/validator"
 )
 
 var (
 	ErrDuplicateConversation = errors.New("duplicate conversation")
+	ErrDuplicateParticipant  = errors.New("duplicate participant")
+)
+
+const (
+	RoleOwner  = "owner"
+	RoleMember = "member"
 )
 
 type Conversation struct {
 	Id          int64   `json:"id"`
+	Title       string  `json:"title"`
+	Group       bool    `json:"group"`
 	Users       []User  `json:"users"`
 	LastMessage Message `json:"lastMessage"`
-	Version     int     `json:"version"`
+	// roles of the users, owners manage title and members of the conversation
+	Participants []Participant `json:"participants,omitempty"`
+	// read state of the user who requested the conversation
+	LastReadMessageId int64 `json:"lastReadMessageId"`
+	UnreadCount       int   `json:"unreadCount"`
+	ConversationSettings
+	// chat status of the other participants, only set in conversation listings
+	Presence []Presence `json:"presence,omitempty"`
+	Version  int        `json:"version"`
+}
+
+type Participant struct {
+	UserId int64  `json:"userId"`
+	Role   string `json:"role"`
+}
+
+// RoleOf returns the role of the user in the conversation or an empty string if the user isn't a member
+func (c Conversation) RoleOf(userId int64) string {
+	for _, p := range c.Participants {
+		if p.UserId == userId {
+			return p.Role
+		}
+	}
+	return ""
+}
+
+// ConversationSettings are personal to every participant of the conversation
+type ConversationSettings struct {
+	Archived bool `json:"archived"`
+	Pinned   bool `json:"pinned"`
+	// messages of a muted conversation are still delivered, but clients shouldn't notify about them
+	MutedUntil *time.Time `json:"mutedUntil"`
+}
+
+func (s ConversationSettings) IsMuted(now time.Time) bool {
+	return s.MutedUntil != nil && s.MutedUntil.After(now)
+}
+
+// ConversationChange is the payload of conversation update events, members are loaded
+// when the event is sent, users removed by the change are notified as well
+type ConversationChange struct {
+	Removed []int64 `json:"removed,omitempty"`
+}
+
+// ReadReceipt tells that the user has read the conversation up to the message
+type ReadReceipt struct {
+	ConversationId int64 `json:"conversationId"`
+	UserId         int64 `json:"userId"`
+	MessageId      int64 `json:"messageId"`
+}
+
+func ValidateConversationTitle(v *validator.Validator, title string) {
+	v.Check(len(title) <= 100, "title", "must not be more than 100 bytes long")
+}
+
+func ValidatePostConversation(v *validator.Validator, dto PostConversationDto) {
+	v.Check(validator.Unique(dto.UserIds), "userIds", "must not contain duplicate values")
+	ValidateConversationTitle(v, dto.Title)
+}
+
+func ValidateConversationSettings(v *validator.Validator, settings ConversationSettings) {
+	if settings.MutedUntil != nil {
+		v.Check(settings.MutedUntil.After(time.Now()), "mutedUntil", "must be in the future")
+	}
 }
 
 func AssertConversation(t *testing.T, got Conversation, want Conversation) {
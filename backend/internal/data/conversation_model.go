@@ -4,19 +4,76 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
+	"golang.org/x/exp/slices"
 )
 
 type ConversationModel interface {
-	Insert(conversation PostConversationDto) (Conversation, error)
+	// Insert, UpdateLastRead, Update, AddParticipant and Leave write the event built by newEvent
+	// to the outbox with the change, newEvent can be nil
+	Insert(conversation PostConversationDto, newEvent func(Conversation) DomainEvent) (Conversation, error)
 	GetAllByUserId(userId int64) ([]Conversation, error)
 	GetById(id int64) (Conversation, error)
+	UpdateLastRead(receipt ReadReceipt, newEvent func(ReadReceipt) DomainEvent) error
+	Update(conversation *Conversation, newEvent func(Conversation) DomainEvent) error
+	AddParticipant(conversationId int64, participant Participant, newEvent func(Participant) DomainEvent) error
+	UpdateParticipant(conversationId int64, participant Participant) error
+	// Leave removes the user from the conversation, if no owner is left the member with the lowest id becomes an owner
+	Leave(conversationId int64, userId int64, newEvent func(Participant) DomainEvent) error
+	UpdateSettings(conversationId int64, userId int64, settings ConversationSettings) error
+	GetMutedUserIds(conversationId int64) ([]int64, error)
 }
 
 type PostConversationDto struct {
 	UserIds []int64 `json:"userIds"`
+	Title   string  `json:"title"`
+	// conversations with more than 2 users are always groups
+	Group bool `json:"group"`
+	// OwnerId is the creator of the conversation, in direct conversations every user is an owner
+	OwnerId int64 `json:"-"`
+}
+
+// Participants returns the users of the new conversation with their roles
+func (dto PostConversationDto) Participants() []Participant {
+	return Map(dto.UserIds, func(id int64) Participant {
+		if !dto.IsGroup() || id == dto.OwnerId {
+			return Participant{UserId: id, Role: RoleOwner}
+		}
+		return Participant{UserId: id, Role: RoleMember}
+	})
+}
+
+func (dto PostConversationDto) IsGroup() bool {
+	return dto.Group || len(dto.UserIds) > 2
+}
+
+// directKey identifies the set of users of a direct conversation, groups have no key
+func (dto PostConversationDto) directKey() sql.NullString {
+	if dto.IsGroup() {
+		return sql.NullString{}
+	}
+	ids := append([]int64{}, dto.UserIds...)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	key := strings.Join(Map(ids, func(id int64) string { return strconv.FormatInt(id, 10) }), ":")
+	return sql.NullString{String: key, Valid: true}
+}
+
+type PatchConversationDto struct {
+	Title string `json:"title"`
+}
+
+type PostParticipantDto struct {
+	UserId int64 `json:"userId"`
+}
+
+type PostReadDto struct {
+	ConversationId int64 `json:"conversationId"`
+	MessageId      int64 `json:"messageId"`
 }
 
 type PsqlConversationModel struct {
@@ -27,40 +84,87 @@ func NewPsqlConversationModel(db *sql.DB) *PsqlConversationModel {
 	return &PsqlConversationModel{db: db}
 }
 
-func (m PsqlConversationModel) Insert(dto PostConversationDto) (Conversation, error) {
+// Insert creates the conversation with its users in a single transaction.
+// If a direct conversation with the same users exists, it returns
+// the existing conversation id with ErrDuplicateConversation
+func (m PsqlConversationModel) Insert(dto PostConversationDto, newEvent func(Conversation) DomainEvent) (Conversation, error) {
 	query := `
-        INSERT INTO conversations(last_message_id)
-        VALUES (0)
+        INSERT INTO conversations(last_message_id, title, is_group, direct_key)
+        VALUES (0, $1, $2, $3)
+        ON CONFLICT (direct_key) DO NOTHING
         RETURNING conversation_id, version`
 
-	cvs := Conversation{}
+	cvs := Conversation{Title: dto.Title, Group: dto.IsGroup(), Participants: dto.Participants()}
+	directKey := dto.directKey()
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.db.QueryRowContext(ctx, query).Scan(&cvs.Id, &cvs.Version)
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Conversation{}, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, dto.Title, cvs.Group, directKey).Scan(&cvs.Id, &cvs.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			var existing Conversation
+			query = `SELECT conversation_id FROM conversations WHERE direct_key = $1`
+			err = tx.QueryRowContext(ctx, query, directKey).Scan(&existing.Id)
+			if err != nil {
+				return Conversation{}, err
+			}
+			return existing, ErrDuplicateConversation
+		default:
+			return Conversation{}, err
+		}
+	}
+
+	err = insertConversationUsers(ctx, tx, &cvs, cvs.Participants)
+	if err != nil {
+		return Conversation{}, err
+	}
+	err = insertDomainEvent(tx, eventOf(newEvent, cvs))
 	if err != nil {
 		return Conversation{}, err
 	}
 
-	err = m.insertConversationUsers(&cvs, dto.UserIds)
+	err = tx.Commit()
 	if err != nil {
-		// remove empty conversation
 		return Conversation{}, err
 	}
 
 	return cvs, nil
 }
 
+// GetAllByUserId returns conversations of the user with the settings of the user,
+// pinned conversations go first, then the ones with the latest messages
 func (m PsqlConversationModel) GetAllByUserId(userId int64) ([]Conversation, error) {
 	query := `
-    SELECT c.conversation_id, c.last_message_id, c.version, array_agg(c_u_ids.user_id) as user_ids
+    SELECT c.conversation_id, c.title, c.is_group, c.last_message_id, c.version, c_u.last_read_message_id,
+			c_u.archived, c_u.pinned, c_u.muted_until,
+			(
+				SELECT COUNT(*) FROM messages as m
+				WHERE m.conversation_id = c.conversation_id
+					AND m.message_id > c_u.last_read_message_id
+					AND m.sender_id <> c_u.user_id
+			) as unread_count,
+			array_agg(c_u_ids.user_id ORDER BY c_u_ids.user_id) as user_ids,
+			array_agg(c_u_ids.role ORDER BY c_u_ids.user_id) as roles
     FROM conversations_users as c_u
 			INNER JOIN conversations as c
 				ON c.conversation_id = c_u.conversation_id
 			INNER JOIN conversations_users as c_u_ids
 				ON c.conversation_id = c_u_ids.conversation_id
     WHERE c_u.user_id = $1
-		GROUP BY c.conversation_id`
+		GROUP BY c.conversation_id, c_u.user_id, c_u.last_read_message_id, c_u.archived, c_u.pinned, c_u.muted_until
+		ORDER BY c_u.pinned DESC,
+			(
+				SELECT MAX(m.created_at) FROM messages as m
+				WHERE m.conversation_id = c.conversation_id
+			) DESC NULLS LAST,
+			c.conversation_id DESC`
 
 	conversations := []Conversation{}
 
@@ -81,10 +185,25 @@ func (m PsqlConversationModel) GetAllByUserId(userId int64) ([]Conversation, err
 	for rows.Next() {
 		conversation := Conversation{}
 		userIds := []int64{}
+		roles := []string{}
 		var lastMessageId int64
-		if err := rows.Scan(&conversation.Id, &lastMessageId, &conversation.Version, (*pq.Int64Array)(&userIds)); err != nil {
+		if err := rows.Scan(
+			&conversation.Id,
+			&conversation.Title,
+			&conversation.Group,
+			&lastMessageId,
+			&conversation.Version,
+			&conversation.LastReadMessageId,
+			&conversation.Archived,
+			&conversation.Pinned,
+			&conversation.MutedUntil,
+			&conversation.UnreadCount,
+			(*pq.Int64Array)(&userIds),
+			(*pq.StringArray)(&roles),
+		); err != nil {
 			return nil, err
 		}
+		conversation.Participants = participants(userIds, roles)
 		err = m.getUsers(&conversation, userIds)
 		if err != nil {
 			return nil, err
@@ -104,7 +223,9 @@ func (m PsqlConversationModel) GetAllByUserId(userId int64) ([]Conversation, err
 
 func (m PsqlConversationModel) GetById(id int64) (Conversation, error) {
 	query := `
-		SELECT c.conversation_id, c.last_message_id, c.version, array_agg(c_u.user_id) as user_ids
+		SELECT c.conversation_id, c.title, c.is_group, c.last_message_id, c.version,
+			array_agg(c_u.user_id ORDER BY c_u.user_id) as user_ids,
+			array_agg(c_u.role ORDER BY c_u.user_id) as roles
 		FROM conversations_users as c_u
 			INNER JOIN conversations as c
 				ON c.conversation_id = c_u.conversation_id
@@ -114,15 +235,19 @@ func (m PsqlConversationModel) GetById(id int64) (Conversation, error) {
 	var conversation Conversation
 	var lastMessageId int64
 	userIds := []int64{}
+	roles := []string{}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	err := m.db.QueryRowContext(ctx, query, id).Scan(
 		&conversation.Id,
+		&conversation.Title,
+		&conversation.Group,
 		&lastMessageId,
 		&conversation.Version,
 		(*pq.Int64Array)(&userIds),
+		(*pq.StringArray)(&roles),
 	)
 
 	if err != nil {
@@ -134,6 +259,7 @@ func (m PsqlConversationModel) GetById(id int64) (Conversation, error) {
 		}
 	}
 
+	conversation.Participants = participants(userIds, roles)
 	err = m.getUsers(&conversation, userIds)
 	if err != nil {
 		return Conversation{}, err
@@ -146,29 +272,252 @@ func (m PsqlConversationModel) GetById(id int64) (Conversation, error) {
 	return conversation, nil
 }
 
-func (m PsqlConversationModel) insertConversationUsers(conversation *Conversation, userIds []int64) error {
-	for _, userId := range userIds {
-		query := `
-		INSERT INTO conversations_users(conversation_id, user_id)
-		VALUES ($1, $2)`
+// UpdateLastRead moves the read pointer of the user forward, it never moves back
+func (m PsqlConversationModel) UpdateLastRead(receipt ReadReceipt, newEvent func(ReadReceipt) DomainEvent) error {
+	query := `
+		UPDATE conversations_users
+		SET last_read_message_id = GREATEST(last_read_message_id, $3)
+		WHERE conversation_id = $1 AND user_id = $2`
 
-		args := []any{conversation.Id, userId}
+	args := []any{receipt.ConversationId, receipt.UserId, receipt.MessageId}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-		defer cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-		_, err := m.db.QueryContext(ctx, query, args...)
-		if err != nil {
+	err = execAffectingRow(ctx, tx, query, args...)
+	if err != nil {
+		return err
+	}
+	err = insertDomainEvent(tx, eventOf(newEvent, receipt))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Update changes the title of the conversation
+func (m PsqlConversationModel) Update(conversation *Conversation, newEvent func(Conversation) DomainEvent) error {
+	query := `
+		UPDATE conversations
+		SET title = $1, version = version + 1
+		WHERE conversation_id = $2 AND version = $3
+		RETURNING version`
+
+	args := []any{conversation.Title, conversation.Id, conversation.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&conversation.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
 			return err
 		}
+	}
+	err = insertDomainEvent(tx, eventOf(newEvent, *conversation))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m PsqlConversationModel) AddParticipant(conversationId int64, participant Participant, newEvent func(Participant) DomainEvent) error {
+	query := `
+		INSERT INTO conversations_users(conversation_id, user_id, role)
+		VALUES ($1, $2, $3)`
+
+	args := []any{conversationId, participant.UserId, participant.Role}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "conversations_users_pkey"`:
+			return ErrDuplicateParticipant
+		case err.Error() == `pq: insert or update on table "conversations_users" violates foreign key constraint "conversations_users_user_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	err = insertDomainEvent(tx, eventOf(newEvent, participant))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m PsqlConversationModel) UpdateParticipant(conversationId int64, participant Participant) error {
+	query := `
+		UPDATE conversations_users
+		SET role = $3
+		WHERE conversation_id = $1 AND user_id = $2`
+
+	args := []any{conversationId, participant.UserId, participant.Role}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return execAffectingRow(ctx, m.db, query, args...)
+}
+
+func (m PsqlConversationModel) Leave(conversationId int64, userId int64, newEvent func(Participant) DomainEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// owners leaving at the same time have to see each other gone to promote a member
+	_, err = tx.ExecContext(ctx, `SELECT 1 FROM conversations WHERE conversation_id = $1 FOR UPDATE`, conversationId)
+	if err != nil {
+		return err
+	}
+	query := `
+		DELETE FROM conversations_users
+		WHERE conversation_id = $1 AND user_id = $2`
+	err = execAffectingRow(ctx, tx, query, conversationId, userId)
+	if err != nil {
+		return err
+	}
+	query = `
+		UPDATE conversations_users
+		SET role = $2
+		WHERE conversation_id = $1
+			AND user_id = (SELECT min(user_id) FROM conversations_users WHERE conversation_id = $1)
+			AND NOT EXISTS (SELECT 1 FROM conversations_users WHERE conversation_id = $1 AND role = $2)`
+	_, err = tx.ExecContext(ctx, query, conversationId, RoleOwner)
+	if err != nil {
+		return err
+	}
+	err = insertDomainEvent(tx, eventOf(newEvent, Participant{UserId: userId}))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateSettings replaces the settings of the user in the conversation
+func (m PsqlConversationModel) UpdateSettings(conversationId int64, userId int64, settings ConversationSettings) error {
+	query := `
+		UPDATE conversations_users
+		SET archived = $3, pinned = $4, muted_until = $5
+		WHERE conversation_id = $1 AND user_id = $2`
+
+	args := []any{conversationId, userId, settings.Archived, settings.Pinned, settings.MutedUntil}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return execAffectingRow(ctx, m.db, query, args...)
+}
+
+// GetMutedUserIds returns users who have the conversation muted at the moment
+func (m PsqlConversationModel) GetMutedUserIds(conversationId int64) ([]int64, error) {
+	query := `
+		SELECT user_id
+		FROM conversations_users
+		WHERE conversation_id = $1 AND muted_until > now()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIds := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIds = append(userIds, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return userIds, nil
+}
+
+// execAffectingRow runs the query and returns ErrRecordNotFound if no rows were changed
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func execAffectingRow(ctx context.Context, db execer, query string, args ...any) error {
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func participants(userIds []int64, roles []string) []Participant {
+	result := []Participant{}
+	for i, id := range userIds {
+		result = append(result, Participant{UserId: id, Role: roles[i]})
+	}
+	return result
+}
+
+func insertConversationUsers(ctx context.Context, tx *sql.Tx, conversation *Conversation, participants []Participant) error {
+	query := `
+		INSERT INTO conversations_users(conversation_id, user_id, role)
+		VALUES ($1, $2, $3)`
+
+	for _, participant := range participants {
+		args := []any{conversation.Id, participant.UserId, participant.Role}
+		_, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 func (m PsqlConversationModel) getLastMessage(conversation *Conversation, messageId int64) error {
 	query := `
-	    SELECT message_id, sender_id, conversation_id, prev_message_id, created_at, content
+	    SELECT message_id, sender_id, conversation_id, prev_message_id, created_at, content, edited_at, deleted_at
 	    FROM messages
 	    WHERE message_id = $1`
 
@@ -177,7 +526,7 @@ func (m PsqlConversationModel) getLastMessage(conversation *Conversation, messag
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.db.QueryRowContext(ctx, query, messageId).Scan(&msg.Id, &msg.SenderId, &msg.ConversationId, &msg.PrevMessageId, &msg.CreatedAt, &msg.Content)
+	err := m.db.QueryRowContext(ctx, query, messageId).Scan(&msg.Id, &msg.SenderId, &msg.ConversationId, &msg.PrevMessageId, &msg.CreatedAt, &msg.Content, &msg.EditedAt, &msg.DeletedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -194,7 +543,7 @@ func (m PsqlConversationModel) getUsers(conversation *Conversation, userIds []in
 	users := []User{}
 	for _, id := range userIds {
 		query := `
-        SELECT user_id, created_at, email, name, password_hash, user_type_id, version, image_id
+        SELECT user_id, created_at, email, name, password_hash, user_type_id, version, image_id, activated
         FROM users
         WHERE user_id = $1`
 
@@ -212,6 +561,7 @@ func (m PsqlConversationModel) getUsers(conversation *Conversation, userIds []in
 			&user.Type,
 			&user.Version,
 			&user.ImageId,
+			&user.Activated,
 		)
 
 		if err != nil {
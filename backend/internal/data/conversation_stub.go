@@ -1,20 +1,49 @@
 package data
 
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
 type StubConversationModel struct {
+	mu            sync.Mutex
 	conversations []Conversation
 	idCount       int64
 	userModel     UserModel
+	messageModel  MessageModel
+	lastRead      map[readKey]int64
+	settings      map[readKey]ConversationSettings
+	outbox        *StubOutboxModel
+}
+
+type readKey struct {
+	conversationId int64
+	userId         int64
 }
 
 func NewStubConversationModel(conversations []Conversation, userModel UserModel) *StubConversationModel {
-	return &StubConversationModel{conversations: conversations, userModel: userModel}
+	return &StubConversationModel{conversations: conversations, userModel: userModel, lastRead: map[readKey]int64{}, settings: map[readKey]ConversationSettings{}}
 }
 
-func (s *StubConversationModel) Insert(dto PostConversationDto) (Conversation, error) {
+// SetMessageModel allows the stub to count unread messages
+func (s *StubConversationModel) SetMessageModel(messageModel MessageModel) {
+	s.messageModel = messageModel
+}
+
+// SetOutboxModel allows the stub to write domain events of conversations
+func (s *StubConversationModel) SetOutboxModel(outbox *StubOutboxModel) {
+	s.outbox = outbox
+}
+
+func (s *StubConversationModel) Insert(dto PostConversationDto, newEvent func(Conversation) DomainEvent) (Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	for _, existingConversation := range s.conversations {
 		userIds := Map(existingConversation.Users, func(u User) int64 { return u.Id })
-		if EqualArraysContent(userIds, dto.UserIds) {
-			return Conversation{}, ErrDuplicateConversation
+		if !dto.IsGroup() && !existingConversation.Group && EqualArraysContent(userIds, dto.UserIds) {
+			return Conversation{Id: existingConversation.Id}, ErrDuplicateConversation
 		}
 	}
 	s.idCount++
@@ -24,26 +53,73 @@ func (s *StubConversationModel) Insert(dto PostConversationDto) (Conversation, e
 			u, _ := s.userModel.GetById(id)
 			return u
 		}),
-		LastMessage: Message{Id: 0},
-		Version:     1,
+		LastMessage:  Message{Id: 0},
+		Version:      1,
+		Title:        dto.Title,
+		Group:        dto.IsGroup(),
+		Participants: dto.Participants(),
 	}
 	s.conversations = append(s.conversations, conversation)
+	s.outbox.add(eventOf(newEvent, conversation))
 	return conversation, nil
 }
 
 func (s *StubConversationModel) GetAllByUserId(userId int64) ([]Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	result := []Conversation{}
 	for _, conversation := range s.conversations {
 		for _, u := range conversation.Users {
 			if u.Id == userId {
+				key := readKey{conversationId: conversation.Id, userId: userId}
+				conversation.LastReadMessageId = s.lastRead[key]
+				conversation.UnreadCount = s.countUnread(conversation.Id, userId, conversation.LastReadMessageId)
+				conversation.ConversationSettings = s.settings[key]
 				result = append(result, conversation)
 			}
 		}
 	}
+	lastMessageAt := map[int64]time.Time{}
+	for _, conversation := range result {
+		lastMessageAt[conversation.Id] = s.lastMessageAt(conversation)
+	}
+	slices.SortStableFunc(result, func(a, b Conversation) bool {
+		if a.Pinned != b.Pinned {
+			return a.Pinned
+		}
+		if !lastMessageAt[a.Id].Equal(lastMessageAt[b.Id]) {
+			return lastMessageAt[a.Id].After(lastMessageAt[b.Id])
+		}
+		return a.Id > b.Id
+	})
 	return result, nil
 }
 
+// lastMessageAt returns the time of the latest message in the conversation
+func (s *StubConversationModel) lastMessageAt(conversation Conversation) time.Time {
+	latest := conversation.LastMessage.CreatedAt
+	if s.messageModel == nil {
+		return latest
+	}
+	messages, err := s.messageModel.GetAllByConversationId(conversation.Id)
+	if err != nil {
+		return latest
+	}
+	for _, msg := range messages {
+		if msg.CreatedAt.After(latest) {
+			latest = msg.CreatedAt
+		}
+	}
+	return latest
+}
+
 func (s *StubConversationModel) GetById(id int64) (Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getById(id)
+}
+
+func (s *StubConversationModel) getById(id int64) (Conversation, error) {
 	for _, conversation := range s.conversations {
 		if conversation.Id == id {
 			return conversation, nil
@@ -51,3 +127,169 @@ func (s *StubConversationModel) GetById(id int64) (Conversation, error) {
 	}
 	return Conversation{}, ErrRecordNotFound
 }
+
+func (s *StubConversationModel) UpdateLastRead(receipt ReadReceipt, newEvent func(ReadReceipt) DomainEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conversation, err := s.getById(receipt.ConversationId)
+	if err != nil {
+		return err
+	}
+	for _, u := range conversation.Users {
+		if u.Id == receipt.UserId {
+			key := readKey{conversationId: receipt.ConversationId, userId: receipt.UserId}
+			if s.lastRead[key] < receipt.MessageId {
+				s.lastRead[key] = receipt.MessageId
+			}
+			s.outbox.add(eventOf(newEvent, receipt))
+			return nil
+		}
+	}
+	return ErrRecordNotFound
+}
+
+func (s *StubConversationModel) countUnread(conversationId int64, userId int64, lastReadMessageId int64) int {
+	if s.messageModel == nil {
+		return 0
+	}
+	messages, err := s.messageModel.GetAllByConversationId(conversationId)
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for _, msg := range messages {
+		if msg.Id > lastReadMessageId && msg.SenderId != userId {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *StubConversationModel) Update(conversation *Conversation, newEvent func(Conversation) DomainEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.indexOf(conversation.Id)
+	if err != nil {
+		return err
+	}
+	if s.conversations[i].Version != conversation.Version {
+		return ErrEditConflict
+	}
+	conversation.Version++
+	s.conversations[i].Title = conversation.Title
+	s.conversations[i].Version = conversation.Version
+	s.outbox.add(eventOf(newEvent, *conversation))
+	return nil
+}
+
+func (s *StubConversationModel) AddParticipant(conversationId int64, participant Participant, newEvent func(Participant) DomainEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.indexOf(conversationId)
+	if err != nil {
+		return err
+	}
+	if s.conversations[i].RoleOf(participant.UserId) != "" {
+		return ErrDuplicateParticipant
+	}
+	user, err := s.userModel.GetById(participant.UserId)
+	if err != nil {
+		return err
+	}
+	// copy slices, conversations returned earlier must not change
+	s.conversations[i].Users = append(append([]User{}, s.conversations[i].Users...), user)
+	s.conversations[i].Participants = append(append([]Participant{}, s.conversations[i].Participants...), participant)
+	s.outbox.add(eventOf(newEvent, participant))
+	return nil
+}
+
+func (s *StubConversationModel) UpdateParticipant(conversationId int64, participant Participant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.indexOf(conversationId)
+	if err != nil {
+		return err
+	}
+	participants := append([]Participant{}, s.conversations[i].Participants...)
+	for j := range participants {
+		if participants[j].UserId == participant.UserId {
+			participants[j].Role = participant.Role
+			s.conversations[i].Participants = participants
+			return nil
+		}
+	}
+	return ErrRecordNotFound
+}
+
+func (s *StubConversationModel) Leave(conversationId int64, userId int64, newEvent func(Participant) DomainEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.indexOf(conversationId)
+	if err != nil {
+		return err
+	}
+	users := []User{}
+	for _, u := range s.conversations[i].Users {
+		if u.Id != userId {
+			users = append(users, u)
+		}
+	}
+	if len(users) == len(s.conversations[i].Users) {
+		return ErrRecordNotFound
+	}
+	participants := []Participant{}
+	for _, p := range s.conversations[i].Participants {
+		if p.UserId != userId {
+			participants = append(participants, p)
+		}
+	}
+	if len(participants) > 0 && !slices.ContainsFunc(participants, func(p Participant) bool { return p.Role == RoleOwner }) {
+		next := 0
+		for j, p := range participants {
+			if p.UserId < participants[next].UserId {
+				next = j
+			}
+		}
+		participants[next].Role = RoleOwner
+	}
+	s.conversations[i].Users = users
+	s.conversations[i].Participants = participants
+	s.outbox.add(eventOf(newEvent, Participant{UserId: userId}))
+	return nil
+}
+
+func (s *StubConversationModel) UpdateSettings(conversationId int64, userId int64, settings ConversationSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conversation, err := s.getById(conversationId)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(conversation.Users, func(u User) bool { return u.Id == userId }) {
+		return ErrRecordNotFound
+	}
+	s.settings[readKey{conversationId: conversationId, userId: userId}] = settings
+	return nil
+}
+
+func (s *StubConversationModel) GetMutedUserIds(conversationId int64) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userIds := []int64{}
+	now := time.Now()
+	for key, settings := range s.settings {
+		if key.conversationId == conversationId && settings.IsMuted(now) {
+			userIds = append(userIds, key.userId)
+		}
+	}
+	return userIds, nil
+}
+
+func (s *StubConversationModel) indexOf(conversationId int64) (int, error) {
+	for i, conversation := range s.conversations {
+		if conversation.Id == conversationId {
+			return i, nil
+		}
+	}
+	return 0, ErrRecordNotFound
+}
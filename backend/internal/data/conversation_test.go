@@ -3,6 +3,7 @@ package data_test
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/vasiliiperfilev/cookie/internal/data"
 	"github.com/vasiliiperfilev/cookie/internal/database"
@@ -31,7 +32,7 @@ func TestConversationModelIntegration(t *testing.T) {
 		dto := data.PostConversationDto{
 			UserIds: []int64{99, 100},
 		}
-		_, err := model.Insert(dto)
+		_, err := model.Insert(dto, nil)
 		tester.AssertError(t, err)
 		tester.AssertValue(t, err.Error(), `pq: insert or update on table "conversations_users" violates foreign key constraint "conversations_users_user_id_fkey"`, "expected no users error")
 	})
@@ -41,10 +42,32 @@ func TestConversationModelIntegration(t *testing.T) {
 		dto := data.PostConversationDto{
 			UserIds: []int64{1, 2},
 		}
-		_, err := model.Insert(dto)
+		_, err := model.Insert(dto, nil)
 		tester.AssertNoError(t, err)
 	})
 
+	t.Run("it returns existing direct conversation on duplicate insert", func(t *testing.T) {
+		model := data.NewPsqlConversationModel(db)
+		cvs, err := model.Insert(data.PostConversationDto{UserIds: []int64{1, 5}}, nil)
+		tester.AssertNoError(t, err)
+		got, err := model.Insert(data.PostConversationDto{UserIds: []int64{5, 1}}, nil)
+		tester.AssertValue(t, err, data.ErrDuplicateConversation, "Expected duplicate conversation error")
+		tester.AssertValue(t, got.Id, cvs.Id, "Expected existing conversation id")
+	})
+
+	t.Run("it doesn't leave a conversation if users insert fails", func(t *testing.T) {
+		model := data.NewPsqlConversationModel(db)
+		_, err := model.Insert(data.PostConversationDto{UserIds: []int64{1, 99}}, nil)
+		tester.AssertError(t, err)
+		conversations, err := model.GetAllByUserId(1)
+		tester.AssertNoError(t, err)
+		for _, conversation := range conversations {
+			if len(conversation.Users) < 2 {
+				t.Fatalf("Expected no partially created conversations, got %v", conversation)
+			}
+		}
+	})
+
 	t.Run("it gets conversations list for user id", func(t *testing.T) {
 		model := data.NewPsqlConversationModel(db)
 		userId := int64(1)
@@ -57,4 +80,109 @@ func TestConversationModelIntegration(t *testing.T) {
 			}
 		}
 	})
+	t.Run("it updates last read message and counts unread messages", func(t *testing.T) {
+		model := data.NewPsqlConversationModel(db)
+		messageModel := data.NewPsqlMessageModel(db)
+		cvs, err := model.Insert(data.PostConversationDto{UserIds: []int64{3, 4}}, nil)
+		tester.AssertNoError(t, err)
+		msg := data.Message{ConversationId: cvs.Id, SenderId: 4, Content: "unread"}
+		err = messageModel.Insert(&msg, nil)
+		tester.AssertNoError(t, err)
+		got := mustFindConversation(t, model, 3, cvs.Id)
+		tester.AssertValue(t, got.UnreadCount, 1, "Expected 1 unread message")
+		err = model.UpdateLastRead(data.ReadReceipt{ConversationId: cvs.Id, UserId: 3, MessageId: msg.Id}, nil)
+		tester.AssertNoError(t, err)
+		got = mustFindConversation(t, model, 3, cvs.Id)
+		tester.AssertValue(t, got.UnreadCount, 0, "Expected no unread messages")
+		tester.AssertValue(t, got.LastReadMessageId, msg.Id, "Expected message to be read")
+		// pointer doesn't move back
+		err = model.UpdateLastRead(data.ReadReceipt{ConversationId: cvs.Id, UserId: 3, MessageId: 0}, nil)
+		tester.AssertNoError(t, err)
+		got = mustFindConversation(t, model, 3, cvs.Id)
+		tester.AssertValue(t, got.LastReadMessageId, msg.Id, "Expected read pointer to stay")
+	})
+
+	t.Run("it doesn't update last read message if user is not in conversation", func(t *testing.T) {
+		model := data.NewPsqlConversationModel(db)
+		err := model.UpdateLastRead(data.ReadReceipt{ConversationId: 1, UserId: 3, MessageId: 0}, nil)
+		tester.AssertValue(t, err, data.ErrRecordNotFound, "Expected not found error")
+	})
+
+	t.Run("it updates settings of the user and sorts pinned conversations first", func(t *testing.T) {
+		model := data.NewPsqlConversationModel(db)
+		cvs, err := model.Insert(data.PostConversationDto{UserIds: []int64{2, 5}}, nil)
+		tester.AssertNoError(t, err)
+		mutedUntil := time.Now().Add(time.Hour)
+		err = model.UpdateSettings(cvs.Id, 2, data.ConversationSettings{Pinned: true, Archived: true, MutedUntil: &mutedUntil})
+		tester.AssertNoError(t, err)
+		conversations, err := model.GetAllByUserId(2)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, conversations[0].Id, cvs.Id, "Expected pinned conversation first")
+		tester.AssertValue(t, conversations[0].Archived, true, "Expected archived conversation")
+		tester.AssertValue(t, conversations[0].IsMuted(time.Now()), true, "Expected muted conversation")
+		muted, err := model.GetMutedUserIds(cvs.Id)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, fmt.Sprint(muted), fmt.Sprint([]int64{2}), "Expected muted user")
+		got := mustFindConversation(t, model, 5, cvs.Id)
+		tester.AssertValue(t, got.Pinned, false, "Expected settings to be personal")
+		err = model.UpdateSettings(cvs.Id, 3, data.ConversationSettings{Pinned: true})
+		tester.AssertValue(t, err, data.ErrRecordNotFound, "Expected not found error")
+	})
+
+	t.Run("it manages participants of a group conversation", func(t *testing.T) {
+		model := data.NewPsqlConversationModel(db)
+		cvs, err := model.Insert(data.PostConversationDto{UserIds: []int64{1, 2, 3}, Title: "group", OwnerId: 1}, nil)
+		tester.AssertNoError(t, err)
+		got, err := model.GetById(cvs.Id)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, got.Title, "group", "Expected title")
+		tester.AssertValue(t, got.Group, true, "Expected group conversation")
+		tester.AssertValue(t, got.RoleOf(1), data.RoleOwner, "Expected creator to be owner")
+		tester.AssertValue(t, got.RoleOf(2), data.RoleMember, "Expected member role")
+
+		err = model.AddParticipant(cvs.Id, data.Participant{UserId: 4, Role: data.RoleMember}, nil)
+		tester.AssertNoError(t, err)
+		err = model.AddParticipant(cvs.Id, data.Participant{UserId: 4, Role: data.RoleMember}, nil)
+		tester.AssertValue(t, err, data.ErrDuplicateParticipant, "Expected duplicate participant error")
+		err = model.Leave(cvs.Id, 3, nil)
+		tester.AssertNoError(t, err)
+		err = model.Leave(cvs.Id, 3, nil)
+		tester.AssertValue(t, err, data.ErrRecordNotFound, "Expected not found error")
+		err = model.UpdateParticipant(cvs.Id, data.Participant{UserId: 2, Role: data.RoleOwner})
+		tester.AssertNoError(t, err)
+
+		got, err = model.GetById(cvs.Id)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, got.RoleOf(2), data.RoleOwner, "Expected promoted owner")
+		tester.AssertValue(t, got.RoleOf(3), "", "Expected removed user")
+		tester.AssertValue(t, got.RoleOf(4), data.RoleMember, "Expected invited user")
+
+		got.Title = "renamed"
+		err = model.Update(&got, nil)
+		tester.AssertNoError(t, err)
+		got.Version--
+		err = model.Update(&got, nil)
+		tester.AssertValue(t, err, data.ErrEditConflict, "Expected edit conflict")
+
+		err = model.Leave(cvs.Id, 1, nil)
+		tester.AssertNoError(t, err)
+		err = model.Leave(cvs.Id, 2, nil)
+		tester.AssertNoError(t, err)
+		got, err = model.GetById(cvs.Id)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, got.RoleOf(4), data.RoleOwner, "Expected last member to become owner")
+	})
+}
+
+func mustFindConversation(t *testing.T, model data.ConversationModel, userId int64, conversationId int64) data.Conversation {
+	t.Helper()
+	conversations, err := model.GetAllByUserId(userId)
+	tester.AssertNoError(t, err)
+	for _, conversation := range conversations {
+		if conversation.Id == conversationId {
+			return conversation
+		}
+	}
+	t.Fatalf("Expected to find conversation %v of user %v", conversationId, userId)
+	return data.Conversation{}
 }
@@ -0,0 +1,27 @@
+package data
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// EventReplayLimit is the maximum number of events replayed on reconnect,
+	// clients reconnect again from the last received seq to get the rest
+	EventReplayLimit = 1000
+	// EventRetention is how long acknowledged events are kept for other connections of the user
+	EventRetention = 24 * time.Hour
+	// EventMaxAge is how long events are kept if they are never acknowledged,
+	// clients which were offline longer load their state again
+	EventMaxAge = 7 * 24 * time.Hour
+)
+
+// Event is a websocket event stored for the recipient,
+// Seq is increasing for every event of the user
+type Event struct {
+	UserId    int64           `json:"-"`
+	Seq       int64           `json:"seq"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"-"`
+}
@@ -0,0 +1,189 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type EventModel interface {
+	// Append stores the event for every user and returns seq of the event by user id
+	Append(userIds []int64, eventType string, payload []byte) (map[int64]int64, error)
+	Get(userId int64, seq int64) (Event, error)
+	GetAllSince(userId int64, seq int64, limit int) ([]Event, error)
+	Ack(userId int64, seq int64) error
+	// DeleteExpired deletes acknowledged events older than EventRetention and
+	// any events older than EventMaxAge, it returns the number of deleted events
+	DeleteExpired() (int64, error)
+}
+
+type PsqlEventModel struct {
+	db *sql.DB
+}
+
+func NewPsqlEventModel(db *sql.DB) *PsqlEventModel {
+	return &PsqlEventModel{db: db}
+}
+
+func (m PsqlEventModel) Append(userIds []int64, eventType string, payload []byte) (map[int64]int64, error) {
+	query := `
+		WITH seqs AS (
+			INSERT INTO event_sequences (user_id, last_seq)
+			SELECT unnest($1::bigint[]), 1
+			ON CONFLICT (user_id) DO UPDATE SET last_seq = event_sequences.last_seq + 1
+			RETURNING user_id, last_seq
+		)
+		INSERT INTO events (user_id, seq, type, payload)
+		SELECT user_id, last_seq, $2, $3 FROM seqs
+		RETURNING user_id, seq`
+
+	seqs := map[int64]int64{}
+	if len(userIds) == 0 {
+		return seqs, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, pq.Array(userIds), eventType, string(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userId, seq int64
+		if err := rows.Scan(&userId, &seq); err != nil {
+			return nil, err
+		}
+		seqs[userId] = seq
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return seqs, nil
+}
+
+func (m PsqlEventModel) Get(userId int64, seq int64) (Event, error) {
+	query := `
+		SELECT user_id, seq, type, payload, created_at
+		FROM events
+		WHERE user_id = $1 AND seq = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var event Event
+	var payload []byte
+	err := m.db.QueryRowContext(ctx, query, userId, seq).Scan(&event.UserId, &event.Seq, &event.Type, &payload, &event.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return Event{}, ErrRecordNotFound
+		default:
+			return Event{}, err
+		}
+	}
+	event.Payload = payload
+
+	return event, nil
+}
+
+// GetAllSince returns events of the user after the seq in the order they were sent
+func (m PsqlEventModel) GetAllSince(userId int64, seq int64, limit int) ([]Event, error) {
+	query := `
+		SELECT user_id, seq, type, payload, created_at
+		FROM events
+		WHERE user_id = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userId, seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		var payload []byte
+		if err := rows.Scan(&event.UserId, &event.Seq, &event.Type, &payload, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		event.Payload = payload
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Ack records that the user has received events up to the seq, acknowledged
+// events are deleted once they are older than EventRetention
+func (m PsqlEventModel) Ack(userId int64, seq int64) error {
+	query := `
+		UPDATE event_sequences
+		SET acked_seq = GREATEST(acked_seq, LEAST($2, last_seq))
+		WHERE user_id = $1
+		RETURNING acked_seq`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var acked int64
+	err = tx.QueryRowContext(ctx, query, userId, seq).Scan(&acked)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query = `
+		DELETE FROM events
+		WHERE user_id = $1 AND seq <= $2 AND created_at < $3`
+
+	_, err = tx.ExecContext(ctx, query, userId, acked, time.Now().Add(-EventRetention))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m PsqlEventModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM events
+		WHERE created_at < $2 OR (created_at < $1 AND seq <= (
+			SELECT acked_seq FROM event_sequences WHERE event_sequences.user_id = events.user_id
+		))`
+
+	// the sweep can delete many events, it gets more time than other queries
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := m.db.ExecContext(ctx, query, now.Add(-EventRetention), now.Add(-EventMaxAge))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,103 @@
+package data
+
+import (
+	"sync"
+	"time"
+)
+
+type StubEventModel struct {
+	mu     sync.Mutex
+	events map[int64][]Event
+	// last are seqs of the last events, events can be deleted
+	last  map[int64]int64
+	acked map[int64]int64
+}
+
+func NewStubEventModel() *StubEventModel {
+	return &StubEventModel{events: map[int64][]Event{}, last: map[int64]int64{}, acked: map[int64]int64{}}
+}
+
+func (s *StubEventModel) Append(userIds []int64, eventType string, payload []byte) (map[int64]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seqs := map[int64]int64{}
+	for _, userId := range userIds {
+		s.last[userId]++
+		seq := s.last[userId]
+		s.events[userId] = append(s.events[userId], Event{
+			UserId:    userId,
+			Seq:       seq,
+			Type:      eventType,
+			Payload:   append([]byte{}, payload...),
+			CreatedAt: time.Now(),
+		})
+		seqs[userId] = seq
+	}
+	return seqs, nil
+}
+
+func (s *StubEventModel) Get(userId int64, seq int64) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, event := range s.events[userId] {
+		if event.Seq == seq {
+			return event, nil
+		}
+	}
+	return Event{}, ErrRecordNotFound
+}
+
+func (s *StubEventModel) GetAllSince(userId int64, seq int64, limit int) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := []Event{}
+	for _, event := range s.events[userId] {
+		if event.Seq > seq && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s *StubEventModel) Ack(userId int64, seq int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last, ok := s.last[userId]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	if seq > last {
+		seq = last
+	}
+	if seq > s.acked[userId] {
+		s.acked[userId] = seq
+	}
+	return nil
+}
+
+func (s *StubEventModel) DeleteExpired() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	deleted := int64(0)
+	for userId, events := range s.events {
+		kept := []Event{}
+		for _, event := range events {
+			age := now.Sub(event.CreatedAt)
+			if age > EventMaxAge || (age > EventRetention && event.Seq <= s.acked[userId]) {
+				deleted++
+				continue
+			}
+			kept = append(kept, event)
+		}
+		s.events[userId] = kept
+	}
+	return deleted, nil
+}
+
+// Acked returns the last seq acknowledged by the user
+func (s *StubEventModel) Acked(userId int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.acked[userId]
+}
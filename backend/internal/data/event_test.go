@@ -0,0 +1,82 @@
+package data_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/database"
+	"github.com/vasiliiperfilev/cookie/internal/tester"
+)
+
+func TestEventModelIntegration(t *testing.T) {
+	dsn := fmt.Sprintf(
+		"postgres://%s:%s@localhost:%s/%s?sslmode=disable",
+		database.POSTGRES_USER,
+		database.POSTGRES_PASSWORD,
+		database.POSTGRES_PORT,
+		database.POSTGRES_DB,
+	)
+	cfg := database.Config{
+		MaxOpenConns: 25,
+		MaxIdleConns: 25,
+		MaxIdleTime:  "15m",
+		Dsn:          dsn,
+	}
+	db, err := database.OpenDB(cfg)
+	tester.AssertNoError(t, err)
+
+	t.Run("it numbers events of every user and returns them since the seq", func(t *testing.T) {
+		model := data.NewPsqlEventModel(db)
+		first, err := model.Append([]int64{1, 2}, "message", []byte(`{"content":"first"}`))
+		tester.AssertNoError(t, err)
+		second, err := model.Append([]int64{1}, "message", []byte(`{"content":"second"}`))
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, second[1], first[1]+1, "Expected increasing seq of the user")
+
+		events, err := model.GetAllSince(1, first[1], data.EventReplayLimit)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, len(events), 1, "Expected events after the seq")
+		tester.AssertValue(t, events[0].Seq, second[1], "Expected seq of the event")
+		tester.AssertValue(t, string(events[0].Payload), `{"content": "second"}`, "Expected stored payload")
+
+		event, err := model.Get(2, first[2])
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, string(event.Payload), `{"content": "first"}`, "Expected event of the seq")
+		_, err = model.Get(2, 0)
+		tester.AssertValue(t, err, data.ErrRecordNotFound, "Expected not found error")
+	})
+
+	t.Run("it acks events", func(t *testing.T) {
+		model := data.NewPsqlEventModel(db)
+		seqs, err := model.Append([]int64{3}, "read", []byte(`{}`))
+		tester.AssertNoError(t, err)
+		err = model.Ack(3, seqs[3])
+		tester.AssertNoError(t, err)
+		// recent events are kept for other connections
+		events, err := model.GetAllSince(3, 0, data.EventReplayLimit)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, events[len(events)-1].Seq, seqs[3], "Expected acked event to be kept")
+		err = model.Ack(99, 1)
+		tester.AssertValue(t, err, data.ErrRecordNotFound, "Expected not found error")
+	})
+
+	t.Run("it deletes expired events", func(t *testing.T) {
+		model := data.NewPsqlEventModel(db)
+		old, err := model.Append([]int64{4}, "read", []byte(`{}`))
+		tester.AssertNoError(t, err)
+		_, err = db.Exec(`UPDATE events SET created_at = $1 WHERE user_id = 4 AND seq = $2`, time.Now().Add(-data.EventMaxAge-time.Hour), old[4])
+		tester.AssertNoError(t, err)
+		recent, err := model.Append([]int64{4}, "read", []byte(`{}`))
+		tester.AssertNoError(t, err)
+
+		// events which were never acked expire after EventMaxAge
+		_, err = model.DeleteExpired()
+		tester.AssertNoError(t, err)
+		events, err := model.GetAllSince(4, 0, data.EventReplayLimit)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, len(events), 1, "Expected only the recent event")
+		tester.AssertValue(t, events[0].Seq, recent[4], "Expected recent event to be kept")
+	})
+}
@@ -9,6 +9,7 @@ type Item struct {
 	Size       float32 `json:"size"`
 	Name       string  `json:"name"`
 	ImageId    string  `json:"imageId"`
+	Version    int     `json:"-"`
 }
 
 type PostItemDto struct {
@@ -20,8 +20,10 @@ type ItemModel interface {
 	Insert(item *Item) error // TODO: use value instead of pointers
 	GetById(id int64) (Item, error)
 	GetAllBySupplierId(id int64) ([]Item, error)
-	Update(item Item) (Item, error)
-	Delete(id int64) error
+	// Update and Delete write the event built by newEvent to the outbox with the change,
+	// newEvent can be nil
+	Update(item Item, newEvent func(Item) DomainEvent) (Item, error)
+	Delete(id int64, newEvent func(Item) DomainEvent) error
 }
 
 type PsqlItemModel struct {
@@ -36,7 +38,7 @@ func (m PsqlItemModel) Insert(item *Item) error {
 	query := `
     INSERT INTO items(supplier_id, unit_id, size, name, image_url)
     VALUES ($1, $2, $3, $4, $5)
-    RETURNING item_id
+    RETURNING item_id, version
 	`
 
 	unitId, ok := ItemUnitsToId[item.Unit]
@@ -48,7 +50,7 @@ func (m PsqlItemModel) Insert(item *Item) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.db.QueryRowContext(ctx, query, args...).Scan(&item.Id)
+	err := m.db.QueryRowContext(ctx, query, args...).Scan(&item.Id, &item.Version)
 	if err != nil {
 		return err
 	}
@@ -61,7 +63,7 @@ func (m PsqlItemModel) GetById(id int64) (Item, error) {
 		return Item{}, ErrRecordNotFound
 	}
 	query := `
-		SELECT item_id, supplier_id, unit_id, size, name, image_url
+		SELECT item_id, supplier_id, unit_id, size, name, image_url, version
 		FROM items
 		WHERE item_id=$1
 	`
@@ -79,6 +81,7 @@ func (m PsqlItemModel) GetById(id int64) (Item, error) {
 		&item.Size,
 		&item.Name,
 		&item.ImageId,
+		&item.Version,
 	)
 
 	if err != nil {
@@ -99,7 +102,7 @@ func (m PsqlItemModel) GetAllBySupplierId(id int64) ([]Item, error) {
 		return nil, ErrRecordNotFound
 	}
 	query := `
-		SELECT item_id, supplier_id, unit_id, size, name, image_url
+		SELECT item_id, supplier_id, unit_id, size, name, image_url, version
 		FROM items
 		WHERE supplier_id=$1
 	`
@@ -130,6 +133,7 @@ func (m PsqlItemModel) GetAllBySupplierId(id int64) ([]Item, error) {
 			&item.Size,
 			&item.Name,
 			&item.ImageId,
+			&item.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -143,26 +147,49 @@ func (m PsqlItemModel) GetAllBySupplierId(id int64) ([]Item, error) {
 	return items, nil
 }
 
-func (m PsqlItemModel) Update(item Item) (Item, error) {
+func (m PsqlItemModel) Update(item Item, newEvent func(Item) DomainEvent) (Item, error) {
 	if item.Id < 1 {
 		return Item{}, ErrRecordNotFound
 	}
 	query := `
 		UPDATE items
-		SET unit_id = $1, size = $2, name = $3, image_url = $4
-		WHERE item_id = $5
+		SET unit_id = $1, size = $2, name = $3, image_url = $4, version = version + 1
+		WHERE item_id = $5 AND version = $6
+		RETURNING version
 	`
 	unitId, ok := ItemUnitsToId[item.Unit]
 	if !ok {
 		return Item{}, ErrUnprocessableEntity
 	}
 
-	args := []any{unitId, item.Size, item.Name, item.ImageId, item.Id}
+	args := []any{unitId, item.Size, item.Name, item.ImageId, item.Id, item.Version}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.db.QueryContext(ctx, query, args...)
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Item{}, err
+	}
+	defer tx.Rollback()
+
+	// No rows means the item was changed (or deleted) after it was read
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&item.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return Item{}, ErrEditConflict
+		default:
+			return Item{}, err
+		}
+	}
+
+	err = insertDomainEvent(tx, eventOf(newEvent, item))
+	if err != nil {
+		return Item{}, err
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return Item{}, err
 	}
@@ -170,28 +197,51 @@ func (m PsqlItemModel) Update(item Item) (Item, error) {
 	return item, nil
 }
 
-func (m PsqlItemModel) Delete(id int64) error {
+func (m PsqlItemModel) Delete(id int64, newEvent func(Item) DomainEvent) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
 			DELETE FROM items
-			WHERE item_id = $1`
+			WHERE item_id = $1
+			RETURNING item_id, supplier_id, unit_id, size, name, image_url, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	result, err := m.db.Exec(query, id)
+	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	// the deleted item is the payload of the event
+	var item Item
+	var unitId int64
+	err = tx.QueryRowContext(ctx, query, id).Scan(
+		&item.Id,
+		&item.SupplierId,
+		&unitId,
+		&item.Size,
+		&item.Name,
+		&item.ImageId,
+		&item.Version,
+	)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
 	}
+	item.Unit = IdToItemUnits[unitId]
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	err = insertDomainEvent(tx, eventOf(newEvent, item))
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
@@ -2,6 +2,7 @@ package data
 
 type StubItemModel struct {
 	items   map[int64]Item
+	outbox  *StubOutboxModel
 	idCount int64
 }
 
@@ -15,6 +16,11 @@ func NewStubItemModel(items []Item) *StubItemModel {
 	return &StubItemModel{items: itemMap, idCount: int64(idCount)}
 }
 
+// SetOutboxModel allows the stub to write domain events of items
+func (s *StubItemModel) SetOutboxModel(outbox *StubOutboxModel) {
+	s.outbox = outbox
+}
+
 func (s *StubItemModel) Insert(item *Item) error {
 	s.idCount++
 	item.Id = s.idCount
@@ -42,23 +48,24 @@ func (s *StubItemModel) GetAllBySupplierId(id int64) ([]Item, error) {
 	return result, nil
 }
 
-func (s *StubItemModel) Update(item Item) (Item, error) {
-	updated := false
-	for i, existingItem := range s.items {
-		if existingItem.Id == item.Id {
-			s.items[i] = item
-			updated = true
-		}
-	}
-	if !updated {
+func (s *StubItemModel) Update(item Item, newEvent func(Item) DomainEvent) (Item, error) {
+	existingItem, ok := s.items[item.Id]
+	if !ok {
 		return Item{}, ErrRecordNotFound
 	}
+	if existingItem.Version != item.Version {
+		return Item{}, ErrEditConflict
+	}
+	item.Version++
+	s.items[item.Id] = item
+	s.outbox.add(eventOf(newEvent, item))
 	return item, nil
 }
 
-func (s *StubItemModel) Delete(id int64) error {
-	if _, ok := s.items[id]; ok {
+func (s *StubItemModel) Delete(id int64, newEvent func(Item) DomainEvent) error {
+	if item, ok := s.items[id]; ok {
 		delete(s.items, id)
+		s.outbox.add(eventOf(newEvent, item))
 		return nil
 	}
 	return ErrRecordNotFound
@@ -75,14 +75,26 @@ func TestItemModelIntegration(t *testing.T) {
 		want.Name = "Juice"
 		want.Size = 2
 		want.ImageId = "test 2"
-		got, err := model.Update(want)
+		got, err := model.Update(want, nil)
 		tester.AssertNoError(t, err)
+		want.Version++
 		tester.AssertValue(t, got, want, "Expected same items array")
 	})
 
+	t.Run("it doesn't update item with stale version", func(t *testing.T) {
+		model := data.NewPsqlItemModel(db)
+		item := testData[1]
+		err := model.Insert(&item)
+		tester.AssertNoError(t, err)
+		_, err = model.Update(item, nil)
+		tester.AssertNoError(t, err)
+		_, err = model.Update(item, nil)
+		tester.AssertValue(t, err, data.ErrEditConflict, "Expected to have edit conflict error")
+	})
+
 	t.Run("it deletes item", func(t *testing.T) {
 		model := data.NewPsqlItemModel(db)
-		err := model.Delete(testData[0].Id)
+		err := model.Delete(testData[0].Id, nil)
 		tester.AssertNoError(t, err)
 		_, err = model.GetById(testData[0].Id)
 		tester.AssertValue(t, err, data.ErrRecordNotFound, "Expected to have not found error")
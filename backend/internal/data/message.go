@@ -1,6 +1,10 @@
 package data
 
-import "time"
+import (
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/validator"
+)
 
 type Message struct {
 	Id             int64     `json:"id"`
@@ -9,10 +13,90 @@ type Message struct {
 	SenderId       int64     `json:"senderId"`
 	PrevMessageId  int64     `json:"prevMessageId"`
 	CreatedAt      time.Time `json:"createdAt"`
+	// EditedAt is set when the author changed the content
+	EditedAt *time.Time `json:"editedAt,omitempty"`
+	// DeletedAt is set for tombstones, the row stays to keep prev_message_id chain valid
+	DeletedAt   *time.Time   `json:"deletedAt,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// MessageEdit is a previous version of message content
+type MessageEdit struct {
+	MessageId int64     `json:"messageId"`
+	Content   string    `json:"content"`
+	EditedAt  time.Time `json:"editedAt"`
 }
 
+// MessageEditWindow is the time after creation during which the author can edit or delete a message
+const MessageEditWindow = 15 * time.Minute
+
 type PostMessageDto struct {
-	ConversationId int64  `json:"conversationId"`
-	Content        string `json:"content"`
-	PrevMessageId  int64  `json:"prevMessageId"`
+	ConversationId int64   `json:"conversationId"`
+	Content        string  `json:"content"`
+	PrevMessageId  int64   `json:"prevMessageId"`
+	AttachmentIds  []int64 `json:"attachmentIds,omitempty"`
+}
+
+type PatchMessageDto struct {
+	Content string `json:"content"`
+}
+
+func ValidatePatchMessageDto(v *validator.Validator, dto PatchMessageDto) {
+	v.Check(dto.Content != "", "content", "must be provided")
+}
+
+const (
+	MessagePageDefaultLimit = 50
+	MessagePageMaxLimit     = 100
+)
+
+// MessagePage is a cursor into the conversation history ordered by creation time.
+// Before and After are message ids, zero means no cursor. Without cursors
+// the latest messages are returned.
+type MessagePage struct {
+	Before int64
+	After  int64
+	Limit  int
+}
+
+func ValidateMessagePage(v *validator.Validator, page MessagePage) {
+	v.Check(page.Before == 0 || page.After == 0, "before", "can't be used together with after")
+	v.Check(page.Before >= 0, "before", "must be a message id")
+	v.Check(page.After >= 0, "after", "must be a message id")
+	v.Check(page.Limit > 0 && page.Limit <= MessagePageMaxLimit, "limit", "must be between 1 and 100")
+}
+
+const (
+	MessageSearchDefaultPageSize = 20
+	MessageSearchMaxPageSize     = 100
+)
+
+// MessageSearch is a full-text query over messages of the user conversations,
+// ConversationId is optional and limits the search to a single conversation
+type MessageSearch struct {
+	Query          string
+	UserId         int64
+	ConversationId int64
+	Page           int
+	PageSize       int
+}
+
+// MessageSearchResult is a found message with matched words highlighted in the snippet
+type MessageSearchResult struct {
+	Message Message `json:"message"`
+	// Snippet is safe html, the content is escaped and only matched words are wrapped in SnippetStartSel and SnippetStopSel
+	Snippet string `json:"snippet"`
+}
+
+const (
+	SnippetStartSel = "<mark>"
+	SnippetStopSel  = "</mark>"
+)
+
+func ValidateMessageSearch(v *validator.Validator, search MessageSearch) {
+	v.Check(search.Query != "", "q", "must be provided")
+	v.Check(len(search.Query) <= 500, "q", "must not be more than 500 bytes long")
+	v.Check(search.ConversationId >= 0, "conversationId", "must be a conversation id")
+	v.Check(search.Page > 0 && search.Page <= 10_000, "page", "must be between 1 and 10000")
+	v.Check(search.PageSize > 0 && search.PageSize <= MessageSearchMaxPageSize, "pageSize", "must be between 1 and 100")
 }
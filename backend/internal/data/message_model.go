@@ -4,14 +4,23 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 )
 
 type MessageModel interface {
-	Insert(msg *Message) error // TODO: use value, not pointer
+	// Insert writes the event built by newEvent to the outbox with the message, newEvent can be nil
+	Insert(msg *Message, newEvent func(Message) DomainEvent) error // TODO: use value, not pointer
 	GetAllByConversationId(id int64) ([]Message, error)
+	GetPageByConversationId(id int64, page MessagePage) ([]Message, error)
 	GetById(id int64) (Message, error)
 	Update(msg Message) error
+	// Edit and Delete write the event built by newEvent to the outbox with the change, newEvent can be nil
+	Edit(msg *Message, newEvent func(Message) DomainEvent) error
+	// Delete returns the removed attachments, their files are left for the caller to delete
+	Delete(msg *Message, newEvent func(Message) DomainEvent) ([]Attachment, error)
+	GetEditsByMessageId(id int64) ([]MessageEdit, error)
+	Search(search MessageSearch) ([]MessageSearchResult, error)
 }
 
 type PsqlMessageModel struct {
@@ -22,7 +31,7 @@ func NewPsqlMessageModel(db *sql.DB) *PsqlMessageModel {
 	return &PsqlMessageModel{db: db}
 }
 
-func (m PsqlMessageModel) Insert(msg *Message) error {
+func (m PsqlMessageModel) Insert(msg *Message, newEvent func(Message) DomainEvent) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	tx, err := m.db.BeginTx(ctx, nil)
@@ -34,6 +43,14 @@ func (m PsqlMessageModel) Insert(msg *Message) error {
 	if err != nil {
 		return err
 	}
+	err = linkAttachments(tx, msg)
+	if err != nil {
+		return err
+	}
+	err = insertDomainEvent(tx, eventOf(newEvent, *msg))
+	if err != nil {
+		return err
+	}
 	err = tx.Commit()
 	if err != nil {
 		return err
@@ -59,7 +76,7 @@ func insertMessage(msg *Message, tx *sql.Tx) error {
 
 func (m PsqlMessageModel) GetAllByConversationId(id int64) ([]Message, error) {
 	query := `
-	    SELECT message_id, sender_id, conversation_id, prev_message_id, created_at, content
+	    SELECT message_id, sender_id, conversation_id, prev_message_id, created_at, content, edited_at, deleted_at
 	    FROM messages
 	    WHERE conversation_id = $1`
 
@@ -81,7 +98,7 @@ func (m PsqlMessageModel) GetAllByConversationId(id int64) ([]Message, error) {
 
 	for rows.Next() {
 		msg := Message{}
-		if err := rows.Scan(&msg.Id, &msg.SenderId, &msg.ConversationId, &msg.PrevMessageId, &msg.CreatedAt, &msg.Content); err != nil {
+		if err := rows.Scan(&msg.Id, &msg.SenderId, &msg.ConversationId, &msg.PrevMessageId, &msg.CreatedAt, &msg.Content, &msg.EditedAt, &msg.DeletedAt); err != nil {
 			return nil, err
 		}
 		messages = append(messages, msg)
@@ -89,13 +106,85 @@ func (m PsqlMessageModel) GetAllByConversationId(id int64) ([]Message, error) {
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	if err := loadAttachments(ctx, m.db, messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// GetPageByConversationId returns up to page.Limit messages around the cursor,
+// messages are always sorted from the oldest to the newest
+func (m PsqlMessageModel) GetPageByConversationId(id int64, page MessagePage) ([]Message, error) {
+	query := `
+	    SELECT message_id, sender_id, conversation_id, prev_message_id, created_at, content, edited_at, deleted_at
+	    FROM messages
+	    WHERE conversation_id = $1
+	    ORDER BY created_at DESC, message_id DESC
+	    LIMIT $2`
+	args := []any{id, page.Limit}
+	reverse := true
+
+	switch {
+	case page.Before > 0:
+		query = `
+	    SELECT message_id, sender_id, conversation_id, prev_message_id, created_at, content, edited_at, deleted_at
+	    FROM messages
+	    WHERE conversation_id = $1
+	        AND (created_at, message_id) < (SELECT created_at, message_id FROM messages WHERE message_id = $3)
+	    ORDER BY created_at DESC, message_id DESC
+	    LIMIT $2`
+		args = append(args, page.Before)
+	case page.After > 0:
+		query = `
+	    SELECT message_id, sender_id, conversation_id, prev_message_id, created_at, content, edited_at, deleted_at
+	    FROM messages
+	    WHERE conversation_id = $1
+	        AND (created_at, message_id) > (SELECT created_at, message_id FROM messages WHERE message_id = $3)
+	    ORDER BY created_at ASC, message_id ASC
+	    LIMIT $2`
+		args = append(args, page.After)
+		reverse = false
+	}
+
+	messages := make([]Message, 0, page.Limit)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		msg := Message{}
+		if err := rows.Scan(&msg.Id, &msg.SenderId, &msg.ConversationId, &msg.PrevMessageId, &msg.CreatedAt, &msg.Content, &msg.EditedAt, &msg.DeletedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadAttachments(ctx, m.db, messages); err != nil {
+		return nil, err
+	}
+
+	if reverse {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
 
 	return messages, nil
 }
 
 func (m PsqlMessageModel) GetById(id int64) (Message, error) {
 	query := `
-	    SELECT message_id, sender_id, conversation_id, prev_message_id, created_at, content
+	    SELECT message_id, sender_id, conversation_id, prev_message_id, created_at, content, edited_at, deleted_at
 	    FROM messages
 	    WHERE message_id = $1`
 
@@ -104,7 +193,7 @@ func (m PsqlMessageModel) GetById(id int64) (Message, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.db.QueryRowContext(ctx, query, id).Scan(&msg.Id, &msg.SenderId, &msg.ConversationId, &msg.PrevMessageId, &msg.CreatedAt, &msg.Content)
+	err := m.db.QueryRowContext(ctx, query, id).Scan(&msg.Id, &msg.SenderId, &msg.ConversationId, &msg.PrevMessageId, &msg.CreatedAt, &msg.Content, &msg.EditedAt, &msg.DeletedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -113,8 +202,12 @@ func (m PsqlMessageModel) GetById(id int64) (Message, error) {
 			return Message{}, err
 		}
 	}
+	messages := []Message{msg}
+	if err := loadAttachments(ctx, m.db, messages); err != nil {
+		return Message{}, err
+	}
 
-	return msg, nil
+	return messages[0], nil
 }
 
 func (m PsqlMessageModel) Update(msg Message) error {
@@ -139,3 +232,187 @@ func (m PsqlMessageModel) Update(msg Message) error {
 
 	return nil
 }
+
+// Edit saves the current content to the edit history and replaces it with msg.Content
+func (m PsqlMessageModel) Edit(msg *Message, newEvent func(Message) DomainEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO message_edits (message_id, content)
+		SELECT message_id, content FROM messages
+		WHERE message_id = $1 AND deleted_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, msg.Id)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	query = `
+		UPDATE messages
+		SET content = $1, edited_at = NOW()
+		WHERE message_id = $2
+		RETURNING edited_at`
+	err = tx.QueryRowContext(ctx, query, msg.Content, msg.Id).Scan(&msg.EditedAt)
+	if err != nil {
+		return err
+	}
+	err = insertDomainEvent(tx, eventOf(newEvent, *msg))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete turns the message into a tombstone, content, edit history and attachments are erased
+func (m PsqlMessageModel) Delete(msg *Message, newEvent func(Message) DomainEvent) ([]Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE messages
+		SET content = '', deleted_at = NOW()
+		WHERE message_id = $1 AND deleted_at IS NULL
+		RETURNING deleted_at`
+	err = tx.QueryRowContext(ctx, query, msg.Id).Scan(&msg.DeletedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	msg.Content = ""
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM message_edits WHERE message_id = $1`, msg.Id)
+	if err != nil {
+		return nil, err
+	}
+	query = `
+		DELETE FROM attachments
+		WHERE message_id = $1
+		RETURNING attachment_id, message_id, uploader_id, file_id, filename, mime_type, size, width, height, created_at`
+	rows, err := tx.QueryContext(ctx, query, msg.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	removed := []Attachment{}
+	for rows.Next() {
+		var attachment Attachment
+		if err := scanAttachment(rows, &attachment); err != nil {
+			return nil, err
+		}
+		removed = append(removed, attachment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	msg.Attachments = nil
+	err = insertDomainEvent(tx, eventOf(newEvent, *msg))
+	if err != nil {
+		return nil, err
+	}
+
+	return removed, tx.Commit()
+}
+
+func (m PsqlMessageModel) GetEditsByMessageId(id int64) ([]MessageEdit, error) {
+	query := `
+		SELECT message_id, content, edited_at
+		FROM message_edits
+		WHERE message_id = $1
+		ORDER BY edited_at, edit_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []MessageEdit{}
+	for rows.Next() {
+		var edit MessageEdit
+		if err := rows.Scan(&edit.MessageId, &edit.Content, &edit.EditedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return edits, nil
+}
+
+// Search finds messages in the conversations of search.UserId, results are sorted by relevance,
+// content is html escaped before it is highlighted so the snippet is safe html
+func (m PsqlMessageModel) Search(search MessageSearch) ([]MessageSearchResult, error) {
+	query := `
+		SELECT m.message_id, m.sender_id, m.conversation_id, m.prev_message_id, m.created_at, m.content, m.edited_at, m.deleted_at,
+			ts_headline('simple', replace(replace(replace(replace(replace(m.content::text,
+				'&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;'), q.query, $6)
+		FROM messages as m
+		JOIN conversations_users as c_u ON c_u.conversation_id = m.conversation_id AND c_u.user_id = $2
+		CROSS JOIN plainto_tsquery('simple', $1) as q(query)
+		WHERE to_tsvector('simple', m.content::text) @@ q.query
+			AND m.deleted_at IS NULL
+			AND ($3 = 0 OR m.conversation_id = $3)
+		ORDER BY ts_rank(to_tsvector('simple', m.content::text), q.query) DESC, m.created_at DESC, m.message_id DESC
+		LIMIT $4 OFFSET $5`
+
+	options := fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=20, MinWords=5, MaxFragments=2", SnippetStartSel, SnippetStopSel)
+	args := []any{search.Query, search.UserId, search.ConversationId, search.PageSize, (search.Page - 1) * search.PageSize, options}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []MessageSearchResult{}
+	messages := []Message{}
+	for rows.Next() {
+		var result MessageSearchResult
+		msg := &result.Message
+		if err := rows.Scan(&msg.Id, &msg.SenderId, &msg.ConversationId, &msg.PrevMessageId, &msg.CreatedAt, &msg.Content, &msg.EditedAt, &msg.DeletedAt, &result.Snippet); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+		messages = append(messages, result.Message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := loadAttachments(ctx, m.db, messages); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Message.Attachments = messages[i].Attachments
+	}
+
+	return results, nil
+}
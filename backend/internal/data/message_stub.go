@@ -1,12 +1,20 @@
 package data
 
 import (
+	"html"
+	"strings"
 	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
 )
 
 type StubMessageModel struct {
 	mu            sync.Mutex
 	conversations storage
+	edits         map[int64][]MessageEdit
+	attachments   *StubAttachmentModel
+	outbox        *StubOutboxModel
 }
 
 type storage map[int64]struct {
@@ -37,19 +45,38 @@ func NewStubMessageModel(conversations []Conversation, messages []Message) *Stub
 		}
 	}
 
-	return &StubMessageModel{conversations: msgStorage}
+	return &StubMessageModel{conversations: msgStorage, edits: map[int64][]MessageEdit{}}
+}
+
+// SetOutboxModel allows the stub to write domain events of messages
+func (s *StubMessageModel) SetOutboxModel(outbox *StubOutboxModel) {
+	s.outbox = outbox
+}
+
+// SetAttachmentModel allows the stub to send messages with attachments
+func (s *StubMessageModel) SetAttachmentModel(attachments *StubAttachmentModel) {
+	s.attachments = attachments
 }
 
-func (s *StubMessageModel) Insert(msg *Message) error {
+func (s *StubMessageModel) Insert(msg *Message, newEvent func(Message) DomainEvent) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if entry, ok := s.conversations[msg.ConversationId]; !ok {
 		return ErrRecordNotFound
 	} else {
+		msg.Id = entry.IdCount + 1
+		if len(msg.Attachments) > 0 {
+			if s.attachments == nil {
+				return ErrRecordNotFound
+			}
+			if err := s.attachments.link(msg); err != nil {
+				return err
+			}
+		}
 		entry.IdCount++
-		msg.Id = entry.IdCount
 		entry.Messages = append(entry.Messages, *msg)
 		s.conversations[msg.ConversationId] = entry
+		s.outbox.add(eventOf(newEvent, *msg))
 		return nil
 	}
 }
@@ -66,6 +93,53 @@ func (s *StubMessageModel) GetAllByConversationId(id int64) ([]Message, error) {
 	return result, nil
 }
 
+// GetPageByConversationId leaves out the sentinel message, in the database it doesn't belong to any conversation
+func (s *StubMessageModel) GetPageByConversationId(id int64, page MessagePage) ([]Message, error) {
+	all, _ := s.GetAllByConversationId(id)
+	messages := []Message{}
+	for _, msg := range all {
+		if msg.Id != 0 {
+			messages = append(messages, msg)
+		}
+	}
+	slices.SortFunc(messages, func(a, b Message) bool {
+		if a.CreatedAt.Equal(b.CreatedAt) {
+			return a.Id < b.Id
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+	cursor := func(msgId int64) int {
+		return slices.IndexFunc(messages, func(m Message) bool { return m.Id == msgId })
+	}
+	switch {
+	case page.Before > 0:
+		end := cursor(page.Before)
+		if end < 0 {
+			return []Message{}, nil
+		}
+		start := end - page.Limit
+		if start < 0 {
+			start = 0
+		}
+		messages = messages[start:end]
+	case page.After > 0:
+		start := cursor(page.After)
+		if start < 0 {
+			return []Message{}, nil
+		}
+		end := start + 1 + page.Limit
+		if end > len(messages) {
+			end = len(messages)
+		}
+		messages = messages[start+1 : end]
+	default:
+		if len(messages) > page.Limit {
+			messages = messages[len(messages)-page.Limit:]
+		}
+	}
+	return messages, nil
+}
+
 func (s *StubMessageModel) GetById(id int64) (Message, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -97,3 +171,119 @@ func (s *StubMessageModel) DeleteById(id int64) error {
 func (s *StubMessageModel) Update(msg Message) error {
 	return nil
 }
+
+func (s *StubMessageModel) Edit(msg *Message, newEvent func(Message) DomainEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.conversations[msg.ConversationId]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	i := slices.IndexFunc(entry.Messages, func(m Message) bool { return m.Id == msg.Id && m.DeletedAt == nil })
+	if i < 0 {
+		return ErrRecordNotFound
+	}
+	editedAt := time.Now()
+	s.edits[msg.Id] = append(s.edits[msg.Id], MessageEdit{MessageId: msg.Id, Content: entry.Messages[i].Content, EditedAt: editedAt})
+	entry.Messages[i].Content = msg.Content
+	entry.Messages[i].EditedAt = &editedAt
+	msg.EditedAt = &editedAt
+	s.outbox.add(eventOf(newEvent, *msg))
+	return nil
+}
+
+func (s *StubMessageModel) Delete(msg *Message, newEvent func(Message) DomainEvent) ([]Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.conversations[msg.ConversationId]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	i := slices.IndexFunc(entry.Messages, func(m Message) bool { return m.Id == msg.Id && m.DeletedAt == nil })
+	if i < 0 {
+		return nil, ErrRecordNotFound
+	}
+	deletedAt := time.Now()
+	entry.Messages[i].Content = ""
+	entry.Messages[i].DeletedAt = &deletedAt
+	entry.Messages[i].Attachments = nil
+	msg.Content = ""
+	msg.DeletedAt = &deletedAt
+	msg.Attachments = nil
+	delete(s.edits, msg.Id)
+	s.outbox.add(eventOf(newEvent, *msg))
+	if s.attachments == nil {
+		return []Attachment{}, nil
+	}
+	return s.attachments.deleteByMessageId(msg.Id), nil
+}
+
+func (s *StubMessageModel) GetEditsByMessageId(id int64) ([]MessageEdit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	edits := append([]MessageEdit{}, s.edits[id]...)
+	return edits, nil
+}
+
+func (s *StubMessageModel) Search(search MessageSearch) ([]MessageSearchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	words := strings.Fields(strings.ToLower(search.Query))
+	results := []MessageSearchResult{}
+	for conversationId, conversation := range s.conversations {
+		if search.ConversationId != 0 && conversationId != search.ConversationId {
+			continue
+		}
+		if !slices.ContainsFunc(conversation.Users, func(u User) bool { return u.Id == search.UserId }) {
+			continue
+		}
+		for _, msg := range conversation.Messages {
+			if msg.DeletedAt != nil || msg.Id == 0 {
+				continue
+			}
+			snippet, ok := highlight(msg.Content, words)
+			if ok {
+				results = append(results, MessageSearchResult{Message: msg, Snippet: snippet})
+			}
+		}
+	}
+	slices.SortFunc(results, func(a, b MessageSearchResult) bool {
+		if a.Message.ConversationId == b.Message.ConversationId {
+			return a.Message.Id > b.Message.Id
+		}
+		return a.Message.ConversationId < b.Message.ConversationId
+	})
+	start := (search.Page - 1) * search.PageSize
+	if start >= len(results) {
+		return []MessageSearchResult{}, nil
+	}
+	end := start + search.PageSize
+	if end > len(results) {
+		end = len(results)
+	}
+	return results[start:end], nil
+}
+
+// highlight escapes the content and wraps words which match any of the query words,
+// it reports whether all query words were found
+func highlight(content string, words []string) (string, bool) {
+	wanted := map[string]bool{}
+	for _, word := range words {
+		wanted[word] = false
+	}
+	fields := strings.Fields(content)
+	for i, field := range fields {
+		word := strings.ToLower(strings.Trim(field, ".,!?;:"))
+		fields[i] = html.EscapeString(field)
+		if _, ok := wanted[word]; ok {
+			wanted[word] = true
+			fields[i] = SnippetStartSel + fields[i] + SnippetStopSel
+		}
+	}
+	for _, found := range wanted {
+		if !found {
+			return "", false
+		}
+	}
+	return strings.Join(fields, " "), len(wanted) > 0
+}
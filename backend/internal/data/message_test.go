@@ -3,7 +3,9 @@ package data_test
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/vasiliiperfilev/cookie/internal/data"
 	"github.com/vasiliiperfilev/cookie/internal/database"
@@ -35,7 +37,7 @@ func TestMessageModelIntegration(t *testing.T) {
 			Content:        "test",
 			PrevMessageId:  0,
 		}
-		err := messageModel.Insert(&msg)
+		err := messageModel.Insert(&msg, nil)
 		tester.AssertError(t, err)
 		tester.AssertValue(t, err.Error(), `pq: insert or update on table "messages" violates foreign key constraint "fk_conversation_id"`, "expected no users error")
 	})
@@ -51,7 +53,7 @@ func TestMessageModelIntegration(t *testing.T) {
 			Content:        "test",
 			PrevMessageId:  0,
 		}
-		err := messageModel.Insert(&msg)
+		err := messageModel.Insert(&msg, nil)
 		tester.AssertNoError(t, err)
 	})
 
@@ -62,7 +64,7 @@ func TestMessageModelIntegration(t *testing.T) {
 			Content:        "test get",
 			PrevMessageId:  0,
 		}
-		err := messageModel.Insert(&want)
+		err := messageModel.Insert(&want, nil)
 		tester.AssertNoError(t, err)
 		messages, err := messageModel.GetAllByConversationId(int64(0))
 		tester.AssertNoError(t, err)
@@ -94,4 +96,99 @@ func TestMessageModelIntegration(t *testing.T) {
 		tester.AssertValue(t, got.Content, want.Content, "Id must be the same")
 		tester.AssertValue(t, got.PrevMessageId, want.PrevMessageId, "Id must be the same")
 	})
+
+	t.Run("it gets pages of messages around a cursor", func(t *testing.T) {
+		ids := []int64{}
+		for i := 0; i < 3; i++ {
+			msg := data.Message{ConversationId: 0, SenderId: 1, Content: "test page"}
+			err := messageModel.Insert(&msg, nil)
+			tester.AssertNoError(t, err)
+			ids = append(ids, msg.Id)
+		}
+
+		latest, err := messageModel.GetPageByConversationId(0, data.MessagePage{Limit: 2})
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, messageIds(latest), ids[1:], "latest page must be in ascending order")
+
+		before, err := messageModel.GetPageByConversationId(0, data.MessagePage{Before: ids[2], Limit: 1})
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, messageIds(before), ids[1:2], "page before cursor")
+
+		after, err := messageModel.GetPageByConversationId(0, data.MessagePage{After: ids[0], Limit: 5})
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, messageIds(after), ids[1:], "page after cursor")
+	})
+
+	t.Run("it edits a message and keeps edit history", func(t *testing.T) {
+		msg := data.Message{ConversationId: 0, SenderId: 1, Content: "before edit"}
+		err := messageModel.Insert(&msg, nil)
+		tester.AssertNoError(t, err)
+		msg.Content = "after edit"
+		err = messageModel.Edit(&msg, nil)
+		tester.AssertNoError(t, err)
+		got, err := messageModel.GetById(msg.Id)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, got.Content, "after edit", "content must be updated")
+		if got.EditedAt == nil {
+			t.Fatal("Expected message to be marked as edited")
+		}
+		edits, err := messageModel.GetEditsByMessageId(msg.Id)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, len(edits), 1, "expected one edit")
+		tester.AssertValue(t, edits[0].Content, "before edit", "expected previous content")
+	})
+
+	t.Run("it deletes a message leaving a tombstone", func(t *testing.T) {
+		msg := data.Message{ConversationId: 0, SenderId: 1, Content: "to delete"}
+		err := messageModel.Insert(&msg, nil)
+		tester.AssertNoError(t, err)
+		removed, err := messageModel.Delete(&msg, nil)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, len(removed), 0, "message had no attachments")
+		got, err := messageModel.GetById(msg.Id)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, got.Content, "", "content must be erased")
+		if got.DeletedAt == nil {
+			t.Fatal("Expected message to be a tombstone")
+		}
+		err = messageModel.Edit(&msg, nil)
+		tester.AssertValue(t, err, data.ErrRecordNotFound, "tombstone can't be edited")
+		_, err = messageModel.Delete(&msg, nil)
+		tester.AssertValue(t, err, data.ErrRecordNotFound, "tombstone can't be deleted again")
+	})
+
+	t.Run("it searches messages in user conversations", func(t *testing.T) {
+		conversationModel := data.NewPsqlConversationModel(db)
+		cvs, err := conversationModel.Insert(data.PostConversationDto{UserIds: []int64{2, 3}}, nil)
+		tester.AssertNoError(t, err)
+		word := fmt.Sprintf("delivery%d", time.Now().UnixNano())
+		msg := data.Message{ConversationId: cvs.Id, SenderId: 2, Content: "what about the <b>Friday</b> " + word}
+		err = messageModel.Insert(&msg, nil)
+		tester.AssertNoError(t, err)
+
+		search := data.MessageSearch{Query: "friday " + word, UserId: 2, Page: 1, PageSize: 10}
+		results, err := messageModel.Search(search)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, len(results), 1, "expected to find the message")
+		tester.AssertValue(t, results[0].Message.Id, msg.Id, "expected to find the message")
+		if !strings.Contains(results[0].Snippet, data.SnippetStartSel+word+data.SnippetStopSel) {
+			t.Errorf("Expected highlighted snippet, got %v", results[0].Snippet)
+		}
+		if strings.Contains(results[0].Snippet, "<b>") {
+			t.Errorf("Expected escaped snippet, got %v", results[0].Snippet)
+		}
+
+		search.UserId = 1
+		results, err = messageModel.Search(search)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, len(results), 0, "expected not to find messages of other conversations")
+	})
+}
+
+func messageIds(messages []data.Message) []int64 {
+	ids := make([]int64, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.Id)
+	}
+	return ids
 }
@@ -19,6 +19,12 @@ type Models struct {
 	Item         ItemModel
 	Permission   PermissionModel
 	Order        OrderModel
+	Attachment   AttachmentModel
+	Event        EventModel
+	Webhook      WebhookModel
+	Outbox       OutboxModel
+	Notification NotificationModel
+	Presence     PresenceModel
 }
 
 func NewModels(db *sql.DB) Models {
@@ -30,5 +36,11 @@ func NewModels(db *sql.DB) Models {
 		Item:         NewPsqlItemModel(db),
 		Permission:   NewPsqlPermissionModel(db),
 		Order:        NewPsqlOrderModel(db),
+		Attachment:   NewPsqlAttachmentModel(db),
+		Event:        NewPsqlEventModel(db),
+		Webhook:      NewPsqlWebhookModel(db),
+		Outbox:       NewPsqlOutboxModel(db),
+		Notification: NewPsqlNotificationModel(db),
+		Presence:     NewPsqlPresenceModel(db),
 	}
 }
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/validator"
+)
+
+// Types of notifications
+const (
+	NotificationOrderCreated   = "order_created"
+	NotificationOrderUpdated   = "order_updated"
+	NotificationUnreadMessages = "unread_messages"
+)
+
+// Modes of notifications, digest notifications are batched into one email a day
+const (
+	NotifyInstant = "instant"
+	NotifyDigest  = "digest"
+	NotifyOff     = "off"
+)
+
+// Statuses of notifications, dead notifications failed every attempt and aren't retried
+const (
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	NotificationDead    = "dead"
+)
+
+type NotificationPreferences struct {
+	UserId int64  `json:"-"`
+	Mode   string `json:"mode"`
+	// Orders are notifications of new orders and their state changes
+	Orders bool `json:"orders"`
+	// Messages are notifications of unread messages
+	Messages bool `json:"messages"`
+}
+
+// DefaultNotificationPreferences are preferences of users who haven't changed them
+func DefaultNotificationPreferences(userId int64) NotificationPreferences {
+	return NotificationPreferences{UserId: userId, Mode: NotifyInstant, Orders: true, Messages: true}
+}
+
+// Wants tells if the user gets notifications of the type
+func (p NotificationPreferences) Wants(notificationType string) bool {
+	if p.Mode == NotifyOff {
+		return false
+	}
+	if notificationType == NotificationUnreadMessages {
+		return p.Messages
+	}
+	return p.Orders
+}
+
+type Notification struct {
+	Id        int64           `json:"id"`
+	UserId    int64           `json:"userId"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	Digest    bool            `json:"digest"`
+	Status    string          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	CreatedAt time.Time       `json:"createdAt"`
+	// User is the recipient, it is loaded for the notification worker
+	User User `json:"-"`
+}
+
+// UnreadMessages is the payload of unread messages notifications
+type UnreadMessages struct {
+	ConversationId int64 `json:"conversationId"`
+	UnreadCount    int   `json:"unreadCount"`
+	LastMessageId  int64 `json:"lastMessageId"`
+}
+
+func ValidateNotificationPreferences(v *validator.Validator, preferences NotificationPreferences) {
+	v.Check(validator.PermittedValue(preferences.Mode, NotifyInstant, NotifyDigest, NotifyOff), "mode", "must be instant, digest or off")
+}
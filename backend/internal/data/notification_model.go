@@ -0,0 +1,260 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/exp/slices"
+)
+
+type NotificationModel interface {
+	// GetPreferences returns the default preferences of users who haven't changed them
+	GetPreferences(userId int64) (NotificationPreferences, error)
+	UpdatePreferences(preferences NotificationPreferences) error
+	// Enqueue adds the notification for the users who want notifications of its type
+	Enqueue(userIds []int64, notificationType string, payload []byte) error
+	// EnqueueUnreadMessages adds notifications of messages which are unread for longer than
+	// the duration, every message is notified about once. One worker runs it per lease,
+	// the others skip it until the lease ends.
+	EnqueueUnreadMessages(olderThan time.Duration, lease time.Duration) error
+	// ClaimPending returns the oldest pending instant notifications with their users and postpones them
+	// by the lease, so that other workers don't send them meanwhile
+	ClaimPending(limit int, lease time.Duration) ([]Notification, error)
+	// ClaimDigests is ClaimPending of digest notifications, it claims every pending
+	// digest notification of at most the limit of users, so that a user gets one digest
+	ClaimDigests(users int, lease time.Duration) ([]Notification, error)
+	MarkSent(ids []int64) error
+	// MarkFailed counts a failed attempt, notifications are retried after the delay
+	// until they failed the attempts and are dead
+	MarkFailed(ids []int64, attempts int, retryAfter time.Duration) error
+}
+
+type PsqlNotificationModel struct {
+	db *sql.DB
+}
+
+func NewPsqlNotificationModel(db *sql.DB) *PsqlNotificationModel {
+	return &PsqlNotificationModel{db: db}
+}
+
+func (m PsqlNotificationModel) GetPreferences(userId int64) (NotificationPreferences, error) {
+	query := `
+		SELECT mode, orders, messages
+		FROM notification_preferences
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	preferences := NotificationPreferences{UserId: userId}
+	err := m.db.QueryRowContext(ctx, query, userId).Scan(&preferences.Mode, &preferences.Orders, &preferences.Messages)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return DefaultNotificationPreferences(userId), nil
+		default:
+			return NotificationPreferences{}, err
+		}
+	}
+
+	return preferences, nil
+}
+
+func (m PsqlNotificationModel) UpdatePreferences(preferences NotificationPreferences) error {
+	query := `
+		INSERT INTO notification_preferences(user_id, mode, orders, messages)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET mode = EXCLUDED.mode, orders = EXCLUDED.orders, messages = EXCLUDED.messages`
+
+	args := []any{preferences.UserId, preferences.Mode, preferences.Orders, preferences.Messages}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (m PsqlNotificationModel) Enqueue(userIds []int64, notificationType string, payload []byte) error {
+	query := `
+		INSERT INTO notifications(user_id, type, payload, digest)
+		SELECT u.user_id, $2, $3, COALESCE(np.mode, 'instant') = 'digest'
+		FROM unnest($1::bigint[]) AS u(user_id)
+			LEFT JOIN notification_preferences AS np ON np.user_id = u.user_id
+		WHERE COALESCE(np.mode, 'instant') <> 'off'
+			AND CASE WHEN $2 = 'unread_messages' THEN COALESCE(np.messages, true) ELSE COALESCE(np.orders, true) END`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query, pq.Array(userIds), notificationType, payload)
+	return err
+}
+
+func (m PsqlNotificationModel) EnqueueUnreadMessages(olderThan time.Duration, lease time.Duration) error {
+	query := `
+		INSERT INTO job_leases (name, leased_until)
+		VALUES ('unread_messages', NOW() + make_interval(secs => $1))
+		ON CONFLICT (name) DO UPDATE
+		SET leased_until = EXCLUDED.leased_until
+		WHERE job_leases.leased_until <= NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, query, lease.Seconds())
+	if err != nil {
+		return err
+	}
+	leased, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// another worker holds the lease
+	if leased == 0 {
+		return nil
+	}
+
+	query = `
+		WITH unread AS (
+			SELECT cu.conversation_id, cu.user_id, COUNT(*) AS unread_count, MAX(m.message_id) AS last_message_id
+			FROM conversations_users AS cu
+				INNER JOIN messages AS m ON m.conversation_id = cu.conversation_id
+					AND m.sender_id <> cu.user_id
+					AND m.message_id > GREATEST(cu.last_read_message_id, cu.last_notified_message_id)
+					AND m.deleted_at IS NULL
+			GROUP BY cu.conversation_id, cu.user_id
+			HAVING MIN(m.created_at) <= NOW() - make_interval(secs => $1)
+		), notified AS (
+			UPDATE conversations_users AS cu
+			SET last_notified_message_id = unread.last_message_id
+			FROM unread
+			WHERE cu.conversation_id = unread.conversation_id AND cu.user_id = unread.user_id
+		)
+		INSERT INTO notifications(user_id, type, payload, digest)
+		SELECT unread.user_id, 'unread_messages', json_build_object(
+				'conversationId', unread.conversation_id,
+				'unreadCount', unread.unread_count,
+				'lastMessageId', unread.last_message_id
+			), COALESCE(np.mode, 'instant') = 'digest'
+		FROM unread
+			LEFT JOIN notification_preferences AS np ON np.user_id = unread.user_id
+		WHERE COALESCE(np.mode, 'instant') <> 'off' AND COALESCE(np.messages, true)`
+
+	_, err = m.db.ExecContext(ctx, query, olderThan.Seconds())
+	return err
+}
+
+func (m PsqlNotificationModel) ClaimPending(limit int, lease time.Duration) ([]Notification, error) {
+	query := `
+		UPDATE notifications AS n
+		SET claimed_until = NOW() + make_interval(secs => $2)
+		FROM users AS u
+		WHERE n.user_id = u.user_id AND n.notification_id IN (
+			SELECT notification_id
+			FROM notifications
+			WHERE status = 'pending' AND NOT digest AND claimed_until <= NOW()
+			ORDER BY notification_id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING n.notification_id, n.user_id, n.type, n.payload, n.digest, n.status, n.attempts, n.created_at, u.email, u.name`
+
+	return m.claim(query, limit, lease)
+}
+
+func (m PsqlNotificationModel) ClaimDigests(users int, lease time.Duration) ([]Notification, error) {
+	query := `
+		UPDATE notifications AS n
+		SET claimed_until = NOW() + make_interval(secs => $2)
+		FROM users AS u
+		WHERE n.user_id = u.user_id AND n.notification_id IN (
+			SELECT notification_id
+			FROM notifications
+			WHERE status = 'pending' AND digest AND claimed_until <= NOW() AND user_id IN (
+				SELECT user_id
+				FROM notifications
+				WHERE status = 'pending' AND digest AND claimed_until <= NOW()
+				GROUP BY user_id
+				ORDER BY MIN(notification_id)
+				LIMIT $1
+			)
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING n.notification_id, n.user_id, n.type, n.payload, n.digest, n.status, n.attempts, n.created_at, u.email, u.name`
+
+	return m.claim(query, users, lease)
+}
+
+func (m PsqlNotificationModel) claim(query string, limit int, lease time.Duration) ([]Notification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var notification Notification
+		var payload []byte
+		err := rows.Scan(
+			&notification.Id,
+			&notification.UserId,
+			&notification.Type,
+			&payload,
+			&notification.Digest,
+			&notification.Status,
+			&notification.Attempts,
+			&notification.CreatedAt,
+			&notification.User.Email,
+			&notification.User.Name,
+		)
+		if err != nil {
+			return nil, err
+		}
+		notification.Payload = payload
+		notification.User.Id = notification.UserId
+		notifications = append(notifications, notification)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(notifications, func(a, b Notification) bool { return a.Id < b.Id })
+
+	return notifications, nil
+}
+
+func (m PsqlNotificationModel) MarkSent(ids []int64) error {
+	query := `
+		UPDATE notifications
+		SET status = 'sent', sent_at = NOW()
+		WHERE notification_id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query, pq.Array(ids))
+	return err
+}
+
+func (m PsqlNotificationModel) MarkFailed(ids []int64, attempts int, retryAfter time.Duration) error {
+	query := `
+		UPDATE notifications
+		SET attempts = attempts + 1,
+			status = CASE WHEN attempts + 1 >= $2 THEN 'dead' ELSE status END,
+			claimed_until = NOW() + make_interval(secs => $3)
+		WHERE notification_id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query, pq.Array(ids), attempts, retryAfter.Seconds())
+	return err
+}
@@ -0,0 +1,204 @@
+package data
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+type StubNotificationModel struct {
+	mu            sync.Mutex
+	preferences   map[int64]NotificationPreferences
+	notifications []Notification
+	claims        map[int64]time.Time
+	notified      map[readKey]int64
+	// leasedUntil is the end of the lease of unread messages notifications
+	leasedUntil  time.Time
+	conversation *StubConversationModel
+	userModel    UserModel
+	idCount      int64
+}
+
+// NewStubNotificationModel finds unread messages in the conversations if they aren't nil
+func NewStubNotificationModel(userModel UserModel, conversation *StubConversationModel) *StubNotificationModel {
+	return &StubNotificationModel{
+		preferences:  map[int64]NotificationPreferences{},
+		claims:       map[int64]time.Time{},
+		notified:     map[readKey]int64{},
+		conversation: conversation,
+		userModel:    userModel,
+	}
+}
+
+func (s *StubNotificationModel) GetPreferences(userId int64) (NotificationPreferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getPreferences(userId), nil
+}
+
+func (s *StubNotificationModel) getPreferences(userId int64) NotificationPreferences {
+	if preferences, ok := s.preferences[userId]; ok {
+		return preferences
+	}
+	return DefaultNotificationPreferences(userId)
+}
+
+func (s *StubNotificationModel) UpdatePreferences(preferences NotificationPreferences) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.preferences[preferences.UserId] = preferences
+	return nil
+}
+
+func (s *StubNotificationModel) Enqueue(userIds []int64, notificationType string, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, userId := range userIds {
+		s.enqueue(userId, notificationType, payload)
+	}
+	return nil
+}
+
+func (s *StubNotificationModel) enqueue(userId int64, notificationType string, payload []byte) {
+	preferences := s.getPreferences(userId)
+	if !preferences.Wants(notificationType) {
+		return
+	}
+	s.idCount++
+	s.notifications = append(s.notifications, Notification{
+		Id:        s.idCount,
+		UserId:    userId,
+		Type:      notificationType,
+		Payload:   append([]byte{}, payload...),
+		Digest:    preferences.Mode == NotifyDigest,
+		Status:    NotificationPending,
+		CreatedAt: time.Now(),
+	})
+}
+
+func (s *StubNotificationModel) EnqueueUnreadMessages(olderThan time.Duration, lease time.Duration) error {
+	if s.conversation == nil || s.conversation.messageModel == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.leasedUntil.After(time.Now()) {
+		return nil
+	}
+	s.leasedUntil = time.Now().Add(lease)
+	s.conversation.mu.Lock()
+	defer s.conversation.mu.Unlock()
+	before := time.Now().Add(-olderThan)
+	for _, conversation := range s.conversation.conversations {
+		messages, err := s.conversation.messageModel.GetAllByConversationId(conversation.Id)
+		if err != nil {
+			return err
+		}
+		for _, user := range conversation.Users {
+			key := readKey{conversationId: conversation.Id, userId: user.Id}
+			from := s.conversation.lastRead[key]
+			if s.notified[key] > from {
+				from = s.notified[key]
+			}
+			unread := UnreadMessages{ConversationId: conversation.Id}
+			oldest := time.Now()
+			for _, msg := range messages {
+				if msg.Id > from && msg.SenderId != user.Id && msg.DeletedAt == nil {
+					unread.UnreadCount++
+					unread.LastMessageId = msg.Id
+					if msg.CreatedAt.Before(oldest) {
+						oldest = msg.CreatedAt
+					}
+				}
+			}
+			if unread.UnreadCount == 0 || oldest.After(before) {
+				continue
+			}
+			s.notified[key] = unread.LastMessageId
+			payload, _ := json.Marshal(unread)
+			s.enqueue(user.Id, NotificationUnreadMessages, payload)
+		}
+	}
+	return nil
+}
+
+func (s *StubNotificationModel) ClaimPending(limit int, lease time.Duration) ([]Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.claim(lease, func(notification Notification, claimed []Notification) bool {
+		return !notification.Digest && len(claimed) < limit
+	})
+}
+
+func (s *StubNotificationModel) ClaimDigests(users int, lease time.Duration) ([]Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userIds := []int64{}
+	return s.claim(lease, func(notification Notification, claimed []Notification) bool {
+		if !notification.Digest {
+			return false
+		}
+		if !slices.Contains(userIds, notification.UserId) {
+			if len(userIds) == users {
+				return false
+			}
+			userIds = append(userIds, notification.UserId)
+		}
+		return true
+	})
+}
+
+// claim claims pending notifications in the order they were queued while they are accepted
+func (s *StubNotificationModel) claim(lease time.Duration, accept func(Notification, []Notification) bool) ([]Notification, error) {
+	now := time.Now()
+	notifications := []Notification{}
+	for _, notification := range s.notifications {
+		if notification.Status != NotificationPending || s.claims[notification.Id].After(now) {
+			continue
+		}
+		if !accept(notification, notifications) {
+			continue
+		}
+		user, err := s.userModel.GetById(notification.UserId)
+		if err != nil {
+			return nil, err
+		}
+		s.claims[notification.Id] = now.Add(lease)
+		notification.User = user
+		notifications = append(notifications, notification)
+	}
+	return notifications, nil
+}
+
+func (s *StubNotificationModel) MarkSent(ids []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	notifications := []Notification{}
+	for _, notification := range s.notifications {
+		if slices.Contains(ids, notification.Id) {
+			delete(s.claims, notification.Id)
+			continue
+		}
+		notifications = append(notifications, notification)
+	}
+	s.notifications = notifications
+	return nil
+}
+
+func (s *StubNotificationModel) MarkFailed(ids []int64, attempts int, retryAfter time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, notification := range s.notifications {
+		if !slices.Contains(ids, notification.Id) {
+			continue
+		}
+		s.notifications[i].Attempts++
+		if s.notifications[i].Attempts >= attempts {
+			s.notifications[i].Status = NotificationDead
+		}
+		s.claims[notification.Id] = time.Now().Add(retryAfter)
+	}
+	return nil
+}
@@ -33,12 +33,14 @@ type Order struct {
 	Items     []ItemQuantity `json:"items"`
 	StateId   OrderStateId   `json:"stateId"`
 	Client    User           `json:"client"`
+	// Version is sent as ETag by REST, websocket clients send it back with update_order
+	Version int `json:"version"`
 }
 
 type PostOrderDto struct {
-	ClientId       int64
+	ClientId       int64          `json:"-"`
 	Items          []ItemQuantity `json:"items"`
-	ConversationId int64
+	ConversationId int64          `json:"conversationId"`
 }
 
 type PatchOrderDto struct {
@@ -59,10 +61,18 @@ const (
 )
 
 var OrderStateMessage = map[OrderStateId]string{
-	OrderStateCreated:   "created",
-	OrderStateAccepted:  "accepted",
-	OrderStateDeclined:  "declined",
-	OrderStateFulfilled: "fulfilled",
+	OrderStateCreated:              "created",
+	OrderStateAccepted:             "accepted",
+	OrderStateDeclined:             "declined",
+	OrderStateFulfilled:            "fulfilled",
+	OrderStateConfirmedFulfillment: "fulfillment confirmed",
+	OrderStateSupplierChanges:      "changed by supplier",
+	OrderStateClientChanges:        "changed by client",
+}
+
+// OrderStateContent is the content of the message of the order after its state is changed
+func OrderStateContent(order Order) string {
+	return fmt.Sprintf("New state of order with id %v: %v", order.Id, OrderStateMessage[order.StateId])
 }
 
 func ValidatePostOrderInput(v *validator.Validator, dto PostOrderDto) {
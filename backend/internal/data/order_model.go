@@ -12,10 +12,12 @@ import (
 )
 
 type OrderModel interface {
-	Insert(dto PostOrderDto) (Order, error)
+	// Insert and Update write the event built by newEvent to the outbox with the order,
+	// newEvent can be nil
+	Insert(dto PostOrderDto, newEvent func(Order) DomainEvent) (Order, error)
 	GetById(id int64) (Order, error)
 	GetAllByUserId(id int64) ([]Order, error)
-	Update(order Order) (Order, error)
+	Update(order Order, newEvent func(Order) DomainEvent) (Order, error)
 }
 
 type PsqlOrderModel struct {
@@ -26,7 +28,7 @@ func NewPsqlOrderModel(db *sql.DB) *PsqlOrderModel {
 	return &PsqlOrderModel{db: db}
 }
 
-func (m PsqlOrderModel) Insert(dto PostOrderDto) (Order, error) {
+func (m PsqlOrderModel) Insert(dto PostOrderDto, newEvent func(Order) DomainEvent) (Order, error) {
 	// ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	// defer cancel()
 
@@ -68,6 +70,11 @@ func (m PsqlOrderModel) Insert(dto PostOrderDto) (Order, error) {
 		return Order{}, err
 	}
 
+	err = insertDomainEvent(tx, eventOf(newEvent, order))
+	if err != nil {
+		return Order{}, err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return Order{}, err
@@ -81,7 +88,7 @@ func (m PsqlOrderModel) GetById(id int64) (Order, error) {
 		return Order{}, ErrRecordNotFound
 	}
 	query := `
-		SELECT o.order_id, o.message_id, o.created_at, o.updated_at, os.state_id, json_agg(json_build_object(
+		SELECT o.order_id, o.message_id, o.created_at, o.updated_at, o.version, os.state_id, json_agg(json_build_object(
 			'itemId', oi.item_id, 
 			'quantity', oi.quantity
 		)) as items
@@ -106,6 +113,7 @@ func (m PsqlOrderModel) GetById(id int64) (Order, error) {
 		&order.MessageId,
 		&order.CreatedAt,
 		&order.UpdatedAt,
+		&order.Version,
 		&order.StateId,
 		&items,
 	)
@@ -132,7 +140,7 @@ func (m PsqlOrderModel) GetAllByUserId(id int64) ([]Order, error) {
 		return []Order{}, ErrRecordNotFound
 	}
 	query := `
-		SELECT o.order_id, o.message_id, o.created_at, o.updated_at, os.state_id, json_agg(json_build_object(
+		SELECT o.order_id, o.message_id, o.created_at, o.updated_at, o.version, os.state_id, json_agg(json_build_object(
 			'itemId', oi.item_id, 
 			'quantity', oi.quantity
 		)) as items
@@ -176,6 +184,7 @@ func (m PsqlOrderModel) GetAllByUserId(id int64) ([]Order, error) {
 			&order.MessageId,
 			&order.CreatedAt,
 			&order.UpdatedAt,
+			&order.Version,
 			&order.StateId,
 			&items,
 		); err != nil {
@@ -194,7 +203,7 @@ func (m PsqlOrderModel) GetAllByUserId(id int64) ([]Order, error) {
 	return orders, nil
 }
 
-func (m PsqlOrderModel) Update(order Order) (Order, error) {
+func (m PsqlOrderModel) Update(order Order, newEvent func(Order) DomainEvent) (Order, error) {
 	// message for update and transactions and stuff
 	if order.Id < 1 {
 		return Order{}, ErrRecordNotFound
@@ -213,20 +222,26 @@ func (m PsqlOrderModel) Update(order Order) (Order, error) {
 
 	query := `
 		UPDATE orders
-		SET message_id = $1
-		WHERE order_id = $2
+		SET message_id = $1, version = version + 1
+		WHERE order_id = $2 AND version = $3
+		RETURNING version
 	`
 
-	args := []any{order.MessageId, order.Id}
+	args := []any{order.MessageId, order.Id, order.Version}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := txn.QueryContext(ctx, query, args...)
+	// No rows means the order was changed after it was read
+	err = txn.QueryRowContext(ctx, query, args...).Scan(&order.Version)
 	if err != nil {
-		return Order{}, err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return Order{}, ErrEditConflict
+		default:
+			return Order{}, err
+		}
 	}
-	rows.Close()
 	// update state if required
 	if order.StateId != prevOrder.StateId {
 		query = `
@@ -279,6 +294,22 @@ func (m PsqlOrderModel) Update(order Order) (Order, error) {
 		}
 	}
 
+	// the message of the order is changed with it, so the chat shows the new state
+	query = `
+		UPDATE messages
+		SET content = $1
+		WHERE message_id = $2
+	`
+	_, err = txn.ExecContext(ctx, query, OrderStateContent(order), order.MessageId)
+	if err != nil {
+		return Order{}, err
+	}
+
+	err = insertDomainEvent(txn, eventOf(newEvent, order))
+	if err != nil {
+		return Order{}, err
+	}
+
 	err = txn.Commit()
 	if err != nil {
 		return Order{}, err
@@ -327,9 +358,9 @@ func insertOrder(order *Order, tx *sql.Tx) error {
 	query := `
     INSERT INTO orders(message_id)
     VALUES ($1)
-    RETURNING order_id, created_at, updated_at
+    RETURNING order_id, created_at, updated_at, version
 	`
 
-	err := tx.QueryRow(query, order.MessageId).Scan(&order.Id, &order.CreatedAt, &order.UpdatedAt)
+	err := tx.QueryRow(query, order.MessageId).Scan(&order.Id, &order.CreatedAt, &order.UpdatedAt, &order.Version)
 	return err
 }
@@ -9,6 +9,7 @@ type StubOrderModel struct {
 	conversation *StubConversationModel
 	message      *StubMessageModel
 	item         *StubItemModel
+	outbox       *StubOutboxModel
 	idCount      int64
 }
 
@@ -26,7 +27,12 @@ func NewStubOrderModel(orders []Order, item *StubItemModel, conversation *StubCo
 	}
 }
 
-func (s *StubOrderModel) Insert(dto PostOrderDto) (Order, error) {
+// SetOutboxModel allows the stub to write domain events of orders
+func (s *StubOrderModel) SetOutboxModel(outbox *StubOutboxModel) {
+	s.outbox = outbox
+}
+
+func (s *StubOrderModel) Insert(dto PostOrderDto, newEvent func(Order) DomainEvent) (Order, error) {
 	for _, item := range dto.Items {
 		_, err := s.item.GetById(item.ItemId)
 		if err != nil {
@@ -38,16 +44,18 @@ func (s *StubOrderModel) Insert(dto PostOrderDto) (Order, error) {
 		SenderId:       dto.ClientId,
 		Content:        "Order created",
 	}
-	s.message.Insert(&msg)
+	s.message.Insert(&msg, nil)
 
 	s.idCount++
 	order := Order{
 		MessageId: msg.Id,
 		Items:     dto.Items,
 		StateId:   OrderStateCreated,
+		Version:   1,
 	}
 	order.Id = s.idCount
 	s.orders[order.Id] = order
+	s.outbox.add(eventOf(newEvent, order))
 	return order, nil
 }
 
@@ -74,17 +82,20 @@ func (s *StubOrderModel) GetAllByUserId(id int64) ([]Order, error) {
 	return result, nil
 }
 
-func (s *StubOrderModel) Update(order Order) (Order, error) {
+func (s *StubOrderModel) Update(order Order, newEvent func(Order) DomainEvent) (Order, error) {
 	for _, item := range order.Items {
 		_, err := s.item.GetById(item.ItemId)
 		if err != nil {
 			return Order{}, ErrUnprocessableEntity
 		}
 	}
-	if _, ok := s.orders[order.Id]; !ok {
+	if existingOrder, ok := s.orders[order.Id]; !ok {
 		return Order{}, ErrRecordNotFound
-	} else {
-		s.orders[order.Id] = order
+	} else if existingOrder.Version != order.Version {
+		return Order{}, ErrEditConflict
 	}
+	order.Version++
+	s.orders[order.Id] = order
+	s.outbox.add(eventOf(newEvent, order))
 	return order, nil
 }
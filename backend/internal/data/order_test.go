@@ -42,7 +42,7 @@ func TestOrderModelIntegration(t *testing.T) {
 				},
 			},
 		}
-		want, err := orderModel.Insert(dto)
+		want, err := orderModel.Insert(dto, nil)
 		tester.AssertNoError(t, err)
 		got, err := orderModel.GetById(want.Id)
 		tester.AssertNoError(t, err)
@@ -66,9 +66,9 @@ func TestOrderModelIntegration(t *testing.T) {
 			},
 		}
 		// insert 2 orders
-		want1, err := orderModel.Insert(dto)
+		want1, err := orderModel.Insert(dto, nil)
 		tester.AssertNoError(t, err)
-		want2, err := orderModel.Insert(dto)
+		want2, err := orderModel.Insert(dto, nil)
 		tester.AssertNoError(t, err)
 		want := []data.Order{want1, want2}
 		got, err := orderModel.GetAllByUserId(4)
@@ -94,7 +94,7 @@ func TestOrderModelIntegration(t *testing.T) {
 				},
 			},
 		}
-		order, err := orderModel.Insert(dto)
+		order, err := orderModel.Insert(dto, nil)
 		tester.AssertNoError(t, err)
 		order.Items = []data.ItemQuantity{
 			{
@@ -104,11 +104,15 @@ func TestOrderModelIntegration(t *testing.T) {
 		}
 		order.StateId = data.OrderStateClientChanges
 		time.Sleep(1 * time.Second)
-		want, err := orderModel.Update(order)
+		want, err := orderModel.Update(order, nil)
 		tester.AssertNoError(t, err)
+		order.Version++
 		tester.AssertValue(t, want, order, "Expected same item from update order")
 		got, err := orderModel.GetById(want.Id)
 		tester.AssertNoError(t, err)
 		tester.AssertValue(t, got, want, "Expected same item from get order")
+		msg, err := data.NewPsqlMessageModel(db).GetById(want.MessageId)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, msg.Content, data.OrderStateContent(want), "Expected message with the new state")
 	})
 }
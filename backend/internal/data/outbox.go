@@ -0,0 +1,42 @@
+package data
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Types of DomainEvent
+const (
+	DomainMessageCreated      = "message.created"
+	DomainMessageEdited       = "message.edited"
+	DomainMessageDeleted      = "message.deleted"
+	DomainConversationRead    = "conversation.read"
+	DomainConversationUpdated = "conversation.updated"
+	DomainOrderCreated        = "order.created"
+	DomainOrderUpdated        = "order.updated"
+	DomainItemUpdated         = "item.updated"
+	DomainItemDeleted         = "item.deleted"
+)
+
+// DomainEvent is a stored change, it is written to the outbox in the transaction
+// of the change no matter which transport the request came from
+type DomainEvent struct {
+	Id   int64  `json:"id"`
+	Type string `json:"type"`
+	// ConversationId is set for changes of entities which belong to a conversation
+	ConversationId int64 `json:"conversationId,omitempty"`
+	// UserId is the owner of changed items and the author of messages and order changes
+	UserId int64 `json:"userId,omitempty"`
+	// Payload is the changed entity
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"createdAt"`
+	// DispatchedTo are subscribers which already handled the event
+	DispatchedTo []string `json:"-"`
+	// Attempts is the number of times publishing the event failed
+	Attempts int `json:"-"`
+}
+
+func NewDomainEvent(eventType string, entity any) DomainEvent {
+	payload, _ := json.Marshal(entity)
+	return DomainEvent{Type: eventType, Payload: payload}
+}
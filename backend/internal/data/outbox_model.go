@@ -0,0 +1,139 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/exp/slices"
+)
+
+type OutboxModel interface {
+	// ClaimUndispatched returns the oldest undispatched events and postpones them
+	// by the lease, so that relays of other instances don't publish them meanwhile
+	ClaimUndispatched(limit int, lease time.Duration) ([]DomainEvent, error)
+	MarkDispatched(ids []int64) error
+	// MarkFailed stores the failed attempt with subscribers which handled the event, they
+	// don't get it again when it is published to the failed ones after retryAt
+	MarkFailed(event DomainEvent, lastError string, retryAt time.Time) error
+	// MarkDead stops publishing the event after it failed every attempt
+	MarkDead(event DomainEvent, lastError string) error
+}
+
+type PsqlOutboxModel struct {
+	db *sql.DB
+}
+
+func NewPsqlOutboxModel(db *sql.DB) *PsqlOutboxModel {
+	return &PsqlOutboxModel{db: db}
+}
+
+func (m PsqlOutboxModel) ClaimUndispatched(limit int, lease time.Duration) ([]DomainEvent, error) {
+	query := `
+		UPDATE outbox
+		SET claimed_until = NOW() + make_interval(secs => $2)
+		WHERE event_id IN (
+			SELECT event_id
+			FROM outbox
+			WHERE dispatched_at IS NULL AND dead_at IS NULL AND claimed_until <= NOW()
+			ORDER BY event_id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING event_id, event_type, conversation_id, user_id, payload, created_at, dispatched_to, attempts`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []DomainEvent{}
+	for rows.Next() {
+		var event DomainEvent
+		var payload []byte
+		err := rows.Scan(&event.Id, &event.Type, &event.ConversationId, &event.UserId, &payload, &event.CreatedAt, pq.Array(&event.DispatchedTo), &event.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		event.Payload = payload
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// events are published in the order of the changes
+	slices.SortFunc(events, func(a, b DomainEvent) bool { return a.Id < b.Id })
+
+	return events, nil
+}
+
+func (m PsqlOutboxModel) MarkDispatched(ids []int64) error {
+	query := `
+		UPDATE outbox
+		SET dispatched_at = NOW()
+		WHERE event_id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query, pq.Array(ids))
+	return err
+}
+
+func (m PsqlOutboxModel) MarkFailed(event DomainEvent, lastError string, retryAt time.Time) error {
+	query := `
+		UPDATE outbox
+		SET dispatched_to = $2, attempts = $3, last_error = $4, claimed_until = $5
+		WHERE event_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{event.Id, pq.Array(event.DispatchedTo), event.Attempts, lastError, retryAt}
+	_, err := m.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (m PsqlOutboxModel) MarkDead(event DomainEvent, lastError string) error {
+	query := `
+		UPDATE outbox
+		SET dispatched_to = $2, attempts = $3, last_error = $4, dead_at = NOW()
+		WHERE event_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{event.Id, pq.Array(event.DispatchedTo), event.Attempts, lastError}
+	_, err := m.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// insertDomainEvent writes the event of the change to the outbox in the transaction of the change,
+// changes which don't notify anyone have no event
+func insertDomainEvent(tx *sql.Tx, event *DomainEvent) error {
+	if event == nil {
+		return nil
+	}
+	query := `
+		INSERT INTO outbox(event_type, conversation_id, user_id, payload)
+		VALUES ($1, $2, $3, $4)
+		RETURNING event_id, created_at`
+
+	args := []any{event.Type, event.ConversationId, event.UserId, []byte(event.Payload)}
+
+	return tx.QueryRow(query, args...).Scan(&event.Id, &event.CreatedAt)
+}
+
+// eventOf builds the event of the written entity, writes without newEvent have no event
+func eventOf[T any](newEvent func(T) DomainEvent, entity T) *DomainEvent {
+	if newEvent == nil {
+		return nil
+	}
+	event := newEvent(entity)
+	return &event
+}
@@ -0,0 +1,101 @@
+package data
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+type StubOutboxModel struct {
+	mu     sync.Mutex
+	events []DomainEvent
+	claims map[int64]time.Time
+	// dead are events which failed every attempt
+	dead    []DomainEvent
+	idCount int64
+}
+
+func NewStubOutboxModel() *StubOutboxModel {
+	return &StubOutboxModel{claims: map[int64]time.Time{}}
+}
+
+// add is the stub of insertDomainEvent, stub models without the outbox don't store events
+func (s *StubOutboxModel) add(event *DomainEvent) {
+	if s == nil || event == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idCount++
+	event.Id = s.idCount
+	event.CreatedAt = time.Now()
+	s.events = append(s.events, *event)
+}
+
+func (s *StubOutboxModel) ClaimUndispatched(limit int, lease time.Duration) ([]DomainEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	events := []DomainEvent{}
+	for _, event := range s.events {
+		if len(events) == limit {
+			break
+		}
+		if s.claims[event.Id].After(now) {
+			continue
+		}
+		s.claims[event.Id] = now.Add(lease)
+		event.DispatchedTo = append([]string{}, event.DispatchedTo...)
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (s *StubOutboxModel) MarkDispatched(ids []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := []DomainEvent{}
+	for _, event := range s.events {
+		if slices.Contains(ids, event.Id) {
+			delete(s.claims, event.Id)
+			continue
+		}
+		events = append(events, event)
+	}
+	s.events = events
+	return nil
+}
+
+func (s *StubOutboxModel) MarkFailed(event DomainEvent, lastError string, retryAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.events, func(e DomainEvent) bool { return e.Id == event.Id })
+	if i < 0 {
+		return nil
+	}
+	s.events[i].DispatchedTo = append([]string{}, event.DispatchedTo...)
+	s.events[i].Attempts = event.Attempts
+	s.claims[event.Id] = retryAt
+	return nil
+}
+
+func (s *StubOutboxModel) MarkDead(event DomainEvent, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.events, func(e DomainEvent) bool { return e.Id == event.Id })
+	if i < 0 {
+		return nil
+	}
+	s.events = slices.Delete(s.events, i, i+1)
+	delete(s.claims, event.Id)
+	s.dead = append(s.dead, event)
+	return nil
+}
+
+// GetDead returns events which failed every attempt
+func (s *StubOutboxModel) GetDead() []DomainEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]DomainEvent{}, s.dead...)
+}
@@ -0,0 +1,115 @@
+package data_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/database"
+	"github.com/vasiliiperfilev/cookie/internal/tester"
+)
+
+func TestOutboxModelIntegration(t *testing.T) {
+	dsn := fmt.Sprintf(
+		"postgres://%s:%s@localhost:%s/%s?sslmode=disable",
+		database.POSTGRES_USER,
+		database.POSTGRES_PASSWORD,
+		database.POSTGRES_PORT,
+		database.POSTGRES_DB,
+	)
+	cfg := database.Config{
+		MaxOpenConns: 25,
+		MaxIdleConns: 25,
+		MaxIdleTime:  "15m",
+		Dsn:          dsn,
+	}
+	db, err := database.OpenDB(cfg)
+	tester.AssertNoError(t, err)
+
+	t.Run("it writes the event with the message and claims it until dispatched", func(t *testing.T) {
+		messageModel := data.NewPsqlMessageModel(db)
+		model := data.NewPsqlOutboxModel(db)
+		msg := data.Message{ConversationId: 0, SenderId: 1, Content: "outbox"}
+		err := messageModel.Insert(&msg, func(msg data.Message) data.DomainEvent {
+			event := data.NewDomainEvent(data.DomainMessageCreated, msg)
+			event.ConversationId = msg.ConversationId
+			return event
+		})
+		tester.AssertNoError(t, err)
+
+		events, err := model.ClaimUndispatched(100, time.Minute)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, events[len(events)-1].Type, data.DomainMessageCreated, "Expected event of the message")
+		claimed, err := model.ClaimUndispatched(100, time.Minute)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, len(claimed), 0, "Expected claimed events to be skipped")
+
+		err = model.MarkDispatched(data.Map(events, func(e data.DomainEvent) int64 { return e.Id }))
+		tester.AssertNoError(t, err)
+	})
+
+	t.Run("it doesn't write the event if the message isn't written", func(t *testing.T) {
+		messageModel := data.NewPsqlMessageModel(db)
+		model := data.NewPsqlOutboxModel(db)
+		msg := data.Message{ConversationId: 10, SenderId: 1, Content: "outbox"}
+		err := messageModel.Insert(&msg, func(msg data.Message) data.DomainEvent {
+			return data.NewDomainEvent(data.DomainMessageCreated, msg)
+		})
+		tester.AssertError(t, err)
+		events, err := model.ClaimUndispatched(100, time.Minute)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, len(events), 0, "Expected no event")
+	})
+
+	t.Run("it returns subscribers which handled the claimed event", func(t *testing.T) {
+		messageModel := data.NewPsqlMessageModel(db)
+		model := data.NewPsqlOutboxModel(db)
+		msg := data.Message{ConversationId: 0, SenderId: 1, Content: "partly dispatched"}
+		err := messageModel.Insert(&msg, func(msg data.Message) data.DomainEvent {
+			return data.NewDomainEvent(data.DomainMessageCreated, msg)
+		})
+		tester.AssertNoError(t, err)
+
+		events, err := model.ClaimUndispatched(100, 0)
+		tester.AssertNoError(t, err)
+		event := events[len(events)-1]
+		tester.AssertValue(t, len(event.DispatchedTo), 0, "Expected event without subscribers")
+		event.DispatchedTo = []string{"hub"}
+		event.Attempts = 1
+		err = model.MarkFailed(event, "webhooks: unavailable", time.Now())
+		tester.AssertNoError(t, err)
+
+		events, err = model.ClaimUndispatched(100, 0)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, events[len(events)-1].DispatchedTo, []string{"hub"}, "Expected subscribers which handled the event")
+		tester.AssertValue(t, events[len(events)-1].Attempts, 1, "Expected failed attempt")
+		err = model.MarkDispatched([]int64{event.Id})
+		tester.AssertNoError(t, err)
+	})
+
+	t.Run("it doesn't claim failed events before the retry or dead events", func(t *testing.T) {
+		messageModel := data.NewPsqlMessageModel(db)
+		model := data.NewPsqlOutboxModel(db)
+		for _, content := range []string{"retried", "dead"} {
+			msg := data.Message{ConversationId: 0, SenderId: 1, Content: content}
+			err := messageModel.Insert(&msg, func(msg data.Message) data.DomainEvent {
+				return data.NewDomainEvent(data.DomainMessageCreated, msg)
+			})
+			tester.AssertNoError(t, err)
+		}
+
+		events, err := model.ClaimUndispatched(100, 0)
+		tester.AssertNoError(t, err)
+		retried, dead := events[len(events)-2], events[len(events)-1]
+		retried.Attempts = 1
+		tester.AssertNoError(t, model.MarkFailed(retried, "hub: unavailable", time.Now().Add(time.Minute)))
+		dead.Attempts = 10
+		tester.AssertNoError(t, model.MarkDead(dead, "hub: unavailable"))
+
+		events, err = model.ClaimUndispatched(100, 0)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, len(events), 0, "Expected no events to claim")
+		tester.AssertNoError(t, model.MarkDispatched([]int64{retried.Id}))
+	})
+}
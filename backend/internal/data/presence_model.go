@@ -0,0 +1,154 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PresenceTTL is how long users stay online on an instance which stopped refreshing them
+const PresenceTTL = time.Minute
+
+// PresenceModel keeps chat presence of users shared by every API instance
+type PresenceModel interface {
+	// Connect marks the user online on the instance, it reports whether the user was offline on every other instance
+	Connect(instanceId string, userId int64) (bool, error)
+	// Disconnect marks the user offline on the instance, it reports whether the user is offline on every other instance
+	Disconnect(instanceId string, userId int64) (bool, error)
+	// Refresh keeps the users online on the instance, other users of the instance become offline
+	Refresh(instanceId string, userIds []int64) error
+	// GetByUserIds returns presence in the order of the ids, users who never connected are offline
+	GetByUserIds(userIds []int64) ([]Presence, error)
+}
+
+type PsqlPresenceModel struct {
+	db *sql.DB
+}
+
+func NewPsqlPresenceModel(db *sql.DB) *PsqlPresenceModel {
+	return &PsqlPresenceModel{db: db}
+}
+
+func (m PsqlPresenceModel) Connect(instanceId string, userId int64) (bool, error) {
+	return m.change(instanceId, userId, true)
+}
+
+func (m PsqlPresenceModel) Disconnect(instanceId string, userId int64) (bool, error) {
+	return m.change(instanceId, userId, false)
+}
+
+func (m PsqlPresenceModel) change(instanceId string, userId int64, online bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// changes of the user on different instances wait for each other, so that one of them
+	// sees the user online or offline everywhere
+	_, err = tx.ExecContext(ctx, `SELECT 1 FROM users WHERE user_id = $1 FOR NO KEY UPDATE`, userId)
+	if err != nil {
+		return false, err
+	}
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM presence
+			WHERE user_id = $1 AND instance_id <> $2 AND online AND seen_at > $3
+		)`
+	var elsewhere bool
+	err = tx.QueryRowContext(ctx, query, userId, instanceId, time.Now().Add(-PresenceTTL)).Scan(&elsewhere)
+	if err != nil {
+		return false, err
+	}
+	query = `
+		INSERT INTO presence (user_id, instance_id, online, seen_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (user_id, instance_id) DO UPDATE
+		SET online = EXCLUDED.online, seen_at = EXCLUDED.seen_at`
+	_, err = tx.ExecContext(ctx, query, userId, instanceId, online)
+	if err != nil {
+		return false, err
+	}
+
+	return !elsewhere, tx.Commit()
+}
+
+func (m PsqlPresenceModel) Refresh(instanceId string, userIds []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO presence (user_id, instance_id, online, seen_at)
+		SELECT u.user_id, $1, TRUE, NOW() FROM unnest($2::bigint[]) AS u(user_id)
+		ON CONFLICT (user_id, instance_id) DO UPDATE
+		SET online = TRUE, seen_at = EXCLUDED.seen_at`
+	_, err = tx.ExecContext(ctx, query, instanceId, pq.Array(userIds))
+	if err != nil {
+		return err
+	}
+	query = `
+		UPDATE presence
+		SET online = FALSE, seen_at = NOW()
+		WHERE instance_id = $1 AND online AND NOT user_id = ANY($2)`
+	_, err = tx.ExecContext(ctx, query, instanceId, pq.Array(userIds))
+	if err != nil {
+		return err
+	}
+	// expired rows are left only to keep last seen of users, instances get new ids on start
+	query = `
+		DELETE FROM presence AS p
+		WHERE p.seen_at < $1 AND EXISTS (
+			SELECT 1 FROM presence AS q WHERE q.user_id = p.user_id AND q.seen_at > p.seen_at
+		)`
+	_, err = tx.ExecContext(ctx, query, time.Now().Add(-PresenceTTL))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m PsqlPresenceModel) GetByUserIds(userIds []int64) ([]Presence, error) {
+	query := `
+		SELECT user_id, bool_or(online AND seen_at > $2), max(seen_at)
+		FROM presence
+		WHERE user_id = ANY($1)
+		GROUP BY user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, pq.Array(userIds), time.Now().Add(-PresenceTTL))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := map[int64]Presence{}
+	for rows.Next() {
+		var presence Presence
+		if err := rows.Scan(&presence.UserId, &presence.Online, &presence.LastSeen); err != nil {
+			return nil, err
+		}
+		found[presence.UserId] = presence
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return Map(userIds, func(id int64) Presence {
+		if presence, ok := found[id]; ok {
+			return presence
+		}
+		return Presence{UserId: id}
+	}), nil
+}
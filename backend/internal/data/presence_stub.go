@@ -0,0 +1,85 @@
+package data
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+type presenceKey struct {
+	instanceId string
+	userId     int64
+}
+
+type presenceEntry struct {
+	online bool
+	seenAt time.Time
+}
+
+type StubPresenceModel struct {
+	mu       sync.Mutex
+	presence map[presenceKey]presenceEntry
+}
+
+func NewStubPresenceModel() *StubPresenceModel {
+	return &StubPresenceModel{presence: map[presenceKey]presenceEntry{}}
+}
+
+func (s *StubPresenceModel) Connect(instanceId string, userId int64) (bool, error) {
+	return s.change(instanceId, userId, true), nil
+}
+
+func (s *StubPresenceModel) Disconnect(instanceId string, userId int64) (bool, error) {
+	return s.change(instanceId, userId, false), nil
+}
+
+func (s *StubPresenceModel) change(instanceId string, userId int64, online bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elsewhere := false
+	for key, entry := range s.presence {
+		if key.userId == userId && key.instanceId != instanceId && s.isOnline(entry) {
+			elsewhere = true
+		}
+	}
+	s.presence[presenceKey{instanceId: instanceId, userId: userId}] = presenceEntry{online: online, seenAt: time.Now()}
+	return !elsewhere
+}
+
+func (s *StubPresenceModel) Refresh(instanceId string, userIds []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, entry := range s.presence {
+		if key.instanceId == instanceId && entry.online && !slices.Contains(userIds, key.userId) {
+			s.presence[key] = presenceEntry{online: false, seenAt: now}
+		}
+	}
+	for _, userId := range userIds {
+		s.presence[presenceKey{instanceId: instanceId, userId: userId}] = presenceEntry{online: true, seenAt: now}
+	}
+	return nil
+}
+
+func (s *StubPresenceModel) GetByUserIds(userIds []int64) ([]Presence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Map(userIds, func(userId int64) Presence {
+		presence := Presence{UserId: userId}
+		for key, entry := range s.presence {
+			if key.userId != userId {
+				continue
+			}
+			presence.Online = presence.Online || s.isOnline(entry)
+			if entry.seenAt.After(presence.LastSeen) {
+				presence.LastSeen = entry.seenAt
+			}
+		}
+		return presence
+	}), nil
+}
+
+func (s *StubPresenceModel) isOnline(entry presenceEntry) bool {
+	return entry.online && time.Since(entry.seenAt) < PresenceTTL
+}
@@ -0,0 +1,56 @@
+package data_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/vasiliiperfilev/cookie/internal/data"
+	"github.com/vasiliiperfilev/cookie/internal/database"
+	"github.com/vasiliiperfilev/cookie/internal/tester"
+)
+
+func TestPresenceModelIntegration(t *testing.T) {
+	dsn := fmt.Sprintf(
+		"postgres://%s:%s@localhost:%s/%s?sslmode=disable",
+		database.POSTGRES_USER,
+		database.POSTGRES_PASSWORD,
+		database.POSTGRES_PORT,
+		database.POSTGRES_DB,
+	)
+	cfg := database.Config{
+		MaxOpenConns: 25,
+		MaxIdleConns: 25,
+		MaxIdleTime:  "15m",
+		Dsn:          dsn,
+	}
+	db, err := database.OpenDB(cfg)
+	tester.AssertNoError(t, err)
+
+	t.Run("it keeps users online while they are connected to any instance", func(t *testing.T) {
+		model := data.NewPsqlPresenceModel(db)
+		first, err := model.Connect("instance-1", 1)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, first, true, "Expected user to become online")
+		first, err = model.Connect("instance-2", 1)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, first, false, "Expected user to be online on other instance")
+
+		last, err := model.Disconnect("instance-1", 1)
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, last, false, "Expected user to stay online on other instance")
+		presence, err := model.GetByUserIds([]int64{1, 2})
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, presence[0].Online, true, "Expected user to be online")
+		tester.AssertValue(t, presence[1].UserId, int64(2), "Expected presence in order of ids")
+
+		// instance 2 lost the connection without disconnect
+		err = model.Refresh("instance-2", []int64{})
+		tester.AssertNoError(t, err)
+		presence, err = model.GetByUserIds([]int64{1})
+		tester.AssertNoError(t, err)
+		tester.AssertValue(t, presence[0].Online, false, "Expected user to be offline")
+		if presence[0].LastSeen.IsZero() {
+			t.Error("Expected to have last seen time")
+		}
+	})
+}
@@ -1,9 +1,11 @@
 package data
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
+	"strings"
 	"time"
 
 	"github.com/vasiliiperfilev/cookie/internal/validator"
@@ -11,21 +13,58 @@ import (
 
 const (
 	ScopeAuthentication = "authentication"
+	// ScopeWsTicket tokens are exchanged once for a websocket connection
+	// authenticated by their parent token
+	ScopeWsTicket = "ws-ticket"
+	// ScopeActivation tokens are emailed to new users to verify their address
+	ScopeActivation = "activation"
+	// ScopePasswordReset tokens are emailed to users who forgot their password
+	ScopePasswordReset = "password-reset"
 )
 
+// MaxUserAgentLength is the length user agents of sessions are cut to
+const MaxUserAgentLength = 255
+
 type Token struct {
+	Id        int64     `json:"-"`
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	UserId    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	// ParentHash is the token the token was issued for, it is deleted with the parent
+	ParentHash []byte    `json:"-"`
+	CreatedAt  time.Time `json:"-"`
+	// UserAgent is the device the authentication token was issued to
+	UserAgent string `json:"-"`
+}
+
+// Session is an authentication token as it is listed to its user
+type Session struct {
+	Id        int64     `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Expiry    time.Time `json:"expiry"`
+	UserAgent string    `json:"userAgent"`
+	// Current is the session of the request
+	Current bool `json:"current"`
+}
+
+func NewSession(token Token, current []byte) Session {
+	return Session{
+		Id:        token.Id,
+		CreatedAt: token.CreatedAt,
+		Expiry:    token.Expiry,
+		UserAgent: token.UserAgent,
+		Current:   bytes.Equal(token.Hash, current),
+	}
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (Token, error) {
 	token := Token{
-		UserId: userID,
-		Expiry: time.Now().Add(ttl),
-		Scope:  scope,
+		UserId:    userID,
+		Expiry:    time.Now().Add(ttl),
+		Scope:     scope,
+		CreatedAt: time.Now(),
 	}
 
 	randomBytes := make([]byte, 16)
@@ -40,10 +79,39 @@ func generateToken(userID int64, ttl time.Duration, scope string) (Token, error)
 	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
 
 	// sha256.Sum256() function returns an *array* of length 32, so to make it easier to
-	// work with we convert it to a slice using the [:] operator before storing it.
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+	// work with TokenHash converts it to a slice using the [:] operator before storing it.
+	token.Hash = TokenHash(token.Plaintext)
+
+	return token, nil
+}
+
+// TokenHash returns the hash tokens are stored by
+func TokenHash(tokenPlaintext string) []byte {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	return hash[:]
+}
 
+func generateSessionToken(userID int64, ttl time.Duration, userAgent string) (Token, error) {
+	token, err := generateToken(userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return Token{}, err
+	}
+	if len(userAgent) > MaxUserAgentLength {
+		userAgent = strings.ToValidUTF8(userAgent[:MaxUserAgentLength], "")
+	}
+	token.UserAgent = userAgent
+	return token, nil
+}
+
+func generateChildToken(parent Token, ttl time.Duration, scope string) (Token, error) {
+	token, err := generateToken(parent.UserId, ttl, scope)
+	if err != nil {
+		return Token{}, err
+	}
+	if parent.Expiry.Before(token.Expiry) {
+		token.Expiry = parent.Expiry
+	}
+	token.ParentHash = parent.Hash
 	return token, nil
 }
 
@@ -3,11 +3,24 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
 )
 
 type TokenModel interface {
 	New(userID int64, ttl time.Duration, scope string) (Token, error)
+	// NewSession issues an authentication token which is listed with the user agent of the device
+	NewSession(userID int64, ttl time.Duration, userAgent string) (Token, error)
+	// NewChild issues a token of the scope for the user of the parent, it expires no later than the parent
+	NewChild(parent Token, ttl time.Duration, scope string) (Token, error)
+	// Get returns the unexpired token by its hash
+	Get(scope string, hash []byte) (Token, error)
+	// GetAllForUser returns unexpired tokens of the user, the latest first
+	GetAllForUser(scope string, userID int64) ([]Token, error)
+	// Consume deletes the unexpired token and returns it, so it can be used only once
+	Consume(scope string, tokenPlaintext string) (Token, error)
+	// Delete deletes the token of the user by its id and returns it
+	Delete(scope string, userID int64, id int64) (Token, error)
 	DeleteAllForUser(scope string, userID int64) error
 }
 
@@ -25,22 +38,140 @@ func (m PsqlTokenModel) New(userID int64, ttl time.Duration, scope string) (Toke
 		return Token{}, err
 	}
 
-	err = m.insert(token)
+	err = m.insert(&token)
 	return token, err
 }
 
-func (m PsqlTokenModel) insert(token Token) error {
+func (m PsqlTokenModel) NewSession(userID int64, ttl time.Duration, userAgent string) (Token, error) {
+	token, err := generateSessionToken(userID, ttl, userAgent)
+	if err != nil {
+		return Token{}, err
+	}
+
+	err = m.insert(&token)
+	return token, err
+}
+
+func (m PsqlTokenModel) NewChild(parent Token, ttl time.Duration, scope string) (Token, error) {
+	token, err := generateChildToken(parent, ttl, scope)
+	if err != nil {
+		return Token{}, err
+	}
+
+	err = m.insert(&token)
+	return token, err
+}
+
+func (m PsqlTokenModel) insert(token *Token) error {
 	query := `
-        INSERT INTO tokens (hash, user_id, expiry, scope) 
-        VALUES ($1, $2, $3, $4)`
+        INSERT INTO tokens (hash, user_id, expiry, scope, parent_hash, created_at, user_agent) 
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING token_id`
 
-	args := []any{token.Hash, token.UserId, token.Expiry, token.Scope}
+	args := []any{token.Hash, token.UserId, token.Expiry, token.Scope, token.ParentHash, token.CreatedAt, token.UserAgent}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.db.ExecContext(ctx, query, args...)
-	return err
+	return m.db.QueryRowContext(ctx, query, args...).Scan(&token.Id)
+}
+
+func (m PsqlTokenModel) Get(scope string, hash []byte) (Token, error) {
+	query := `
+        SELECT token_id, hash, user_id, expiry, scope, parent_hash, created_at, user_agent
+        FROM tokens
+        WHERE hash = $1 AND scope = $2 AND expiry > $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var token Token
+	err := scanToken(m.db.QueryRowContext(ctx, query, hash, scope, time.Now()), &token)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return Token{}, ErrRecordNotFound
+		default:
+			return Token{}, err
+		}
+	}
+	return token, nil
+}
+
+func (m PsqlTokenModel) GetAllForUser(scope string, userID int64) ([]Token, error) {
+	query := `
+        SELECT token_id, hash, user_id, expiry, scope, parent_hash, created_at, user_agent
+        FROM tokens
+        WHERE scope = $1 AND user_id = $2 AND expiry > $3
+        ORDER BY created_at DESC, token_id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, scope, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []Token{}
+	for rows.Next() {
+		var token Token
+		err := scanToken(rows, &token)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (m PsqlTokenModel) Consume(scope string, tokenPlaintext string) (Token, error) {
+	// expired tokens are kept, the same as expired authentication tokens
+	query := `
+        DELETE FROM tokens
+        WHERE hash = $1 AND scope = $2 AND expiry > $3
+        RETURNING token_id, hash, user_id, expiry, scope, parent_hash, created_at, user_agent`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var token Token
+	err := scanToken(m.db.QueryRowContext(ctx, query, TokenHash(tokenPlaintext), scope, time.Now()), &token)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return Token{}, ErrRecordNotFound
+		default:
+			return Token{}, err
+		}
+	}
+	return token, nil
+}
+
+func (m PsqlTokenModel) Delete(scope string, userID int64, id int64) (Token, error) {
+	query := `
+        DELETE FROM tokens
+        WHERE token_id = $1 AND user_id = $2 AND scope = $3
+        RETURNING token_id, hash, user_id, expiry, scope, parent_hash, created_at, user_agent`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var token Token
+	err := scanToken(m.db.QueryRowContext(ctx, query, id, userID, scope), &token)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return Token{}, ErrRecordNotFound
+		default:
+			return Token{}, err
+		}
+	}
+	return token, nil
 }
 
 func (m PsqlTokenModel) DeleteAllForUser(scope string, userID int64) error {
@@ -54,3 +185,16 @@ func (m PsqlTokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.db.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+func scanToken(row rowScanner, token *Token) error {
+	return row.Scan(
+		&token.Id,
+		&token.Hash,
+		&token.UserId,
+		&token.Expiry,
+		&token.Scope,
+		&token.ParentHash,
+		&token.CreatedAt,
+		&token.UserAgent,
+	)
+}
@@ -1,15 +1,26 @@
 package data
 
 import (
+	"bytes"
+	"sync"
 	"time"
+
+	"golang.org/x/exp/slices"
 )
 
 type StubTokenModel struct {
-	tokens []Token
+	mu      sync.Mutex
+	tokens  []Token
+	idCount int64
 }
 
+// NewStubTokenModel sets ids of the tokens which don't have them
 func NewStubTokenModel(tokens []Token) *StubTokenModel {
-	return &StubTokenModel{tokens: tokens}
+	s := &StubTokenModel{}
+	for _, token := range tokens {
+		s.insert(&token)
+	}
+	return s
 }
 
 func (s *StubTokenModel) New(userID int64, ttl time.Duration, scope string) (Token, error) {
@@ -18,19 +29,142 @@ func (s *StubTokenModel) New(userID int64, ttl time.Duration, scope string) (Tok
 		return Token{}, err
 	}
 
-	s.tokens = append(s.tokens, token)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.insert(&token)
 	return token, err
 }
 
-func (s *StubTokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (s *StubTokenModel) NewSession(userID int64, ttl time.Duration, userAgent string) (Token, error) {
+	token, err := generateSessionToken(userID, ttl, userAgent)
+	if err != nil {
+		return Token{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.insert(&token)
+	return token, err
+}
+
+func (s *StubTokenModel) NewChild(parent Token, ttl time.Duration, scope string) (Token, error) {
+	token, err := generateChildToken(parent, ttl, scope)
+	if err != nil {
+		return Token{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.insert(&token)
+	return token, err
+}
+
+func (s *StubTokenModel) insert(token *Token) {
+	if token.Id == 0 {
+		s.idCount++
+		token.Id = s.idCount
+	}
+	s.tokens = append(s.tokens, *token)
+}
+
+func (s *StubTokenModel) Get(scope string, hash []byte) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(scope, hash)
+	if i < 0 {
+		return Token{}, ErrRecordNotFound
+	}
+	return s.tokens[i], nil
+}
+
+func (s *StubTokenModel) GetAllForUser(scope string, userID int64) ([]Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := []Token{}
+	for _, token := range s.tokens {
+		if token.Scope == scope && token.UserId == userID && token.Expiry.After(time.Now()) {
+			tokens = append(tokens, token)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b Token) bool {
+		if a.CreatedAt.Equal(b.CreatedAt) {
+			return a.Id > b.Id
+		}
+		return a.CreatedAt.After(b.CreatedAt)
+	})
+	return tokens, nil
+}
+
+func (s *StubTokenModel) Consume(scope string, tokenPlaintext string) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(scope, TokenHash(tokenPlaintext))
+	if i < 0 {
+		return Token{}, ErrRecordNotFound
+	}
+	token := s.tokens[i]
+	s.tokens = remove(s.tokens, i)
+	return token, nil
+}
+
+func (s *StubTokenModel) Delete(scope string, userID int64, id int64) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	for i, token := range s.tokens {
-		if token.Scope == scope && token.UserId == userID {
+		if token.Id == id && token.UserId == userID && token.Scope == scope {
 			s.tokens = remove(s.tokens, i)
+			return token, nil
 		}
 	}
+	return Token{}, ErrRecordNotFound
+}
+
+func (s *StubTokenModel) DeleteAllForUser(scope string, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := s.tokens[:0]
+	for _, token := range s.tokens {
+		if token.Scope != scope || token.UserId != userID {
+			tokens = append(tokens, token)
+		}
+	}
+	s.tokens = tokens
 	return nil
 }
 
+// consumeAll consumes the token and deletes tokens of its user in the scopes at once,
+// it returns the token and the deleted tokens
+func (s *StubTokenModel) consumeAll(scope string, tokenPlaintext string, scopes ...string) (Token, []Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(scope, TokenHash(tokenPlaintext))
+	if i < 0 {
+		return Token{}, nil, ErrRecordNotFound
+	}
+	token := s.tokens[i]
+	s.tokens = remove(s.tokens, i)
+	tokens := []Token{}
+	deleted := []Token{}
+	for _, t := range s.tokens {
+		if t.UserId == token.UserId && slices.Contains(scopes, t.Scope) {
+			deleted = append(deleted, t)
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+	s.tokens = tokens
+	return token, deleted, nil
+}
+
+func (s *StubTokenModel) find(scope string, hash []byte) int {
+	for i, token := range s.tokens {
+		if token.Scope == scope && bytes.Equal(token.Hash, hash) && token.Expiry.After(time.Now()) {
+			return i
+		}
+	}
+	return -1
+}
+
 func remove[T any](s []T, i int) []T {
 	s[i] = s[len(s)-1]
 	return s[:len(s)-1]
@@ -29,7 +29,16 @@ type User struct {
 	Password  password  `json:"-"`
 	Type      int       `json:"type"`
 	ImageId   string    `json:"imageId"`
-	Version   int       `json:"-"`
+	// Activated users verified their email, only they can place and accept orders
+	Activated bool `json:"activated"`
+	Version   int  `json:"-"`
+}
+
+// Presence is the chat connection status of a user
+type Presence struct {
+	UserId   int64     `json:"userId"`
+	Online   bool      `json:"online"`
+	LastSeen time.Time `json:"lastSeen"`
 }
 
 type PostUserDto struct {
@@ -6,6 +6,8 @@ import (
 	"database/sql"
 	"errors"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type UserModel interface {
@@ -15,6 +17,10 @@ type UserModel interface {
 	GetAllBySearch(query string) ([]User, error)
 	Update(user User) error
 	GetForToken(tokenScope, tokenPlaintext string) (User, error)
+	// ResetPassword consumes the password reset token and sets the password of its user, sessions
+	// and reset tokens of the user are deleted in the same transaction. It returns the user and
+	// the deleted sessions
+	ResetPassword(tokenPlaintext string, plaintextPassword string) (User, []Token, error)
 }
 
 type PsqlUserModel struct {
@@ -27,11 +33,11 @@ func NewPsqlUserModel(db *sql.DB) *PsqlUserModel {
 
 func (m PsqlUserModel) Insert(user *User) error {
 	query := `
-        INSERT INTO users (email, name, password_hash, user_type_id, image_id) 
-        VALUES ($1, $2, $3, $4, $5)
+        INSERT INTO users (email, name, password_hash, user_type_id, image_id, activated) 
+        VALUES ($1, $2, $3, $4, $5, $6)
         RETURNING user_id, created_at, version`
 
-	args := []any{user.Email, user.Name, user.Password.hash, user.Type, user.ImageId}
+	args := []any{user.Email, user.Name, user.Password.hash, user.Type, user.ImageId, user.Activated}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -53,7 +59,7 @@ func (m PsqlUserModel) Insert(user *User) error {
 
 func (m PsqlUserModel) GetByEmail(email string) (User, error) {
 	query := `
-        SELECT user_id, created_at, email, name, password_hash, user_type_id, version, image_id
+        SELECT user_id, created_at, email, name, password_hash, user_type_id, version, image_id, activated
         FROM users
         WHERE email = $1`
 
@@ -71,6 +77,7 @@ func (m PsqlUserModel) GetByEmail(email string) (User, error) {
 		&user.Type,
 		&user.Version,
 		&user.ImageId,
+		&user.Activated,
 	)
 
 	if err != nil {
@@ -87,7 +94,7 @@ func (m PsqlUserModel) GetByEmail(email string) (User, error) {
 
 func (m PsqlUserModel) GetById(id int64) (User, error) {
 	query := `
-        SELECT user_id, created_at, email, name, password_hash, user_type_id, version, image_id
+        SELECT user_id, created_at, email, name, password_hash, user_type_id, version, image_id, activated
         FROM users
         WHERE user_id = $1`
 
@@ -105,6 +112,7 @@ func (m PsqlUserModel) GetById(id int64) (User, error) {
 		&user.Type,
 		&user.Version,
 		&user.ImageId,
+		&user.Activated,
 	)
 
 	if err != nil {
@@ -127,14 +135,15 @@ func (m PsqlUserModel) GetById(id int64) (User, error) {
 func (m PsqlUserModel) Update(user User) error {
 	query := `
         UPDATE users
-        SET email = $1, password_hash = $2, name = $3, version = version + 1
-        WHERE user_id = $4 AND version = $5
+        SET email = $1, password_hash = $2, name = $3, activated = $4, version = version + 1
+        WHERE user_id = $5 AND version = $6
         RETURNING version`
 
 	args := []any{
 		user.Email,
 		user.Password.hash,
 		user.Name,
+		user.Activated,
 		user.Id,
 		user.Version,
 	}
@@ -157,13 +166,89 @@ func (m PsqlUserModel) Update(user User) error {
 	return nil
 }
 
+func (m PsqlUserModel) ResetPassword(tokenPlaintext string, plaintextPassword string) (User, []Token, error) {
+	var user User
+	// the password is hashed before the transaction, hashing is slow
+	err := user.Password.Set(plaintextPassword)
+	if err != nil {
+		return User{}, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, nil, err
+	}
+	defer tx.Rollback()
+
+	// concurrent requests with the same token wait for the row, so only one of them consumes it
+	query := `
+        DELETE FROM tokens
+        WHERE hash = $1 AND scope = $2 AND expiry > $3
+        RETURNING user_id`
+	err = tx.QueryRowContext(ctx, query, TokenHash(tokenPlaintext), ScopePasswordReset, time.Now()).Scan(&user.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return User{}, nil, ErrRecordNotFound
+		default:
+			return User{}, nil, err
+		}
+	}
+
+	query = `
+        UPDATE users
+        SET password_hash = $1, version = version + 1
+        WHERE user_id = $2
+        RETURNING created_at, email, name, user_type_id, version, image_id, activated`
+	err = tx.QueryRowContext(ctx, query, user.Password.hash, user.Id).Scan(
+		&user.CreatedAt,
+		&user.Email,
+		&user.Name,
+		&user.Type,
+		&user.Version,
+		&user.ImageId,
+		&user.Activated,
+	)
+	if err != nil {
+		return User{}, nil, err
+	}
+
+	query = `
+        DELETE FROM tokens
+        WHERE user_id = $1 AND scope = ANY($2)
+        RETURNING token_id, hash, user_id, expiry, scope, parent_hash, created_at, user_agent`
+	rows, err := tx.QueryContext(ctx, query, user.Id, pq.Array([]string{ScopePasswordReset, ScopeAuthentication}))
+	if err != nil {
+		return User{}, nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Token{}
+	for rows.Next() {
+		var token Token
+		if err := scanToken(rows, &token); err != nil {
+			return User{}, nil, err
+		}
+		if token.Scope == ScopeAuthentication {
+			sessions = append(sessions, token)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return User{}, nil, err
+	}
+
+	return user, sessions, tx.Commit()
+}
+
 func (m PsqlUserModel) GetForToken(tokenScope, tokenPlaintext string) (User, error) {
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
 	// Remember that this returns a byte *array* with length 32, not a slice.
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
-        SELECT a.user_id, a.created_at, a.email, a.name, a.password_hash, a.user_type_id, a.image_id, a.version
+        SELECT a.user_id, a.created_at, a.email, a.name, a.password_hash, a.user_type_id, a.image_id, a.version, a.activated
         FROM users as a
         INNER JOIN tokens as t
         ON a.user_id = t.user_id
@@ -192,6 +277,7 @@ func (m PsqlUserModel) GetForToken(tokenScope, tokenPlaintext string) (User, err
 		&user.Type,
 		&user.ImageId,
 		&user.Version,
+		&user.Activated,
 	)
 	if err != nil {
 		switch {
@@ -208,7 +294,7 @@ func (m PsqlUserModel) GetForToken(tokenScope, tokenPlaintext string) (User, err
 
 func (m PsqlUserModel) GetAllBySearch(query string) ([]User, error) {
 	q := `
-        SELECT user_id, created_at, email, name, user_type_id, image_id
+        SELECT user_id, created_at, email, name, user_type_id, image_id, activated
         FROM users
         WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '') `
 
@@ -230,7 +316,7 @@ func (m PsqlUserModel) GetAllBySearch(query string) ([]User, error) {
 
 	for rows.Next() {
 		user := User{}
-		if err := rows.Scan(&user.Id, &user.CreatedAt, &user.Email, &user.Name, &user.Type, &user.ImageId); err != nil {
+		if err := rows.Scan(&user.Id, &user.CreatedAt, &user.Email, &user.Name, &user.Type, &user.ImageId, &user.Activated); err != nil {
 			return nil, err
 		}
 		users = append(users, user)
@@ -11,12 +11,18 @@ import (
 type StubUserModel struct {
 	users   []User
 	idCount int64
+	tokens  *StubTokenModel
 }
 
 func NewStubUserModel(users []User) *StubUserModel {
 	return &StubUserModel{users: users}
 }
 
+// SetTokenModel allows the stub to reset passwords with tokens of the token model
+func (s *StubUserModel) SetTokenModel(tokens *StubTokenModel) {
+	s.tokens = tokens
+}
+
 func (s *StubUserModel) Insert(user *User) error {
 	if _, err := s.GetByEmail(user.Email); err == nil {
 		return ErrDuplicateEmail
@@ -46,7 +52,7 @@ func (s *StubUserModel) GetById(id int64) (User, error) {
 }
 
 func (s *StubUserModel) Update(user User) error {
-	if _, err := s.GetByEmail(user.Email); err == nil {
+	if other, err := s.GetByEmail(user.Email); err == nil && other.Id != user.Id {
 		return ErrDuplicateEmail
 	}
 	for k, v := range s.users {
@@ -71,6 +77,33 @@ func (s *StubUserModel) GetForToken(tokenScope, tokenPlaintext string) (User, er
 	return User{}, ErrRecordNotFound
 }
 
+func (s *StubUserModel) ResetPassword(tokenPlaintext string, plaintextPassword string) (User, []Token, error) {
+	if s.tokens == nil {
+		return User{}, nil, ErrRecordNotFound
+	}
+	token, deleted, err := s.tokens.consumeAll(ScopePasswordReset, tokenPlaintext, ScopePasswordReset, ScopeAuthentication)
+	if err != nil {
+		return User{}, nil, err
+	}
+	sessions := []Token{}
+	for _, t := range deleted {
+		if t.Scope == ScopeAuthentication {
+			sessions = append(sessions, t)
+		}
+	}
+	for i := range s.users {
+		if s.users[i].Id == token.UserId {
+			err := s.users[i].Password.Set(plaintextPassword)
+			if err != nil {
+				return User{}, nil, err
+			}
+			s.users[i].Version++
+			return s.users[i], sessions, nil
+		}
+	}
+	return User{}, nil, ErrRecordNotFound
+}
+
 func (s *StubUserModel) GetAllBySearch(query string) ([]User, error) {
 	result := []User{}
 	queryNoSymbols := regexp.MustCompile(`[^a-zA-Z0-9 ]+`).ReplaceAllString(query, "")
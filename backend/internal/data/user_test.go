@@ -1,8 +1,10 @@
 package data_test
 
 import (
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/vasiliiperfilev/cookie/internal/data"
@@ -176,6 +178,33 @@ func TestUserModelIntegration(t *testing.T) {
 		}
 	})
 
+	t.Run("it resets the password once and returns the deleted sessions", func(t *testing.T) {
+		model := data.NewPsqlUserModel(db)
+		tokenModel := data.NewPsqlTokenModel(db)
+		insertedUser := data.User{
+			Email:   "reset@test.com",
+			Name:    "test",
+			Type:    1,
+			ImageId: "id",
+		}
+		insertedUser.Password.Set("pa5$wOrd123")
+		err := model.Insert(&insertedUser)
+		tester.AssertNoError(t, err)
+		session, err := tokenModel.NewSession(insertedUser.Id, time.Hour, "phone")
+		tester.AssertNoError(t, err)
+		reset, err := tokenModel.New(insertedUser.Id, time.Hour, data.ScopePasswordReset)
+		tester.AssertNoError(t, err)
+
+		user, sessions, err := model.ResetPassword(reset.Plaintext, "n3w$Password")
+		tester.AssertNoError(t, err)
+		matches, _ := user.Password.Matches("n3w$Password")
+		tester.AssertValue(t, matches, true, "Expected new password")
+		tester.AssertValue(t, len(sessions), 1, "Expected deleted session")
+		tester.AssertValue(t, sessions[0].Id, session.Id, "Expected session of the user")
+		_, _, err = model.ResetPassword(reset.Plaintext, "n3w$Password")
+		tester.AssertValue(t, errors.Is(err, data.ErrRecordNotFound), true, "Expected consumed token")
+	})
+
 }
 
 func assertErrorKeys(t *testing.T, keys []string, errors map[string]string) {
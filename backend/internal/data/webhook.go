@@ -0,0 +1,86 @@
+package data
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"github.com/vasiliiperfilev/cookie/internal/validator"
+)
+
+// Event types users can subscribe webhooks to
+const (
+	WebhookOrderCreated    = "order.created"
+	WebhookOrderUpdated    = "order.updated"
+	WebhookMessageReceived = "message.received"
+)
+
+var WebhookEventTypes = []string{WebhookOrderCreated, WebhookOrderUpdated, WebhookMessageReceived}
+
+const (
+	WebhookDeliveriesDefaultLimit = 50
+	WebhookDeliveriesMaxLimit     = 100
+)
+
+// Statuses of webhook deliveries, dead deliveries failed every attempt and aren't retried
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+type Webhook struct {
+	Id     int64  `json:"id"`
+	UserId int64  `json:"userId"`
+	Url    string `json:"url"`
+	// Secret signs deliveries, it is only returned when the webhook is created
+	Secret     string    `json:"secret,omitempty"`
+	EventTypes []string  `json:"eventTypes"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type PostWebhookDto struct {
+	Url        string   `json:"url"`
+	EventTypes []string `json:"eventTypes"`
+}
+
+// WebhookDelivery is an event in the outbox of the webhook
+type WebhookDelivery struct {
+	Id             int64           `json:"id"`
+	WebhookId      int64           `json:"webhookId"`
+	EventType      string          `json:"eventType"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"nextAttemptAt"`
+	LastStatusCode int             `json:"lastStatusCode"`
+	LastError      string          `json:"lastError"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	DeliveredAt    *time.Time      `json:"deliveredAt"`
+	// EventId is the outbox event of the delivery, deliveries enqueued before
+	// event ids were stored have none
+	EventId int64 `json:"eventId"`
+	// Webhook is the target of the delivery, it is loaded for the delivery worker
+	Webhook Webhook `json:"-"`
+}
+
+func ValidatePostWebhookInput(v *validator.Validator, dto PostWebhookDto) {
+	u, err := url.Parse(dto.Url)
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+	v.Check(len(dto.EventTypes) > 0, "eventTypes", "must have at least one event type")
+	v.Check(validator.Unique(dto.EventTypes), "eventTypes", "must not contain duplicate values")
+	for _, eventType := range dto.EventTypes {
+		v.Check(validator.PermittedValue(eventType, WebhookEventTypes...), "eventTypes", "must contain known event types")
+	}
+}
+
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
@@ -0,0 +1,290 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type WebhookModel interface {
+	// Insert generates the secret of the webhook
+	Insert(webhook *Webhook) error
+	GetById(id int64) (Webhook, error)
+	GetAllByUserId(userId int64) ([]Webhook, error)
+	Delete(id int64) error
+	// Enqueue adds the event to outboxes of webhooks of the users subscribed to its type,
+	// an event is enqueued once per webhook, so it can be enqueued again
+	Enqueue(eventId int64, userIds []int64, eventType string, payload []byte) error
+	// ClaimDue returns pending deliveries which are due with their webhooks and postpones
+	// them by the lease, so that other workers don't send them meanwhile
+	ClaimDue(limit int, lease time.Duration) ([]WebhookDelivery, error)
+	// UpdateDelivery stores the result of a delivery attempt
+	UpdateDelivery(delivery WebhookDelivery) error
+	// GetAllDeliveries returns the latest deliveries of the webhook, all statuses if status is empty
+	GetAllDeliveries(webhookId int64, status string, limit int) ([]WebhookDelivery, error)
+}
+
+type PsqlWebhookModel struct {
+	db *sql.DB
+}
+
+func NewPsqlWebhookModel(db *sql.DB) *PsqlWebhookModel {
+	return &PsqlWebhookModel{db: db}
+}
+
+func (m PsqlWebhookModel) Insert(webhook *Webhook) error {
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return err
+	}
+	webhook.Secret = secret
+
+	query := `
+		INSERT INTO webhooks (user_id, url, secret, event_types)
+		VALUES ($1, $2, $3, $4)
+		RETURNING webhook_id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{webhook.UserId, webhook.Url, webhook.Secret, pq.Array(webhook.EventTypes)}
+	return m.db.QueryRowContext(ctx, query, args...).Scan(&webhook.Id, &webhook.CreatedAt)
+}
+
+func (m PsqlWebhookModel) GetById(id int64) (Webhook, error) {
+	query := `
+		SELECT webhook_id, user_id, url, event_types, created_at
+		FROM webhooks
+		WHERE webhook_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var webhook Webhook
+	err := m.db.QueryRowContext(ctx, query, id).Scan(&webhook.Id, &webhook.UserId, &webhook.Url, pq.Array(&webhook.EventTypes), &webhook.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return Webhook{}, ErrRecordNotFound
+		default:
+			return Webhook{}, err
+		}
+	}
+
+	return webhook, nil
+}
+
+func (m PsqlWebhookModel) GetAllByUserId(userId int64) ([]Webhook, error) {
+	query := `
+		SELECT webhook_id, user_id, url, event_types, created_at
+		FROM webhooks
+		WHERE user_id = $1
+		ORDER BY webhook_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var webhook Webhook
+		err := rows.Scan(&webhook.Id, &webhook.UserId, &webhook.Url, pq.Array(&webhook.EventTypes), &webhook.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (m PsqlWebhookModel) Delete(id int64) error {
+	query := `
+		DELETE FROM webhooks
+		WHERE webhook_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m PsqlWebhookModel) Enqueue(eventId int64, userIds []int64, eventType string, payload []byte) error {
+	query := `
+		INSERT INTO webhook_deliveries (event_id, webhook_id, event_type, payload)
+		SELECT $1, webhook_id, $3, $4
+		FROM webhooks
+		WHERE user_id = ANY($2) AND $3 = ANY(event_types)
+		ON CONFLICT (event_id, webhook_id) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query, eventId, pq.Array(userIds), eventType, string(payload))
+	return err
+}
+
+func (m PsqlWebhookModel) ClaimDue(limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM webhooks w
+		WHERE d.webhook_id = w.webhook_id AND d.delivery_id IN (
+			SELECT delivery_id
+			FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.delivery_id, d.webhook_id, COALESCE(d.event_id, 0), d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at,
+			d.last_status_code, d.last_error, d.created_at, d.delivered_at, w.user_id, w.url, w.secret`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var payload []byte
+		err := rows.Scan(
+			&delivery.Id,
+			&delivery.WebhookId,
+			&delivery.EventId,
+			&delivery.EventType,
+			&payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.NextAttemptAt,
+			&delivery.LastStatusCode,
+			&delivery.LastError,
+			&delivery.CreatedAt,
+			&delivery.DeliveredAt,
+			&delivery.Webhook.UserId,
+			&delivery.Webhook.Url,
+			&delivery.Webhook.Secret,
+		)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Payload = payload
+		delivery.Webhook.Id = delivery.WebhookId
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (m PsqlWebhookModel) UpdateDelivery(delivery WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, next_attempt_at = $4, last_status_code = $5, last_error = $6, delivered_at = $7
+		WHERE delivery_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{
+		delivery.Id,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt,
+		delivery.LastStatusCode,
+		delivery.LastError,
+		delivery.DeliveredAt,
+	}
+	result, err := m.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m PsqlWebhookModel) GetAllDeliveries(webhookId int64, status string, limit int) ([]WebhookDelivery, error) {
+	query := `
+		SELECT delivery_id, webhook_id, COALESCE(event_id, 0), event_type, payload, status, attempts, next_attempt_at,
+			last_status_code, last_error, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1 AND (status = $2 OR $2 = '')
+		ORDER BY created_at DESC, delivery_id DESC
+		LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, webhookId, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var payload []byte
+		err := rows.Scan(
+			&delivery.Id,
+			&delivery.WebhookId,
+			&delivery.EventId,
+			&delivery.EventType,
+			&payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.NextAttemptAt,
+			&delivery.LastStatusCode,
+			&delivery.LastError,
+			&delivery.CreatedAt,
+			&delivery.DeliveredAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Payload = payload
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,153 @@
+package data
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+type StubWebhookModel struct {
+	mu         sync.Mutex
+	webhooks   []Webhook
+	deliveries []WebhookDelivery
+	idCount    int64
+	// deliveryIdCount is the last id of deliveries
+	deliveryIdCount int64
+}
+
+func NewStubWebhookModel() *StubWebhookModel {
+	return &StubWebhookModel{}
+}
+
+func (s *StubWebhookModel) Insert(webhook *Webhook) error {
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idCount++
+	webhook.Id = s.idCount
+	webhook.Secret = secret
+	webhook.CreatedAt = time.Now()
+	s.webhooks = append(s.webhooks, *webhook)
+	return nil
+}
+
+func (s *StubWebhookModel) GetById(id int64) (Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.webhooks, func(w Webhook) bool { return w.Id == id })
+	if i < 0 {
+		return Webhook{}, ErrRecordNotFound
+	}
+	webhook := s.webhooks[i]
+	webhook.Secret = ""
+	return webhook, nil
+}
+
+func (s *StubWebhookModel) GetAllByUserId(userId int64) ([]Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	webhooks := []Webhook{}
+	for _, webhook := range s.webhooks {
+		if webhook.UserId == userId {
+			webhook.Secret = ""
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+func (s *StubWebhookModel) Delete(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.webhooks, func(w Webhook) bool { return w.Id == id })
+	if i < 0 {
+		return ErrRecordNotFound
+	}
+	s.webhooks = slices.Delete(s.webhooks, i, i+1)
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range s.deliveries {
+		if delivery.WebhookId != id {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	s.deliveries = deliveries
+	return nil
+}
+
+func (s *StubWebhookModel) Enqueue(eventId int64, userIds []int64, eventType string, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, webhook := range s.webhooks {
+		if !slices.Contains(userIds, webhook.UserId) || !slices.Contains(webhook.EventTypes, eventType) {
+			continue
+		}
+		enqueued := slices.ContainsFunc(s.deliveries, func(d WebhookDelivery) bool {
+			return d.EventId == eventId && d.WebhookId == webhook.Id
+		})
+		if enqueued {
+			continue
+		}
+		now := time.Now()
+		s.deliveryIdCount++
+		s.deliveries = append(s.deliveries, WebhookDelivery{
+			Id:            s.deliveryIdCount,
+			WebhookId:     webhook.Id,
+			EventId:       eventId,
+			EventType:     eventType,
+			Payload:       append([]byte{}, payload...),
+			Status:        DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+	return nil
+}
+
+func (s *StubWebhookModel) ClaimDue(limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	deliveries := []WebhookDelivery{}
+	for i, delivery := range s.deliveries {
+		if len(deliveries) == limit {
+			break
+		}
+		if delivery.Status != DeliveryPending || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		s.deliveries[i].NextAttemptAt = now.Add(lease)
+		j := slices.IndexFunc(s.webhooks, func(w Webhook) bool { return w.Id == delivery.WebhookId })
+		delivery.Webhook = s.webhooks[j]
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func (s *StubWebhookModel) UpdateDelivery(delivery WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.deliveries, func(d WebhookDelivery) bool { return d.Id == delivery.Id })
+	if i < 0 {
+		return ErrRecordNotFound
+	}
+	delivery.Webhook = Webhook{}
+	s.deliveries[i] = delivery
+	return nil
+}
+
+func (s *StubWebhookModel) GetAllDeliveries(webhookId int64, status string, limit int) ([]WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deliveries := []WebhookDelivery{}
+	for i := len(s.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		delivery := s.deliveries[i]
+		if delivery.WebhookId == webhookId && (status == "" || delivery.Status == status) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,69 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"text/template"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// Message is a rendered email
+type Message struct {
+	To        string
+	Subject   string
+	PlainBody string
+	HtmlBody  string
+}
+
+// Sender delivers rendered emails, it is SMTPSender in production
+// and FileSender or MemorySender in development and tests
+type Sender interface {
+	Send(msg Message) error
+}
+
+type Mailer struct {
+	sender Sender
+}
+
+func New(sender Sender) *Mailer {
+	return &Mailer{sender: sender}
+}
+
+// Send renders the template with the data and sends it to the recipient,
+// templates define "subject", "plainBody" and "htmlBody"
+func (m *Mailer) Send(recipient, templateFile string, data any) error {
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return err
+	}
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return err
+	}
+	// the html body is escaped as html
+	htmlTmpl, err := htmltemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return err
+	}
+
+	return m.sender.Send(Message{
+		To:        recipient,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HtmlBody:  htmlBody.String(),
+	})
+}
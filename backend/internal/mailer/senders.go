@@ -0,0 +1,130 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type SMTPSender struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+}
+
+// NewSMTPSender sends emails from the sender address, the auth is skipped without the username
+func NewSMTPSender(host string, port int, username, password, sender string) *SMTPSender {
+	s := &SMTPSender{addr: host + ":" + strconv.Itoa(port), sender: sender}
+	if username != "" {
+		s.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return s
+}
+
+func (s *SMTPSender) Send(msg Message) error {
+	body, err := msg.bytes(s.sender)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(s.addr, s.auth, s.sender, []string{msg.To}, body)
+}
+
+// FileSender writes emails to the directory instead of sending them
+type FileSender struct {
+	dir    string
+	sender string
+}
+
+func NewFileSender(dir, sender string) *FileSender {
+	return &FileSender{dir: dir, sender: sender}
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9@._-]`)
+
+func (s *FileSender) Send(msg Message) error {
+	body, err := msg.bytes(s.sender)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(s.dir, 0o755)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), unsafeFileChars.ReplaceAllString(msg.To, "_"))
+	return os.WriteFile(filepath.Join(s.dir, name), body, 0o644)
+}
+
+// MemorySender keeps sent emails for tests
+type MemorySender struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemorySender() *MemorySender {
+	return &MemorySender{}
+}
+
+func (s *MemorySender) Send(msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+// Messages returns the emails sent to the recipient
+func (s *MemorySender) Messages(to string) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	messages := []Message{}
+	for _, msg := range s.messages {
+		if msg.To == to {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// bytes formats the message as a multipart email with plain and html alternatives
+func (msg Message) bytes(from string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	parts := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "From: %s\r\n", from)
+	fmt.Fprintf(buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", msg.PlainBody},
+		{"text/html", msg.HtmlBody},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=UTF-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,2 @@
+ALTER TABLE items DROP COLUMN IF EXISTS version;
+ALTER TABLE orders DROP COLUMN IF EXISTS version;
\ No newline at end of file
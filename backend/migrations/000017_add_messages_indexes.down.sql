@@ -0,0 +1 @@
+DROP INDEX IF EXISTS messages_conversation_id_message_id_idx;
\ No newline at end of file
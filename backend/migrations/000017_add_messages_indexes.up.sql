@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS messages_conversation_id_message_id_idx ON messages (conversation_id, message_id);
\ No newline at end of file
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS messages_conversation_id_created_at_idx ON messages (conversation_id, created_at, message_id);
\ No newline at end of file
@@ -0,0 +1,11 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at timestamp(0) with time zone;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+CREATE TABLE IF NOT EXISTS message_edits (
+    edit_id bigserial PRIMARY KEY,
+    message_id bigint NOT NULL REFERENCES messages(message_id) ON DELETE CASCADE,
+    content citext NOT NULL,
+    edited_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS message_edits_message_id_idx ON message_edits (message_id);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS attachments (
+    attachment_id bigserial PRIMARY KEY,
+    message_id bigint REFERENCES messages(message_id) ON DELETE CASCADE, -- NULL until the attachment is sent with a message
+    uploader_id bigint NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    file_id text NOT NULL UNIQUE,
+    filename text NOT NULL,
+    mime_type text NOT NULL,
+    size bigint NOT NULL,
+    width integer NOT NULL DEFAULT 0,
+    height integer NOT NULL DEFAULT 0,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS attachments_message_id_idx ON attachments (message_id);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS messages_content_idx;
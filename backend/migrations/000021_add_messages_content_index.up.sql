@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS messages_content_idx ON messages USING GIN (to_tsvector('simple', content::text));
@@ -0,0 +1,3 @@
+ALTER TABLE conversations_users DROP COLUMN IF EXISTS role;
+ALTER TABLE conversations DROP COLUMN IF EXISTS is_group;
+ALTER TABLE conversations DROP COLUMN IF EXISTS title;
@@ -0,0 +1,6 @@
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS title text NOT NULL DEFAULT '';
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS is_group boolean NOT NULL DEFAULT false;
+
+ALTER TABLE conversations_users ADD COLUMN IF NOT EXISTS role text NOT NULL DEFAULT 'member';
+-- every participant of an existing conversation is equal
+UPDATE conversations_users SET role = 'owner';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS conversations_direct_key_idx;
+ALTER TABLE conversations DROP COLUMN IF EXISTS direct_key;
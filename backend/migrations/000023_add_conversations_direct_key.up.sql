@@ -0,0 +1,18 @@
+-- direct_key identifies the users of a direct conversation, groups don't have one
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS direct_key text;
+
+-- existing duplicates are kept, only the oldest of them gets the key
+UPDATE conversations as c
+SET direct_key = k.direct_key
+FROM (
+    SELECT DISTINCT ON (direct_key) conversation_id, direct_key
+    FROM (
+        SELECT conversation_id, string_agg(user_id::text, ':' ORDER BY user_id) as direct_key
+        FROM conversations_users
+        GROUP BY conversation_id
+    ) as keys
+    ORDER BY direct_key, conversation_id
+) as k
+WHERE c.conversation_id = k.conversation_id AND c.is_group = false;
+
+CREATE UNIQUE INDEX IF NOT EXISTS conversations_direct_key_idx ON conversations (direct_key);
@@ -0,0 +1,3 @@
+ALTER TABLE conversations_users DROP COLUMN IF EXISTS muted_until;
+ALTER TABLE conversations_users DROP COLUMN IF EXISTS pinned;
+ALTER TABLE conversations_users DROP COLUMN IF EXISTS archived;
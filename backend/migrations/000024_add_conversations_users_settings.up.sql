@@ -0,0 +1,3 @@
+ALTER TABLE conversations_users ADD COLUMN IF NOT EXISTS archived boolean NOT NULL DEFAULT false;
+ALTER TABLE conversations_users ADD COLUMN IF NOT EXISTS pinned boolean NOT NULL DEFAULT false;
+ALTER TABLE conversations_users ADD COLUMN IF NOT EXISTS muted_until timestamp(0) with time zone;
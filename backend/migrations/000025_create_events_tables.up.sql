@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS event_sequences (
+    user_id bigint PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    last_seq bigint NOT NULL DEFAULT 0,
+    acked_seq bigint NOT NULL DEFAULT 0
+);
+
+-- websocket events kept for replay after reconnect
+CREATE TABLE IF NOT EXISTS events (
+    user_id bigint NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    seq bigint NOT NULL,
+    type text NOT NULL,
+    payload jsonb NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY(user_id, seq)
+);
@@ -0,0 +1,2 @@
+DELETE FROM tokens WHERE parent_hash IS NOT NULL;
+ALTER TABLE tokens DROP COLUMN IF EXISTS parent_hash;
@@ -0,0 +1 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS parent_hash bytea REFERENCES tokens(hash) ON DELETE CASCADE;
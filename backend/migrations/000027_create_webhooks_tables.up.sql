@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    webhook_id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    url text NOT NULL,
+    secret text NOT NULL,
+    event_types text[] NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id);
+
+-- outbox of webhook deliveries, pending ones are sent by the delivery worker
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    delivery_id bigserial PRIMARY KEY,
+    webhook_id bigint NOT NULL REFERENCES webhooks(webhook_id) ON DELETE CASCADE,
+    event_type text NOT NULL,
+    payload jsonb NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_status_code integer NOT NULL DEFAULT 0,
+    last_error text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    delivered_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, created_at);
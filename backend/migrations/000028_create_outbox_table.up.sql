@@ -0,0 +1,14 @@
+-- domain events are written in the transaction of the change they describe,
+-- the relay publishes them and marks them as dispatched
+CREATE TABLE IF NOT EXISTS outbox (
+    event_id bigserial PRIMARY KEY,
+    event_type text NOT NULL,
+    conversation_id bigint NOT NULL DEFAULT 0,
+    user_id bigint NOT NULL DEFAULT 0,
+    payload jsonb NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    claimed_until timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    dispatched_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS outbox_undispatched_idx ON outbox (event_id) WHERE dispatched_at IS NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE conversations_users DROP COLUMN IF EXISTS last_notified_message_id;
+DROP TABLE IF EXISTS notifications;
+DROP TABLE IF EXISTS notification_preferences;
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id bigint PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    mode text NOT NULL DEFAULT 'instant',
+    orders boolean NOT NULL DEFAULT true,
+    messages boolean NOT NULL DEFAULT true
+);
+
+-- queue of email notifications, digest ones are sent once a day
+CREATE TABLE IF NOT EXISTS notifications (
+    notification_id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    type text NOT NULL,
+    payload jsonb NOT NULL,
+    digest boolean NOT NULL DEFAULT false,
+    claimed_until timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    sent_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS notifications_unsent_idx ON notifications (digest, notification_id) WHERE sent_at IS NULL;
+
+-- unread messages up to this one were notified about
+ALTER TABLE conversations_users ADD COLUMN IF NOT EXISTS last_notified_message_id bigint NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS activated boolean NOT NULL DEFAULT false;
+
+-- users registered before email verification keep their access
+UPDATE users SET activated = true;
@@ -0,0 +1,6 @@
+-- hub deliveries over the NOTIFY payload limit, notifications carry their id
+CREATE TABLE IF NOT EXISTS broker_payloads (
+    payload_id bigserial PRIMARY KEY,
+    payload text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+-- subscribers which handled the event, failed ones get it again without the others
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS dispatched_to text[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS notifications_pending_idx;
+CREATE INDEX IF NOT EXISTS notifications_unsent_idx ON notifications (digest, notification_id) WHERE sent_at IS NULL;
+
+ALTER TABLE notifications DROP COLUMN IF EXISTS attempts;
+ALTER TABLE notifications DROP COLUMN IF EXISTS status;
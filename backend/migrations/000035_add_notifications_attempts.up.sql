@@ -0,0 +1,7 @@
+-- dead notifications failed every attempt and aren't retried
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'pending';
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS attempts integer NOT NULL DEFAULT 0;
+UPDATE notifications SET status = 'sent' WHERE sent_at IS NOT NULL;
+
+DROP INDEX IF EXISTS notifications_unsent_idx;
+CREATE INDEX IF NOT EXISTS notifications_pending_idx ON notifications (digest, notification_id) WHERE status = 'pending';
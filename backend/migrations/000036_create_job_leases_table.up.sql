@@ -0,0 +1,5 @@
+-- periodic jobs which one instance runs per interval, the instance holding the lease runs it
+CREATE TABLE IF NOT EXISTS job_leases (
+    name text PRIMARY KEY,
+    leased_until timestamp with time zone NOT NULL
+);
@@ -0,0 +1,10 @@
+-- chat connections of users on every API instance, instances refresh seen_at of their online users
+-- and users of an instance which stopped refreshing them are offline
+CREATE TABLE IF NOT EXISTS presence (
+    user_id bigint NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    instance_id text NOT NULL,
+    online boolean NOT NULL,
+    seen_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, instance_id)
+);
+CREATE INDEX IF NOT EXISTS presence_instance_id_idx ON presence (instance_id);
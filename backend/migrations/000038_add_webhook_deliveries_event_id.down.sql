@@ -0,0 +1,2 @@
+ALTER TABLE webhook_deliveries DROP CONSTRAINT IF EXISTS webhook_deliveries_event_id_webhook_id_key;
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS event_id;
@@ -0,0 +1,3 @@
+-- outbox event of the delivery, the relay may publish an event again, so it is enqueued once per webhook
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS event_id bigint;
+ALTER TABLE webhook_deliveries ADD CONSTRAINT webhook_deliveries_event_id_webhook_id_key UNIQUE (event_id, webhook_id);
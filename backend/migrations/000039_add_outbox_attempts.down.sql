@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS outbox_undispatched_idx;
+CREATE INDEX IF NOT EXISTS outbox_undispatched_idx ON outbox (event_id) WHERE dispatched_at IS NULL;
+ALTER TABLE outbox DROP COLUMN IF EXISTS dead_at;
+ALTER TABLE outbox DROP COLUMN IF EXISTS last_error;
+ALTER TABLE outbox DROP COLUMN IF EXISTS attempts;
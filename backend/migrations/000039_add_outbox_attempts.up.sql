@@ -0,0 +1,7 @@
+-- failed events are claimed again with exponential backoff until they run out of attempts,
+-- dead events are kept for inspection and aren't published again
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS attempts integer NOT NULL DEFAULT 0;
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS last_error text NOT NULL DEFAULT '';
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS dead_at timestamp(0) with time zone;
+DROP INDEX IF EXISTS outbox_undispatched_idx;
+CREATE INDEX IF NOT EXISTS outbox_undispatched_idx ON outbox (event_id) WHERE dispatched_at IS NULL AND dead_at IS NULL;
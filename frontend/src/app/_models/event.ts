@@ -1,18 +1,49 @@
 import { Message, MessageDto } from './message';
-import { Order } from './order';
+import { Order, PostOrderDto, UpdateOrderDto } from './order';
 
 export enum WsEventType {
+  AUTH = 'auth',
   MESSAGE = 'message',
   NEW_ORDER = 'new_order',
   UPDATE_ORDER = 'update_order',
+  ITEM_UPDATED = 'item_updated',
+  ITEM_DELETED = 'item_deleted',
+  ACK = 'ack',
+  CLOSE = 'close',
+  REPLY = 'reply',
+  ERROR = 'error',
+}
+
+export interface WsError {
+  code: string;
+  message: string;
+  errors: Record<string, string>;
+}
+
+export interface WsReplyEvent {
+  type: string;
+  requestId?: string;
+  payload: Message | Order | WsError;
+}
+
+export interface WsAuthEvent {
+  type: string;
+  payload: { token: string };
+}
+
+export interface WsAckEvent {
+  type: string;
+  payload: { seq: number };
 }
 
 export interface WsMessageEvent {
   type: string;
+  requestId?: string;
   payload: Message | MessageDto;
 }
 
 export interface WsOrderEvent {
   type: string;
-  payload: Order;
+  requestId?: string;
+  payload: Order | PostOrderDto | UpdateOrderDto;
 }
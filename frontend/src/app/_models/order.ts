@@ -11,6 +11,7 @@ export interface Order {
   }[];
   stateId: OrderState;
   client?: User;
+  version: number;
 }
 
 export enum OrderState {
@@ -38,3 +39,10 @@ export interface PatchOrderDto {
   }[];
   stateId?: OrderState;
 }
+
+// UpdateOrderDto is the payload of the update_order command
+export interface UpdateOrderDto extends PatchOrderDto {
+  orderId: number;
+  // the order is changed only if it still has this version
+  version?: number;
+}
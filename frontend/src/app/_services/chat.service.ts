@@ -1,43 +1,71 @@
+import { HttpClient } from '@angular/common/http';
 import { Injectable } from '@angular/core';
 import {
   Message,
   Order,
+  PatchOrderDto,
+  PostOrderDto,
+  WsAckEvent,
+  WsAuthEvent,
+  WsError,
   WsEventType,
   WsMessageEvent,
   WsOrderEvent,
+  WsReplyEvent,
 } from '@app/_models';
 import { environment } from '@environments/environment';
-import { Subject } from 'rxjs';
-import { webSocket } from 'rxjs/webSocket';
+import { Observable, Subject } from 'rxjs';
+import { WebSocketSubject, webSocket } from 'rxjs/webSocket';
 import { HistoryService } from './history.service';
 import { OrdersService } from './order.service';
 import { UserService } from './user.service';
 
+// RESYNC_CLOSE_CODE closes connections which fell behind or whose replay was truncated
+const RESYNC_CLOSE_CODE = 4000;
+
 @Injectable({
   providedIn: 'root',
 })
 export class ChatService {
-  private wsConn: Subject<WsMessageEvent | WsOrderEvent>;
+  private wsConn!: WebSocketSubject<
+    WsMessageEvent | WsOrderEvent | WsAuthEvent | WsReplyEvent | WsAckEvent
+  >;
+  // sent events waiting for a reply or an error by their request id
+  private pending = new Map<
+    string,
+    { evt: WsMessageEvent | WsOrderEvent; reply: Subject<any> }
+  >();
+  private nextRequestId = 1;
+  // server-sent events are used when the websocket can't be opened, e.g. behind proxies
+  private opened = false;
+  private eventSource?: EventSource;
+  private lastSeq = 0;
+  // received events are acked once a second, the server keeps unacked ones longer
+  private ackedSeq = 0;
+  private ackTimer?: ReturnType<typeof setTimeout>;
 
   constructor(
+    private http: HttpClient,
     private historyService: HistoryService,
     private userService: UserService,
     private orderService: OrdersService
   ) {
-    this.wsConn = webSocket<WsMessageEvent | WsOrderEvent>(
-      `${environment.webSocketUrl}/v1/chat?token=${userService.tokenValue?.token}`
-    );
-    this.wsConn.subscribe({
-      next: (e) => this.receiveEvent(e), // Called whenever there is a message from the server.
-      error: (err) => console.log(err), // Called if at any point WebSocket API signals some kind of error.
-      complete: () => console.log('complete'), // Called when connection is closed (for whatever reason).
-    });
+    this.openWebSocket();
   }
 
   sendMessage(content: string, conversationId: number) {
     const msgs = this.historyService.messagesValue[conversationId];
     const prevMessageId =
       msgs && msgs.length > 0 ? msgs[msgs.length - 1].id : 0;
+    if (this.eventSource) {
+      this.http
+        .post<Message>(
+          `${environment.apiUrl}/v1/conversations/${conversationId}/messages`,
+          { prevMessageId, content }
+        )
+        .subscribe({ error: (err) => console.log(err) });
+      return;
+    }
     const wsMsgEvt: WsMessageEvent = {
       type: WsEventType.MESSAGE,
       payload: {
@@ -46,27 +74,163 @@ export class ChatService {
         content: content,
       },
     };
-    this.wsConn.next(wsMsgEvt);
+    this.send(wsMsgEvt);
   }
 
-  sendOrder(order: Order) {
-    const wsMsgEvt: WsOrderEvent = {
+  // orders are created and changed by the server, members receive the order from it
+  createOrder(dto: PostOrderDto): Observable<Order> {
+    if (this.eventSource) {
+      return this.orderService.create(dto);
+    }
+    return this.send({
       type: WsEventType.NEW_ORDER,
-      payload: order,
-    };
-    this.wsConn.next(wsMsgEvt);
+      payload: dto,
+    });
   }
 
-  sendUpdatedOrder(order: Order) {
-    const wsMsgEvt: WsOrderEvent = {
+  updateOrder(orderId: number, dto: PatchOrderDto): Observable<Order> {
+    if (this.eventSource) {
+      return this.orderService.update(orderId, dto);
+    }
+    return this.send({
       type: WsEventType.UPDATE_ORDER,
-      payload: order,
-    };
-    this.wsConn.next(wsMsgEvt);
+      payload: { orderId, ...dto },
+    });
   }
 
-  private receiveEvent(evt: WsMessageEvent | WsOrderEvent) {
-    if (evt.type === WsEventType.MESSAGE) {
+  // the token is sent in the first message, so it doesn't end up in access logs,
+  // the connection is reopened from the last seq when the server asks to resync
+  private openWebSocket() {
+    const since = this.lastSeq > 0 ? `?since=${this.lastSeq}` : '';
+    this.wsConn = webSocket<
+      WsMessageEvent | WsOrderEvent | WsAuthEvent | WsReplyEvent | WsAckEvent
+    >({
+      url: `${environment.webSocketUrl}/v1/chat${since}`,
+      openObserver: {
+        next: () => {
+          this.opened = true;
+          this.wsConn.next({
+            type: WsEventType.AUTH,
+            payload: { token: this.userService.tokenValue?.token ?? '' },
+          });
+        },
+      },
+      closeObserver: {
+        next: (e) => {
+          if (e.code === RESYNC_CLOSE_CODE) {
+            this.openWebSocket();
+          }
+        },
+      },
+    });
+    this.wsConn.subscribe({
+      next: (e) => this.receiveSequencedEvent(e), // Called whenever there is a message from the server.
+      error: (err) => {
+        // Called if at any point WebSocket API signals some kind of error.
+        console.log(err);
+        if (!this.opened) {
+          this.openEventStream();
+        }
+      },
+      complete: () => console.log('complete'), // Called when connection is closed (for whatever reason).
+    });
+  }
+
+  // event streams are authenticated with a ticket, since EventSource can't set headers,
+  // a new ticket is needed for every reconnect
+  private openEventStream() {
+    this.http
+      .post<{ token: string }>(`${environment.apiUrl}/v1/chat/tickets`, {})
+      .subscribe({
+        next: (ticket) => {
+          const since = this.lastSeq > 0 ? `&since=${this.lastSeq}` : '';
+          const eventSource = new EventSource(
+            `${environment.apiUrl}/v1/chat/events?ticket=${ticket.token}${since}`
+          );
+          eventSource.onmessage = (m) => {
+            const evt = JSON.parse(m.data);
+            if (evt.type === WsEventType.CLOSE) {
+              eventSource.close();
+              this.reopenEventStream();
+            } else {
+              this.receiveSequencedEvent(evt);
+            }
+          };
+          eventSource.onerror = () => {
+            eventSource.close();
+            this.reopenEventStream();
+          };
+          this.eventSource = eventSource;
+        },
+        error: (err) => console.log(err),
+      });
+  }
+
+  private reopenEventStream() {
+    if (this.userService.tokenValue) {
+      setTimeout(() => this.openEventStream(), 1000);
+    }
+  }
+
+  // send returns the reply payload, errors of the request are WsError
+  private send(evt: WsMessageEvent | WsOrderEvent) {
+    evt.requestId = String(this.nextRequestId++);
+    const reply = new Subject<any>();
+    this.pending.set(evt.requestId, { evt, reply });
+    this.wsConn.next(evt);
+    return reply.asObservable();
+  }
+
+  // stored events have a seq, it is acked and used to resume after reconnect
+  private receiveSequencedEvent(
+    evt: WsMessageEvent | WsOrderEvent | WsAuthEvent | WsReplyEvent
+  ) {
+    const seq = (evt as { seq?: number }).seq;
+    if (seq) {
+      this.lastSeq = seq;
+      this.scheduleAck();
+    }
+    this.receiveEvent(evt);
+  }
+
+  private scheduleAck() {
+    if (this.ackTimer) {
+      return;
+    }
+    this.ackTimer = setTimeout(() => {
+      this.ackTimer = undefined;
+      const seq = this.lastSeq;
+      if (seq <= this.ackedSeq) {
+        return;
+      }
+      this.ackedSeq = seq;
+      if (this.eventSource) {
+        this.http
+          .post(`${environment.apiUrl}/v1/chat/acks`, { seq })
+          .subscribe({ error: (err) => console.log(err) });
+      } else {
+        this.wsConn.next({ type: WsEventType.ACK, payload: { seq } });
+      }
+    }, 1000);
+  }
+
+  private receiveEvent(
+    evt: WsMessageEvent | WsOrderEvent | WsAuthEvent | WsReplyEvent
+  ) {
+    if (evt.type === WsEventType.REPLY) {
+      const requestId = (evt as WsReplyEvent).requestId ?? '';
+      const request = this.pending.get(requestId);
+      this.pending.delete(requestId);
+      request?.reply.next(evt.payload);
+      request?.reply.complete();
+    } else if (evt.type === WsEventType.ERROR) {
+      const requestId = (evt as WsReplyEvent).requestId ?? '';
+      const error = evt.payload as WsError;
+      const request = this.pending.get(requestId);
+      console.log(error.code, error.message, request?.evt);
+      this.pending.delete(requestId);
+      request?.reply.error(error);
+    } else if (evt.type === WsEventType.MESSAGE) {
       this.historyService.pushToLocalHistory(evt.payload as Message);
     } else if (
       evt.type === WsEventType.NEW_ORDER ||
@@ -1,9 +1,9 @@
-import { HttpErrorResponse } from '@angular/common/http';
 import { Component, Inject, Optional } from '@angular/core';
 import { MAT_DIALOG_DATA, MatDialogRef } from '@angular/material/dialog';
-import { Item, OrderState, User, UserType } from '@app/_models';
+import { Item, OrderState, User, UserType, WsError } from '@app/_models';
 import {
   AlertService,
+  ChatService,
   ItemsService,
   OrdersService,
   UserService,
@@ -32,6 +32,7 @@ export class OrderDialogComponent {
 
   constructor(
     private alertService: AlertService,
+    private chatService: ChatService,
     private orderService: OrdersService,
     private itemsService: ItemsService,
     private userService: UserService,
@@ -61,8 +62,8 @@ export class OrderDialogComponent {
   }
 
   updateOrderState(state: OrderState) {
-    this.orderService
-      .update(this.data.order!.id, {
+    this.chatService
+      .updateOrder(this.data.order!.id, {
         stateId: state,
       })
       .pipe(first())
@@ -71,16 +72,16 @@ export class OrderDialogComponent {
           this.alertService.success('Order state updated!');
           this.dialogRef.close({ action: CrudDialogAction.UPDATE, order });
         },
-        error: (error: HttpErrorResponse) => {
-          this.alertService.error(error.error.message);
+        error: (error: WsError) => {
+          this.alertService.error(error.message);
           console.log(error);
         },
       });
   }
 
   createOrder() {
-    this.orderService
-      .create({
+    this.chatService
+      .createOrder({
         conversationId: this.data.conversation!.id,
         items: this.toItemsArray(),
       })
@@ -93,8 +94,8 @@ export class OrderDialogComponent {
             order,
           });
         },
-        error: (error: HttpErrorResponse) => {
-          this.alertService.error(error.error.message);
+        error: (error: WsError) => {
+          this.alertService.error(error.message);
           console.log(error);
         },
       });
@@ -1,8 +1,7 @@
-import { HttpErrorResponse } from '@angular/common/http';
 import { Component, OnInit, ViewChild } from '@angular/core';
 import { MatDialog } from '@angular/material/dialog';
 import { MatTable } from '@angular/material/table';
-import { Order, OrderState } from '@app/_models';
+import { Order, OrderState, WsError } from '@app/_models';
 import { AlertService, ChatService, OrdersService } from '@app/_services';
 import { CrudDialogAction } from '@app/catalog/catalog.component';
 import { first } from 'rxjs';
@@ -59,18 +58,17 @@ export class OrderListComponent implements OnInit {
   }
 
   updateOrder(orderId: number, stateId: OrderState) {
-    this.orderService
-      .update(orderId, {
+    this.chatService
+      .updateOrder(orderId, {
         stateId,
       })
       .pipe(first())
       .subscribe({
-        next: (order) => {
+        next: () => {
           this.alertService.success('Order state updated!');
-          this.chatService.sendUpdatedOrder(order);
         },
-        error: (error: HttpErrorResponse) => {
-          this.alertService.error(error.error.message);
+        error: (error: WsError) => {
+          this.alertService.error(error.message);
           console.log(error);
         },
       });